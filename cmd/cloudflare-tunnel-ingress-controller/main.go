@@ -10,15 +10,11 @@ import (
 	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
 	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/controller"
 	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/coverage"
-	"github.com/cloudflare/cloudflare-go"
 	"github.com/go-logr/logr"
 	"github.com/go-logr/stdr"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"sigs.k8s.io/controller-runtime/pkg/cache"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	crlog "sigs.k8s.io/controller-runtime/pkg/log"
@@ -34,6 +30,12 @@ type rootCmdFlags struct {
 	controllerClass            string
 	logLevel                   int
 	cloudflareAPIToken         string
+	cloudflareAPIKey           string
+	cloudflareAPIEmail         string
+	cloudflareAuthMode         string
+	cloudflareCredentialsDir   string
+	cloudflareAuthCheck        bool
+	cloudflareAuthCheckStrict  bool
 	cloudflareAccountId        string
 	cloudflareTunnelName       string
 	namespace                  string
@@ -45,10 +47,72 @@ type rootCmdFlags struct {
 	// path to the JSON file with cloudflared pod template customization
 	cloudflaredDeploymentConfig string
 	clusterDomain               string
+	catchAllService             string
+	hostnameConflictPolicy      string
 	leaderElect                 bool
 	dnsCommentTemplate          string
+	allowDNSTakeover            bool
+	txtOwnerId                  string
+	zones                       []string
+	zoneIds                     []string
+	zoneCacheTTL                time.Duration
+	cloudflareAPIRateLimit      float64
+	cloudflareAPIBurst          int
+	cloudflareAPIMaxRetries     int
+	cloudflareAPIBaseURL        string
 	metricsBindAddress          string
 	healthProbeBindAddress      string
+	enableGatewayAPI            bool
+	enableCloudflareAccess      bool
+	enableHostnamePolicies      bool
+	enableTunnelParameters      bool
+	tunnelSyncDebounce          time.Duration
+	resyncPeriod                time.Duration
+	driftReportOnly             bool
+	preflight                   bool
+	preflightInterval           time.Duration
+	preflightDNSWrite           bool
+	dryRun                      bool
+	// format of the plan report, text or json
+	output string
+}
+
+func (o rootCmdFlags) dnsOptions() cloudflarecontroller.DNSOptions {
+	return cloudflarecontroller.DNSOptions{
+		CommentTemplate: o.dnsCommentTemplate,
+		AllowTakeover:   o.allowDNSTakeover,
+		OwnerID:         o.txtOwnerId,
+		Zones:           o.zones,
+		ZoneIDs:         o.zoneIds,
+		ZoneCacheTTL:    o.zoneCacheTTL,
+	}
+}
+
+// credentialSource reads the Cloudflare credentials from the files of the
+// credentials directory when set, from the flags otherwise.
+func (o rootCmdFlags) credentialSource() (cloudflarecontroller.CredentialSource, error) {
+	mode, err := cloudflarecontroller.ParseAuthMode(o.cloudflareAuthMode)
+	if err != nil {
+		return nil, err
+	}
+	if o.cloudflareCredentialsDir != "" {
+		return cloudflarecontroller.NewFileCredentials(o.logger.WithName("credentials"), o.cloudflareCredentialsDir, mode)
+	}
+	credentials := cloudflarecontroller.Credentials{Mode: mode, APIToken: o.cloudflareAPIToken, APIKey: o.cloudflareAPIKey, APIEmail: o.cloudflareAPIEmail}
+	if err := credentials.Validate(); err != nil {
+		return nil, err
+	}
+	return cloudflarecontroller.StaticCredentials(credentials), nil
+}
+
+// apiClientOptions returns the options of the Cloudflare API clients, with a
+// new request budget the clients created with them share.
+func (o rootCmdFlags) apiClientOptions() cloudflarecontroller.APIClientOptions {
+	return cloudflarecontroller.APIClientOptions{
+		Budget:     cloudflarecontroller.NewAPIBudget(o.cloudflareAPIRateLimit, o.cloudflareAPIBurst),
+		MaxRetries: o.cloudflareAPIMaxRetries,
+		BaseURL:    o.cloudflareAPIBaseURL,
+	}
 }
 
 func main() {
@@ -67,19 +131,35 @@ func main() {
 		cloudflaredImagePullPolicy: "IfNotPresent",
 		cloudflaredReplicaCount:    1,
 		clusterDomain:              "cluster.local",
+		hostnameConflictPolicy:     controller.HostnameConflictPolicyFirstOwner,
 		dnsCommentTemplate:         "managed by cloudflare-tunnel-ingress-controller, tunnel [{{.TunnelName}}]",
 		metricsBindAddress:         ":9090",
 		healthProbeBindAddress:     ":8081",
+		tunnelSyncDebounce:         2 * time.Second,
+		resyncPeriod:               10 * time.Minute,
+		preflight:                  true,
+		preflightInterval:          10 * time.Minute,
+		zoneCacheTTL:               5 * time.Minute,
+		cloudflareAPIRateLimit:     4,
+		cloudflareAPIBurst:         10,
+		cloudflareAPIMaxRetries:    5,
+		cloudflareAuthMode:         string(cloudflarecontroller.AuthModeAPIToken),
+		cloudflareAuthCheck:        true,
+		output:                     controller.PlanFormatText,
 	}
 
 	crlog.SetLogger(rootLogger.WithName("controller-runtime"))
 
 	rootCommand := cobra.Command{
 		Use: "tunnel-controller",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			ctx := context.Background()
-
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
 			options.cloudflareAPIToken = viper.GetString("cloudflare-api-token")
+			options.cloudflareAPIKey = viper.GetString("cloudflare-api-key")
+			options.cloudflareAPIEmail = viper.GetString("cloudflare-api-email")
+			options.cloudflareAuthMode = viper.GetString("cloudflare-auth-mode")
+			options.cloudflareCredentialsDir = viper.GetString("cloudflare-credentials-dir")
+			options.cloudflareAuthCheck = viper.GetBool("cloudflare-auth-check")
+			options.cloudflareAuthCheckStrict = viper.GetBool("cloudflare-auth-check-strict")
 			options.cloudflareAccountId = viper.GetString("cloudflare-account-id")
 			options.cloudflareTunnelName = viper.GetString("cloudflare-tunnel-name")
 			options.ingressClass = viper.GetString("ingress-class")
@@ -93,48 +173,120 @@ func main() {
 			options.cloudflaredReplicaCount = viper.GetInt32("cloudflared-replica-count")
 			options.cloudflaredDeploymentConfig = viper.GetString("cloudflared-deployment-config")
 			options.clusterDomain = viper.GetString("cluster-domain")
+			options.catchAllService = viper.GetString("catch-all-service")
+			options.hostnameConflictPolicy = viper.GetString("hostname-conflict-policy")
 			options.leaderElect = viper.GetBool("leader-elect")
 			options.dnsCommentTemplate = viper.GetString("dns-comment-template")
+			options.allowDNSTakeover = viper.GetBool("allow-dns-takeover")
+			options.txtOwnerId = viper.GetString("txt-owner-id")
+			options.zones = viper.GetStringSlice("zone")
+			options.zoneIds = viper.GetStringSlice("zone-id")
+			options.zoneCacheTTL = viper.GetDuration("zone-cache-ttl")
+			options.cloudflareAPIRateLimit = viper.GetFloat64("cloudflare-api-rate-limit")
+			options.cloudflareAPIBurst = viper.GetInt("cloudflare-api-burst")
+			options.cloudflareAPIMaxRetries = viper.GetInt("cloudflare-api-max-retries")
+			options.cloudflareAPIBaseURL = viper.GetString("cloudflare-api-base-url")
 			options.metricsBindAddress = viper.GetString("metrics-bind-address")
 			options.healthProbeBindAddress = viper.GetString("health-probe-bind-address")
-			controllerDeploymentName := viper.GetString("controller-deployment-name")
+			options.enableGatewayAPI = viper.GetBool("enable-gateway-api")
+			options.enableCloudflareAccess = viper.GetBool("enable-cloudflare-access")
+			options.enableHostnamePolicies = viper.GetBool("enable-hostname-policies")
+			options.enableTunnelParameters = viper.GetBool("enable-tunnel-parameters")
+			options.tunnelSyncDebounce = viper.GetDuration("tunnel-sync-debounce")
+			options.resyncPeriod = viper.GetDuration("resync-period")
+			options.driftReportOnly = viper.GetBool("drift-report-only")
+			options.preflight = viper.GetBool("preflight")
+			options.preflightInterval = viper.GetDuration("preflight-interval")
+			options.preflightDNSWrite = viper.GetBool("preflight-dns-write")
+			options.dryRun = viper.GetBool("dry-run")
+			options.output = viper.GetString("output")
 
 			stdr.SetVerbosity(options.logLevel)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			if err := controller.ValidateHostnameConflictPolicy(options.hostnameConflictPolicy); err != nil {
+				return err
+			}
+			if options.dryRun {
+				return runPlan(ctx, options, cmd.OutOrStdout())
+			}
+
+			controllerDeploymentName := viper.GetString("controller-deployment-name")
+
 			logger := options.logger
 			logger.Info("logging verbosity", "level", options.logLevel)
 
-			logger.V(3).Info("build cloudflare client with API Token", "api-token", "<redacted>")
-			cloudflareClient, err := cloudflare.NewWithAPIToken(options.cloudflareAPIToken)
+			credentials, err := options.credentialSource()
+			if err != nil {
+				logger.Error(err, "load cloudflare credentials")
+				os.Exit(1)
+			}
+			logger.V(3).Info("build cloudflare client", "auth-mode", options.cloudflareAuthMode, "credentials-dir", options.cloudflareCredentialsDir)
+			apiClientOptions := options.apiClientOptions()
+			cloudflareClient, err := cloudflarecontroller.NewAPIClient(credentials, apiClientOptions)
 			if err != nil {
 				logger.Error(err, "create cloudflare client")
 				os.Exit(1)
 			}
 
+			if options.cloudflareAuthCheck {
+				report, err := cloudflarecontroller.CheckAuth(ctx, logger.WithName("auth-check"), cloudflareClient, credentials, options.cloudflareAccountId)
+				if err != nil {
+					logger.Error(err, "check cloudflare credentials")
+					os.Exit(1)
+				}
+				// the check may read the policies of a working token wrong, the
+				// preflight tells for sure
+				if len(report.Missing) > 0 && options.cloudflareAuthCheckStrict {
+					logger.Error(nil, "cloudflare credentials lack permissions the controller needs", "auth-mode", report.Mode, "missing", report.Missing)
+					os.Exit(1)
+				}
+				if len(report.Missing) > 0 {
+					logger.Info("cloudflare credentials seem to lack permissions the controller needs", "auth-mode", report.Mode, "missing", report.Missing)
+				}
+				if len(report.Unchecked) > 0 {
+					logger.Info("could not check every permission from the policies of the api token", "unchecked", report.Unchecked)
+				}
+				logger.Info("cloudflare credentials checked", "auth-mode", report.Mode)
+			}
+
+			cloudflareAPI := cloudflarecontroller.NewCloudflareAPI(cloudflareClient)
 			var tunnelClient *cloudflarecontroller.TunnelClient
 
 			logger.V(3).Info("bootstrap tunnel client with tunnel name", "account-id", options.cloudflareAccountId, "tunnel-name", options.cloudflareTunnelName)
-			tunnelClient, err = cloudflarecontroller.BootstrapTunnelClientWithTunnelName(ctx, logger.WithName("tunnel-client"), cloudflareClient, options.cloudflareAccountId, options.cloudflareTunnelName, options.dnsCommentTemplate)
+			tunnelClient, err = cloudflarecontroller.BootstrapTunnelClientWithTunnelName(ctx, logger.WithName("tunnel-client"), cloudflareAPI, options.cloudflareAccountId, options.cloudflareTunnelName, options.dnsOptions())
 			if err != nil {
 				logger.Error(err, "bootstrap tunnel client with tunnel name")
 				os.Exit(1)
 			}
 
+			var preflight *cloudflarecontroller.Preflight
+			if options.preflight {
+				preflight = cloudflarecontroller.NewPreflight(logger.WithName("preflight"), tunnelClient,
+					cloudflarecontroller.NewCredentialVerifier(cloudflareClient, credentials, options.cloudflareAccountId), options.preflightDNSWrite)
+				if err := preflight.Run(ctx).Err(); err != nil {
+					logger.Error(err, "cloudflare preflight failed, the controller is not ready until it passes")
+				} else {
+					logger.Info("cloudflare preflight passed")
+				}
+			}
+
 			cfg, err := config.GetConfig()
 			if err != nil {
 				logger.Error(err, "unable to get kubeconfig")
 				os.Exit(1)
 			}
 
+			scheme, err := newScheme(options)
+			if err != nil {
+				logger.Error(err, "unable to build scheme")
+				os.Exit(1)
+			}
+
 			mgr, err := manager.New(cfg, manager.Options{
-				Cache: cache.Options{
-					ByObject: map[client.Object]cache.ByObject{
-						&corev1.Secret{}: {
-							Namespaces: map[string]cache.Config{
-								options.namespace: {},
-							},
-						},
-					},
-				},
+				Scheme: scheme,
+				Cache:  cacheOptions(options),
 				Metrics: metricsserver.Options{
 					BindAddress: options.metricsBindAddress,
 				},
@@ -153,33 +305,103 @@ func main() {
 				os.Exit(1)
 			}
 			// the tunnel client is already bootstrapped at this point, so the
-			// controller is ready once the manager can serve requests
-			if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+			// controller is ready once the manager can serve requests and the
+			// preflight passed
+			var readyCheck healthz.Checker = healthz.Ping
+			if preflight != nil {
+				readyCheck = preflight.Check
+			}
+			if err := mgr.AddReadyzCheck("readyz", readyCheck); err != nil {
 				logger.Error(err, "unable to set up ready check")
 				os.Exit(1)
 			}
 
+			deploymentConfig, configHash, err := controller.LoadCloudflaredDeploymentConfig(options.cloudflaredDeploymentConfig)
+			if err != nil {
+				logger.Error(err, "load cloudflared deployment config")
+				os.Exit(1)
+			}
+
+			cloudflaredConfig := controller.CloudflaredConfig{
+				Image:             options.cloudflaredImage,
+				ImagePullPolicy:   options.cloudflaredImagePullPolicy,
+				Replicas:          options.cloudflaredReplicaCount,
+				Protocol:          options.cloudflaredProtocol,
+				ExtraArgs:         options.cloudflaredExtraArgs,
+				Customization:     deploymentConfig,
+				CustomizationHash: configHash,
+			}
+
 			logger.Info("cloudflare-tunnel-ingress-controller start serving")
+			if options.enableCloudflareAccess {
+				err = controller.RegisterAccessController(logger, mgr,
+					controller.AccessControllerOptions{
+						ClusterDomain:          options.clusterDomain,
+						AccessClient:           cloudflarecontroller.NewAccessClient(logger.WithName("access-client"), cloudflareClient, options.cloudflareAccountId, options.cloudflareTunnelName),
+						EnableHostnamePolicies: options.enableHostnamePolicies,
+					})
+				if err != nil {
+					return err
+				}
+			}
+
+			var tunnelRegistry *controller.TunnelRegistry
+			if options.enableTunnelParameters {
+				tunnelRegistry = controller.NewTunnelRegistry(logger.WithName("tunnel-registry"), mgr.GetClient(), options.namespace,
+					cloudflarecontroller.TunnelCredentials{AccountID: options.cloudflareAccountId, Credentials: credentials},
+					cloudflarecontroller.NewTunnelClientProvider(logger.WithName("tunnel-client"), apiClientOptions, options.dnsOptions()))
+				err = controller.RegisterTunnelParametersController(logger, mgr,
+					controller.TunnelParametersControllerOptions{
+						Namespace:         options.namespace,
+						CloudflaredConfig: cloudflaredConfig,
+						TunnelRegistry:    tunnelRegistry,
+					})
+				if err != nil {
+					return err
+				}
+			}
+
 			err = controller.RegisterIngressController(logger, mgr,
 				controller.IngressControllerOptions{
-					IngressClassName:    options.ingressClass,
-					ControllerClassName: options.controllerClass,
-					ClusterDomain:       options.clusterDomain,
-					CFTunnelClient:      tunnelClient,
+					IngressClassName:       options.ingressClass,
+					ControllerClassName:    options.controllerClass,
+					ClusterDomain:          options.clusterDomain,
+					CatchAllService:        options.catchAllService,
+					HostnameConflictPolicy: options.hostnameConflictPolicy,
+					CFTunnelClient:         tunnelClient,
+					TunnelRegistry:         tunnelRegistry,
+					EnableCloudflareAccess: options.enableCloudflareAccess,
+					EnableHostnamePolicies: options.enableHostnamePolicies,
+					SyncDebounce:           options.tunnelSyncDebounce,
+					Drift: controller.DriftOptions{
+						ResyncPeriod: options.resyncPeriod,
+						ReportOnly:   options.driftReportOnly,
+					},
 				})
 			if err != nil {
 				return err
 			}
 
-			deploymentConfig, configHash, err := controller.LoadCloudflaredDeploymentConfig(options.cloudflaredDeploymentConfig)
-			if err != nil {
-				logger.Error(err, "load cloudflared deployment config")
-				os.Exit(1)
-			}
-
 			done := make(chan struct{})
 			defer close(done)
 
+			if options.enableGatewayAPI {
+				err = controller.RegisterGatewayControllers(logger, mgr,
+					controller.GatewayControllerOptions{
+						ControllerClassName:    options.controllerClass,
+						ClusterDomain:          options.clusterDomain,
+						TunnelNamePrefix:       options.cloudflareTunnelName,
+						Namespace:              options.namespace,
+						CloudflaredConfig:      cloudflaredConfig,
+						TunnelClientFactory:    cloudflarecontroller.NewTunnelClientFactory(logger.WithName("tunnel-client"), cloudflareAPI, options.cloudflareAccountId, options.dnsOptions()),
+						HostnameConflictPolicy: options.hostnameConflictPolicy,
+						EnableHostnamePolicies: options.enableHostnamePolicies,
+					})
+				if err != nil {
+					return err
+				}
+			}
+
 			reconcileConnector := func() {
 				// bind the connector resources to the controller Deployment so
 				// garbage collection removes them when the controller is
@@ -194,16 +416,9 @@ func main() {
 					ownerReference = resolved
 				}
 
-				reconcileErr := controller.CreateOrUpdateControlledCloudflared(ctx, mgr.GetClient(), tunnelClient, options.namespace, controller.CloudflaredConfig{
-					Image:             options.cloudflaredImage,
-					ImagePullPolicy:   options.cloudflaredImagePullPolicy,
-					Replicas:          options.cloudflaredReplicaCount,
-					Protocol:          options.cloudflaredProtocol,
-					ExtraArgs:         options.cloudflaredExtraArgs,
-					Customization:     deploymentConfig,
-					CustomizationHash: configHash,
-					Owner:             ownerReference,
-				})
+				connectorConfig := cloudflaredConfig
+				connectorConfig.Owner = ownerReference
+				reconcileErr := controller.CreateOrUpdateControlledCloudflared(ctx, mgr.GetClient(), tunnelClient, options.namespace, connectorConfig)
 				if reconcileErr != nil {
 					logger.WithName("controlled-cloudflared").Error(reconcileErr, "create controlled cloudflared")
 				}
@@ -233,6 +448,11 @@ func main() {
 				}
 			}()
 
+			// every replica runs the preflight, its readiness depends on it
+			if preflight != nil && options.preflightInterval > 0 {
+				go preflight.RunEvery(ctx, options.preflightInterval, done)
+			}
+
 			// controller-runtime manager would graceful shutdown with signal by itself, no need to provide context
 			return mgr.Start(context.Background())
 		},
@@ -242,7 +462,17 @@ func main() {
 	rootCommand.PersistentFlags().StringVar(&options.controllerClass, "controller-class", options.controllerClass, "controller class name")
 	rootCommand.PersistentFlags().IntVarP(&options.logLevel, "log-level", "v", options.logLevel, "numeric log level")
 	rootCommand.PersistentFlags().StringVar(&options.cloudflareAPIToken, "cloudflare-api-token", options.cloudflareAPIToken, "cloudflare api token")
+	rootCommand.PersistentFlags().StringVar(&options.cloudflareAPIKey, "cloudflare-api-key", options.cloudflareAPIKey, "cloudflare global api key, with --cloudflare-auth-mode=api-key")
+	rootCommand.PersistentFlags().StringVar(&options.cloudflareAPIEmail, "cloudflare-api-email", options.cloudflareAPIEmail, "email of the cloudflare user owning the global api key, with --cloudflare-auth-mode=api-key")
+	rootCommand.PersistentFlags().StringVar(&options.cloudflareAuthMode, "cloudflare-auth-mode", options.cloudflareAuthMode, "how the cloudflare api requests authenticate: api-token for a user api token, account-api-token for an account api token, api-key for a global api key with its email")
+	rootCommand.PersistentFlags().StringVar(&options.cloudflareCredentialsDir, "cloudflare-credentials-dir", options.cloudflareCredentialsDir, "directory of the cloudflare credential files, eg. a mounted Secret: api-token, or api-key and api-email. The files are read again when they change, in place of the credential flags")
+	rootCommand.PersistentFlags().BoolVar(&options.cloudflareAuthCheck, "cloudflare-auth-check", options.cloudflareAuthCheck, "verify the cloudflare credentials and their permissions at startup, exit when the credentials are invalid and log the permissions they seem to lack")
+	rootCommand.PersistentFlags().BoolVar(&options.cloudflareAuthCheckStrict, "cloudflare-auth-check-strict", options.cloudflareAuthCheckStrict, "exit when the auth check finds a missing permission, instead of logging it")
 	rootCommand.PersistentFlags().StringVar(&options.cloudflareAccountId, "cloudflare-account-id", options.cloudflareAccountId, "cloudflare account id")
+	rootCommand.PersistentFlags().Float64Var(&options.cloudflareAPIRateLimit, "cloudflare-api-rate-limit", options.cloudflareAPIRateLimit, "Cloudflare API requests per second allowed on average, shared by every tunnel, Cloudflare allows 1200 requests per 5 minutes")
+	rootCommand.PersistentFlags().IntVar(&options.cloudflareAPIBurst, "cloudflare-api-burst", options.cloudflareAPIBurst, "Cloudflare API requests allowed at once above the rate limit")
+	rootCommand.PersistentFlags().IntVar(&options.cloudflareAPIMaxRetries, "cloudflare-api-max-retries", options.cloudflareAPIMaxRetries, "how many times a Cloudflare API request failing with a 429, a 5xx or a network error is retried, with exponential backoff or after the Retry-After of the response, a POST only after a 429 or a connection failure")
+	rootCommand.PersistentFlags().StringVar(&options.cloudflareAPIBaseURL, "cloudflare-api-base-url", options.cloudflareAPIBaseURL, "base URL of the Cloudflare API, eg. http://fake-cloudflare:8787/client/v4 to develop against the fake-cloudflare emulator, empty for https://api.cloudflare.com/client/v4")
 	rootCommand.PersistentFlags().StringVar(&options.cloudflareTunnelName, "cloudflare-tunnel-name", options.cloudflareTunnelName, "cloudflare tunnel name")
 	rootCommand.PersistentFlags().StringVar(&options.namespace, "namespace", options.namespace, "namespace to execute cloudflared connector")
 	rootCommand.PersistentFlags().StringVar(&options.cloudflaredProtocol, "cloudflared-protocol", options.cloudflaredProtocol, "cloudflared protocol")
@@ -252,12 +482,40 @@ func main() {
 	rootCommand.PersistentFlags().Int32Var(&options.cloudflaredReplicaCount, "cloudflared-replica-count", options.cloudflaredReplicaCount, "replica count for the managed cloudflared connector")
 	rootCommand.PersistentFlags().StringVar(&options.cloudflaredDeploymentConfig, "cloudflared-deployment-config", options.cloudflaredDeploymentConfig, "path to JSON file with cloudflared deployment pod template customization")
 	rootCommand.PersistentFlags().StringVar(&options.clusterDomain, "cluster-domain", options.clusterDomain, "kubernetes cluster domain, used to build service FQDN (should match kubelet --cluster-domain)")
+	rootCommand.PersistentFlags().StringVar(&options.catchAllService, "catch-all-service", options.catchAllService, "cloudflared service serving the requests no Ingress matches, eg. http://fallback.default.svc.cluster.local:80 or http_status:503, empty for http_status:404. An Ingress with a defaultBackend and no rules takes precedence")
+	rootCommand.PersistentFlags().StringVar(&options.hostnameConflictPolicy, "hostname-conflict-policy", options.hostnameConflictPolicy, "how a host and path claimed by Ingresses and exposed Services of several namespaces of a tunnel is resolved: first-owner keeps the one created first, reject drops the rule of every claimant")
 	rootCommand.PersistentFlags().BoolVar(&options.leaderElect, "leader-elect", options.leaderElect, "enable leader election for high availability")
 	rootCommand.PersistentFlags().String("controller-deployment-name", "", "name of the controller Deployment, set as owner of the connector resources so garbage collection removes them on uninstall")
 	rootCommand.PersistentFlags().StringVar(&options.metricsBindAddress, "metrics-bind-address", options.metricsBindAddress, "address for the metrics endpoint, set to 0 to disable")
 	rootCommand.PersistentFlags().StringVar(&options.healthProbeBindAddress, "health-probe-bind-address", options.healthProbeBindAddress, "address for the healthz/readyz endpoints, set to 0 to disable")
+	rootCommand.PersistentFlags().BoolVar(&options.enableGatewayAPI, "enable-gateway-api", options.enableGatewayAPI, "reconcile GatewayClasses, Gateways and HTTPRoutes, requires the Gateway API CRDs to be installed")
+	rootCommand.PersistentFlags().BoolVar(&options.enableCloudflareAccess, "enable-cloudflare-access", options.enableCloudflareAccess, "reconcile CloudflareAccess objects into Cloudflare Access applications, requires the CloudflareAccess CRD to be installed")
+	rootCommand.PersistentFlags().BoolVar(&options.enableHostnamePolicies, "enable-hostname-policies", options.enableHostnamePolicies, "restrict the hostnames of every namespace to the ones HostnamePolicy objects allow, requires the HostnamePolicy CRD to be installed")
+	rootCommand.PersistentFlags().BoolVar(&options.enableTunnelParameters, "enable-tunnel-parameters", options.enableTunnelParameters, "route IngressClasses referencing TunnelParameters to their own tunnel, requires the TunnelParameters CRD to be installed")
+	rootCommand.PersistentFlags().DurationVar(&options.tunnelSyncDebounce, "tunnel-sync-debounce", options.tunnelSyncDebounce, "delay between an Ingress event and the sync of its tunnel, events arriving in the meantime are synced at once")
+	rootCommand.PersistentFlags().DurationVar(&options.resyncPeriod, "resync-period", options.resyncPeriod, "how often every tunnel config and its DNS records are compared with Cloudflare to detect drift, 0 disables the check")
+	rootCommand.PersistentFlags().BoolVar(&options.driftReportOnly, "drift-report-only", options.driftReportOnly, "report drift found by the resync in logs and metrics without repairing it")
+	rootCommand.PersistentFlags().BoolVar(&options.preflight, "preflight", options.preflight, "verify the cloudflare credentials and probe tunnel and DNS access on every managed zone, the controller is not ready while it fails. Only the default tunnel is probed, not the ones of TunnelParameters")
+	rootCommand.PersistentFlags().DurationVar(&options.preflightInterval, "preflight-interval", options.preflightInterval, "how often the preflight runs again after startup, a failed one runs again after a backoff starting at 15s, 0 runs it at startup only")
+	rootCommand.PersistentFlags().BoolVar(&options.preflightDNSWrite, "preflight-dns-write", options.preflightDNSWrite, "let the preflight create then delete a TXT record in every managed zone to probe the DNS edit permission, on every run of every replica")
+	rootCommand.PersistentFlags().BoolVar(&options.dryRun, "dry-run", options.dryRun, "print the Cloudflare changes the controller would make and exit, without changing anything, like the plan command")
+	rootCommand.PersistentFlags().StringVarP(&options.output, "output", "o", options.output, "format of the report printed by --dry-run and the plan command, text or json")
+	rootCommand.PersistentFlags().BoolVar(&options.allowDNSTakeover, "allow-dns-takeover", options.allowDNSTakeover, "replace the existing CNAME, A and AAAA records of the hostnames this controller does not own, instead of reporting a conflict on the Ingress. The records owned by another tunnel or owner are never replaced")
+	rootCommand.PersistentFlags().StringVar(&options.txtOwnerId, "txt-owner-id", options.txtOwnerId, "owner written in the ownership TXT records, set a different one on every cluster sharing a tunnel name, the records of another owner are never updated nor deleted")
+	rootCommand.PersistentFlags().StringSliceVar(&options.zones, "zone", options.zones, "names of the zones the DNS records are managed in, the hostnames of other zones fail, empty allows every zone the API token can read")
+	rootCommand.PersistentFlags().StringSliceVar(&options.zoneIds, "zone-id", options.zoneIds, "IDs of the zones the DNS records are managed in, in addition to --zone")
+	rootCommand.PersistentFlags().DurationVar(&options.zoneCacheTTL, "zone-cache-ttl", options.zoneCacheTTL, "how long the zones listed from Cloudflare are reused, 0 lists them on every sync")
 	rootCommand.PersistentFlags().StringVar(&options.dnsCommentTemplate, "dns-comment-template", options.dnsCommentTemplate, "Go template for DNS record comments. Available variables: {{.TunnelName}}, {{.TunnelId}}, {{.Hostname}}. Set to empty string to disable. Note: Cloudflare limits comment length by plan (Free: 100, Pro/Biz/Ent: 500 chars). See https://developers.cloudflare.com/dns/manage-dns-records/reference/record-attributes/")
 
+	rootCommand.AddCommand(&cobra.Command{
+		Use:   "plan",
+		Short: "Print the Cloudflare changes the controller would make, without changing anything",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPlan(context.Background(), options, cmd.OutOrStdout())
+		},
+	})
+
 	viper.AutomaticEnv()
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	if err := viper.BindPFlags(rootCommand.PersistentFlags()); err != nil {
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/apis/v1alpha1"
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/controller"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+func newScheme(options rootCmdFlags) (*runtime.Scheme, error) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, errors.Wrap(err, "add client-go api to scheme")
+	}
+	if options.enableCloudflareAccess || options.enableTunnelParameters || options.enableHostnamePolicies {
+		if err := v1alpha1.AddToScheme(scheme); err != nil {
+			return nil, errors.Wrap(err, "add v1alpha1 api to scheme")
+		}
+	}
+	if options.enableGatewayAPI {
+		if err := gatewayv1.Install(scheme); err != nil {
+			return nil, errors.Wrap(err, "add gateway api to scheme")
+		}
+	}
+	return scheme, nil
+}
+
+// cacheOptions only caches the Secrets of the controller namespace, the
+// credentials of the TunnelParameters live there.
+func cacheOptions(options rootCmdFlags) cache.Options {
+	return cache.Options{
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.Secret{}: {
+				Namespaces: map[string]cache.Config{
+					options.namespace: {},
+				},
+			},
+		},
+	}
+}
+
+// runPlan writes the Cloudflare changes the ingress controller would make to
+// out. Missing tunnels are reported instead of created, and nothing is
+// written to Kubernetes.
+func runPlan(ctx context.Context, options rootCmdFlags, out io.Writer) error {
+	logger := options.logger
+
+	credentials, err := options.credentialSource()
+	if err != nil {
+		return errors.Wrap(err, "load cloudflare credentials")
+	}
+	apiClientOptions := options.apiClientOptions()
+	cloudflareClient, err := cloudflarecontroller.NewAPIClient(credentials, apiClientOptions)
+	if err != nil {
+		return errors.Wrap(err, "create cloudflare client")
+	}
+	tunnelClient, err := cloudflarecontroller.LookupTunnelClientWithTunnelName(ctx, logger.WithName("tunnel-client"), cloudflarecontroller.NewCloudflareAPI(cloudflareClient), options.cloudflareAccountId, options.cloudflareTunnelName, options.dnsOptions())
+	if err != nil {
+		return errors.Wrap(err, "look up tunnel client with tunnel name")
+	}
+
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return errors.Wrap(err, "get kubeconfig")
+	}
+	scheme, err := newScheme(options)
+	if err != nil {
+		return errors.Wrap(err, "build scheme")
+	}
+	kubeCluster, err := cluster.New(cfg, func(clusterOptions *cluster.Options) {
+		clusterOptions.Scheme = scheme
+		clusterOptions.Cache = cacheOptions(options)
+	})
+	if err != nil {
+		return errors.Wrap(err, "set up kubernetes client")
+	}
+
+	var tunnelRegistry *controller.TunnelRegistry
+	if options.enableTunnelParameters {
+		tunnelRegistry = controller.NewTunnelRegistry(logger.WithName("tunnel-registry"), kubeCluster.GetClient(), options.namespace,
+			cloudflarecontroller.TunnelCredentials{AccountID: options.cloudflareAccountId, Credentials: credentials},
+			cloudflarecontroller.NewLookupTunnelClientProvider(logger.WithName("tunnel-client"), apiClientOptions, options.dnsOptions()))
+	}
+
+	plans, err := controller.PlanIngressController(ctx, logger.WithName("ingress-controller"), kubeCluster,
+		controller.IngressControllerOptions{
+			IngressClassName:       options.ingressClass,
+			ControllerClassName:    options.controllerClass,
+			ClusterDomain:          options.clusterDomain,
+			CatchAllService:        options.catchAllService,
+			HostnameConflictPolicy: options.hostnameConflictPolicy,
+			CFTunnelClient:         tunnelClient,
+			TunnelRegistry:         tunnelRegistry,
+			EnableCloudflareAccess: options.enableCloudflareAccess,
+			EnableHostnamePolicies: options.enableHostnamePolicies,
+		})
+	if err != nil {
+		return err
+	}
+	if err := controller.WritePlanReport(out, plans, options.output); err != nil {
+		return err
+	}
+	for _, plan := range plans {
+		if plan.Error != "" {
+			return errors.Errorf("tunnel %s could not be planned", plan.Tunnel)
+		}
+	}
+	return nil
+}
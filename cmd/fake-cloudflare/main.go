@@ -0,0 +1,56 @@
+// fake-cloudflare serves the part of the Cloudflare API the controller uses
+// from memory, so the controller runs without a Cloudflare account with
+// --cloudflare-api-base-url pointing at it.
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller/fake"
+	"github.com/go-logr/stdr"
+	"github.com/spf13/cobra"
+)
+
+type rootCmdFlags struct {
+	listenAddress string
+	accountId     string
+	zones         []string
+	apiToken      string
+	logLevel      int
+}
+
+func main() {
+	logger := stdr.NewWithOptions(log.New(os.Stderr, "", log.LstdFlags), stdr.Options{LogCaller: stdr.All})
+
+	options := rootCmdFlags{
+		listenAddress: ":8787",
+		accountId:     "fake-account",
+		zones:         []string{"example.com"},
+	}
+
+	rootCommand := cobra.Command{
+		Use: "fake-cloudflare",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			stdr.SetVerbosity(options.logLevel)
+			api := fake.NewCloudflare(options.accountId)
+			for _, zone := range options.zones {
+				api.AddZone(zone)
+			}
+			logger.Info("serving the cloudflare api", "address", options.listenAddress, "base-url-path", fake.APIPrefix, "account-id", options.accountId, "zones", options.zones)
+			return http.ListenAndServe(options.listenAddress, fake.NewServer(logger, api, options.apiToken))
+		},
+	}
+
+	rootCommand.Flags().StringVar(&options.listenAddress, "listen-address", options.listenAddress, "address to serve the api on")
+	rootCommand.Flags().StringVar(&options.accountId, "account-id", options.accountId, "id of the cloudflare account")
+	rootCommand.Flags().StringSliceVar(&options.zones, "zone", options.zones, "name of a zone of the account, repeat the flag for more")
+	rootCommand.Flags().StringVar(&options.apiToken, "api-token", options.apiToken, "only api token accepted, empty accepts any credentials")
+	rootCommand.Flags().IntVarP(&options.logLevel, "log-level", "v", options.logLevel, "numeric log level, 3 logs every request")
+
+	if err := rootCommand.Execute(); err != nil {
+		logger.Error(err, "serve the cloudflare api")
+		os.Exit(1)
+	}
+}
@@ -72,6 +72,14 @@ export default defineConfig({
               label: "Rotate Cloudflare Credentials",
               slug: "how-to/rotate-cloudflare-credentials",
             },
+            {
+              label: "Preview Cloudflare Changes",
+              slug: "how-to/preview-changes",
+            },
+            {
+              label: "Develop Without Cloudflare",
+              slug: "how-to/develop-without-cloudflare",
+            },
             { label: "Troubleshooting", slug: "guides/troubleshooting" },
           ],
         },
@@ -89,6 +97,9 @@ export default defineConfig({
               label: "Ingress Annotations",
               slug: "reference/ingress-annotations",
             },
+            { label: "Gateway API", slug: "reference/gateway-api" },
+            { label: "Cloudflare Access", slug: "reference/cloudflare-access" },
+            { label: "Hostname Policy", slug: "reference/hostname-policy" },
             {
               label: "Cloudflare Credentials",
               slug: "reference/cloudflare-credentials",
@@ -0,0 +1,195 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AccessDecision is the action an Access policy takes when it matches.
+// +kubebuilder:validation:Enum=allow;deny;bypass;non_identity
+type AccessDecision string
+
+const (
+	AccessDecisionAllow       AccessDecision = "allow"
+	AccessDecisionDeny        AccessDecision = "deny"
+	AccessDecisionBypass      AccessDecision = "bypass"
+	AccessDecisionNonIdentity AccessDecision = "non_identity"
+)
+
+// Condition types and reasons reported on CloudflareAccess objects.
+const (
+	// AccessConditionAccepted is true when the spec is valid and the object
+	// is not in conflict with another one.
+	AccessConditionAccepted = "Accepted"
+	// AccessConditionResolvedRefs is true when every target resolved.
+	AccessConditionResolvedRefs = "ResolvedRefs"
+	// AccessConditionProgrammed is true when the Access application and its
+	// policies match the spec. Hostnames of the targets are only exposed
+	// through the tunnel while this condition is true.
+	AccessConditionProgrammed = "Programmed"
+
+	AccessReasonAccepted        = "Accepted"
+	AccessReasonInvalidPolicy   = "InvalidPolicy"
+	AccessReasonConflicted      = "Conflicted"
+	AccessReasonResolvedRefs    = "ResolvedRefs"
+	AccessReasonTargetNotFound  = "TargetNotFound"
+	AccessReasonNoHostnames     = "NoHostnames"
+	AccessReasonProgrammed      = "Programmed"
+	AccessReasonCloudflareError = "CloudflareError"
+)
+
+// CloudflareAccessSpec declares the Access application protecting the
+// hostnames of the targets.
+// +kubebuilder:validation:XValidation:rule="!(has(self.autoRedirectToIdentity) && self.autoRedirectToIdentity) || (has(self.allowedIdentityProviders) && size(self.allowedIdentityProviders) == 1)",message="autoRedirectToIdentity requires exactly one allowedIdentityProviders entry"
+type CloudflareAccessSpec struct {
+	// TargetRefs selects the Ingresses to protect. Only Ingresses in the
+	// same namespace as this object can be referenced. All hostnames of all
+	// targets become destinations of one shared Access application, so one
+	// login covers all of them.
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=16
+	// +listType=map
+	// +listMapKey=kind
+	// +listMapKey=name
+	TargetRefs []TargetRef `json:"targetRefs"`
+
+	// Policies of the Access application in ascending order of precedence,
+	// the first entry is evaluated first.
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=32
+	Policies []AccessPolicy `json:"policies"`
+
+	// SessionDuration is how long a session lasts before the user has to
+	// authenticate again.
+	// +optional
+	// +kubebuilder:validation:Enum=30m;6h;12h;24h;168h;730h
+	SessionDuration string `json:"sessionDuration,omitempty"`
+
+	// AllowedIdentityProviders limits which identity providers users can
+	// pick for this application, by identity provider ID. Empty means every
+	// provider configured in the account.
+	// +optional
+	// +kubebuilder:validation:MaxItems=16
+	AllowedIdentityProviders []string `json:"allowedIdentityProviders,omitempty"`
+
+	// AutoRedirectToIdentity skips the identity provider selection page.
+	// The Cloudflare API requires exactly one entry in
+	// allowedIdentityProviders when this is true.
+	// +optional
+	AutoRedirectToIdentity *bool `json:"autoRedirectToIdentity,omitempty"`
+}
+
+type TargetRef struct {
+	// Kind of the target resource.
+	// +kubebuilder:validation:Enum=Ingress
+	Kind string `json:"kind"`
+
+	// Name of the target resource.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
+
+// AccessPolicy is an Access policy owned by the application.
+type AccessPolicy struct {
+	// Name of the policy, unique within the object.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=128
+	Name string `json:"name"`
+
+	// Decision taken when the policy matches.
+	// +optional
+	// +kubebuilder:default=allow
+	Decision AccessDecision `json:"decision,omitempty"`
+
+	// Include matches when any of its rules matches.
+	Include AccessRules `json:"include"`
+
+	// Require matches when all of its rules match.
+	// +optional
+	Require *AccessRules `json:"require,omitempty"`
+
+	// Exclude denies the policy when any of its rules matches.
+	// +optional
+	Exclude *AccessRules `json:"exclude,omitempty"`
+}
+
+// AccessRules lists the selectors of a policy rule set, every entry becomes
+// one Access rule.
+type AccessRules struct {
+	// Emails matches users by email address.
+	// +optional
+	Emails []string `json:"emails,omitempty"`
+
+	// EmailDomains matches users by the domain of their email address,
+	// without the "@".
+	// +optional
+	EmailDomains []string `json:"emailDomains,omitempty"`
+
+	// Groups matches members of Access groups, by group ID.
+	// +optional
+	Groups []string `json:"groups,omitempty"`
+
+	// IPRanges matches clients by source address, as an IP or a CIDR.
+	// +optional
+	IPRanges []string `json:"ipRanges,omitempty"`
+
+	// ServiceTokens matches requests authenticated with one of the Access
+	// service tokens, by token ID.
+	// +optional
+	ServiceTokens []string `json:"serviceTokens,omitempty"`
+
+	// AnyValidServiceToken matches requests authenticated with any service
+	// token of the account.
+	// +optional
+	AnyValidServiceToken bool `json:"anyValidServiceToken,omitempty"`
+}
+
+type CloudflareAccessStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ApplicationID of the managed Access application.
+	// +optional
+	ApplicationID string `json:"applicationID,omitempty"`
+
+	// AUD is the application audience tag. Origins that validate the
+	// Cf-Access-Jwt-Assertion header need this value.
+	// +optional
+	AUD string `json:"aud,omitempty"`
+
+	// Hostnames currently covered by the Access application.
+	// +optional
+	Hostnames []string `json:"hostnames,omitempty"`
+}
+
+// CloudflareAccess puts a Cloudflare Access application in front of the
+// hostnames of Ingresses.
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=cfaccess
+// +kubebuilder:printcolumn:name="Programmed",type=string,JSONPath=`.status.conditions[?(@.type=="Programmed")].status`
+// +kubebuilder:printcolumn:name="Application",type=string,JSONPath=`.status.applicationID`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type CloudflareAccess struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CloudflareAccessSpec   `json:"spec,omitempty"`
+	Status CloudflareAccessStatus `json:"status,omitempty"`
+}
+
+// CloudflareAccessList contains a list of CloudflareAccess.
+// +kubebuilder:object:root=true
+type CloudflareAccessList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CloudflareAccess `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CloudflareAccess{}, &CloudflareAccessList{})
+}
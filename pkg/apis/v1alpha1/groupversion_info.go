@@ -0,0 +1,21 @@
+// Package v1alpha1 contains the API types of the
+// cloudflare-tunnel-ingress-controller.strrl.dev API group.
+// +kubebuilder:object:generate=true
+// +groupName=cloudflare-tunnel-ingress-controller.strrl.dev
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/scheme"
+)
+
+var (
+	// GroupVersion is the group version used to register these objects.
+	GroupVersion = schema.GroupVersion{Group: "cloudflare-tunnel-ingress-controller.strrl.dev", Version: "v1alpha1"}
+
+	// SchemeBuilder is used to add go types to the GroupVersionKind scheme.
+	SchemeBuilder = &scheme.Builder{GroupVersion: GroupVersion}
+
+	// AddToScheme adds the types in this group-version to the given scheme.
+	AddToScheme = SchemeBuilder.AddToScheme
+)
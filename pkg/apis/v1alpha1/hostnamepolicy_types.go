@@ -0,0 +1,59 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HostnamePolicySpec selects namespaces and the hostnames their Ingresses and
+// exposed Services may claim.
+// +kubebuilder:validation:XValidation:rule="has(self.namespaces) || has(self.namespaceSelector)",message="namespaces or namespaceSelector is required"
+type HostnamePolicySpec struct {
+	// Namespaces the policy applies to, by name.
+	// +optional
+	// +kubebuilder:validation:MaxItems=64
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// NamespaceSelector selects the namespaces the policy applies to by
+	// label, in addition to Namespaces. An empty selector selects every
+	// namespace.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// AllowedDomainSuffixes are the domains whose hostnames are allowed:
+	// team-a.example.com allows team-a.example.com and every hostname below
+	// it, including the hostnames of its subzones.
+	// +optional
+	// +kubebuilder:validation:MaxItems=64
+	AllowedDomainSuffixes []string `json:"allowedDomainSuffixes,omitempty"`
+
+	// AllowCatchAll lets an Ingress with a defaultBackend and no rules serve
+	// the requests matching no rule of its tunnel.
+	// +optional
+	AllowCatchAll bool `json:"allowCatchAll,omitempty"`
+}
+
+// HostnamePolicy restricts the hostnames the Ingresses and exposed Services of
+// the selected namespaces may claim. A namespace selected by several policies
+// may claim the hostnames of all of them, a namespace selected by none may
+// claim no hostname.
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,shortName=hostpolicy
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type HostnamePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec HostnamePolicySpec `json:"spec,omitempty"`
+}
+
+// HostnamePolicyList contains a list of HostnamePolicy.
+// +kubebuilder:object:root=true
+type HostnamePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HostnamePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HostnamePolicy{}, &HostnamePolicyList{})
+}
@@ -0,0 +1,103 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TunnelParametersKind is the kind IngressClasses reference in
+// spec.parameters to route their Ingresses to another tunnel.
+const TunnelParametersKind = "TunnelParameters"
+
+// Condition types and reasons reported on TunnelParameters objects.
+const (
+	// TunnelParametersConditionReady is true when the tunnel is bootstrapped
+	// and its cloudflared connector is deployed.
+	TunnelParametersConditionReady = "Ready"
+
+	TunnelParametersReasonReady              = "Ready"
+	TunnelParametersReasonInvalidCredentials = "InvalidCredentials"
+	TunnelParametersReasonCloudflareError    = "CloudflareError"
+	TunnelParametersReasonConnectorError     = "ConnectorError"
+)
+
+// TunnelParametersSpec names a Cloudflare tunnel and the credentials to
+// manage it with.
+type TunnelParametersSpec struct {
+	// TunnelName of the Cloudflare tunnel, created when it does not exist yet.
+	// +kubebuilder:validation:MinLength=1
+	TunnelName string `json:"tunnelName"`
+
+	// AccountID of the Cloudflare account owning the tunnel. Defaults to the
+	// account of the controller.
+	// +optional
+	AccountID string `json:"accountID,omitempty"`
+
+	// CredentialsSecretRef points at the Secret holding the credentials. The
+	// Secret must live in the namespace of the controller. Defaults to the
+	// credentials of the controller.
+	// +optional
+	CredentialsSecretRef *CredentialsSecretRef `json:"credentialsSecretRef,omitempty"`
+}
+
+// CredentialsSecretRef references a Secret in the namespace of the controller.
+type CredentialsSecretRef struct {
+	// Name of the Secret.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// APITokenKey is the key of the API token in the Secret.
+	// +optional
+	// +kubebuilder:default=api-token
+	APITokenKey string `json:"apiTokenKey,omitempty"`
+
+	// AuthMode is how the credentials authenticate. The api-key mode reads
+	// the Global API Key and its email from the api-key and api-email keys
+	// of the Secret.
+	// +optional
+	// +kubebuilder:validation:Enum=api-token;account-api-token;api-key
+	// +kubebuilder:default=api-token
+	AuthMode string `json:"authMode,omitempty"`
+}
+
+type TunnelParametersStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// TunnelDomain is the <tunnel-id>.cfargotunnel.com domain the DNS records
+	// of the Ingresses point at.
+	// +optional
+	TunnelDomain string `json:"tunnelDomain,omitempty"`
+}
+
+// TunnelParameters routes the Ingresses of the IngressClasses referencing it
+// to a dedicated Cloudflare tunnel.
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster,shortName=tunnelparams
+// +kubebuilder:printcolumn:name="Tunnel",type=string,JSONPath=`.spec.tunnelName`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type TunnelParameters struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TunnelParametersSpec   `json:"spec,omitempty"`
+	Status TunnelParametersStatus `json:"status,omitempty"`
+}
+
+// TunnelParametersList contains a list of TunnelParameters.
+// +kubebuilder:object:root=true
+type TunnelParametersList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TunnelParameters `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TunnelParameters{}, &TunnelParametersList{})
+}
@@ -0,0 +1,324 @@
+package cloudflarecontroller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/metrics"
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+)
+
+// AccessApplicationTag marks the Access applications managed by this
+// controller, independently of their display name.
+const AccessApplicationTag = "managed-by-cloudflare-tunnel-ingress-controller"
+
+// AccessApplication is the desired state of one managed Access application.
+type AccessApplication struct {
+	// Name is the deterministic application name, see AccessApplicationName.
+	Name string
+	// ID of the application when already known, empty to look it up by name.
+	ID                       string
+	Hostnames                []string
+	SessionDuration          string
+	AllowedIdentityProviders []string
+	AutoRedirectToIdentity   *bool
+	// Policies in ascending order of precedence.
+	Policies []AccessPolicy
+}
+
+// AccessPolicy is a policy owned by a managed Access application.
+type AccessPolicy struct {
+	Name     string
+	Decision string
+	Include  AccessRules
+	Require  AccessRules
+	Exclude  AccessRules
+}
+
+// AccessRules lists the selectors of a rule set, every entry becomes one
+// Access rule.
+type AccessRules struct {
+	Emails               []string
+	EmailDomains         []string
+	Groups               []string
+	IPRanges             []string
+	ServiceTokens        []string
+	AnyValidServiceToken bool
+}
+
+// AccessApplicationRef identifies an Access application on the Cloudflare side.
+type AccessApplicationRef struct {
+	ID  string
+	AUD string
+}
+
+type AccessClientInterface interface {
+	// PutAccessApplication creates or updates the application and replaces
+	// its policies with the desired ones.
+	PutAccessApplication(ctx context.Context, application AccessApplication) (AccessApplicationRef, error)
+	// DeleteAccessApplication deletes the application with the given ID, or
+	// the one with the given name when the ID is empty. A missing
+	// application is not an error.
+	DeleteAccessApplication(ctx context.Context, id string, name string) error
+	// AccessApplicationName returns the deterministic name of the application
+	// owned by the given CloudflareAccess object.
+	AccessApplicationName(namespace string, name string) string
+}
+
+var _ AccessClientInterface = &AccessClient{}
+
+type AccessClient struct {
+	logger     logr.Logger
+	cfClient   *cloudflare.API
+	accountId  string
+	tunnelName string
+}
+
+func NewAccessClient(logger logr.Logger, cfClient *cloudflare.API, accountId string, tunnelName string) *AccessClient {
+	return &AccessClient{logger: logger, cfClient: cfClient, accountId: accountId, tunnelName: tunnelName}
+}
+
+// AccessApplicationName includes the tunnel name, so two clusters sharing one
+// Cloudflare account do not collide on the same namespace and object name.
+func (a *AccessClient) AccessApplicationName(namespace string, name string) string {
+	return fmt.Sprintf("ctic:%s:%s/%s", a.tunnelName, namespace, name)
+}
+
+func (a *AccessClient) PutAccessApplication(ctx context.Context, application AccessApplication) (AccessApplicationRef, error) {
+	if len(application.Hostnames) == 0 {
+		return AccessApplicationRef{}, errors.Errorf("access application %s has no hostname", application.Name)
+	}
+
+	if err := a.ensureTag(ctx); err != nil {
+		return AccessApplicationRef{}, errors.Wrap(err, "ensure access tag")
+	}
+
+	existing, found, err := a.findApplication(ctx, application.ID, application.Name)
+	if err != nil {
+		return AccessApplicationRef{}, errors.Wrapf(err, "find access application %s", application.Name)
+	}
+
+	var destinations []cloudflare.AccessDestination
+	for _, hostname := range application.Hostnames {
+		destinations = append(destinations, cloudflare.AccessDestination{Type: cloudflare.AccessDestinationPublic, URI: hostname})
+	}
+
+	var result cloudflare.AccessApplication
+	if found {
+		result, err = a.cfClient.UpdateAccessApplication(ctx, cloudflare.AccountIdentifier(a.accountId), cloudflare.UpdateAccessApplicationParams{
+			ID:                     existing.ID,
+			Name:                   application.Name,
+			Type:                   cloudflare.SelfHosted,
+			Domain:                 application.Hostnames[0],
+			Destinations:           destinations,
+			SessionDuration:        application.SessionDuration,
+			AllowedIdps:            application.AllowedIdentityProviders,
+			AutoRedirectToIdentity: application.AutoRedirectToIdentity,
+			Tags:                   []string{AccessApplicationTag},
+		})
+		if err != nil {
+			metrics.CloudflareAPIErrors.WithLabelValues("update_access_application").Inc()
+			return AccessApplicationRef{}, errors.Wrapf(err, "update access application %s", application.Name)
+		}
+	} else {
+		a.logger.Info("create access application", "name", application.Name, "hostnames", application.Hostnames)
+		result, err = a.cfClient.CreateAccessApplication(ctx, cloudflare.AccountIdentifier(a.accountId), cloudflare.CreateAccessApplicationParams{
+			Name:                   application.Name,
+			Type:                   cloudflare.SelfHosted,
+			Domain:                 application.Hostnames[0],
+			Destinations:           destinations,
+			SessionDuration:        application.SessionDuration,
+			AllowedIdps:            application.AllowedIdentityProviders,
+			AutoRedirectToIdentity: application.AutoRedirectToIdentity,
+			Tags:                   []string{AccessApplicationTag},
+		})
+		if err != nil {
+			metrics.CloudflareAPIErrors.WithLabelValues("create_access_application").Inc()
+			return AccessApplicationRef{}, errors.Wrapf(err, "create access application %s", application.Name)
+		}
+	}
+
+	if err := a.syncPolicies(ctx, result.ID, application.Policies); err != nil {
+		return AccessApplicationRef{ID: result.ID, AUD: result.AUD}, errors.Wrapf(err, "sync policies of access application %s", application.Name)
+	}
+
+	return AccessApplicationRef{ID: result.ID, AUD: result.AUD}, nil
+}
+
+func (a *AccessClient) DeleteAccessApplication(ctx context.Context, id string, name string) error {
+	existing, found, err := a.findApplication(ctx, id, name)
+	if err != nil {
+		return errors.Wrapf(err, "find access application %s", name)
+	}
+	if !found {
+		return nil
+	}
+
+	a.logger.Info("delete access application", "name", name, "id", existing.ID)
+	err = a.cfClient.DeleteAccessApplication(ctx, cloudflare.AccountIdentifier(a.accountId), existing.ID)
+	if err != nil && !isNotFound(err) {
+		metrics.CloudflareAPIErrors.WithLabelValues("delete_access_application").Inc()
+		return errors.Wrapf(err, "delete access application %s", name)
+	}
+	return nil
+}
+
+func (a *AccessClient) ensureTag(ctx context.Context) error {
+	_, err := a.cfClient.GetAccessTag(ctx, cloudflare.AccountIdentifier(a.accountId), AccessApplicationTag)
+	if err == nil {
+		return nil
+	}
+	if !isNotFound(err) {
+		metrics.CloudflareAPIErrors.WithLabelValues("get_access_tag").Inc()
+		return errors.Wrapf(err, "get access tag %s", AccessApplicationTag)
+	}
+	_, err = a.cfClient.CreateAccessTag(ctx, cloudflare.AccountIdentifier(a.accountId), cloudflare.CreateAccessTagParams{Name: AccessApplicationTag})
+	if err != nil {
+		metrics.CloudflareAPIErrors.WithLabelValues("create_access_tag").Inc()
+		return errors.Wrapf(err, "create access tag %s", AccessApplicationTag)
+	}
+	return nil
+}
+
+// findApplication looks the application up by ID first, and falls back to
+// its deterministic name plus the ownership tag, so an application whose ID
+// got lost from the status is adopted instead of duplicated.
+func (a *AccessClient) findApplication(ctx context.Context, id string, name string) (cloudflare.AccessApplication, bool, error) {
+	if id != "" {
+		application, err := a.cfClient.GetAccessApplication(ctx, cloudflare.AccountIdentifier(a.accountId), id)
+		if err == nil {
+			return application, true, nil
+		}
+		if !isNotFound(err) {
+			metrics.CloudflareAPIErrors.WithLabelValues("get_access_application").Inc()
+			return cloudflare.AccessApplication{}, false, errors.Wrapf(err, "get access application %s", id)
+		}
+	}
+
+	applications, err := listAllPages(accessPageSize, func(page cloudflare.ResultInfo) ([]cloudflare.AccessApplication, *cloudflare.ResultInfo, error) {
+		return a.cfClient.ListAccessApplications(ctx, cloudflare.AccountIdentifier(a.accountId), cloudflare.ListAccessApplicationsParams{ResultInfo: page})
+	})
+	if err != nil {
+		metrics.CloudflareAPIErrors.WithLabelValues("list_access_applications").Inc()
+		return cloudflare.AccessApplication{}, false, errors.Wrap(err, "list access applications")
+	}
+	for _, application := range applications {
+		if application.Name == name && slices.Contains(application.Tags, AccessApplicationTag) {
+			return application, true, nil
+		}
+	}
+	return cloudflare.AccessApplication{}, false, nil
+}
+
+// syncPolicies replaces the policies of the application with the desired
+// ones, matched by name. Policies are updated in place before stale ones are
+// deleted, so the application is never left without a policy in between.
+func (a *AccessClient) syncPolicies(ctx context.Context, applicationID string, desired []AccessPolicy) error {
+	existing, err := listAllPages(accessPageSize, func(page cloudflare.ResultInfo) ([]cloudflare.AccessPolicy, *cloudflare.ResultInfo, error) {
+		return a.cfClient.ListAccessPolicies(ctx, cloudflare.AccountIdentifier(a.accountId), cloudflare.ListAccessPoliciesParams{ApplicationID: applicationID, ResultInfo: page})
+	})
+	if err != nil {
+		metrics.CloudflareAPIErrors.WithLabelValues("list_access_policies").Inc()
+		return errors.Wrap(err, "list access policies")
+	}
+
+	existingByName := map[string]cloudflare.AccessPolicy{}
+	for _, policy := range existing {
+		existingByName[policy.Name] = policy
+	}
+
+	for index, policy := range desired {
+		precedence := index + 1
+		include := renderAccessRules(policy.Include)
+		require := renderAccessRules(policy.Require)
+		exclude := renderAccessRules(policy.Exclude)
+
+		if old, ok := existingByName[policy.Name]; ok {
+			delete(existingByName, policy.Name)
+			_, err := a.cfClient.UpdateAccessPolicy(ctx, cloudflare.AccountIdentifier(a.accountId), cloudflare.UpdateAccessPolicyParams{
+				ApplicationID: applicationID,
+				PolicyID:      old.ID,
+				Precedence:    precedence,
+				Decision:      policy.Decision,
+				Name:          policy.Name,
+				Include:       include,
+				Require:       require,
+				Exclude:       exclude,
+			})
+			if err != nil {
+				metrics.CloudflareAPIErrors.WithLabelValues("update_access_policy").Inc()
+				return errors.Wrapf(err, "update access policy %s", policy.Name)
+			}
+			continue
+		}
+
+		_, err := a.cfClient.CreateAccessPolicy(ctx, cloudflare.AccountIdentifier(a.accountId), cloudflare.CreateAccessPolicyParams{
+			ApplicationID: applicationID,
+			Precedence:    precedence,
+			Decision:      policy.Decision,
+			Name:          policy.Name,
+			Include:       include,
+			Require:       require,
+			Exclude:       exclude,
+		})
+		if err != nil {
+			metrics.CloudflareAPIErrors.WithLabelValues("create_access_policy").Inc()
+			return errors.Wrapf(err, "create access policy %s", policy.Name)
+		}
+	}
+
+	for _, stale := range existingByName {
+		err := a.cfClient.DeleteAccessPolicy(ctx, cloudflare.AccountIdentifier(a.accountId), cloudflare.DeleteAccessPolicyParams{
+			ApplicationID: applicationID,
+			PolicyID:      stale.ID,
+		})
+		if err != nil && !isNotFound(err) {
+			metrics.CloudflareAPIErrors.WithLabelValues("delete_access_policy").Inc()
+			return errors.Wrapf(err, "delete access policy %s", stale.Name)
+		}
+	}
+	return nil
+}
+
+// renderAccessRules converts a rule set into the rule objects of the Access
+// API. The API expects an empty list rather than null for an empty set.
+func renderAccessRules(rules AccessRules) []interface{} {
+	result := []interface{}{}
+	for _, email := range rules.Emails {
+		rule := cloudflare.AccessGroupEmail{}
+		rule.Email.Email = email
+		result = append(result, rule)
+	}
+	for _, domain := range rules.EmailDomains {
+		rule := cloudflare.AccessGroupEmailDomain{}
+		rule.EmailDomain.Domain = domain
+		result = append(result, rule)
+	}
+	for _, group := range rules.Groups {
+		rule := cloudflare.AccessGroupAccessGroup{}
+		rule.Group.ID = group
+		result = append(result, rule)
+	}
+	for _, ipRange := range rules.IPRanges {
+		rule := cloudflare.AccessGroupIP{}
+		rule.IP.IP = ipRange
+		result = append(result, rule)
+	}
+	for _, token := range rules.ServiceTokens {
+		rule := cloudflare.AccessGroupServiceToken{}
+		rule.ServiceToken.ID = token
+		result = append(result, rule)
+	}
+	if rules.AnyValidServiceToken {
+		result = append(result, cloudflare.AccessGroupAnyValidServiceToken{})
+	}
+	return result
+}
+
+func isNotFound(err error) bool {
+	var notFound *cloudflare.NotFoundError
+	return errors.As(err, &notFound)
+}
@@ -0,0 +1,41 @@
+package cloudflarecontroller
+
+import (
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessApplicationName(t *testing.T) {
+	client := NewAccessClient(logr.Discard(), nil, "account", "cluster-a")
+	assert.Equal(t, "ctic:cluster-a:monitoring/grafana", client.AccessApplicationName("monitoring", "grafana"))
+}
+
+func Test_renderAccessRules(t *testing.T) {
+	email := cloudflare.AccessGroupEmail{}
+	email.Email.Email = "alice@example.com"
+	domain := cloudflare.AccessGroupEmailDomain{}
+	domain.EmailDomain.Domain = "example.com"
+	group := cloudflare.AccessGroupAccessGroup{}
+	group.Group.ID = "group-id"
+	ip := cloudflare.AccessGroupIP{}
+	ip.IP.IP = "10.0.0.0/8"
+	token := cloudflare.AccessGroupServiceToken{}
+	token.ServiceToken.ID = "token-id"
+
+	got := renderAccessRules(AccessRules{
+		Emails:               []string{"alice@example.com"},
+		EmailDomains:         []string{"example.com"},
+		Groups:               []string{"group-id"},
+		IPRanges:             []string{"10.0.0.0/8"},
+		ServiceTokens:        []string{"token-id"},
+		AnyValidServiceToken: true,
+	})
+	assert.Equal(t, []interface{}{email, domain, group, ip, token, cloudflare.AccessGroupAnyValidServiceToken{}}, got)
+
+	// an empty rule set must render as an empty list, not null, so the API
+	// clears require and exclude on update
+	assert.Equal(t, []interface{}{}, renderAccessRules(AccessRules{}))
+}
@@ -0,0 +1,239 @@
+package cloudflarecontroller
+
+import (
+	"context"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/metrics"
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultMinRetryDelay = time.Second
+	defaultMaxRetryDelay = 30 * time.Second
+)
+
+// APIBudget is the token bucket every Cloudflare API request of the
+// controller draws from, whatever the client or the tunnel. A response asking
+// to retry later pauses the whole budget.
+type APIBudget struct {
+	limiter *rate.Limiter
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+// NewAPIBudget allows requestsPerSecond on average and burst requests at
+// once, Cloudflare allows 1200 requests per 5 minutes, or 4 per second.
+func NewAPIBudget(requestsPerSecond float64, burst int) *APIBudget {
+	return &APIBudget{limiter: rate.NewLimiter(rate.Limit(requestsPerSecond), burst)}
+}
+
+// wait blocks until the budget allows one more request.
+func (b *APIBudget) wait(ctx context.Context) error {
+	start := time.Now()
+	b.mu.Lock()
+	pause := time.Until(b.pausedUntil)
+	b.mu.Unlock()
+	if err := sleep(ctx, pause); err != nil {
+		return err
+	}
+	if err := b.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	metrics.CloudflareAPIThrottleWait.Add(time.Since(start).Seconds())
+	metrics.CloudflareAPIBudgetRemaining.Set(b.limiter.Tokens())
+	return nil
+}
+
+func (b *APIBudget) pause(until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+}
+
+// APIClientOptions configures the Cloudflare API clients.
+type APIClientOptions struct {
+	// Budget limits the requests of the client, nil does not limit them.
+	Budget *APIBudget
+	// MaxRetries is how many times a request failing with a 429, a 5xx or a
+	// network error is retried.
+	MaxRetries int
+	// MinRetryDelay and MaxRetryDelay bound the exponential backoff between
+	// the retries, zero uses 1s and 30s. A Retry-After longer than
+	// MaxRetryDelay fails the request at once.
+	MinRetryDelay time.Duration
+	MaxRetryDelay time.Duration
+	// BaseURL replaces https://api.cloudflare.com/client/v4, eg. to use a
+	// local emulator, empty keeps it.
+	BaseURL string
+}
+
+// NewAPIClient creates a Cloudflare API client retrying and limiting its
+// requests as the options tell, in place of the retries of cloudflare-go.
+// Every request authenticates with the current credentials of the source.
+func NewAPIClient(source CredentialSource, options APIClientOptions) (*cloudflare.API, error) {
+	credentials, err := source.Credentials()
+	if err != nil {
+		return nil, errors.Wrap(err, "get cloudflare credentials")
+	}
+	if err := credentials.Validate(); err != nil {
+		return nil, err
+	}
+
+	transport := newRetryTransport(&authTransport{next: http.DefaultTransport, source: source}, options)
+	clientOptions := []cloudflare.Option{
+		cloudflare.HTTPClient(&http.Client{Transport: transport}),
+		cloudflare.UsingRetryPolicy(0, 0, 0),
+		cloudflare.UsingRateLimit(math.Inf(1)),
+	}
+	if options.BaseURL != "" {
+		clientOptions = append(clientOptions, cloudflare.BaseURL(strings.TrimSuffix(options.BaseURL, "/")))
+	}
+	if credentials.mode() == AuthModeAPIKey {
+		return cloudflare.New(credentials.APIKey, credentials.APIEmail, clientOptions...)
+	}
+	return cloudflare.NewWithAPIToken(credentials.APIToken, clientOptions...)
+}
+
+type retryTransport struct {
+	next    http.RoundTripper
+	options APIClientOptions
+}
+
+func newRetryTransport(next http.RoundTripper, options APIClientOptions) *retryTransport {
+	if options.MinRetryDelay <= 0 {
+		options.MinRetryDelay = defaultMinRetryDelay
+	}
+	if options.MaxRetryDelay <= 0 {
+		options.MaxRetryDelay = defaultMaxRetryDelay
+	}
+	return &retryTransport{next: next, options: options}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if t.options.Budget != nil {
+			if err := t.options.Budget.wait(ctx); err != nil {
+				return nil, errors.Wrap(err, "wait for the cloudflare api budget")
+			}
+		}
+
+		attemptReq := req
+		if attempt > 0 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, errors.Wrap(err, "rewind request body")
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+		resp, err := t.next.RoundTrip(attemptReq)
+
+		reason, delay := t.retryDelay(req, resp, err, attempt)
+		if reason == "" {
+			return resp, err
+		}
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests && t.options.Budget != nil {
+			t.options.Budget.pause(time.Now().Add(delay))
+		}
+		if attempt >= t.options.MaxRetries || delay > t.options.MaxRetryDelay || (req.Body != nil && req.GetBody == nil) || ctx.Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		metrics.CloudflareAPIRetries.WithLabelValues(reason).Inc()
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryDelay returns why the request is retried and after how long, an empty
+// reason when it is not. The Retry-After of the response wins over the
+// backoff. A POST may have created something before failing, it is only
+// retried when it was rate limited or never sent.
+func (t *retryTransport) retryDelay(req *http.Request, resp *http.Response, err error, attempt int) (string, time.Duration) {
+	replayable := req.Method != http.MethodPost
+	var reason string
+	switch {
+	case err != nil:
+		if !replayable && !isDialError(err) {
+			return "", 0
+		}
+		reason = "error"
+	case resp.StatusCode == http.StatusTooManyRequests:
+		reason = strconv.Itoa(resp.StatusCode)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		if !replayable {
+			return "", 0
+		}
+		reason = strconv.Itoa(resp.StatusCode)
+	default:
+		return "", 0
+	}
+	if resp != nil {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return reason, delay
+		}
+	}
+
+	// exponential backoff with jitter, so the reconciles failing together
+	// do not retry together
+	backoff := t.options.MinRetryDelay << min(attempt, 30)
+	if backoff <= 0 || backoff > t.options.MaxRetryDelay {
+		backoff = t.options.MaxRetryDelay
+	}
+	return reason, backoff/2 + rand.N(backoff/2+1)
+}
+
+// isDialError reports whether the connection could not be opened, so the
+// request never reached the API.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// parseRetryAfter parses the seconds or the HTTP date of a Retry-After header.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, duration time.Duration) error {
+	if duration <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
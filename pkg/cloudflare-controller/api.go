@@ -0,0 +1,76 @@
+package cloudflarecontroller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/pkg/errors"
+)
+
+// CloudflareAPI is the part of the Cloudflare API the tunnel client uses:
+// tunnels, their configuration, zones and DNS records. NewCloudflareAPI
+// implements it with cloudflare-go, package fake in memory.
+type CloudflareAPI interface {
+	ListTunnels(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.TunnelListParams) ([]cloudflare.Tunnel, *cloudflare.ResultInfo, error)
+	CreateTunnel(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.TunnelCreateParams) (cloudflare.Tunnel, error)
+	GetTunnelToken(ctx context.Context, rc *cloudflare.ResourceContainer, tunnelID string) (string, error)
+	GetTunnelConfiguration(ctx context.Context, rc *cloudflare.ResourceContainer, tunnelID string) (cloudflare.TunnelConfigurationResult, error)
+	UpdateTunnelConfiguration(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.TunnelConfigurationParams) (cloudflare.TunnelConfigurationResult, error)
+
+	// ListZonesPage lists one page of the zones the credentials can read.
+	ListZonesPage(ctx context.Context, page cloudflare.ResultInfo) ([]cloudflare.Zone, *cloudflare.ResultInfo, error)
+
+	ListDNSRecords(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error)
+	// ListDNSRecordsWithPrefix lists one page of the records of the type
+	// whose name starts with the prefix.
+	ListDNSRecordsWithPrefix(ctx context.Context, rc *cloudflare.ResourceContainer, recordType string, prefix string, page cloudflare.ResultInfo) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error)
+	CreateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.CreateDNSRecordParams) (cloudflare.DNSRecord, error)
+	UpdateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.UpdateDNSRecordParams) (cloudflare.DNSRecord, error)
+	DeleteDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, recordID string) error
+}
+
+// NewCloudflareAPI returns the CloudflareAPI sending the requests with the
+// client.
+func NewCloudflareAPI(cfClient *cloudflare.API) CloudflareAPI {
+	return cloudflareAPI{API: cfClient}
+}
+
+// cloudflareAPI adds the listings cloudflare-go lacks: ListZones of
+// cloudflare-go fetches the pages concurrently and fails when the zone count
+// changes in between, and it has no name prefix filter for DNS records.
+type cloudflareAPI struct {
+	*cloudflare.API
+}
+
+func (c cloudflareAPI) ListZonesPage(ctx context.Context, page cloudflare.ResultInfo) ([]cloudflare.Zone, *cloudflare.ResultInfo, error) {
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(page.Page))
+	query.Set("per_page", strconv.Itoa(page.PerPage))
+	return rawList[cloudflare.Zone](ctx, c.API, "/zones?"+query.Encode())
+}
+
+func (c cloudflareAPI) ListDNSRecordsWithPrefix(ctx context.Context, rc *cloudflare.ResourceContainer, recordType string, prefix string, page cloudflare.ResultInfo) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error) {
+	query := url.Values{}
+	query.Set("type", recordType)
+	query.Set("name.startswith", prefix)
+	query.Set("page", strconv.Itoa(page.Page))
+	query.Set("per_page", strconv.Itoa(page.PerPage))
+	return rawList[cloudflare.DNSRecord](ctx, c.API, fmt.Sprintf("/zones/%s/dns_records?%s", rc.Identifier, query.Encode()))
+}
+
+func rawList[T any](ctx context.Context, cfClient *cloudflare.API, endpoint string) ([]T, *cloudflare.ResultInfo, error) {
+	response, err := cfClient.Raw(ctx, http.MethodGet, endpoint, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var items []T
+	if err := json.Unmarshal(response.Result, &items); err != nil {
+		return nil, nil, errors.Wrapf(err, "unmarshal result of %s", endpoint)
+	}
+	return items, response.ResultInfo, nil
+}
@@ -0,0 +1,206 @@
+package cloudflarecontroller
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/metrics"
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyAPI answers the requests with the queued failures first, then with a
+// tunnel.
+type flakyAPI struct {
+	mu       sync.Mutex
+	failures []func(w http.ResponseWriter)
+	requests []time.Time
+	bodies   []string
+}
+
+func (f *flakyAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	f.requests = append(f.requests, time.Now())
+	f.bodies = append(f.bodies, string(body))
+	var failure func(w http.ResponseWriter)
+	if len(f.failures) > 0 {
+		failure, f.failures = f.failures[0], f.failures[1:]
+	}
+	f.mu.Unlock()
+
+	if failure != nil {
+		failure(w)
+		return
+	}
+	_, _ = w.Write([]byte(`{"success":true,"errors":[],"messages":[],"result":{"id":"tunnel-id","name":"tunnel"}}`))
+}
+
+func failWith(status int, retryAfter string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":10000,"message":"try again"}],"messages":[],"result":null}`))
+	}
+}
+
+func newFlakyAPIClient(t *testing.T, api *flakyAPI, options APIClientOptions) *cloudflare.API {
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	cfClient, err := cloudflare.NewWithAPIToken("token",
+		cloudflare.BaseURL(server.URL),
+		cloudflare.HTTPClient(&http.Client{Transport: newRetryTransport(http.DefaultTransport, options)}),
+		cloudflare.UsingRetryPolicy(0, 0, 0),
+		cloudflare.UsingRateLimit(math.Inf(1)),
+	)
+	require.NoError(t, err)
+	return cfClient
+}
+
+func TestRetryTransportRetries(t *testing.T) {
+	api := &flakyAPI{failures: []func(w http.ResponseWriter){
+		failWith(http.StatusServiceUnavailable, ""),
+		failWith(http.StatusInternalServerError, ""),
+	}}
+	cfClient := newFlakyAPIClient(t, api, APIClientOptions{MaxRetries: 3, MinRetryDelay: time.Millisecond, MaxRetryDelay: 10 * time.Millisecond})
+	retries := testutil.ToFloat64(metrics.CloudflareAPIRetries.WithLabelValues("503"))
+
+	tunnel, err := cfClient.GetTunnel(context.Background(), cloudflare.AccountIdentifier("account"), "tunnel-id")
+	require.NoError(t, err)
+	assert.Equal(t, "tunnel-id", tunnel.ID)
+	require.Len(t, api.requests, 3)
+	assert.Equal(t, retries+1, testutil.ToFloat64(metrics.CloudflareAPIRetries.WithLabelValues("503")))
+}
+
+func TestRetryTransportPosts(t *testing.T) {
+	api := &flakyAPI{failures: []func(w http.ResponseWriter){
+		failWith(http.StatusTooManyRequests, ""),
+		failWith(http.StatusTooManyRequests, ""),
+	}}
+	cfClient := newFlakyAPIClient(t, api, APIClientOptions{MaxRetries: 3, MinRetryDelay: time.Millisecond, MaxRetryDelay: 10 * time.Millisecond})
+	params := cloudflare.TunnelCreateParams{Name: "tunnel", Secret: "secret"}
+
+	tunnel, err := cfClient.CreateTunnel(context.Background(), cloudflare.AccountIdentifier("account"), params)
+	require.NoError(t, err)
+	assert.Equal(t, "tunnel-id", tunnel.ID)
+	require.Len(t, api.requests, 3, "a rate limited post was not processed")
+	assert.Equal(t, api.bodies[0], api.bodies[2], "the body is sent again")
+	assert.NotEmpty(t, api.bodies[2])
+
+	// the tunnel may have been created before the server failed
+	api.failures = []func(w http.ResponseWriter){failWith(http.StatusBadGateway, "")}
+	api.requests = nil
+	_, err = cfClient.CreateTunnel(context.Background(), cloudflare.AccountIdentifier("account"), params)
+	require.Error(t, err)
+	assert.Len(t, api.requests, 1)
+
+	// a post that could not connect was never sent
+	server := httptest.NewServer(api)
+	server.Close()
+	var attempts int
+	transport := newRetryTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return http.DefaultTransport.RoundTrip(req)
+	}), APIClientOptions{MaxRetries: 2, MinRetryDelay: time.Millisecond, MaxRetryDelay: 10 * time.Millisecond})
+	unreachable, err := cloudflare.NewWithAPIToken("token",
+		cloudflare.BaseURL(server.URL),
+		cloudflare.HTTPClient(&http.Client{Transport: transport}),
+		cloudflare.UsingRetryPolicy(0, 0, 0),
+	)
+	require.NoError(t, err)
+	_, err = unreachable.CreateTunnel(context.Background(), cloudflare.AccountIdentifier("account"), params)
+	require.Error(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRetryTransportGivesUp(t *testing.T) {
+	api := &flakyAPI{failures: []func(w http.ResponseWriter){
+		failWith(http.StatusBadGateway, ""),
+		failWith(http.StatusBadGateway, ""),
+		failWith(http.StatusBadGateway, ""),
+	}}
+	cfClient := newFlakyAPIClient(t, api, APIClientOptions{MaxRetries: 1, MinRetryDelay: time.Millisecond, MaxRetryDelay: 10 * time.Millisecond})
+
+	_, err := cfClient.GetTunnel(context.Background(), cloudflare.AccountIdentifier("account"), "tunnel-id")
+	require.Error(t, err)
+	assert.Len(t, api.requests, 2)
+
+	// a client error is not retried
+	api.failures = []func(w http.ResponseWriter){failWith(http.StatusForbidden, "")}
+	api.requests = nil
+	_, err = cfClient.GetTunnel(context.Background(), cloudflare.AccountIdentifier("account"), "tunnel-id")
+	require.Error(t, err)
+	assert.Len(t, api.requests, 1)
+}
+
+func TestRetryTransportRetryAfter(t *testing.T) {
+	api := &flakyAPI{failures: []func(w http.ResponseWriter){failWith(http.StatusTooManyRequests, "1")}}
+	budget := NewAPIBudget(1000, 10)
+	cfClient := newFlakyAPIClient(t, api, APIClientOptions{Budget: budget, MaxRetries: 3, MinRetryDelay: time.Millisecond, MaxRetryDelay: 5 * time.Second})
+
+	_, err := cfClient.GetTunnel(context.Background(), cloudflare.AccountIdentifier("account"), "tunnel-id")
+	require.NoError(t, err)
+	require.Len(t, api.requests, 2)
+	assert.GreaterOrEqual(t, api.requests[1].Sub(api.requests[0]), time.Second, "the retry waits for the Retry-After")
+	assert.False(t, budget.pausedUntil.IsZero(), "a 429 pauses the shared budget")
+
+	// a Retry-After beyond the longest delay fails at once
+	api.failures = []func(w http.ResponseWriter){failWith(http.StatusTooManyRequests, "60")}
+	api.requests = nil
+	start := time.Now()
+	_, err = cfClient.GetTunnel(context.Background(), cloudflare.AccountIdentifier("account"), "tunnel-id")
+	require.Error(t, err)
+	assert.Len(t, api.requests, 1)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestAPIBudgetIsShared(t *testing.T) {
+	api := &flakyAPI{}
+	budget := NewAPIBudget(20, 1)
+	options := APIClientOptions{Budget: budget}
+	clients := []*cloudflare.API{newFlakyAPIClient(t, api, options), newFlakyAPIClient(t, api, options)}
+	waited := testutil.ToFloat64(metrics.CloudflareAPIThrottleWait)
+
+	start := time.Now()
+	for i := range 6 {
+		_, err := clients[i%2].GetTunnel(context.Background(), cloudflare.AccountIdentifier("account"), "tunnel-id")
+		require.NoError(t, err)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond, "5 requests beyond the burst at 20 per second")
+	assert.Greater(t, testutil.ToFloat64(metrics.CloudflareAPIThrottleWait), waited)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	delay, ok := parseRetryAfter("3")
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, delay)
+
+	delay, ok = parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.True(t, ok)
+	assert.InDelta(t, time.Minute, delay, float64(2*time.Second))
+
+	delay, ok = parseRetryAfter(time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat))
+	assert.True(t, ok)
+	assert.Zero(t, delay)
+
+	_, ok = parseRetryAfter("")
+	assert.False(t, ok)
+	_, ok = parseRetryAfter("soon")
+	assert.False(t, ok)
+}
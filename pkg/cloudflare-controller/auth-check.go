@@ -0,0 +1,211 @@
+package cloudflarecontroller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+)
+
+// Permission is a permission the controller needs, named as in the API
+// token form of the dashboard.
+type Permission string
+
+const (
+	PermissionTunnelEdit Permission = "Account:Cloudflare Tunnel:Edit"
+	PermissionZoneRead   Permission = "Zone:Zone:Read"
+	PermissionDNSEdit    Permission = "Zone:DNS:Edit"
+
+	// the read permissions are included in the edit ones
+	PermissionTunnelRead Permission = "Account:Cloudflare Tunnel:Read"
+	PermissionDNSRead    Permission = "Zone:DNS:Read"
+)
+
+// RequiredPermissions are the permissions the controller needs, Cloudflare
+// Access needs more.
+var RequiredPermissions = []Permission{PermissionTunnelEdit, PermissionZoneRead, PermissionDNSEdit}
+
+// permissionGroups are the names of the API permission groups granting the
+// permission.
+var permissionGroups = map[Permission][]string{
+	PermissionTunnelEdit: {"Cloudflare Tunnel Write", "Argo Tunnel Write"},
+	PermissionZoneRead:   {"Zone Read", "Zone Write"},
+	PermissionDNSEdit:    {"DNS Write"},
+}
+
+// readPermissionGroups are the names of the API permission groups known to
+// grant none of the required permissions. A token with a group neither of
+// them names may hold a permission under a name the controller does not
+// know, the missing permissions are then only unchecked.
+var readPermissionGroups = []string{"Cloudflare Tunnel Read", "Argo Tunnel Read", "DNS Read"}
+
+// AuthReport is the outcome of CheckAuth.
+type AuthReport struct {
+	Mode AuthMode
+	// Missing are the permissions the credentials lack.
+	Missing []Permission
+	// Unchecked are the permissions that could not be checked, an API token
+	// usually cannot read its own policies, and may hold permission groups
+	// the controller does not know.
+	Unchecked []Permission
+}
+
+// CheckAuth verifies the credentials, then reports the permissions they lack
+// by listing tunnels, zones and DNS records and by reading the policies of
+// the API token when allowed. It fails only when the credentials are invalid
+// or the API cannot be reached.
+func CheckAuth(ctx context.Context, logger logr.Logger, cfClient *cloudflare.API, source CredentialSource, accountId string) (AuthReport, error) {
+	credentials, err := source.Credentials()
+	if err != nil {
+		return AuthReport{}, errors.Wrap(err, "get cloudflare credentials")
+	}
+	report := AuthReport{Mode: credentials.mode()}
+
+	logger.V(3).Info("verify cloudflare credentials", "mode", report.Mode)
+	tokenID, err := verifyCredentials(ctx, cfClient, report.Mode, accountId)
+	if err != nil {
+		return AuthReport{}, errors.Wrapf(err, "verify cloudflare credentials of mode %s", report.Mode)
+	}
+
+	cloudflareAPI := NewCloudflareAPI(cfClient)
+	_, _, err = cloudflareAPI.ListTunnels(ctx, cloudflare.AccountIdentifier(accountId), cloudflare.TunnelListParams{ResultInfo: cloudflare.ResultInfo{Page: 1, PerPage: 1}})
+	if err := report.probe(PermissionTunnelEdit, err); err != nil {
+		return AuthReport{}, errors.Wrap(err, "list tunnels")
+	}
+	zones, _, err := cloudflareAPI.ListZonesPage(ctx, cloudflare.ResultInfo{Page: 1, PerPage: 1})
+	if err := report.probe(PermissionZoneRead, err); err != nil {
+		return AuthReport{}, errors.Wrap(err, "list zones")
+	}
+	if err == nil && len(zones) == 0 {
+		report.Missing = append(report.Missing, PermissionZoneRead)
+	}
+	if len(zones) > 0 {
+		_, _, err = cloudflareAPI.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zones[0].ID), cloudflare.ListDNSRecordsParams{ResultInfo: cloudflare.ResultInfo{Page: 1, PerPage: 1}})
+		if err := report.probe(PermissionDNSEdit, err); err != nil {
+			return AuthReport{}, errors.Wrapf(err, "list dns records of zone %s", zones[0].Name)
+		}
+	}
+
+	// the listings only prove read access, the policies of the token tell
+	// about edit access. A Global API Key has every permission of its user.
+	if report.Mode == AuthModeAPIKey {
+		return report, nil
+	}
+	token, err := getAPIToken(ctx, cfClient, report.Mode, accountId, tokenID)
+	for _, permission := range RequiredPermissions {
+		if slices.Contains(report.Missing, permission) {
+			continue
+		}
+		if err != nil {
+			if permission != PermissionZoneRead {
+				report.Unchecked = append(report.Unchecked, permission)
+			}
+			continue
+		}
+		switch {
+		case grants(token, permission):
+		case hasUnknownGroup(token):
+			report.Unchecked = append(report.Unchecked, permission)
+		default:
+			report.Missing = append(report.Missing, permission)
+		}
+	}
+	if err != nil {
+		logger.V(3).Info("api token cannot read its own policies", "error", err.Error())
+	}
+	return report, nil
+}
+
+// probe records the permission as missing when the request was forbidden,
+// and returns the other errors.
+func (r *AuthReport) probe(permission Permission, err error) error {
+	// cloudflare-go returns an AuthenticationError for a 403
+	var forbiddenErr *cloudflare.AuthenticationError
+	if errors.As(err, &forbiddenErr) {
+		r.Missing = append(r.Missing, permission)
+		return nil
+	}
+	return err
+}
+
+// verifyCredentials returns the ID of the API token, empty for an API key.
+func verifyCredentials(ctx context.Context, cfClient *cloudflare.API, mode AuthMode, accountId string) (string, error) {
+	var verified cloudflare.APITokenVerifyBody
+	switch mode {
+	case AuthModeAPIKey:
+		_, err := cfClient.UserDetails(ctx)
+		return "", err
+	case AuthModeAccountAPIToken:
+		response, err := cfClient.Raw(ctx, http.MethodGet, fmt.Sprintf("/accounts/%s/tokens/verify", accountId), nil, nil)
+		if err != nil {
+			return "", err
+		}
+		if err := json.Unmarshal(response.Result, &verified); err != nil {
+			return "", errors.Wrap(err, "unmarshal token verification")
+		}
+	default:
+		var err error
+		verified, err = cfClient.VerifyAPIToken(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
+	if verified.Status != "active" {
+		return "", errors.Errorf("api token %s is %s", verified.ID, verified.Status)
+	}
+	return verified.ID, nil
+}
+
+func getAPIToken(ctx context.Context, cfClient *cloudflare.API, mode AuthMode, accountId string, tokenID string) (cloudflare.APIToken, error) {
+	if mode != AuthModeAccountAPIToken {
+		return cfClient.GetAPIToken(ctx, tokenID)
+	}
+	var token cloudflare.APIToken
+	response, err := cfClient.Raw(ctx, http.MethodGet, fmt.Sprintf("/accounts/%s/tokens/%s", accountId, tokenID), nil, nil)
+	if err != nil {
+		return token, err
+	}
+	if err := json.Unmarshal(response.Result, &token); err != nil {
+		return token, errors.Wrap(err, "unmarshal api token")
+	}
+	return token, nil
+}
+
+// hasUnknownGroup tells whether the token allows a permission group the
+// controller does not know by name.
+func hasUnknownGroup(token cloudflare.APIToken) bool {
+	for _, policy := range token.Policies {
+		if policy.Effect != "allow" {
+			continue
+		}
+		for _, group := range policy.PermissionGroups {
+			known := slices.Contains(readPermissionGroups, group.Name)
+			for _, names := range permissionGroups {
+				known = known || slices.Contains(names, group.Name)
+			}
+			if !known {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func grants(token cloudflare.APIToken, permission Permission) bool {
+	for _, policy := range token.Policies {
+		if policy.Effect != "allow" {
+			continue
+		}
+		for _, group := range policy.PermissionGroups {
+			if slices.Contains(permissionGroups[permission], group.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package cloudflarecontroller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// authAPI answers the requests of CheckAuth, with a status per path for the
+// failures.
+type authAPI struct {
+	failures map[string]int
+	groups   []string
+}
+
+func (a *authAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if status, ok := a.failures[r.URL.Path]; ok {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":10000,"message":"denied"}],"messages":[],"result":null}`))
+		return
+	}
+	var result any
+	switch r.URL.Path {
+	case "/user/tokens/verify", "/accounts/account/tokens/verify":
+		result = cloudflare.APITokenVerifyBody{ID: "token-id", Status: "active"}
+	case "/user/tokens/token-id", "/accounts/account/tokens/token-id":
+		policy := cloudflare.APITokenPolicies{Effect: "allow"}
+		for _, group := range a.groups {
+			policy.PermissionGroups = append(policy.PermissionGroups, cloudflare.APITokenPermissionGroups{Name: group})
+		}
+		result = cloudflare.APIToken{ID: "token-id", Policies: []cloudflare.APITokenPolicies{policy}}
+	case "/user":
+		result = cloudflare.User{ID: "user"}
+	case "/zones":
+		result = []cloudflare.Zone{{ID: "zone", Name: "example.com"}}
+	default:
+		result = []any{}
+	}
+	body, _ := json.Marshal(map[string]any{"success": true, "errors": []any{}, "messages": []any{}, "result": result})
+	_, _ = w.Write(body)
+}
+
+func checkAuth(t *testing.T, api *authAPI, credentials Credentials) (AuthReport, error) {
+	t.Helper()
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	cfClient, err := NewAPIClient(StaticCredentials(credentials), APIClientOptions{BaseURL: server.URL})
+	require.NoError(t, err)
+	return CheckAuth(context.Background(), logr.Discard(), cfClient, StaticCredentials(credentials), "account")
+}
+
+func TestCheckAuth(t *testing.T) {
+	token := Credentials{Mode: AuthModeAPIToken, APIToken: "token"}
+	allGroups := []string{"Cloudflare Tunnel Write", "Zone Read", "DNS Write"}
+
+	report, err := checkAuth(t, &authAPI{groups: allGroups}, token)
+	require.NoError(t, err)
+	assert.Empty(t, report.Missing)
+	assert.Empty(t, report.Unchecked)
+
+	report, err = checkAuth(t, &authAPI{groups: []string{"Cloudflare Tunnel Write", "Zone Read", "DNS Read"}}, token)
+	require.NoError(t, err)
+	assert.Equal(t, []Permission{PermissionDNSEdit}, report.Missing, "DNS Read is not enough")
+
+	report, err = checkAuth(t, &authAPI{groups: []string{"Cloudflare Tunnel Write", "Zone Read", "DNS Records Edit"}}, token)
+	require.NoError(t, err)
+	assert.Empty(t, report.Missing)
+	assert.Equal(t, []Permission{PermissionDNSEdit}, report.Unchecked, "an unknown group may grant the permission")
+
+	report, err = checkAuth(t, &authAPI{groups: allGroups, failures: map[string]int{"/accounts/account/cfd_tunnel": http.StatusForbidden}}, token)
+	require.NoError(t, err)
+	assert.Equal(t, []Permission{PermissionTunnelEdit}, report.Missing)
+
+	report, err = checkAuth(t, &authAPI{failures: map[string]int{"/user/tokens/token-id": http.StatusForbidden}}, token)
+	require.NoError(t, err)
+	assert.Empty(t, report.Missing)
+	assert.Equal(t, []Permission{PermissionTunnelEdit, PermissionDNSEdit}, report.Unchecked, "the listings prove zone read access")
+
+	_, err = checkAuth(t, &authAPI{failures: map[string]int{"/user/tokens/verify": http.StatusUnauthorized}}, token)
+	assert.Error(t, err)
+}
+
+func TestCheckAuthModes(t *testing.T) {
+	api := &authAPI{groups: []string{"Cloudflare Tunnel Write", "Zone Read", "DNS Write"}, failures: map[string]int{
+		"/user/tokens/verify": http.StatusForbidden,
+	}}
+	report, err := checkAuth(t, api, Credentials{Mode: AuthModeAccountAPIToken, APIToken: "token"})
+	require.NoError(t, err)
+	assert.Equal(t, AuthModeAccountAPIToken, report.Mode)
+	assert.Empty(t, report.Missing)
+
+	report, err = checkAuth(t, api, Credentials{Mode: AuthModeAPIKey, APIKey: "key", APIEmail: "user@example.com"})
+	require.NoError(t, err)
+	assert.Empty(t, report.Missing)
+	assert.Empty(t, report.Unchecked, "a global api key has every permission of its user")
+}
@@ -11,36 +11,60 @@ import (
 	"k8s.io/utils/ptr"
 )
 
-func BootstrapTunnelClientWithTunnelName(ctx context.Context, logger logr.Logger, cfClient *cloudflare.API, accountId string, tunnelName string, dnsCommentTemplate string) (*TunnelClient, error) {
+func BootstrapTunnelClientWithTunnelName(ctx context.Context, logger logr.Logger, cfClient CloudflareAPI, accountId string, tunnelName string, dns DNSOptions) (*TunnelClient, error) {
 	logger.V(3).Info("fetch tunnel id with tunnel name", "account-id", accountId, "tunnel-name", tunnelName)
 	tunnelId, err := GetTunnelIdFromTunnelName(ctx, logger, cfClient, tunnelName, accountId)
 	if err != nil {
 		return nil, errors.Wrapf(err, "get tunnel id from tunnel name %s", tunnelName)
 	}
 	logger.V(3).Info("tunnel id fetched", "tunnel-id", tunnelId, "tunnel-name", tunnelName, "account-id", accountId)
-	return NewTunnelClient(logger, cfClient, accountId, tunnelId, tunnelName, dnsCommentTemplate), nil
+	return NewTunnelClient(logger, cfClient, accountId, tunnelId, tunnelName, dns), nil
 }
 
-func GetTunnelIdFromTunnelName(ctx context.Context, logger logr.Logger, cfClient *cloudflare.API, tunnelName string, accountId string) (string, error) {
+// LookupTunnelClientWithTunnelName is BootstrapTunnelClientWithTunnelName
+// without creating a missing tunnel, for the callers not allowed to change
+// anything on Cloudflare.
+func LookupTunnelClientWithTunnelName(ctx context.Context, logger logr.Logger, cfClient CloudflareAPI, accountId string, tunnelName string, dns DNSOptions) (*TunnelClient, error) {
+	tunnelId, found, err := findTunnelIdByName(ctx, logger, cfClient, tunnelName, accountId)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get tunnel id from tunnel name %s", tunnelName)
+	}
+	if !found {
+		return nil, errors.Errorf("tunnel %s does not exist yet", tunnelName)
+	}
+	return NewTunnelClient(logger, cfClient, accountId, tunnelId, tunnelName, dns), nil
+}
+
+func findTunnelIdByName(ctx context.Context, logger logr.Logger, cfClient CloudflareAPI, tunnelName string, accountId string) (string, bool, error) {
 	logger.V(3).Info("list cloudflare tunnels", "account-id", accountId)
-	tunnels, _, err := cfClient.ListTunnels(ctx, cloudflare.ResourceIdentifier(accountId), cloudflare.TunnelListParams{
-		IsDeleted: ptr.To(false),
-		// FIXME: that's a workaround for https://github.com/cloudflare/cloudflare-go/issues/1247
-		ResultInfo: cloudflare.ResultInfo{
-			Page:    1,
-			PerPage: 1000,
-		},
+	tunnels, err := listAllPages(tunnelsPageSize, func(page cloudflare.ResultInfo) ([]cloudflare.Tunnel, *cloudflare.ResultInfo, error) {
+		return cfClient.ListTunnels(ctx, cloudflare.ResourceIdentifier(accountId), cloudflare.TunnelListParams{
+			Name:       tunnelName,
+			IsDeleted:  ptr.To(false),
+			ResultInfo: page,
+		})
 	})
 	logger.V(3).Info("list cloudflare tunnels complete", "account-id", accountId, "tunnels", tunnels)
 
 	if err != nil {
-		return "", errors.Wrap(err, "list cloudflare tunnels")
+		return "", false, errors.Wrap(err, "list cloudflare tunnels")
 	}
 	for _, tunnel := range tunnels {
 		if tunnel.Name == tunnelName {
-			return tunnel.ID, nil
+			return tunnel.ID, true, nil
 		}
 	}
+	return "", false, nil
+}
+
+func GetTunnelIdFromTunnelName(ctx context.Context, logger logr.Logger, cfClient CloudflareAPI, tunnelName string, accountId string) (string, error) {
+	tunnelId, found, err := findTunnelIdByName(ctx, logger, cfClient, tunnelName, accountId)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return tunnelId, nil
+	}
 
 	// create tunnel if not found
 	logger.V(3).Info("tunnel not found, create tunnel", "account-id", accountId, "tunnel-name", tunnelName)
@@ -62,3 +86,60 @@ func GetTunnelIdFromTunnelName(ctx context.Context, logger logr.Logger, cfClient
 
 	return newTunnel.ID, nil
 }
+
+// TunnelClientFactory bootstraps the client of the tunnel with the given name,
+// the tunnel is created when it does not exist yet.
+type TunnelClientFactory func(ctx context.Context, tunnelName string) (TunnelClientInterface, error)
+
+func NewTunnelClientFactory(logger logr.Logger, cfClient CloudflareAPI, accountId string, dns DNSOptions) TunnelClientFactory {
+	return func(ctx context.Context, tunnelName string) (TunnelClientInterface, error) {
+		tunnelClient, err := BootstrapTunnelClientWithTunnelName(ctx, logger, cfClient, accountId, tunnelName, dns)
+		if err != nil {
+			return nil, err
+		}
+		return tunnelClient, nil
+	}
+}
+
+// TunnelCredentials are the account and credentials a tunnel is managed with.
+type TunnelCredentials struct {
+	AccountID   string
+	Credentials CredentialSource
+}
+
+// TunnelClientProvider bootstraps the client of the tunnel with the given
+// name in the account of the credentials, the tunnel is created when it does
+// not exist yet.
+type TunnelClientProvider func(ctx context.Context, credentials TunnelCredentials, tunnelName string) (TunnelClientInterface, error)
+
+func NewTunnelClientProvider(logger logr.Logger, api APIClientOptions, dns DNSOptions) TunnelClientProvider {
+	return func(ctx context.Context, credentials TunnelCredentials, tunnelName string) (TunnelClientInterface, error) {
+		cfClient, err := NewAPIClient(credentials.Credentials, api)
+		if err != nil {
+			return nil, errors.Wrap(err, "create cloudflare client")
+		}
+		cloudflareAPI := NewCloudflareAPI(cfClient)
+		tunnelClient, err := BootstrapTunnelClientWithTunnelName(ctx, logger, cloudflareAPI, credentials.AccountID, tunnelName, dns)
+		if err != nil {
+			return nil, err
+		}
+		return tunnelClient, nil
+	}
+}
+
+// NewLookupTunnelClientProvider is NewTunnelClientProvider without creating
+// missing tunnels.
+func NewLookupTunnelClientProvider(logger logr.Logger, api APIClientOptions, dns DNSOptions) TunnelClientProvider {
+	return func(ctx context.Context, credentials TunnelCredentials, tunnelName string) (TunnelClientInterface, error) {
+		cfClient, err := NewAPIClient(credentials.Credentials, api)
+		if err != nil {
+			return nil, errors.Wrap(err, "create cloudflare client")
+		}
+		cloudflareAPI := NewCloudflareAPI(cfClient)
+		tunnelClient, err := LookupTunnelClientWithTunnelName(ctx, logger, cloudflareAPI, credentials.AccountID, tunnelName, dns)
+		if err != nil {
+			return nil, err
+		}
+		return tunnelClient, nil
+	}
+}
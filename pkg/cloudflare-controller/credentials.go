@@ -0,0 +1,184 @@
+package cloudflarecontroller
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+)
+
+// AuthMode is how the requests authenticate to the Cloudflare API.
+type AuthMode string
+
+const (
+	// AuthModeAPIToken uses an API token owned by a user.
+	AuthModeAPIToken AuthMode = "api-token"
+	// AuthModeAccountAPIToken uses an API token owned by the account, which
+	// cannot call the /user endpoints.
+	AuthModeAccountAPIToken AuthMode = "account-api-token"
+	// AuthModeAPIKey uses the Global API Key of a user with its email.
+	AuthModeAPIKey AuthMode = "api-key"
+)
+
+// Credential file names read by FileCredentials, the keys of a mounted
+// Secret.
+const (
+	APITokenFile = "api-token"
+	APIKeyFile   = "api-key"
+	APIEmailFile = "api-email"
+)
+
+// ParseAuthMode returns the mode with the name, empty is AuthModeAPIToken.
+func ParseAuthMode(name string) (AuthMode, error) {
+	switch mode := AuthMode(name); mode {
+	case "":
+		return AuthModeAPIToken, nil
+	case AuthModeAPIToken, AuthModeAccountAPIToken, AuthModeAPIKey:
+		return mode, nil
+	default:
+		return "", errors.Errorf("unknown cloudflare auth mode %q, use %s, %s or %s", name, AuthModeAPIToken, AuthModeAccountAPIToken, AuthModeAPIKey)
+	}
+}
+
+// Credentials authenticate the requests to the Cloudflare API.
+type Credentials struct {
+	// Mode is AuthModeAPIToken when empty.
+	Mode     AuthMode
+	APIToken string
+	APIKey   string
+	APIEmail string
+}
+
+func (c Credentials) mode() AuthMode {
+	if c.Mode == "" {
+		return AuthModeAPIToken
+	}
+	return c.Mode
+}
+
+// Validate checks the credentials of the mode are set.
+func (c Credentials) Validate() error {
+	if c.mode() == AuthModeAPIKey {
+		if c.APIKey == "" || c.APIEmail == "" {
+			return errors.New("cloudflare api key and email are required")
+		}
+		return nil
+	}
+	if c.APIToken == "" {
+		return errors.New("cloudflare api token is required")
+	}
+	return nil
+}
+
+func (c Credentials) apply(header http.Header) {
+	header.Del("Authorization")
+	header.Del("X-Auth-Key")
+	header.Del("X-Auth-Email")
+	header.Del("X-Auth-User-Service-Key")
+	if c.mode() == AuthModeAPIKey {
+		header.Set("X-Auth-Key", c.APIKey)
+		header.Set("X-Auth-Email", c.APIEmail)
+		return
+	}
+	header.Set("Authorization", "Bearer "+c.APIToken)
+}
+
+// CredentialSource returns the credentials of every request, so a source
+// reading them again picks up rotated credentials without a restart.
+type CredentialSource interface {
+	Credentials() (Credentials, error)
+}
+
+// StaticCredentials never change.
+type StaticCredentials Credentials
+
+func (s StaticCredentials) Credentials() (Credentials, error) {
+	return Credentials(s), nil
+}
+
+// FileCredentials reads the credentials of the mode from the files of a
+// directory, eg. a mounted Secret: api-token, or api-key and api-email. The
+// files are read again once they change.
+type FileCredentials struct {
+	logger logr.Logger
+	dir    string
+	mode   AuthMode
+
+	mu          sync.Mutex
+	credentials Credentials
+	// modTimes are the modification times the credentials were read at
+	modTimes []time.Time
+}
+
+func NewFileCredentials(logger logr.Logger, dir string, mode AuthMode) (*FileCredentials, error) {
+	source := &FileCredentials{logger: logger, dir: dir, mode: mode}
+	if _, err := source.Credentials(); err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+func (f *FileCredentials) Credentials() (Credentials, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	files := []string{APITokenFile}
+	if f.mode == AuthModeAPIKey {
+		files = []string{APIKeyFile, APIEmailFile}
+	}
+	modTimes := make([]time.Time, len(files))
+	for i, name := range files {
+		info, err := os.Stat(filepath.Join(f.dir, name))
+		if err != nil {
+			return Credentials{}, errors.Wrapf(err, "stat cloudflare credential file %s", name)
+		}
+		modTimes[i] = info.ModTime()
+	}
+	if f.modTimes != nil && slices.EqualFunc(f.modTimes, modTimes, time.Time.Equal) {
+		return f.credentials, nil
+	}
+
+	values := make([]string, len(files))
+	for i, name := range files {
+		content, err := os.ReadFile(filepath.Join(f.dir, name))
+		if err != nil {
+			return Credentials{}, errors.Wrapf(err, "read cloudflare credential file %s", name)
+		}
+		values[i] = strings.TrimSpace(string(content))
+	}
+	credentials := Credentials{Mode: f.mode, APIToken: values[0]}
+	if f.mode == AuthModeAPIKey {
+		credentials = Credentials{Mode: f.mode, APIKey: values[0], APIEmail: values[1]}
+	}
+	if err := credentials.Validate(); err != nil {
+		return Credentials{}, errors.Wrapf(err, "read cloudflare credentials from %s", f.dir)
+	}
+	if f.modTimes != nil {
+		f.logger.Info("reloaded cloudflare credentials", "dir", f.dir)
+	}
+	f.credentials = credentials
+	f.modTimes = modTimes
+	return credentials, nil
+}
+
+// authTransport sets the credentials of the source on every request.
+type authTransport struct {
+	next   http.RoundTripper
+	source CredentialSource
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	credentials, err := t.source.Credentials()
+	if err != nil {
+		return nil, errors.Wrap(err, "get cloudflare credentials")
+	}
+	req = req.Clone(req.Context())
+	credentials.apply(req.Header)
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,97 @@
+package cloudflarecontroller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAuthMode(t *testing.T) {
+	mode, err := ParseAuthMode("")
+	require.NoError(t, err)
+	assert.Equal(t, AuthModeAPIToken, mode)
+	mode, err = ParseAuthMode("api-key")
+	require.NoError(t, err)
+	assert.Equal(t, AuthModeAPIKey, mode)
+	_, err = ParseAuthMode("password")
+	assert.Error(t, err)
+}
+
+func TestFileCredentialsReload(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string, modTime time.Time) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	start := time.Now().Add(-time.Hour)
+
+	_, err := NewFileCredentials(logr.Discard(), dir, AuthModeAPIKey)
+	assert.Error(t, err, "the files are missing")
+
+	write(APIKeyFile, "key\n", start)
+	write(APIEmailFile, "user@example.com\n", start)
+	source, err := NewFileCredentials(logr.Discard(), dir, AuthModeAPIKey)
+	require.NoError(t, err)
+	credentials, err := source.Credentials()
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{Mode: AuthModeAPIKey, APIKey: "key", APIEmail: "user@example.com"}, credentials)
+
+	write(APIKeyFile, "rotated-key", start.Add(time.Minute))
+	credentials, err = source.Credentials()
+	require.NoError(t, err)
+	assert.Equal(t, "rotated-key", credentials.APIKey)
+
+	write(APIKeyFile, "", start.Add(2*time.Minute))
+	_, err = source.Credentials()
+	assert.Error(t, err, "an emptied file is not a credential")
+}
+
+func TestAPIClientUsesCurrentCredentials(t *testing.T) {
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Clone())
+		_, _ = w.Write([]byte(`{"success":true,"errors":[],"messages":[],"result":{"id":"tunnel-id"}}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, APITokenFile), []byte("first"), 0o600))
+	source, err := NewFileCredentials(logr.Discard(), dir, AuthModeAPIToken)
+	require.NoError(t, err)
+	cfClient, err := NewAPIClient(source, APIClientOptions{BaseURL: server.URL})
+	require.NoError(t, err)
+
+	_, err = cfClient.GetTunnel(context.Background(), cloudflare.AccountIdentifier("account"), "tunnel-id")
+	require.NoError(t, err)
+	path := filepath.Join(dir, APITokenFile)
+	require.NoError(t, os.WriteFile(path, []byte("second"), 0o600))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later))
+	_, err = cfClient.GetTunnel(context.Background(), cloudflare.AccountIdentifier("account"), "tunnel-id")
+	require.NoError(t, err)
+
+	require.Len(t, headers, 2)
+	assert.Equal(t, "Bearer first", headers[0].Get("Authorization"))
+	assert.Equal(t, "Bearer second", headers[1].Get("Authorization"))
+
+	keyClient, err := NewAPIClient(StaticCredentials{Mode: AuthModeAPIKey, APIKey: "key", APIEmail: "user@example.com"}, APIClientOptions{BaseURL: server.URL})
+	require.NoError(t, err)
+	_, err = keyClient.GetTunnel(context.Background(), cloudflare.AccountIdentifier("account"), "tunnel-id")
+	require.NoError(t, err)
+	assert.Empty(t, headers[2].Get("Authorization"))
+	assert.Equal(t, "key", headers[2].Get("X-Auth-Key"))
+	assert.Equal(t, "user@example.com", headers[2].Get("X-Auth-Email"))
+
+	_, err = NewAPIClient(StaticCredentials{Mode: AuthModeAPIKey, APIKey: "key"}, APIClientOptions{BaseURL: server.URL})
+	assert.Error(t, err, "the email is required")
+}
@@ -3,6 +3,8 @@ package cloudflarecontroller
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
 	"strings"
 
 	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
@@ -13,9 +15,17 @@ import (
 
 const ManagedRecordTXTPrefix = "_ctic_managed"
 
+// ManagedRecordWildcardLabel stands for the * of a wildcard hostname in the
+// name of its ownership TXT record, a * is only valid as the first label.
+const ManagedRecordWildcardLabel = "_wildcard"
+
 type ManagedRecordTXTContent struct {
 	Controller string `json:"controller"`
 	Tunnel     string `json:"tunnel"`
+	// Owner tells apart the controllers sharing a tunnel name, like the
+	// clusters of a blue/green pair. Empty for the records written without
+	// owner ID.
+	Owner string `json:"owner,omitempty"`
 }
 
 const ControllerIdentifier = "strrl.dev/cloudflare-tunnel-ingress-controller"
@@ -28,12 +38,33 @@ type DNSOperationCreate struct {
 	Hostname string
 	Type     string
 	Content  string
+	// DNSOnly creates a CNAME record unproxied, TXT records never are
+	DNSOnly bool
+	// TTL in seconds, zero is automatic
+	TTL int
 }
 
 type DNSOperationUpdate struct {
 	OldRecord cloudflare.DNSRecord
 	Type      string
 	Content   string
+	// DNSOnly updates a CNAME record unproxied, TXT records never are
+	DNSOnly bool
+	// TTL in seconds, zero is automatic
+	TTL int
+}
+
+// proxied tells whether the record is served through the Cloudflare proxy.
+func proxied(recordType string, dnsOnly bool) bool {
+	return recordType == "CNAME" && !dnsOnly
+}
+
+// recordTTL maps a TTL of the operations to the API, where 1 is automatic.
+func recordTTL(ttl int) int {
+	if ttl == 0 {
+		return 1
+	}
+	return ttl
 }
 
 type DNSOperationDelete struct {
@@ -49,13 +80,23 @@ type DNSOperationDelete struct {
 //
 // The TXT record is used to identify records managed by this controller.
 // Deletion only occurs when a matching TXT record exists for the current tunnel.
+//
+// Ownership records of the tunnel written without owner, before the owner ID
+// was set, are adopted for the hostnames of the exposures and rewritten with
+// the owner, they are never deleted.
+//
+// Existing records of the exposures are taken over, the A and AAAA records
+// being deleted since they cannot coexist with a CNAME. The exposures whose
+// records must not be taken over are left out by takeoverConflicts first.
 func syncDNSRecord(
 	logger logr.Logger,
 	exposures []exposure.Exposure,
 	existedCNAMERecords []cloudflare.DNSRecord,
+	existedAddressRecords []cloudflare.DNSRecord,
 	existedTXTRecords []cloudflare.DNSRecord,
 	tunnelId string,
 	tunnelName string,
+	ownerID string,
 ) ([]DNSOperationCreate, []DNSOperationUpdate, []DNSOperationDelete, error) {
 	effectiveExposures := exposure.Active(exposures)
 
@@ -63,13 +104,19 @@ func syncDNSRecord(
 	var toUpdate []DNSOperationUpdate
 	var toDelete []DNSOperationDelete
 
-	expectedTXTContent, err := renderTXTContent(tunnelName)
+	expectedTXTContent, err := renderTXTContent(tunnelName, ownerID)
 	if err != nil {
 		return nil, nil, nil, errors.Wrap(err, "render managed record TXT content")
 	}
 
-	// Create or update CNAME/TXT records for active exposures
+	// Create or update CNAME/TXT records for active exposures, the first
+	// exposure of a hostname decides the attributes of its records
+	var synced []string
 	for _, item := range effectiveExposures {
+		if slices.Contains(synced, item.Hostname) {
+			continue
+		}
+		synced = append(synced, item.Hostname)
 		txtRecordName := managedTXTRecordName(item.Hostname)
 
 		// DNS management is delegated externally for this exposure: relinquish
@@ -79,8 +126,8 @@ func syncDNSRecord(
 		// external system already repointed it the record must survive and only
 		// the ownership TXT is dropped.
 		if item.DisableDNSManagement {
-			containsTXT, oldTXT := dnsRecordsContainsHostname(existedTXTRecords, txtRecordName)
-			if containsTXT && oldTXT.Content == expectedTXTContent {
+			containsTXT, oldTXT := findOwnedTXTRecord(existedTXTRecords, txtRecordName, tunnelName, ownerID, true)
+			if containsTXT {
 				containsCNAME, oldCNAME := dnsRecordsContainsHostname(existedCNAMERecords, item.Hostname)
 				if containsCNAME && oldCNAME.Content == tunnelDomain(tunnelId) {
 					toDelete = append(toDelete, DNSOperationDelete{
@@ -100,14 +147,29 @@ func syncDNSRecord(
 			continue
 		}
 
+		for _, addressRecord := range existedAddressRecords {
+			if addressRecord.Name != item.Hostname {
+				continue
+			}
+			logger.Info("taking over DNS record not managed by this controller",
+				"hostname", item.Hostname,
+				"type", addressRecord.Type,
+				"existing-content", addressRecord.Content,
+			)
+			toDelete = append(toDelete, DNSOperationDelete{
+				OldRecord: addressRecord,
+			})
+		}
+
 		// Handle CNAME record
 		containsCNAME, oldCNAME := dnsRecordsContainsHostname(existedCNAMERecords, item.Hostname)
 		if containsCNAME {
 			// Check if this record is managed by this controller
 			hasTXTRecord, _ := dnsRecordsContainsHostname(existedTXTRecords, txtRecordName)
-			if !hasTXTRecord {
-				logger.Info("WARNING: overriding DNS record not managed by this controller",
+			if !hasTXTRecord && oldCNAME.Content != tunnelDomain(tunnelId) {
+				logger.Info("taking over DNS record not managed by this controller",
 					"hostname", item.Hostname,
+					"type", oldCNAME.Type,
 					"existing-content", oldCNAME.Content,
 				)
 			}
@@ -115,12 +177,16 @@ func syncDNSRecord(
 				OldRecord: oldCNAME,
 				Type:      "CNAME",
 				Content:   tunnelDomain(tunnelId),
+				DNSOnly:   item.DNSOnly,
+				TTL:       item.DNSTTL,
 			})
 		} else {
 			toCreate = append(toCreate, DNSOperationCreate{
 				Hostname: item.Hostname,
 				Type:     "CNAME",
 				Content:  tunnelDomain(tunnelId),
+				DNSOnly:  item.DNSOnly,
+				TTL:      item.DNSTTL,
 			})
 		}
 
@@ -150,7 +216,7 @@ func syncDNSRecord(
 
 		// Check if there's a corresponding TXT record managed by this tunnel
 		txtRecordName := managedTXTRecordName(cnameRecord.Name)
-		hasMatchingTXT, matchingTXTRecord := findMatchingTXTRecord(existedTXTRecords, txtRecordName, expectedTXTContent)
+		hasMatchingTXT, matchingTXTRecord := findOwnedTXTRecord(existedTXTRecords, txtRecordName, tunnelName, ownerID, false)
 
 		// Only delete if we have a matching TXT record (proves ownership)
 		if hasMatchingTXT {
@@ -169,21 +235,19 @@ func syncDNSRecord(
 // migrateLegacyDNSRecords handles migration from the old comment-based ownership to TXT-based ownership.
 // It identifies CNAME records that use the legacy comment format and are no longer in active exposures,
 // and returns delete operations for them. Records already tracked by TXT records are skipped
-// (they are handled by syncDNSRecord).
+// (they are handled by syncDNSRecord). The comment does not name the owner, so with an owner
+// ID the comment-based records are left alone.
 func migrateLegacyDNSRecords(
 	logger logr.Logger,
 	exposures []exposure.Exposure,
 	existedCNAMERecords []cloudflare.DNSRecord,
 	existedTXTRecords []cloudflare.DNSRecord,
 	tunnelName string,
+	ownerID string,
 ) ([]DNSOperationDelete, error) {
 	effectiveExposures := exposure.Active(exposures)
 
 	legacyComment := renderLegacyComment(tunnelName)
-	expectedTXTContent, err := renderTXTContent(tunnelName)
-	if err != nil {
-		return nil, errors.Wrap(err, "render managed record TXT content")
-	}
 
 	var toDelete []DNSOperationDelete
 	for _, cnameRecord := range existedCNAMERecords {
@@ -195,13 +259,13 @@ func migrateLegacyDNSRecords(
 
 		// Skip records already tracked by TXT (handled by syncDNSRecord)
 		txtRecordName := managedTXTRecordName(cnameRecord.Name)
-		hasTXTRecord, _ := findMatchingTXTRecord(existedTXTRecords, txtRecordName, expectedTXTContent)
+		hasTXTRecord, _ := findOwnedTXTRecord(existedTXTRecords, txtRecordName, tunnelName, ownerID, false)
 		if hasTXTRecord {
 			continue
 		}
 
 		// Delete if the CNAME has the legacy comment format matching the current tunnel
-		if cnameRecord.Comment == legacyComment {
+		if ownerID == "" && cnameRecord.Comment == legacyComment {
 			logger.Info("migrating legacy comment-based record for deletion",
 				"hostname", cnameRecord.Name,
 			)
@@ -211,9 +275,48 @@ func migrateLegacyDNSRecords(
 		}
 	}
 
+	// Ownership records of wildcards used to keep the *, they are replaced by
+	// the records named with ManagedRecordWildcardLabel
+	for _, txtRecord := range existedTXTRecords {
+		if strings.HasPrefix(txtRecord.Name, ManagedRecordTXTPrefix+".*.") && ownsTXTRecord(txtRecord, tunnelName, ownerID, true) {
+			logger.Info("migrating legacy wildcard ownership record for deletion",
+				"hostname", txtRecord.Name,
+			)
+			toDelete = append(toDelete, DNSOperationDelete{
+				OldRecord: txtRecord,
+			})
+		}
+	}
+
 	return toDelete, nil
 }
 
+// OwnedDNSRecord is a DNS record the controller manages for an exposure.
+type OwnedDNSRecord struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	// Content is empty for the ownership TXT record.
+	Content string `json:"content,omitempty"`
+}
+
+// OwnedDNSRecords lists the records a sync of the exposures into the tunnel
+// with the given domain leaves in place, in the order of the exposures.
+func OwnedDNSRecords(exposures []exposure.Exposure, tunnelDomain string) []OwnedDNSRecord {
+	var result []OwnedDNSRecord
+	var seen []string
+	for _, item := range exposure.Active(exposures) {
+		if item.DisableDNSManagement || item.Hostname == "" || slices.Contains(seen, item.Hostname) {
+			continue
+		}
+		seen = append(seen, item.Hostname)
+		result = append(result,
+			OwnedDNSRecord{Type: "CNAME", Name: item.Hostname, Content: tunnelDomain},
+			OwnedDNSRecord{Type: "TXT", Name: managedTXTRecordName(item.Hostname)},
+		)
+	}
+	return result
+}
+
 func dnsRecordsContainsHostname(records []cloudflare.DNSRecord, hostname string) (bool, cloudflare.DNSRecord) {
 	for _, item := range records {
 		if item.Name == hostname {
@@ -224,22 +327,119 @@ func dnsRecordsContainsHostname(records []cloudflare.DNSRecord, hostname string)
 }
 
 // managedTXTRecordName returns the name of the ownership TXT record that tracks
-// the given hostname, e.g. "_ctic_managed.dash.strrl.cloud".
+// the given hostname, e.g. "_ctic_managed.dash.strrl.cloud", or
+// "_ctic_managed._wildcard.strrl.cloud" for "*.strrl.cloud".
 func managedTXTRecordName(hostname string) string {
+	if parent, ok := strings.CutPrefix(hostname, "*."); ok {
+		hostname = ManagedRecordWildcardLabel + "." + parent
+	}
 	return fmt.Sprintf("%s.%s", ManagedRecordTXTPrefix, hostname)
 }
 
-// findMatchingTXTRecord returns the TXT record matching both name and content,
-// used to prove this controller/tunnel owns the corresponding CNAME record.
-func findMatchingTXTRecord(records []cloudflare.DNSRecord, name string, content string) (bool, cloudflare.DNSRecord) {
+// wildcardConflicts reports the hostnames overlapping a hostname of another
+// tunnel: a hostname covered by the wildcard of another tunnel, or a wildcard
+// covering a hostname of another tunnel. The records created first win, the
+// hostnames whose records the tunnel already owns are never reported. The
+// tunnel of another owner counts as another tunnel.
+func wildcardConflicts(exposures []exposure.Exposure, existedTXTRecords []cloudflare.DNSRecord, tunnelName string, ownerID string) ([]ExposureError, error) {
+	// owners maps the hostnames of the other tunnels to their tunnel
+	owners := map[string]string{}
+	for _, record := range existedTXTRecords {
+		if ownsTXTRecord(record, tunnelName, ownerID, true) {
+			continue
+		}
+		content, err := parseTXTContent(record.Content)
+		if err != nil || content.Controller != ControllerIdentifier {
+			continue
+		}
+		owners[exposureHostname(record.Name)] = content.describeTunnel()
+	}
+
+	var failures []ExposureError
+	for _, item := range exposure.Active(exposures) {
+		if item.DisableDNSManagement || item.Hostname == "" {
+			continue
+		}
+		if owned, _ := findOwnedTXTRecord(existedTXTRecords, managedTXTRecordName(item.Hostname), tunnelName, ownerID, true); owned {
+			continue
+		}
+		hostname := Domain{Name: item.Hostname}
+		for _, other := range slices.Sorted(maps.Keys(owners)) {
+			otherDomain := Domain{Name: other}
+			if hostname.IsCoveredBy(otherDomain) {
+				failures = addExposureError(failures, item.Hostname, errors.Errorf("hostname %s is covered by wildcard %s of %s", item.Hostname, other, owners[other]))
+			} else if otherDomain.IsCoveredBy(hostname) {
+				failures = addExposureError(failures, item.Hostname, errors.Errorf("wildcard %s covers hostname %s of %s", item.Hostname, other, owners[other]))
+			}
+		}
+	}
+	return failures, nil
+}
+
+// takeoverConflicts reports the hostnames whose existing CNAME, A or AAAA
+// records the tunnel does not own, unless the takeover is allowed globally or
+// by the exposure. The hostnames owned by another tunnel, or by another owner
+// of the tunnel, are always reported, the takeover only applies to records
+// without owner. Otherwise a CNAME already pointing at the tunnel, or
+// carrying the legacy comment of the tunnel, is owned even without its TXT
+// record.
+func takeoverConflicts(exposures []exposure.Exposure, existedRecords []cloudflare.DNSRecord, existedTXTRecords []cloudflare.DNSRecord, tunnelId string, tunnelName string, ownerID string, allowTakeover bool) ([]ExposureError, error) {
+	legacyComment := renderLegacyComment(tunnelName)
+
+	var failures []ExposureError
+	for _, item := range exposure.Active(exposures) {
+		if item.DisableDNSManagement || item.Hostname == "" {
+			continue
+		}
+		txtRecordName := managedTXTRecordName(item.Hostname)
+		if owned, _ := findOwnedTXTRecord(existedTXTRecords, txtRecordName, tunnelName, ownerID, true); owned {
+			continue
+		}
+		if containsTXT, txt := dnsRecordsContainsHostname(existedTXTRecords, txtRecordName); containsTXT {
+			if content, err := parseTXTContent(txt.Content); err == nil && content.Controller == ControllerIdentifier {
+				failures = addExposureError(failures, item.Hostname, errors.Errorf("hostname %s is managed by %s, refusing to take it over", item.Hostname, content.describeTunnel()))
+				continue
+			}
+		}
+		if allowTakeover || item.AllowDNSTakeover {
+			continue
+		}
+		containsCNAME, cname := dnsRecordsContainsHostname(existedRecords, item.Hostname)
+		if containsCNAME && cname.Type == "CNAME" && (cname.Content == tunnelDomain(tunnelId) || cname.Comment == legacyComment) {
+			continue
+		}
+		for _, record := range existedRecords {
+			if record.Name == item.Hostname {
+				failures = addExposureError(failures, item.Hostname, errors.Errorf("existing %s record %s -> %s is not managed by tunnel %s, refusing to take it over", record.Type, record.Name, record.Content, tunnelName))
+			}
+		}
+	}
+	return failures, nil
+}
+
+// findOwnedTXTRecord returns the TXT record with the name owned by the tunnel
+// and owner, used to prove this controller/tunnel owns the corresponding CNAME
+// record.
+func findOwnedTXTRecord(records []cloudflare.DNSRecord, name string, tunnelName string, ownerID string, adopt bool) (bool, cloudflare.DNSRecord) {
 	for _, record := range records {
-		if record.Name == name && record.Content == content {
+		if record.Name == name && ownsTXTRecord(record, tunnelName, ownerID, adopt) {
 			return true, record
 		}
 	}
 	return false, cloudflare.DNSRecord{}
 }
 
+// ownsTXTRecord tells whether the ownership record was written for the tunnel
+// and owner. With adopt, a record of the tunnel written without owner is owned
+// too.
+func ownsTXTRecord(record cloudflare.DNSRecord, tunnelName string, ownerID string, adopt bool) bool {
+	content, err := parseTXTContent(record.Content)
+	if err != nil || content.Controller != ControllerIdentifier || content.Tunnel != tunnelName {
+		return false
+	}
+	return content.Owner == ownerID || (adopt && content.Owner == "")
+}
+
 func exposureContainsHostname(exposures []exposure.Exposure, hostname string) (bool, exposure.Exposure) {
 	for _, item := range exposures {
 		if item.Hostname == hostname {
@@ -259,10 +459,11 @@ func renderLegacyComment(tunnelName string) string {
 	return fmt.Sprintf(LegacyCommentFormat, tunnelName)
 }
 
-func renderTXTContent(tunnelName string) (string, error) {
+func renderTXTContent(tunnelName string, ownerID string) (string, error) {
 	content := ManagedRecordTXTContent{
 		Controller: ControllerIdentifier,
 		Tunnel:     tunnelName,
+		Owner:      ownerID,
 	}
 	jsonBytes, err := json.Marshal(content)
 	if err != nil {
@@ -271,6 +472,14 @@ func renderTXTContent(tunnelName string) (string, error) {
 	return string(jsonBytes), nil
 }
 
+// describeTunnel names the tunnel of the record in messages.
+func (c ManagedRecordTXTContent) describeTunnel() string {
+	if c.Owner == "" {
+		return "tunnel " + c.Tunnel
+	}
+	return fmt.Sprintf("tunnel %s of owner %s", c.Tunnel, c.Owner)
+}
+
 func parseTXTContent(content string) (*ManagedRecordTXTContent, error) {
 	var result ManagedRecordTXTContent
 	if err := json.Unmarshal([]byte(content), &result); err != nil {
@@ -14,12 +14,14 @@ const WhateverTunnelDomain = "whatever.cfargotunnel.com"
 
 func Test_syncDNSRecord(t *testing.T) {
 	type args struct {
-		logger              logr.Logger
-		exposures           []exposure.Exposure
-		existedCNAMERecords []cloudflare.DNSRecord
-		existedTXTRecords   []cloudflare.DNSRecord
-		tunnelId            string
-		tunnelName          string
+		logger                logr.Logger
+		exposures             []exposure.Exposure
+		existedCNAMERecords   []cloudflare.DNSRecord
+		existedAddressRecords []cloudflare.DNSRecord
+		existedTXTRecords     []cloudflare.DNSRecord
+		tunnelId              string
+		tunnelName            string
+		ownerID               string
 	}
 	var tests = []struct {
 		name       string
@@ -76,6 +78,50 @@ func Test_syncDNSRecord(t *testing.T) {
 			wantDelete: nil,
 			wantErr:    false,
 		},
+		{
+			name: "create wildcard exposure",
+			args: args{
+				logger: logr.Discard(),
+				exposures: []exposure.Exposure{
+					{
+						Hostname:      "*.example.com",
+						ServiceTarget: "http://10.0.0.1:233",
+					},
+				},
+				tunnelId:   WhateverTunnelId,
+				tunnelName: "tunnel-in-test",
+			},
+			wantCreate: []DNSOperationCreate{
+				{
+					Hostname: "*.example.com",
+					Type:     "CNAME",
+					Content:  WhateverTunnelDomain,
+				},
+				{
+					Hostname: "_ctic_managed._wildcard.example.com",
+					Type:     "TXT",
+					Content:  `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test"}`,
+				},
+			},
+		},
+		{
+			name: "delete removed wildcard exposure",
+			args: args{
+				logger: logr.Discard(),
+				existedCNAMERecords: []cloudflare.DNSRecord{
+					{Name: "*.example.com", Type: "CNAME", Content: WhateverTunnelDomain},
+				},
+				existedTXTRecords: []cloudflare.DNSRecord{
+					{Name: "_ctic_managed._wildcard.example.com", Type: "TXT", Content: `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test"}`},
+				},
+				tunnelId:   WhateverTunnelId,
+				tunnelName: "tunnel-in-test",
+			},
+			wantDelete: []DNSOperationDelete{
+				{OldRecord: cloudflare.DNSRecord{Name: "*.example.com", Type: "CNAME", Content: WhateverTunnelDomain}},
+				{OldRecord: cloudflare.DNSRecord{Name: "_ctic_managed._wildcard.example.com", Type: "TXT", Content: `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test"}`}},
+			},
+		},
 		{
 			name: "ignore deleted exposure",
 			args: args{
@@ -206,6 +252,116 @@ func Test_syncDNSRecord(t *testing.T) {
 			wantDelete: nil,
 			wantErr:    false,
 		},
+		{
+			name: "take over A and AAAA records",
+			args: args{
+				logger: logr.Discard(),
+				exposures: []exposure.Exposure{
+					{Hostname: "test.example.com", ServiceTarget: "http://10.0.0.1:233", PathPrefix: "/"},
+					{Hostname: "test.example.com", ServiceTarget: "http://10.0.0.1:233", PathPrefix: "/api"},
+				},
+				existedAddressRecords: []cloudflare.DNSRecord{
+					{ID: "a", Name: "test.example.com", Type: "A", Content: "1.2.3.4"},
+					{ID: "aaaa", Name: "test.example.com", Type: "AAAA", Content: "::1"},
+					{ID: "other", Name: "other.example.com", Type: "A", Content: "1.2.3.4"},
+				},
+				tunnelId:   WhateverTunnelId,
+				tunnelName: "tunnel-in-test",
+			},
+			wantCreate: []DNSOperationCreate{
+				{Hostname: "test.example.com", Type: "CNAME", Content: WhateverTunnelDomain},
+				{Hostname: "_ctic_managed.test.example.com", Type: "TXT", Content: `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test"}`},
+			},
+			wantDelete: []DNSOperationDelete{
+				{OldRecord: cloudflare.DNSRecord{ID: "a", Name: "test.example.com", Type: "A", Content: "1.2.3.4"}},
+				{OldRecord: cloudflare.DNSRecord{ID: "aaaa", Name: "test.example.com", Type: "AAAA", Content: "::1"}},
+			},
+		},
+		{
+			name: "DNS-only record with TTL",
+			args: args{
+				logger: logr.Discard(),
+				exposures: []exposure.Exposure{
+					{Hostname: "cdn.example.com", ServiceTarget: "http://10.0.0.1:233", PathPrefix: "/", DNSOnly: true, DNSTTL: 300},
+					{Hostname: "cdn.example.com", ServiceTarget: "http://10.0.0.1:233", PathPrefix: "/api"},
+				},
+				existedCNAMERecords: []cloudflare.DNSRecord{
+					{ID: "cname", Name: "cdn.example.com", Type: "CNAME", Content: WhateverTunnelDomain},
+				},
+				existedTXTRecords: []cloudflare.DNSRecord{
+					{ID: "txt", Name: "_ctic_managed.cdn.example.com", Type: "TXT", Content: `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test"}`},
+				},
+				tunnelId:   WhateverTunnelId,
+				tunnelName: "tunnel-in-test",
+			},
+			wantUpdate: []DNSOperationUpdate{
+				{
+					OldRecord: cloudflare.DNSRecord{ID: "cname", Name: "cdn.example.com", Type: "CNAME", Content: WhateverTunnelDomain},
+					Type:      "CNAME",
+					Content:   WhateverTunnelDomain,
+					DNSOnly:   true,
+					TTL:       300,
+				},
+				{
+					OldRecord: cloudflare.DNSRecord{ID: "txt", Name: "_ctic_managed.cdn.example.com", Type: "TXT", Content: `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test"}`},
+					Type:      "TXT",
+					Content:   `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test"}`,
+				},
+			},
+		},
+		{
+			name: "adopt TXT record written without owner",
+			args: args{
+				logger: logr.Discard(),
+				exposures: []exposure.Exposure{
+					{Hostname: "test.example.com", ServiceTarget: "http://10.0.0.1:233", PathPrefix: "/"},
+				},
+				existedCNAMERecords: []cloudflare.DNSRecord{
+					{ID: "cname", Name: "test.example.com", Type: "CNAME", Content: WhateverTunnelDomain},
+				},
+				existedTXTRecords: []cloudflare.DNSRecord{
+					{ID: "txt", Name: "_ctic_managed.test.example.com", Type: "TXT", Content: `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test"}`},
+				},
+				tunnelId:   WhateverTunnelId,
+				tunnelName: "tunnel-in-test",
+				ownerID:    "blue",
+			},
+			wantUpdate: []DNSOperationUpdate{
+				{
+					OldRecord: cloudflare.DNSRecord{ID: "cname", Name: "test.example.com", Type: "CNAME", Content: WhateverTunnelDomain},
+					Type:      "CNAME",
+					Content:   WhateverTunnelDomain,
+				},
+				{
+					OldRecord: cloudflare.DNSRecord{ID: "txt", Name: "_ctic_managed.test.example.com", Type: "TXT", Content: `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test"}`},
+					Type:      "TXT",
+					Content:   `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test","owner":"blue"}`,
+				},
+			},
+		},
+		{
+			name: "do not delete records of another owner or without owner",
+			args: args{
+				logger: logr.Discard(),
+				existedCNAMERecords: []cloudflare.DNSRecord{
+					{Name: "green.example.com", Type: "CNAME", Content: WhateverTunnelDomain},
+					{Name: "legacy.example.com", Type: "CNAME", Content: WhateverTunnelDomain},
+					{Name: "blue.example.com", Type: "CNAME", Content: WhateverTunnelDomain},
+				},
+				existedTXTRecords: []cloudflare.DNSRecord{
+					{Name: "_ctic_managed.green.example.com", Type: "TXT", Content: `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test","owner":"green"}`},
+					{Name: "_ctic_managed.legacy.example.com", Type: "TXT", Content: `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test"}`},
+					{Name: "_ctic_managed.blue.example.com", Type: "TXT", Content: `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test","owner":"blue"}`},
+				},
+				tunnelId:   WhateverTunnelId,
+				tunnelName: "tunnel-in-test",
+				ownerID:    "blue",
+			},
+			wantDelete: []DNSOperationDelete{
+				{OldRecord: cloudflare.DNSRecord{Name: "blue.example.com", Type: "CNAME", Content: WhateverTunnelDomain}},
+				{OldRecord: cloudflare.DNSRecord{Name: "_ctic_managed.blue.example.com", Type: "TXT", Content: `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test","owner":"blue"}`}},
+			},
+		},
 		{
 			name: "delete unused exposure with TXT",
 			args: args{
@@ -493,9 +649,11 @@ func Test_syncDNSRecord(t *testing.T) {
 				tt.args.logger,
 				tt.args.exposures,
 				tt.args.existedCNAMERecords,
+				tt.args.existedAddressRecords,
 				tt.args.existedTXTRecords,
 				tt.args.tunnelId,
 				tt.args.tunnelName,
+				tt.args.ownerID,
 			)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("syncDNSRecord() error = %v, wantErr %v", err, tt.wantErr)
@@ -521,6 +679,7 @@ func Test_migrateLegacyDNSRecords(t *testing.T) {
 		existedCNAMERecords []cloudflare.DNSRecord
 		existedTXTRecords   []cloudflare.DNSRecord
 		tunnelName          string
+		ownerID             string
 	}
 	tests := []struct {
 		name       string
@@ -621,6 +780,32 @@ func Test_migrateLegacyDNSRecords(t *testing.T) {
 			},
 			wantDelete: nil,
 		},
+		{
+			name: "keep legacy comment-based record with owner ID",
+			args: args{
+				logger: logr.Discard(),
+				existedCNAMERecords: []cloudflare.DNSRecord{
+					{Name: "test.example.com", Type: "CNAME", Content: WhateverTunnelDomain, Comment: "managed by strrl.dev/cloudflare-tunnel-ingress-controller, tunnel [tunnel-in-test]"},
+				},
+				tunnelName: "tunnel-in-test",
+				ownerID:    "blue",
+			},
+			wantDelete: nil,
+		},
+		{
+			name: "delete wildcard TXT record named with the *",
+			args: args{
+				logger: logr.Discard(),
+				existedTXTRecords: []cloudflare.DNSRecord{
+					{Name: "_ctic_managed.*.example.com", Type: "TXT", Content: `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test"}`},
+					{Name: "_ctic_managed.*.example.org", Type: "TXT", Content: `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"other-tunnel"}`},
+				},
+				tunnelName: "tunnel-in-test",
+			},
+			wantDelete: []DNSOperationDelete{
+				{OldRecord: cloudflare.DNSRecord{Name: "_ctic_managed.*.example.com", Type: "TXT", Content: `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test"}`}},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -630,6 +815,7 @@ func Test_migrateLegacyDNSRecords(t *testing.T) {
 				tt.args.existedCNAMERecords,
 				tt.args.existedTXTRecords,
 				tt.args.tunnelName,
+				tt.args.ownerID,
 			)
 			if err != nil {
 				t.Errorf("migrateLegacyDNSRecords() unexpected error: %v", err)
@@ -641,8 +827,155 @@ func Test_migrateLegacyDNSRecords(t *testing.T) {
 	}
 }
 
+func Test_wildcardConflicts(t *testing.T) {
+	otherTunnel := `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"other-tunnel"}`
+	thisTunnel := `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test"}`
+	tests := []struct {
+		name      string
+		hostnames []string
+		records   []cloudflare.DNSRecord
+		ownerID   string
+		want      []ExposureError
+	}{
+		{
+			name:      "hostname under the wildcard of another tunnel",
+			hostnames: []string{"app.example.com", "app.sub.example.com"},
+			records:   []cloudflare.DNSRecord{{Name: "_ctic_managed._wildcard.example.com", Content: otherTunnel}},
+			want:      []ExposureError{{Hostname: "app.example.com", Reason: "hostname app.example.com is covered by wildcard *.example.com of tunnel other-tunnel"}},
+		},
+		{
+			name:      "wildcard over a hostname of another tunnel",
+			hostnames: []string{"*.example.com"},
+			records:   []cloudflare.DNSRecord{{Name: "_ctic_managed.app.example.com", Content: otherTunnel}},
+			want:      []ExposureError{{Hostname: "*.example.com", Reason: "wildcard *.example.com covers hostname app.example.com of tunnel other-tunnel"}},
+		},
+		{
+			name:      "wildcard and hostname of the same tunnel",
+			hostnames: []string{"*.example.com", "app.example.com"},
+			records:   []cloudflare.DNSRecord{{Name: "_ctic_managed.app.example.com", Content: thisTunnel}},
+		},
+		{
+			name:      "wildcard of another owner of the tunnel",
+			hostnames: []string{"app.example.com"},
+			records:   []cloudflare.DNSRecord{{Name: "_ctic_managed._wildcard.example.com", Content: `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test","owner":"green"}`}},
+			ownerID:   "blue",
+			want:      []ExposureError{{Hostname: "app.example.com", Reason: "hostname app.example.com is covered by wildcard *.example.com of tunnel tunnel-in-test of owner green"}},
+		},
+		{
+			name:      "wildcard of the tunnel written without owner",
+			hostnames: []string{"app.example.com"},
+			records:   []cloudflare.DNSRecord{{Name: "_ctic_managed._wildcard.example.com", Content: thisTunnel}},
+			ownerID:   "blue",
+		},
+		{
+			name:      "hostname owned before the wildcard",
+			hostnames: []string{"app.example.com"},
+			records: []cloudflare.DNSRecord{
+				{Name: "_ctic_managed._wildcard.example.com", Content: otherTunnel},
+				{Name: "_ctic_managed.app.example.com", Content: thisTunnel},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var exposures []exposure.Exposure
+			for _, hostname := range tt.hostnames {
+				exposures = append(exposures, exposure.Exposure{Hostname: hostname, ServiceTarget: "http://10.0.0.1:80"})
+			}
+			got, err := wildcardConflicts(exposures, tt.records, "tunnel-in-test", tt.ownerID)
+			if err != nil {
+				t.Fatalf("wildcardConflicts() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wildcardConflicts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_takeoverConflicts(t *testing.T) {
+	thisTunnel := `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test"}`
+	otherTunnel := `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"other-tunnel"}`
+	records := []cloudflare.DNSRecord{
+		{Name: "owned.example.com", Type: "CNAME", Content: "other.example.net"},
+		{Name: "pointed.example.com", Type: "CNAME", Content: WhateverTunnelDomain},
+		{Name: "legacy.example.com", Type: "CNAME", Content: "other.example.net", Comment: renderLegacyComment("tunnel-in-test")},
+		{Name: "manual.example.com", Type: "CNAME", Content: "other.example.net"},
+		{Name: "address.example.com", Type: "A", Content: "1.2.3.4"},
+		{Name: "address.example.com", Type: "AAAA", Content: "::1"},
+		{Name: "taken.example.com", Type: "CNAME", Content: "other.cfargotunnel.com"},
+	}
+	txtRecords := []cloudflare.DNSRecord{
+		{Name: "_ctic_managed.owned.example.com", Content: thisTunnel},
+		{Name: "_ctic_managed.taken.example.com", Content: otherTunnel},
+	}
+	var exposures []exposure.Exposure
+	for _, hostname := range []string{"owned.example.com", "pointed.example.com", "legacy.example.com", "manual.example.com", "address.example.com", "taken.example.com", "new.example.com"} {
+		exposures = append(exposures, exposure.Exposure{Hostname: hostname, ServiceTarget: "http://10.0.0.1:80"})
+	}
+
+	got, err := takeoverConflicts(exposures, records, txtRecords, WhateverTunnelId, "tunnel-in-test", "", false)
+	if err != nil {
+		t.Fatalf("takeoverConflicts() unexpected error: %v", err)
+	}
+	want := []ExposureError{
+		{Hostname: "manual.example.com", Reason: "existing CNAME record manual.example.com -> other.example.net is not managed by tunnel tunnel-in-test, refusing to take it over"},
+		{Hostname: "address.example.com", Reason: "existing A record address.example.com -> 1.2.3.4 is not managed by tunnel tunnel-in-test, refusing to take it over"},
+		{Hostname: "taken.example.com", Reason: "hostname taken.example.com is managed by tunnel other-tunnel, refusing to take it over"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("takeoverConflicts() = %v, want %v", got, want)
+	}
+
+	exposures[3].AllowDNSTakeover = true
+	exposures[4].DisableDNSManagement = true
+	exposures[5].IsDeleted = true
+	got, err = takeoverConflicts(exposures, records, txtRecords, WhateverTunnelId, "tunnel-in-test", "", false)
+	if err != nil {
+		t.Fatalf("takeoverConflicts() unexpected error: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("takeoverConflicts() = %v, want no conflict for the exposures allowing the takeover", got)
+	}
+
+	got, err = takeoverConflicts([]exposure.Exposure{{Hostname: "manual.example.com"}}, records, txtRecords, WhateverTunnelId, "tunnel-in-test", "", true)
+	if err != nil || len(got) != 0 {
+		t.Errorf("takeoverConflicts() = %v, %v, want no conflict when the takeover is allowed", got, err)
+	}
+
+	got, err = takeoverConflicts([]exposure.Exposure{{Hostname: "owned.example.com"}}, records, txtRecords, WhateverTunnelId, "tunnel-in-test", "blue", false)
+	if err != nil {
+		t.Fatalf("takeoverConflicts() unexpected error: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("takeoverConflicts() = %v, want the record written without owner to be adopted", got)
+	}
+	txtRecords[0].Content = `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"tunnel-in-test","owner":"green"}`
+	got, err = takeoverConflicts([]exposure.Exposure{{Hostname: "owned.example.com"}}, records, txtRecords, WhateverTunnelId, "tunnel-in-test", "blue", false)
+	if err != nil {
+		t.Fatalf("takeoverConflicts() unexpected error: %v", err)
+	}
+	want = []ExposureError{{Hostname: "owned.example.com", Reason: "hostname owned.example.com is managed by tunnel tunnel-in-test of owner green, refusing to take it over"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("takeoverConflicts() = %v, want %v", got, want)
+	}
+
+	// the takeover only applies to records without owner
+	got, err = takeoverConflicts([]exposure.Exposure{{Hostname: "owned.example.com", AllowDNSTakeover: true}, {Hostname: "taken.example.com"}}, records, txtRecords, WhateverTunnelId, "tunnel-in-test", "blue", true)
+	if err != nil {
+		t.Fatalf("takeoverConflicts() unexpected error: %v", err)
+	}
+	want = []ExposureError{
+		{Hostname: "owned.example.com", Reason: "hostname owned.example.com is managed by tunnel tunnel-in-test of owner green, refusing to take it over"},
+		{Hostname: "taken.example.com", Reason: "hostname taken.example.com is managed by tunnel other-tunnel, refusing to take it over"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("takeoverConflicts() = %v, want the records of other owners to be refused even with the takeover allowed, %v", got, want)
+	}
+}
+
 func Test_renderTXTContent(t *testing.T) {
-	result, err := renderTXTContent("my-tunnel")
+	result, err := renderTXTContent("my-tunnel", "")
 	if err != nil {
 		t.Fatalf("renderTXTContent() unexpected error: %v", err)
 	}
@@ -652,6 +985,17 @@ func Test_renderTXTContent(t *testing.T) {
 	}
 }
 
+func Test_renderTXTContentWithOwner(t *testing.T) {
+	result, err := renderTXTContent("my-tunnel", "blue")
+	if err != nil {
+		t.Fatalf("renderTXTContent() unexpected error: %v", err)
+	}
+	expected := `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"my-tunnel","owner":"blue"}`
+	if result != expected {
+		t.Errorf("renderTXTContent() = %v, want %v", result, expected)
+	}
+}
+
 func Test_parseTXTContent(t *testing.T) {
 	content := `{"controller":"strrl.dev/cloudflare-tunnel-ingress-controller","tunnel":"my-tunnel"}`
 	result, err := parseTXTContent(content)
@@ -19,3 +19,19 @@ func (d Domain) IsSubDomainOf(target Domain) bool {
 	}
 	return true
 }
+
+// IsWildcard tells whether the domain is a wildcard, eg. *.example.com.
+func (d Domain) IsWildcard() bool {
+	return strings.HasPrefix(d.Name, "*.")
+}
+
+// IsCoveredBy tells whether the wildcard matches the domain. As for the host
+// of an Ingress, the wildcard matches a single label: *.example.com matches
+// foo.example.com, neither example.com nor foo.bar.example.com.
+func (d Domain) IsCoveredBy(wildcard Domain) bool {
+	if !wildcard.IsWildcard() || d.IsWildcard() {
+		return false
+	}
+	_, parent, ok := strings.Cut(d.Name, ".")
+	return ok && strings.EqualFold(parent, strings.TrimPrefix(wildcard.Name, "*."))
+}
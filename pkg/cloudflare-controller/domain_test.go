@@ -73,3 +73,25 @@ func TestDomain_IsSubDomainOf(t *testing.T) {
 		})
 	}
 }
+
+func TestDomain_IsCoveredBy(t *testing.T) {
+	tests := []struct {
+		domain   string
+		wildcard string
+		expected bool
+	}{
+		{domain: "foo.example.com", wildcard: "*.example.com", expected: true},
+		{domain: "Foo.Example.com", wildcard: "*.example.COM", expected: true},
+		{domain: "foo.bar.example.com", wildcard: "*.example.com", expected: false},
+		{domain: "example.com", wildcard: "*.example.com", expected: false},
+		{domain: "*.example.com", wildcard: "*.example.com", expected: false},
+		{domain: "foo.example.com", wildcard: "bar.example.com", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain+" "+tt.wildcard, func(t *testing.T) {
+			if got := (Domain{Name: tt.domain}).IsCoveredBy(Domain{Name: tt.wildcard}); got != tt.expected {
+				t.Errorf("IsCoveredBy() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package cloudflarecontroller
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+)
+
+// ExposureError is why the hostname of an exposure could not be synced.
+type ExposureError struct {
+	Hostname string `json:"hostname"`
+	Reason   string `json:"reason"`
+}
+
+// PartialSyncError is returned by PutExposures when some hostnames could not
+// be synced, every other exposure has been applied.
+type PartialSyncError struct {
+	Failures []ExposureError
+}
+
+func (e *PartialSyncError) Error() string {
+	var reasons []string
+	for _, item := range e.Failures {
+		reasons = append(reasons, item.Reason)
+	}
+	return fmt.Sprintf("%d hostnames failed to sync: %s", len(e.Failures), strings.Join(reasons, "; "))
+}
+
+// Reason returns why the hostname failed to sync, empty when it did not.
+func (e *PartialSyncError) Reason(hostname string) string {
+	index := slices.IndexFunc(e.Failures, func(item ExposureError) bool { return item.Hostname == hostname })
+	if index < 0 {
+		return ""
+	}
+	return e.Failures[index].Reason
+}
+
+// addExposureError records the first failure of each hostname.
+func addExposureError(failures []ExposureError, hostname string, err error) []ExposureError {
+	if slices.ContainsFunc(failures, func(item ExposureError) bool { return item.Hostname == hostname }) {
+		return failures
+	}
+	return append(failures, ExposureError{Hostname: hostname, Reason: err.Error()})
+}
+
+// exposureHostname maps the name of a DNS record back to the hostname it is
+// created for.
+func exposureHostname(recordName string) string {
+	hostname := strings.TrimPrefix(recordName, ManagedRecordTXTPrefix+".")
+	if parent, ok := strings.CutPrefix(hostname, ManagedRecordWildcardLabel+"."); ok {
+		return "*." + parent
+	}
+	return hostname
+}
+
+// withoutFailedExposures drops the exposures of the failed hostnames.
+func withoutFailedExposures(exposures []exposure.Exposure, failures []ExposureError) []exposure.Exposure {
+	if len(failures) == 0 {
+		return exposures
+	}
+	return slices.DeleteFunc(slices.Clone(exposures), func(item exposure.Exposure) bool {
+		return slices.ContainsFunc(failures, func(failure ExposureError) bool { return failure.Hostname == item.Hostname })
+	})
+}
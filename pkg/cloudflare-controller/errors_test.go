@@ -0,0 +1,32 @@
+package cloudflarecontroller
+
+import (
+	"testing"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartialSyncError(t *testing.T) {
+	var failures []ExposureError
+	failures = addExposureError(failures, "typo.exmaple.com", errors.New("hostname typo.exmaple.com not belong to any zone"))
+	failures = addExposureError(failures, exposureHostname("_ctic_managed.app.example.com"), errors.New("create DNS record"))
+	failures = addExposureError(failures, "app.example.com", errors.New("update DNS record"))
+	assert.Equal(t, []ExposureError{
+		{Hostname: "typo.exmaple.com", Reason: "hostname typo.exmaple.com not belong to any zone"},
+		{Hostname: "app.example.com", Reason: "create DNS record"},
+	}, failures)
+
+	err := &PartialSyncError{Failures: failures}
+	assert.Equal(t, "2 hostnames failed to sync: hostname typo.exmaple.com not belong to any zone; create DNS record", err.Error())
+	assert.Equal(t, "create DNS record", err.Reason("app.example.com"))
+	assert.Empty(t, err.Reason("api.example.com"))
+
+	exposures := []exposure.Exposure{
+		{Hostname: "typo.exmaple.com", ServiceTarget: "http://typo.default.svc.cluster.local:80", PathPrefix: "/"},
+		{Hostname: "api.example.com", ServiceTarget: "http://api.default.svc.cluster.local:80", PathPrefix: "/"},
+	}
+	assert.Equal(t, exposures[1:], withoutFailedExposures(exposures, failures))
+	assert.Len(t, exposures, 2)
+}
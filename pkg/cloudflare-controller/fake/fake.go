@@ -0,0 +1,603 @@
+// Package fake implements the Cloudflare API of the tunnel client in memory,
+// so the controller can be tested end to end without network nor
+// credentials. It keeps the tunnels, their configuration, the zones and the
+// DNS records, and rejects the inputs the real API rejects with the same
+// error types.
+package fake
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// MaxCommentLength is the longest DNS record comment of the Free plan.
+const MaxCommentLength = 100
+
+// readPermissions are the read permissions the edit permissions include.
+var readPermissions = map[cloudflarecontroller.Permission]cloudflarecontroller.Permission{
+	cloudflarecontroller.PermissionTunnelEdit: cloudflarecontroller.PermissionTunnelRead,
+	cloudflarecontroller.PermissionDNSEdit:    cloudflarecontroller.PermissionDNSRead,
+}
+
+var _ cloudflarecontroller.CloudflareAPI = (*Cloudflare)(nil)
+
+// Cloudflare is an in-memory Cloudflare account, safe for concurrent use.
+type Cloudflare struct {
+	accountID string
+
+	mu      sync.Mutex
+	lastID  int
+	tunnels []cloudflare.Tunnel
+	configs map[string]cloudflare.TunnelConfigurationResult
+	zones   []cloudflare.Zone
+	// records are the DNS records by zone ID
+	records map[string][]cloudflare.DNSRecord
+	// denied are the names of the zones a permission is denied on, empty
+	// for an account permission
+	denied map[cloudflarecontroller.Permission][]string
+}
+
+// NewCloudflare creates an empty account with the ID.
+func NewCloudflare(accountID string) *Cloudflare {
+	return &Cloudflare{
+		accountID: accountID,
+		configs:   map[string]cloudflare.TunnelConfigurationResult{},
+		records:   map[string][]cloudflare.DNSRecord{},
+		denied:    map[cloudflarecontroller.Permission][]string{},
+	}
+}
+
+// Deny makes the requests needing the permission fail with a 403, as with an
+// API token lacking it. zoneName is the zone of a zone permission, empty for
+// an account permission. Denying a read permission denies the edit one too,
+// and a zone without Zone:Zone:Read is not listed.
+func (c *Cloudflare) Deny(permission cloudflarecontroller.Permission, zoneName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.denied[permission] = append(c.denied[permission], strings.ToLower(zoneName))
+}
+
+// AddZone adds an active zone to the account.
+func (c *Cloudflare) AddZone(name string) cloudflare.Zone {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	zone := cloudflare.Zone{ID: c.newID(), Name: strings.ToLower(name), Status: "active"}
+	c.zones = append(c.zones, zone)
+	return zone
+}
+
+// AddDNSRecord adds a record to the zone with the name, as if it was created
+// outside of the controller.
+func (c *Cloudflare) AddDNSRecord(zoneName string, params cloudflare.CreateDNSRecordParams) (cloudflare.DNSRecord, error) {
+	zone, ok := c.zoneByName(zoneName)
+	if !ok {
+		return cloudflare.DNSRecord{}, notFound(7003, "zone %s not found", zoneName)
+	}
+	return c.CreateDNSRecord(context.Background(), cloudflare.ZoneIdentifier(zone.ID), params)
+}
+
+// Snapshot is the state of the account.
+type Snapshot struct {
+	Tunnels []TunnelSnapshot `json:"tunnels"`
+	Zones   []ZoneSnapshot   `json:"zones"`
+}
+
+type TunnelSnapshot struct {
+	ID            string                               `json:"id"`
+	Name          string                               `json:"name"`
+	Deleted       bool                                 `json:"deleted"`
+	Configuration cloudflare.TunnelConfigurationResult `json:"configuration"`
+}
+
+type ZoneSnapshot struct {
+	ID      string                 `json:"id"`
+	Name    string                 `json:"name"`
+	Records []cloudflare.DNSRecord `json:"records"`
+}
+
+// Snapshot returns the tunnels with their configuration and the zones with
+// their records.
+func (c *Cloudflare) Snapshot() Snapshot {
+	snapshot := Snapshot{Tunnels: []TunnelSnapshot{}, Zones: []ZoneSnapshot{}}
+	for _, tunnel := range c.Tunnels() {
+		c.mu.Lock()
+		configuration := c.configs[tunnel.ID]
+		c.mu.Unlock()
+		snapshot.Tunnels = append(snapshot.Tunnels, TunnelSnapshot{ID: tunnel.ID, Name: tunnel.Name, Deleted: tunnel.DeletedAt != nil, Configuration: configuration})
+	}
+	c.mu.Lock()
+	zones := slices.Clone(c.zones)
+	c.mu.Unlock()
+	for _, zone := range zones {
+		records := c.DNSRecords(zone.Name)
+		if records == nil {
+			records = []cloudflare.DNSRecord{}
+		}
+		snapshot.Zones = append(snapshot.Zones, ZoneSnapshot{ID: zone.ID, Name: zone.Name, Records: records})
+	}
+	return snapshot
+}
+
+// Tunnels returns the tunnels of the account, the deleted ones included.
+func (c *Cloudflare) Tunnels() []cloudflare.Tunnel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.tunnels)
+}
+
+// TunnelConfiguration returns the configuration of the tunnel, the zero value
+// when it has none.
+func (c *Cloudflare) TunnelConfiguration(tunnelID string) cloudflare.TunnelConfiguration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.configs[tunnelID].Config
+}
+
+// DNSRecords returns the records of the zone with the name, sorted by name
+// and type.
+func (c *Cloudflare) DNSRecords(zoneName string) []cloudflare.DNSRecord {
+	zone, ok := c.zoneByName(zoneName)
+	if !ok {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	records := slices.Clone(c.records[zone.ID])
+	slices.SortFunc(records, func(a, b cloudflare.DNSRecord) int {
+		return strings.Compare(a.Name+" "+a.Type, b.Name+" "+b.Type)
+	})
+	return records
+}
+
+func (c *Cloudflare) ListTunnels(_ context.Context, rc *cloudflare.ResourceContainer, params cloudflare.TunnelListParams) ([]cloudflare.Tunnel, *cloudflare.ResultInfo, error) {
+	if err := c.checkAccount(rc); err != nil {
+		return nil, nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.forbidden(cloudflarecontroller.PermissionTunnelRead, ""); err != nil {
+		return nil, nil, err
+	}
+	var result []cloudflare.Tunnel
+	for _, tunnel := range c.tunnels {
+		if (params.Name == "" || tunnel.Name == params.Name) &&
+			(params.UUID == "" || tunnel.ID == params.UUID) &&
+			(params.IsDeleted == nil || *params.IsDeleted == (tunnel.DeletedAt != nil)) {
+			result = append(result, tunnel)
+		}
+	}
+	items, info := paginate(result, params.ResultInfo)
+	return items, info, nil
+}
+
+func (c *Cloudflare) CreateTunnel(_ context.Context, rc *cloudflare.ResourceContainer, params cloudflare.TunnelCreateParams) (cloudflare.Tunnel, error) {
+	if err := c.checkAccount(rc); err != nil {
+		return cloudflare.Tunnel{}, err
+	}
+	if params.Name == "" {
+		return cloudflare.Tunnel{}, requestError(1003, "tunnel name is required")
+	}
+	if len(params.Secret) < 32 {
+		return cloudflare.Tunnel{}, requestError(1003, "tunnel secret must be at least 32 bytes")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.forbidden(cloudflarecontroller.PermissionTunnelEdit, ""); err != nil {
+		return cloudflare.Tunnel{}, err
+	}
+	for _, tunnel := range c.tunnels {
+		if tunnel.Name == params.Name && tunnel.DeletedAt == nil {
+			return cloudflare.Tunnel{}, requestError(1013, "you already have a tunnel named %s", params.Name)
+		}
+	}
+	now := time.Now()
+	tunnel := cloudflare.Tunnel{
+		ID:         fmt.Sprintf("00000000-0000-4000-8000-%012x", c.nextID()),
+		Name:       params.Name,
+		Secret:     params.Secret,
+		CreatedAt:  &now,
+		TunnelType: "cfd_tunnel",
+		Status:     "inactive",
+	}
+	c.tunnels = append(c.tunnels, tunnel)
+	return tunnel, nil
+}
+
+func (c *Cloudflare) GetTunnelToken(_ context.Context, rc *cloudflare.ResourceContainer, tunnelID string) (string, error) {
+	if err := c.checkAccount(rc); err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.forbidden(cloudflarecontroller.PermissionTunnelEdit, ""); err != nil {
+		return "", err
+	}
+	tunnel, ok := c.tunnel(tunnelID)
+	if !ok {
+		return "", notFound(1000, "tunnel %s not found", tunnelID)
+	}
+	token, err := json.Marshal(map[string]string{"a": c.accountID, "t": tunnel.ID, "s": base64.StdEncoding.EncodeToString([]byte(tunnel.Secret))})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(token), nil
+}
+
+func (c *Cloudflare) GetTunnelConfiguration(_ context.Context, rc *cloudflare.ResourceContainer, tunnelID string) (cloudflare.TunnelConfigurationResult, error) {
+	if err := c.checkAccount(rc); err != nil {
+		return cloudflare.TunnelConfigurationResult{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.forbidden(cloudflarecontroller.PermissionTunnelRead, ""); err != nil {
+		return cloudflare.TunnelConfigurationResult{}, err
+	}
+	if _, ok := c.tunnel(tunnelID); !ok {
+		return cloudflare.TunnelConfigurationResult{}, notFound(1000, "tunnel %s not found", tunnelID)
+	}
+	result, ok := c.configs[tunnelID]
+	if !ok {
+		return cloudflare.TunnelConfigurationResult{TunnelID: tunnelID}, nil
+	}
+	return result, nil
+}
+
+func (c *Cloudflare) UpdateTunnelConfiguration(_ context.Context, rc *cloudflare.ResourceContainer, params cloudflare.TunnelConfigurationParams) (cloudflare.TunnelConfigurationResult, error) {
+	if err := c.checkAccount(rc); err != nil {
+		return cloudflare.TunnelConfigurationResult{}, err
+	}
+	if err := validateIngressRules(params.Config.Ingress); err != nil {
+		return cloudflare.TunnelConfigurationResult{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.forbidden(cloudflarecontroller.PermissionTunnelEdit, ""); err != nil {
+		return cloudflare.TunnelConfigurationResult{}, err
+	}
+	if _, ok := c.tunnel(params.TunnelID); !ok {
+		return cloudflare.TunnelConfigurationResult{}, notFound(1000, "tunnel %s not found", params.TunnelID)
+	}
+	result := cloudflare.TunnelConfigurationResult{
+		TunnelID: params.TunnelID,
+		Config:   params.Config,
+		Version:  c.configs[params.TunnelID].Version + 1,
+	}
+	c.configs[params.TunnelID] = result
+	return result, nil
+}
+
+// validateIngressRules rejects the rules cloudflared would not start with.
+func validateIngressRules(rules []cloudflare.UnvalidatedIngressRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	for index, rule := range rules {
+		if rule.Service == "" {
+			return requestError(1055, "ingress rule %d has no service", index)
+		}
+		if strings.Contains(strings.TrimPrefix(rule.Hostname, "*."), "*") {
+			return requestError(1055, "hostname %s of ingress rule %d may only have a leading wildcard", rule.Hostname, index)
+		}
+	}
+	last := rules[len(rules)-1]
+	if last.Hostname != "" || last.Path != "" {
+		return requestError(1055, "the last ingress rule must match every request, it may have neither hostname nor path")
+	}
+	return nil
+}
+
+func (c *Cloudflare) ListZonesPage(_ context.Context, page cloudflare.ResultInfo) ([]cloudflare.Zone, *cloudflare.ResultInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	zones := slices.DeleteFunc(slices.Clone(c.zones), func(zone cloudflare.Zone) bool {
+		return c.forbidden(cloudflarecontroller.PermissionZoneRead, zone.Name) != nil
+	})
+	items, info := paginate(zones, page)
+	return items, info, nil
+}
+
+func (c *Cloudflare) ListDNSRecords(_ context.Context, rc *cloudflare.ResourceContainer, params cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error) {
+	return c.listDNSRecords(rc, params.ResultInfo, func(record cloudflare.DNSRecord) bool {
+		return (params.Type == "" || record.Type == params.Type) &&
+			(params.Name == "" || record.Name == strings.ToLower(params.Name)) &&
+			(params.Content == "" || record.Content == params.Content) &&
+			(params.Comment == "" || record.Comment == params.Comment)
+	})
+}
+
+func (c *Cloudflare) ListDNSRecordsWithPrefix(_ context.Context, rc *cloudflare.ResourceContainer, recordType string, prefix string, page cloudflare.ResultInfo) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error) {
+	return c.listDNSRecords(rc, page, func(record cloudflare.DNSRecord) bool {
+		return record.Type == recordType && strings.HasPrefix(record.Name, strings.ToLower(prefix))
+	})
+}
+
+func (c *Cloudflare) listDNSRecords(rc *cloudflare.ResourceContainer, page cloudflare.ResultInfo, match func(record cloudflare.DNSRecord) bool) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	zone, ok := c.zone(rc.Identifier)
+	if !ok {
+		return nil, nil, zoneNotFound(rc.Identifier)
+	}
+	if err := c.forbidden(cloudflarecontroller.PermissionDNSRead, zone.Name); err != nil {
+		return nil, nil, err
+	}
+	var result []cloudflare.DNSRecord
+	for _, record := range c.records[rc.Identifier] {
+		if match(record) {
+			result = append(result, record)
+		}
+	}
+	items, info := paginate(result, page)
+	return items, info, nil
+}
+
+func (c *Cloudflare) CreateDNSRecord(_ context.Context, rc *cloudflare.ResourceContainer, params cloudflare.CreateDNSRecordParams) (cloudflare.DNSRecord, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	zone, ok := c.zone(rc.Identifier)
+	if !ok {
+		return cloudflare.DNSRecord{}, zoneNotFound(rc.Identifier)
+	}
+	if err := c.forbidden(cloudflarecontroller.PermissionDNSEdit, zone.Name); err != nil {
+		return cloudflare.DNSRecord{}, err
+	}
+	now := time.Now()
+	record := cloudflare.DNSRecord{
+		ID:         c.newID(),
+		Type:       params.Type,
+		Name:       strings.TrimSuffix(strings.ToLower(params.Name), "."),
+		Content:    params.Content,
+		Proxied:    cloudflare.BoolPtr(params.Proxied != nil && *params.Proxied),
+		TTL:        params.TTL,
+		Comment:    params.Comment,
+		Tags:       params.Tags,
+		CreatedOn:  now,
+		ModifiedOn: now,
+	}
+	if record.TTL == 0 {
+		record.TTL = 1
+	}
+	if err := c.validateDNSRecord(zone, record); err != nil {
+		return cloudflare.DNSRecord{}, err
+	}
+	c.records[zone.ID] = append(c.records[zone.ID], record)
+	return record, nil
+}
+
+func (c *Cloudflare) UpdateDNSRecord(_ context.Context, rc *cloudflare.ResourceContainer, params cloudflare.UpdateDNSRecordParams) (cloudflare.DNSRecord, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	zone, ok := c.zone(rc.Identifier)
+	if !ok {
+		return cloudflare.DNSRecord{}, zoneNotFound(rc.Identifier)
+	}
+	if err := c.forbidden(cloudflarecontroller.PermissionDNSEdit, zone.Name); err != nil {
+		return cloudflare.DNSRecord{}, err
+	}
+	index := slices.IndexFunc(c.records[zone.ID], func(record cloudflare.DNSRecord) bool { return record.ID == params.ID })
+	if index < 0 {
+		return cloudflare.DNSRecord{}, notFound(81044, "record %s not found", params.ID)
+	}
+
+	// fields left empty keep their value, as the PATCH of the real API
+	record := c.records[zone.ID][index]
+	if params.Type != "" {
+		record.Type = params.Type
+	}
+	if params.Name != "" {
+		record.Name = strings.TrimSuffix(strings.ToLower(params.Name), ".")
+	}
+	if params.Content != "" {
+		record.Content = params.Content
+	}
+	if params.Proxied != nil {
+		record.Proxied = cloudflare.BoolPtr(*params.Proxied)
+	}
+	if params.TTL != 0 {
+		record.TTL = params.TTL
+	}
+	if params.Comment != nil {
+		record.Comment = *params.Comment
+	}
+	if params.Tags != nil {
+		record.Tags = params.Tags
+	}
+	record.ModifiedOn = time.Now()
+	if err := c.validateDNSRecord(zone, record); err != nil {
+		return cloudflare.DNSRecord{}, err
+	}
+	c.records[zone.ID][index] = record
+	return record, nil
+}
+
+func (c *Cloudflare) DeleteDNSRecord(_ context.Context, rc *cloudflare.ResourceContainer, recordID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	zone, ok := c.zone(rc.Identifier)
+	if !ok {
+		return zoneNotFound(rc.Identifier)
+	}
+	if err := c.forbidden(cloudflarecontroller.PermissionDNSEdit, zone.Name); err != nil {
+		return err
+	}
+	records := c.records[rc.Identifier]
+	index := slices.IndexFunc(records, func(record cloudflare.DNSRecord) bool { return record.ID == recordID })
+	if index < 0 {
+		return notFound(81044, "record %s not found", recordID)
+	}
+	c.records[rc.Identifier] = slices.Delete(records, index, index+1)
+	return nil
+}
+
+// validateDNSRecord rejects the record as the real API would, the other
+// records of the zone included.
+func (c *Cloudflare) validateDNSRecord(zone cloudflare.Zone, record cloudflare.DNSRecord) error {
+	if record.Name != zone.Name && !strings.HasSuffix(record.Name, "."+zone.Name) {
+		return requestError(9005, "record name %s is not in zone %s", record.Name, zone.Name)
+	}
+	if record.Content == "" {
+		return requestError(9005, "content of record %s is required", record.Name)
+	}
+	switch record.Type {
+	case "A":
+		if ip := net.ParseIP(record.Content); ip == nil || ip.To4() == nil {
+			return requestError(9005, "content %s of A record %s is not an IPv4 address", record.Content, record.Name)
+		}
+	case "AAAA":
+		if ip := net.ParseIP(record.Content); ip == nil || ip.To4() != nil {
+			return requestError(9005, "content %s of AAAA record %s is not an IPv6 address", record.Content, record.Name)
+		}
+	case "CNAME", "TXT":
+	default:
+		return requestError(9000, "record type %s is not supported", record.Type)
+	}
+	if *record.Proxied && record.Type == "TXT" {
+		return requestError(9004, "record %s of type TXT cannot be proxied", record.Name)
+	}
+	if record.TTL != 1 && (record.TTL < 30 || record.TTL > 86400) {
+		return requestError(9021, "TTL %d of record %s must be 1 or between 30 and 86400", record.TTL, record.Name)
+	}
+	if len(record.Comment) > MaxCommentLength {
+		return requestError(9100, "comment of record %s is longer than %d characters", record.Name, MaxCommentLength)
+	}
+
+	for _, other := range c.records[zone.ID] {
+		if other.ID == record.ID || other.Name != record.Name {
+			continue
+		}
+		if other.Type == record.Type && other.Content == record.Content {
+			return requestError(81058, "an identical record already exists")
+		}
+		if (record.Type == "CNAME" || other.Type == "CNAME") && isAddressOrCNAME(record.Type) && isAddressOrCNAME(other.Type) {
+			return requestError(81053, "an A, AAAA, or CNAME record with the host %s already exists", record.Name)
+		}
+	}
+	return nil
+}
+
+func isAddressOrCNAME(recordType string) bool {
+	return recordType == "A" || recordType == "AAAA" || recordType == "CNAME"
+}
+
+func (c *Cloudflare) checkAccount(rc *cloudflare.ResourceContainer) error {
+	if rc.Identifier != c.accountID {
+		return notFound(7003, "account %s not found", rc.Identifier)
+	}
+	return nil
+}
+
+// forbidden fails when the permission, or the read permission it includes,
+// is denied on the zone with the name.
+func (c *Cloudflare) forbidden(permission cloudflarecontroller.Permission, zoneName string) error {
+	for _, denied := range []cloudflarecontroller.Permission{permission, readPermissions[permission]} {
+		if slices.Contains(c.denied[denied], zoneName) {
+			return forbidden("the API token lacks %s", denied)
+		}
+	}
+	return nil
+}
+
+func (c *Cloudflare) tunnel(tunnelID string) (cloudflare.Tunnel, bool) {
+	index := slices.IndexFunc(c.tunnels, func(tunnel cloudflare.Tunnel) bool { return tunnel.ID == tunnelID && tunnel.DeletedAt == nil })
+	if index < 0 {
+		return cloudflare.Tunnel{}, false
+	}
+	return c.tunnels[index], true
+}
+
+func (c *Cloudflare) zone(zoneID string) (cloudflare.Zone, bool) {
+	index := slices.IndexFunc(c.zones, func(zone cloudflare.Zone) bool { return zone.ID == zoneID })
+	if index < 0 {
+		return cloudflare.Zone{}, false
+	}
+	return c.zones[index], true
+}
+
+func (c *Cloudflare) zoneByName(name string) (cloudflare.Zone, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	index := slices.IndexFunc(c.zones, func(zone cloudflare.Zone) bool { return zone.Name == strings.ToLower(name) })
+	if index < 0 {
+		return cloudflare.Zone{}, false
+	}
+	return c.zones[index], true
+}
+
+func (c *Cloudflare) nextID() int {
+	c.lastID++
+	return c.lastID
+}
+
+func (c *Cloudflare) newID() string {
+	return fmt.Sprintf("%032x", c.nextID())
+}
+
+// paginate returns the page of the items, every item when no page size is
+// given.
+func paginate[T any](items []T, page cloudflare.ResultInfo) ([]T, *cloudflare.ResultInfo) {
+	if page.PerPage <= 0 {
+		return slices.Clone(items), &cloudflare.ResultInfo{Page: 1, PerPage: len(items), TotalPages: 1, Count: len(items), Total: len(items)}
+	}
+	number := max(page.Page, 1)
+	start := min((number-1)*page.PerPage, len(items))
+	end := min(start+page.PerPage, len(items))
+	return slices.Clone(items[start:end]), &cloudflare.ResultInfo{
+		Page:       number,
+		PerPage:    page.PerPage,
+		TotalPages: (len(items) + page.PerPage - 1) / page.PerPage,
+		Count:      end - start,
+		Total:      len(items),
+	}
+}
+
+func requestError(code int, format string, args ...any) error {
+	message := fmt.Sprintf(format, args...)
+	err := cloudflare.NewRequestError(&cloudflare.Error{
+		StatusCode:    http.StatusBadRequest,
+		Type:          cloudflare.ErrorTypeRequest,
+		Errors:        []cloudflare.ResponseInfo{{Code: code, Message: message}},
+		ErrorCodes:    []int{code},
+		ErrorMessages: []string{message},
+	})
+	return &err
+}
+
+func notFound(code int, format string, args ...any) error {
+	message := fmt.Sprintf(format, args...)
+	err := cloudflare.NewNotFoundError(&cloudflare.Error{
+		StatusCode:    http.StatusNotFound,
+		Type:          cloudflare.ErrorTypeNotFound,
+		Errors:        []cloudflare.ResponseInfo{{Code: code, Message: message}},
+		ErrorCodes:    []int{code},
+		ErrorMessages: []string{message},
+	})
+	return &err
+}
+
+func forbidden(format string, args ...any) error {
+	message := fmt.Sprintf(format, args...)
+	// cloudflare-go returns an AuthenticationError for a 403
+	err := cloudflare.NewAuthenticationError(&cloudflare.Error{
+		StatusCode:    http.StatusForbidden,
+		Type:          cloudflare.ErrorTypeAuthentication,
+		Errors:        []cloudflare.ResponseInfo{{Code: 10000, Message: message}},
+		ErrorCodes:    []int{10000},
+		ErrorMessages: []string{message},
+	})
+	return &err
+}
+
+func zoneNotFound(zoneID string) error {
+	return notFound(7003, "zone %s not found", zoneID)
+}
@@ -0,0 +1,119 @@
+package fake
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloudflareTunnels(t *testing.T) {
+	ctx := context.Background()
+	api := NewCloudflare("account")
+	account := cloudflare.AccountIdentifier("account")
+	secret := "0123456789abcdef0123456789abcdef"
+
+	tunnel, err := api.CreateTunnel(ctx, account, cloudflare.TunnelCreateParams{Name: "tunnel", Secret: secret})
+	require.NoError(t, err)
+	_, err = api.CreateTunnel(ctx, account, cloudflare.TunnelCreateParams{Name: "tunnel", Secret: secret})
+	var requestErr *cloudflare.RequestError
+	assert.ErrorAs(t, err, &requestErr, "tunnel names are unique")
+	_, err = api.CreateTunnel(ctx, account, cloudflare.TunnelCreateParams{Name: "short", Secret: "secret"})
+	assert.ErrorAs(t, err, &requestErr)
+	_, err = api.CreateTunnel(ctx, cloudflare.AccountIdentifier("other"), cloudflare.TunnelCreateParams{Name: "other", Secret: secret})
+	var notFoundErr *cloudflare.NotFoundError
+	assert.ErrorAs(t, err, &notFoundErr)
+
+	tunnels, _, err := api.ListTunnels(ctx, account, cloudflare.TunnelListParams{Name: "tunnel"})
+	require.NoError(t, err)
+	require.Len(t, tunnels, 1)
+	assert.Equal(t, tunnel.ID, tunnels[0].ID)
+	token, err := api.GetTunnelToken(ctx, account, tunnel.ID)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+	_, err = api.GetTunnelToken(ctx, account, "missing")
+	assert.ErrorAs(t, err, &notFoundErr)
+
+	_, err = api.UpdateTunnelConfiguration(ctx, account, cloudflare.TunnelConfigurationParams{
+		TunnelID: tunnel.ID,
+		Config: cloudflare.TunnelConfiguration{Ingress: []cloudflare.UnvalidatedIngressRule{
+			{Hostname: "web.example.com", Service: "http://web.default:80"},
+		}},
+	})
+	assert.ErrorAs(t, err, &requestErr, "the last rule must match every request")
+
+	result, err := api.UpdateTunnelConfiguration(ctx, account, cloudflare.TunnelConfigurationParams{
+		TunnelID: tunnel.ID,
+		Config: cloudflare.TunnelConfiguration{Ingress: []cloudflare.UnvalidatedIngressRule{
+			{Hostname: "web.example.com", Service: "http://web.default:80"},
+			{Service: "http_status:404"},
+		}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Version)
+	assert.Len(t, api.TunnelConfiguration(tunnel.ID).Ingress, 2)
+}
+
+func TestCloudflareDNSRecords(t *testing.T) {
+	ctx := context.Background()
+	api := NewCloudflare("account")
+	zone := cloudflare.ZoneIdentifier(api.AddZone("example.com").ID)
+
+	cname, err := api.CreateDNSRecord(ctx, zone, cloudflare.CreateDNSRecordParams{Type: "CNAME", Name: "Web.example.com", Content: "tunnel.cfargotunnel.com", Proxied: cloudflare.BoolPtr(true)})
+	require.NoError(t, err)
+	assert.Equal(t, "web.example.com", cname.Name)
+	assert.Equal(t, 1, cname.TTL)
+
+	var requestErr *cloudflare.RequestError
+	for name, params := range map[string]cloudflare.CreateDNSRecordParams{
+		"outside the zone": {Type: "CNAME", Name: "web.example.org", Content: "tunnel.cfargotunnel.com"},
+		"next to a CNAME":  {Type: "A", Name: "web.example.com", Content: "192.0.2.1"},
+		"identical":        {Type: "CNAME", Name: "web.example.com", Content: "tunnel.cfargotunnel.com"},
+		"invalid TTL":      {Type: "TXT", Name: "web.example.com", Content: "owned", TTL: 10},
+		"proxied TXT":      {Type: "TXT", Name: "web.example.com", Content: "owned", Proxied: cloudflare.BoolPtr(true)},
+		"invalid address":  {Type: "AAAA", Name: "api.example.com", Content: "192.0.2.1"},
+		"unsupported type": {Type: "MX", Name: "example.com", Content: "mail.example.com"},
+		"long comment":     {Type: "TXT", Name: "web.example.com", Content: "owned", Comment: string(make([]byte, MaxCommentLength+1))},
+		"missing content":  {Type: "TXT", Name: "web.example.com"},
+	} {
+		_, err := api.CreateDNSRecord(ctx, zone, params)
+		assert.ErrorAs(t, err, &requestErr, name)
+	}
+
+	_, err = api.CreateDNSRecord(ctx, zone, cloudflare.CreateDNSRecordParams{Type: "TXT", Name: "_ctic_managed.web.example.com", Content: "owned"})
+	require.NoError(t, err)
+	address := cloudflare.CreateDNSRecordParams{Type: "A", Name: "api.example.com", Content: "192.0.2.1", TTL: 60}
+	_, err = api.CreateDNSRecord(ctx, zone, address)
+	require.NoError(t, err)
+	_, err = api.CreateDNSRecord(ctx, zone, address)
+	assert.ErrorAs(t, err, &requestErr, "an identical record already exists")
+
+	records, info, err := api.ListDNSRecords(ctx, zone, cloudflare.ListDNSRecordsParams{ResultInfo: cloudflare.ResultInfo{Page: 1, PerPage: 2}})
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, 3, info.Total)
+	assert.Equal(t, 2, info.TotalPages)
+	records, _, err = api.ListDNSRecords(ctx, zone, cloudflare.ListDNSRecordsParams{Type: "CNAME", Content: "tunnel.cfargotunnel.com"})
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+	records, _, err = api.ListDNSRecordsWithPrefix(ctx, zone, "TXT", "_ctic_managed.", cloudflare.ResultInfo{Page: 1, PerPage: 10})
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+
+	updated, err := api.UpdateDNSRecord(ctx, zone, cloudflare.UpdateDNSRecordParams{ID: cname.ID, Content: "other.cfargotunnel.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "other.cfargotunnel.com", updated.Content)
+	assert.Equal(t, "CNAME", updated.Type, "empty fields keep their value")
+	assert.True(t, *updated.Proxied)
+
+	require.NoError(t, api.DeleteDNSRecord(ctx, zone, cname.ID))
+	err = api.DeleteDNSRecord(ctx, zone, cname.ID)
+	var notFoundErr *cloudflare.NotFoundError
+	assert.ErrorAs(t, err, &notFoundErr)
+	assert.Len(t, api.DNSRecords("example.com"), 2)
+
+	_, _, err = api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier("missing"), cloudflare.ListDNSRecordsParams{})
+	assert.ErrorAs(t, err, &notFoundErr)
+}
@@ -0,0 +1,100 @@
+package fake
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/metrics"
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failedChecks returns the failed checks of the report as "check zone".
+func failedChecks(report cloudflarecontroller.PreflightReport) []string {
+	var failed []string
+	for _, result := range report.Results {
+		if result.Err != nil {
+			failed = append(failed, string(result.Check)+" "+result.Zone)
+		}
+	}
+	return failed
+}
+
+func TestPreflightTunnelClient(t *testing.T) {
+	ctx := context.Background()
+	api := NewCloudflare("account")
+	api.AddZone("example.com")
+	api.AddZone("example.org")
+	_, err := api.AddDNSRecord("example.com", cloudflare.CreateDNSRecordParams{Type: "TXT", Name: "_ctic_preflight.example.com", Content: "cloudflare-tunnel-ingress-controller preflight 00"})
+	require.NoError(t, err)
+	_, err = api.AddDNSRecord("example.com", cloudflare.CreateDNSRecordParams{Type: "TXT", Name: "_ctic_preflight.example.com", Content: "not created by the preflight"})
+	require.NoError(t, err)
+	tunnelClient, err := cloudflarecontroller.BootstrapTunnelClientWithTunnelName(ctx, logr.Discard(), api, "account", "tunnel", cloudflarecontroller.DNSOptions{})
+	require.NoError(t, err)
+
+	report := cloudflarecontroller.PreflightTunnelClient(ctx, logr.Discard(), tunnelClient, nil, true)
+	require.NoError(t, report.Err())
+	assert.Len(t, report.Results, 7)
+	records := api.DNSRecords("example.com")
+	require.Len(t, records, 1, "the leftover record is deleted with the probe")
+	assert.Equal(t, "not created by the preflight", records[0].Content)
+	assert.Empty(t, api.DNSRecords("example.org"))
+
+	// without the write probe the zones are only read
+	api.Deny(cloudflarecontroller.PermissionDNSEdit, "example.org")
+	report = cloudflarecontroller.PreflightTunnelClient(ctx, logr.Discard(), tunnelClient, nil, false)
+	require.NoError(t, report.Err())
+	assert.Len(t, report.Results, 5)
+	assert.Len(t, api.DNSRecords("example.com"), 1)
+
+	api.Deny(cloudflarecontroller.PermissionDNSEdit, "example.org")
+	report = cloudflarecontroller.PreflightTunnelClient(ctx, logr.Discard(), tunnelClient, func(context.Context) error {
+		return errors.New("token expired")
+	}, true)
+	assert.Equal(t, []string{"credentials ", "dns_write example.org"}, failedChecks(report))
+	assert.ErrorContains(t, report.Err(), "dns_write of zone example.org")
+
+	api.Deny(cloudflarecontroller.PermissionTunnelRead, "")
+	report = cloudflarecontroller.PreflightTunnelClient(ctx, logr.Discard(), tunnelClient, nil, true)
+	assert.Equal(t, []string{"tunnel_read ", "tunnel_write ", "dns_write example.org"}, failedChecks(report), "the edit permission includes the read one")
+
+	filtered, err := cloudflarecontroller.BootstrapTunnelClientWithTunnelName(ctx, logr.Discard(), NewCloudflare("account"), "account", "tunnel", cloudflarecontroller.DNSOptions{Zones: []string{"example.net"}})
+	require.NoError(t, err)
+	report = cloudflarecontroller.PreflightTunnelClient(ctx, logr.Discard(), filtered, nil, true)
+	assert.Equal(t, []string{"zones "}, failedChecks(report))
+	assert.ErrorContains(t, report.Err(), "example.net")
+}
+
+func TestPreflightOverHTTP(t *testing.T) {
+	ctx := context.Background()
+	api := NewCloudflare("account")
+	api.AddZone("example.com")
+	server := httptest.NewServer(NewServer(logr.Discard(), api, "token"))
+	defer server.Close()
+
+	credentials := cloudflarecontroller.StaticCredentials{APIToken: "token"}
+	cfClient, err := cloudflarecontroller.NewAPIClient(credentials, cloudflarecontroller.APIClientOptions{BaseURL: server.URL + APIPrefix})
+	require.NoError(t, err)
+	tunnelClient, err := cloudflarecontroller.BootstrapTunnelClientWithTunnelName(ctx, logr.Discard(), cloudflarecontroller.NewCloudflareAPI(cfClient), "account", "tunnel", cloudflarecontroller.DNSOptions{})
+	require.NoError(t, err)
+	preflight := cloudflarecontroller.NewPreflight(logr.Discard(), tunnelClient, cloudflarecontroller.NewCredentialVerifier(cfClient, credentials, "account"), true)
+
+	assert.NoError(t, preflight.Run(ctx).Err())
+	assert.NoError(t, preflight.Check(nil))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.PreflightCheckPassed.WithLabelValues("dns_write", "example.com")))
+
+	api.Deny(cloudflarecontroller.PermissionDNSRead, "example.com")
+	report := preflight.Run(ctx)
+	assert.Equal(t, []string{"dns_read example.com", "dns_write example.com"}, failedChecks(report))
+	var forbiddenErr *cloudflare.AuthenticationError
+	assert.ErrorAs(t, report.Results[len(report.Results)-2].Err, &forbiddenErr, "the fake server answers 403")
+	assert.Error(t, preflight.Check(nil), "the controller is not ready")
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.PreflightCheckPassed.WithLabelValues("dns_read", "example.com")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.PreflightCheckPassed.WithLabelValues("credentials", "")))
+}
@@ -0,0 +1,256 @@
+package fake
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/go-logr/logr"
+)
+
+// APIPrefix is the path of the Cloudflare API on the server, as on
+// api.cloudflare.com.
+const APIPrefix = "/client/v4"
+
+// FakeTokenID is the ID of every API token the server verifies.
+const FakeTokenID = "fake-token-id"
+
+// Server serves the Cloudflare API v4 endpoints the controller uses from the
+// account: tunnels, their configuration and token, zones and DNS records.
+// GET /inspect dumps the Snapshot of the account.
+type Server struct {
+	logger logr.Logger
+	api    *Cloudflare
+	// apiToken is the only token accepted, empty accepts any credentials
+	apiToken string
+	mux      *http.ServeMux
+}
+
+func NewServer(logger logr.Logger, api *Cloudflare, apiToken string) *Server {
+	s := &Server{logger: logger, api: api, apiToken: apiToken, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET "+APIPrefix+"/accounts/{account}/cfd_tunnel", s.listTunnels)
+	s.mux.HandleFunc("POST "+APIPrefix+"/accounts/{account}/cfd_tunnel", s.createTunnel)
+	s.mux.HandleFunc("GET "+APIPrefix+"/accounts/{account}/cfd_tunnel/{tunnel}/token", s.getTunnelToken)
+	s.mux.HandleFunc("GET "+APIPrefix+"/accounts/{account}/cfd_tunnel/{tunnel}/configurations", s.getTunnelConfiguration)
+	s.mux.HandleFunc("PUT "+APIPrefix+"/accounts/{account}/cfd_tunnel/{tunnel}/configurations", s.updateTunnelConfiguration)
+	s.mux.HandleFunc("GET "+APIPrefix+"/zones", s.listZones)
+	s.mux.HandleFunc("GET "+APIPrefix+"/zones/{zone}/dns_records", s.listDNSRecords)
+	s.mux.HandleFunc("POST "+APIPrefix+"/zones/{zone}/dns_records", s.createDNSRecord)
+	s.mux.HandleFunc("PATCH "+APIPrefix+"/zones/{zone}/dns_records/{record}", s.updateDNSRecord)
+	s.mux.HandleFunc("DELETE "+APIPrefix+"/zones/{zone}/dns_records/{record}", s.deleteDNSRecord)
+	s.mux.HandleFunc("GET "+APIPrefix+"/user", s.getUser)
+	s.mux.HandleFunc("GET "+APIPrefix+"/user/tokens/verify", s.verifyToken)
+	s.mux.HandleFunc("GET "+APIPrefix+"/user/tokens/{token}", s.getToken)
+	s.mux.HandleFunc("GET "+APIPrefix+"/accounts/{account}/tokens/verify", s.verifyToken)
+	s.mux.HandleFunc("GET "+APIPrefix+"/accounts/{account}/tokens/{token}", s.getToken)
+	s.mux.HandleFunc("GET /inspect", s.inspect)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.logger.V(3).Info("request", "method", r.Method, "path", r.URL.Path, "query", r.URL.RawQuery)
+	if r.URL.Path != "/inspect" && !s.authenticated(r) {
+		writeErrors(w, http.StatusUnauthorized, cloudflare.ResponseInfo{Code: 10000, Message: "Authentication error"})
+		return
+	}
+	_, pattern := s.mux.Handler(r)
+	if pattern == "" {
+		writeErrors(w, http.StatusNotFound, cloudflare.ResponseInfo{Code: 7000, Message: "No route for that URI"})
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) authenticated(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return s.apiToken == "" && r.Header.Get("X-Auth-Key") != "" && r.Header.Get("X-Auth-Email") != ""
+	}
+	return s.apiToken == "" || token == s.apiToken
+}
+
+func (s *Server) listTunnels(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	params := cloudflare.TunnelListParams{Name: query.Get("name"), UUID: query.Get("uuid"), ResultInfo: pageOf(r)}
+	if value := query.Get("is_deleted"); value != "" {
+		deleted, err := strconv.ParseBool(value)
+		if err != nil {
+			writeErrors(w, http.StatusBadRequest, cloudflare.ResponseInfo{Code: 1001, Message: "is_deleted must be a boolean"})
+			return
+		}
+		params.IsDeleted = &deleted
+	}
+	tunnels, info, err := s.api.ListTunnels(r.Context(), cloudflare.AccountIdentifier(r.PathValue("account")), params)
+	writeResult(w, tunnels, info, err)
+}
+
+func (s *Server) createTunnel(w http.ResponseWriter, r *http.Request) {
+	var params cloudflare.TunnelCreateParams
+	if !readBody(w, r, &params) {
+		return
+	}
+	tunnel, err := s.api.CreateTunnel(r.Context(), cloudflare.AccountIdentifier(r.PathValue("account")), params)
+	writeResult(w, tunnel, nil, err)
+}
+
+func (s *Server) getTunnelToken(w http.ResponseWriter, r *http.Request) {
+	token, err := s.api.GetTunnelToken(r.Context(), cloudflare.AccountIdentifier(r.PathValue("account")), r.PathValue("tunnel"))
+	writeResult(w, token, nil, err)
+}
+
+func (s *Server) getTunnelConfiguration(w http.ResponseWriter, r *http.Request) {
+	result, err := s.api.GetTunnelConfiguration(r.Context(), cloudflare.AccountIdentifier(r.PathValue("account")), r.PathValue("tunnel"))
+	writeResult(w, result, nil, err)
+}
+
+func (s *Server) updateTunnelConfiguration(w http.ResponseWriter, r *http.Request) {
+	var params cloudflare.TunnelConfigurationParams
+	if !readBody(w, r, &params) {
+		return
+	}
+	params.TunnelID = r.PathValue("tunnel")
+	result, err := s.api.UpdateTunnelConfiguration(r.Context(), cloudflare.AccountIdentifier(r.PathValue("account")), params)
+	writeResult(w, result, nil, err)
+}
+
+func (s *Server) listZones(w http.ResponseWriter, r *http.Request) {
+	zones, info, err := s.api.ListZonesPage(r.Context(), pageOf(r))
+	writeResult(w, zones, info, err)
+}
+
+func (s *Server) listDNSRecords(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	zone := cloudflare.ZoneIdentifier(r.PathValue("zone"))
+	if prefix := query.Get("name.startswith"); prefix != "" {
+		records, info, err := s.api.ListDNSRecordsWithPrefix(r.Context(), zone, query.Get("type"), prefix, pageOf(r))
+		writeResult(w, records, info, err)
+		return
+	}
+	records, info, err := s.api.ListDNSRecords(r.Context(), zone, cloudflare.ListDNSRecordsParams{
+		Type:       query.Get("type"),
+		Name:       query.Get("name"),
+		Content:    query.Get("content"),
+		Comment:    query.Get("comment"),
+		ResultInfo: pageOf(r),
+	})
+	writeResult(w, records, info, err)
+}
+
+func (s *Server) createDNSRecord(w http.ResponseWriter, r *http.Request) {
+	var params cloudflare.CreateDNSRecordParams
+	if !readBody(w, r, &params) {
+		return
+	}
+	record, err := s.api.CreateDNSRecord(r.Context(), cloudflare.ZoneIdentifier(r.PathValue("zone")), params)
+	writeResult(w, record, nil, err)
+}
+
+func (s *Server) updateDNSRecord(w http.ResponseWriter, r *http.Request) {
+	var params cloudflare.UpdateDNSRecordParams
+	if !readBody(w, r, &params) {
+		return
+	}
+	params.ID = r.PathValue("record")
+	record, err := s.api.UpdateDNSRecord(r.Context(), cloudflare.ZoneIdentifier(r.PathValue("zone")), params)
+	writeResult(w, record, nil, err)
+}
+
+func (s *Server) deleteDNSRecord(w http.ResponseWriter, r *http.Request) {
+	err := s.api.DeleteDNSRecord(r.Context(), cloudflare.ZoneIdentifier(r.PathValue("zone")), r.PathValue("record"))
+	writeResult(w, map[string]string{"id": r.PathValue("record")}, nil, err)
+}
+
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, cloudflare.User{ID: "fake-user", Email: r.Header.Get("X-Auth-Email")}, nil, nil)
+}
+
+// verifyToken accepts every token the server authenticates.
+func (s *Server) verifyToken(w http.ResponseWriter, _ *http.Request) {
+	writeResult(w, cloudflare.APITokenVerifyBody{ID: FakeTokenID, Status: "active"}, nil, nil)
+}
+
+// getToken returns a token with every permission the controller needs.
+func (s *Server) getToken(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("token") != FakeTokenID {
+		writeErrors(w, http.StatusNotFound, cloudflare.ResponseInfo{Code: 1003, Message: "Invalid API Token"})
+		return
+	}
+	writeResult(w, cloudflare.APIToken{
+		ID:     FakeTokenID,
+		Name:   "fake-cloudflare",
+		Status: "active",
+		Policies: []cloudflare.APITokenPolicies{{
+			Effect: "allow",
+			PermissionGroups: []cloudflare.APITokenPermissionGroups{
+				{Name: "Cloudflare Tunnel Write"},
+				{Name: "Zone Read"},
+				{Name: "DNS Write"},
+			},
+		}},
+	}, nil, nil)
+}
+
+func (s *Server) inspect(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(s.api.Snapshot())
+}
+
+// pageOf reads the page and per_page query parameters, 0 when missing.
+func pageOf(r *http.Request) cloudflare.ResultInfo {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	return cloudflare.ResultInfo{Page: page, PerPage: perPage}
+}
+
+func readBody(w http.ResponseWriter, r *http.Request, params any) bool {
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		writeErrors(w, http.StatusBadRequest, cloudflare.ResponseInfo{Code: 1001, Message: "invalid request body: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+type response struct {
+	cloudflare.Response
+	Result     any                    `json:"result"`
+	ResultInfo *cloudflare.ResultInfo `json:"result_info,omitempty"`
+}
+
+// writeResult writes the result, or the error with the status code
+// cloudflare-go turns back into the same error type.
+func writeResult(w http.ResponseWriter, result any, info *cloudflare.ResultInfo, err error) {
+	var requestErr *cloudflare.RequestError
+	var notFoundErr *cloudflare.NotFoundError
+	var forbiddenErr *cloudflare.AuthenticationError
+	switch {
+	case errors.As(err, &requestErr):
+		writeErrors(w, http.StatusBadRequest, requestErr.Errors()...)
+	case errors.As(err, &forbiddenErr):
+		writeErrors(w, http.StatusForbidden, forbiddenErr.Errors()...)
+	case errors.As(err, &notFoundErr):
+		writeErrors(w, http.StatusNotFound, notFoundErr.Errors()...)
+	case err != nil:
+		writeErrors(w, http.StatusInternalServerError, cloudflare.ResponseInfo{Code: 10001, Message: err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, response{
+			Response:   cloudflare.Response{Success: true, Errors: []cloudflare.ResponseInfo{}, Messages: []cloudflare.ResponseInfo{}},
+			Result:     result,
+			ResultInfo: info,
+		})
+	}
+}
+
+func writeErrors(w http.ResponseWriter, status int, errs ...cloudflare.ResponseInfo) {
+	writeJSON(w, status, response{Response: cloudflare.Response{Success: false, Errors: errs, Messages: []cloudflare.ResponseInfo{}}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,59 @@
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	api := NewCloudflare("account")
+	api.AddZone("example.com")
+	server := httptest.NewServer(NewServer(logr.Discard(), api, "token"))
+	defer server.Close()
+
+	unauthorized, err := cloudflarecontroller.NewAPIClient(cloudflarecontroller.StaticCredentials{APIToken: "wrong"}, cloudflarecontroller.APIClientOptions{BaseURL: server.URL + APIPrefix})
+	require.NoError(t, err)
+	_, err = cloudflarecontroller.BootstrapTunnelClientWithTunnelName(ctx, logr.Discard(), cloudflarecontroller.NewCloudflareAPI(unauthorized), "account", "tunnel", cloudflarecontroller.DNSOptions{})
+	assert.Error(t, err)
+
+	cfClient, err := cloudflarecontroller.NewAPIClient(cloudflarecontroller.StaticCredentials{APIToken: "token"}, cloudflarecontroller.APIClientOptions{BaseURL: server.URL + APIPrefix})
+	require.NoError(t, err)
+	tunnelClient, err := cloudflarecontroller.BootstrapTunnelClientWithTunnelName(ctx, logr.Discard(), cloudflarecontroller.NewCloudflareAPI(cfClient), "account", "tunnel", cloudflarecontroller.DNSOptions{})
+	require.NoError(t, err)
+	token, err := tunnelClient.FetchTunnelToken(ctx)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+
+	require.NoError(t, tunnelClient.PutExposures(ctx, []exposure.Exposure{
+		{Hostname: "web.example.com", ServiceTarget: "http://web.default.svc.cluster.local:80", PathPrefix: "/"},
+	}))
+	require.NoError(t, tunnelClient.PutExposures(ctx, []exposure.Exposure{
+		{Hostname: "api.example.com", ServiceTarget: "http://api.default.svc.cluster.local:80", PathPrefix: "/"},
+	}))
+
+	response, err := http.Get(server.URL + "/inspect")
+	require.NoError(t, err)
+	defer response.Body.Close()
+	var snapshot Snapshot
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&snapshot))
+	require.Len(t, snapshot.Tunnels, 1)
+	ingress := snapshot.Tunnels[0].Configuration.Config.Ingress
+	require.Len(t, ingress, 2)
+	assert.Equal(t, "api.example.com", ingress[0].Hostname)
+	require.Len(t, snapshot.Zones, 1)
+	var names []string
+	for _, record := range snapshot.Zones[0].Records {
+		names = append(names, record.Type+" "+record.Name)
+	}
+	assert.Equal(t, []string{"TXT _ctic_managed.api.example.com", "CNAME api.example.com"}, names, "the records of web.example.com are deleted")
+}
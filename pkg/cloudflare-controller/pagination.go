@@ -0,0 +1,56 @@
+package cloudflarecontroller
+
+import (
+	"context"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// Page sizes of the listings, the largest each endpoint accepts.
+const (
+	zonesPageSize      = 50
+	dnsRecordsPageSize = 1000
+	tunnelsPageSize    = 1000
+	accessPageSize     = 1000
+)
+
+// listAllPages requests the pages of a listing one after the other. A page
+// shorter than perPage is the last one unless the result info announces more,
+// some endpoints leave the page count out of the result info and the
+// pagination of cloudflare-go then stops after the first page.
+func listAllPages[T any](perPage int, fetch func(page cloudflare.ResultInfo) ([]T, *cloudflare.ResultInfo, error)) ([]T, error) {
+	var result []T
+	for page := 1; ; page++ {
+		items, info, err := fetch(cloudflare.ResultInfo{Page: page, PerPage: perPage})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, items...)
+		if len(items) == 0 || (len(items) < perPage && (info == nil || !info.HasMorePages())) {
+			return result, nil
+		}
+	}
+}
+
+// listZones lists every zone the credentials can read.
+func listZones(ctx context.Context, cfClient CloudflareAPI) ([]cloudflare.Zone, error) {
+	return listAllPages(zonesPageSize, func(page cloudflare.ResultInfo) ([]cloudflare.Zone, *cloudflare.ResultInfo, error) {
+		return cfClient.ListZonesPage(ctx, page)
+	})
+}
+
+// listDNSRecords lists the records of the zone matching the params.
+func listDNSRecords(ctx context.Context, cfClient CloudflareAPI, zoneID string, params cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, error) {
+	return listAllPages(dnsRecordsPageSize, func(page cloudflare.ResultInfo) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error) {
+		params.ResultInfo = page
+		return cfClient.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), params)
+	})
+}
+
+// listDNSRecordsWithPrefix lists the records of the zone of the type whose
+// name starts with the prefix.
+func listDNSRecordsWithPrefix(ctx context.Context, cfClient CloudflareAPI, zoneID string, recordType string, prefix string) ([]cloudflare.DNSRecord, error) {
+	return listAllPages(dnsRecordsPageSize, func(page cloudflare.ResultInfo) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error) {
+		return cfClient.ListDNSRecordsWithPrefix(ctx, cloudflare.ZoneIdentifier(zoneID), recordType, prefix, page)
+	})
+}
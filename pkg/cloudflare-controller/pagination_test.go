@@ -0,0 +1,171 @@
+package cloudflarecontroller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pagedAPI serves the listings of the Cloudflare API page by page, the
+// tunnels without the page count like the real API does.
+type pagedAPI struct {
+	tunnels []cloudflare.Tunnel
+	zones   []cloudflare.Zone
+	records []cloudflare.DNSRecord
+
+	mu      sync.Mutex
+	queries []string
+}
+
+func (p *pagedAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.queries = append(p.queries, r.URL.Path+"?"+r.URL.RawQuery)
+	p.mu.Unlock()
+
+	query := r.URL.Query()
+	switch {
+	case r.URL.Path == "/accounts/account/cfd_tunnel":
+		var result []cloudflare.Tunnel
+		for _, tunnel := range p.tunnels {
+			if query.Get("name") == "" || tunnel.Name == query.Get("name") {
+				result = append(result, tunnel)
+			}
+		}
+		writePage(w, r, result, false)
+	case r.URL.Path == "/zones":
+		writePage(w, r, p.zones, true)
+	case r.URL.Path == "/zones/zone/dns_records":
+		var result []cloudflare.DNSRecord
+		for _, record := range p.records {
+			if (query.Get("type") == "" || record.Type == query.Get("type")) &&
+				(query.Get("name") == "" || record.Name == query.Get("name")) &&
+				strings.HasPrefix(record.Name, query.Get("name.startswith")) &&
+				(query.Get("content") == "" || record.Content == query.Get("content")) &&
+				(query.Get("comment") == "" || record.Comment == query.Get("comment")) {
+				result = append(result, record)
+			}
+		}
+		writePage(w, r, result, true)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writePage[T any](w http.ResponseWriter, r *http.Request, items []T, withTotals bool) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 {
+		perPage = 20
+	}
+	start := min((page-1)*perPage, len(items))
+	end := min(start+perPage, len(items))
+	info := cloudflare.ResultInfo{Page: page, PerPage: perPage, Count: end - start}
+	if withTotals {
+		info.Total = len(items)
+		info.TotalPages = (len(items) + perPage - 1) / perPage
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"success":     true,
+		"errors":      []any{},
+		"messages":    []any{},
+		"result":      items[start:end],
+		"result_info": info,
+	})
+}
+
+func newPagedAPIClient(t *testing.T, api *pagedAPI) CloudflareAPI {
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	cfClient, err := cloudflare.NewWithAPIToken("token", cloudflare.BaseURL(server.URL))
+	require.NoError(t, err)
+	return NewCloudflareAPI(cfClient)
+}
+
+func TestListAllPages(t *testing.T) {
+	api := &pagedAPI{}
+	for i := range 2*tunnelsPageSize + 1 {
+		api.tunnels = append(api.tunnels, cloudflare.Tunnel{ID: fmt.Sprintf("id-%d", i), Name: "tunnel"})
+	}
+	api.tunnels = append(api.tunnels, cloudflare.Tunnel{ID: "other", Name: "other"})
+	for i := range 2*zonesPageSize + 3 {
+		api.zones = append(api.zones, cloudflare.Zone{ID: fmt.Sprintf("zone-%d", i), Name: fmt.Sprintf("example-%d.com", i)})
+	}
+	cfClient := newPagedAPIClient(t, api)
+
+	tunnelId, found, err := findTunnelIdByName(context.Background(), logr.Discard(), cfClient, "other", "account")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "other", tunnelId)
+
+	tunnels, err := listAllPages(tunnelsPageSize, func(page cloudflare.ResultInfo) ([]cloudflare.Tunnel, *cloudflare.ResultInfo, error) {
+		return cfClient.ListTunnels(context.Background(), cloudflare.AccountIdentifier("account"), cloudflare.TunnelListParams{Name: "tunnel", ResultInfo: page})
+	})
+	require.NoError(t, err)
+	assert.Len(t, tunnels, 2*tunnelsPageSize+1, "the pages after the first are listed without page count")
+
+	zones, err := listZones(context.Background(), cfClient)
+	require.NoError(t, err)
+	assert.Len(t, zones, 2*zonesPageSize+3)
+}
+
+func TestListDNSRecordsForZone(t *testing.T) {
+	txtContent, err := renderTXTContent("tunnel", "")
+	require.NoError(t, err)
+
+	api := &pagedAPI{}
+	for i := range 3 * dnsRecordsPageSize {
+		api.records = append(api.records,
+			cloudflare.DNSRecord{ID: fmt.Sprintf("other-%d", i), Type: "CNAME", Name: fmt.Sprintf("other-%d.example.com", i), Content: "example.net"},
+			cloudflare.DNSRecord{ID: fmt.Sprintf("app-%d", i), Type: "CNAME", Name: fmt.Sprintf("app-%d.example.com", i), Content: tunnelDomain("tunnel-id")},
+			cloudflare.DNSRecord{ID: fmt.Sprintf("app-%d-txt", i), Type: "TXT", Name: fmt.Sprintf("_ctic_managed.app-%d.example.com", i), Content: txtContent},
+		)
+	}
+	api.records = append(api.records,
+		cloudflare.DNSRecord{ID: "web", Type: "A", Name: "web.example.com", Content: "192.0.2.1"},
+		cloudflare.DNSRecord{ID: "web-txt", Type: "TXT", Name: "web.example.com", Content: "v=spf1 -all"},
+		cloudflare.DNSRecord{ID: "legacy", Type: "CNAME", Name: "legacy.example.com", Content: "example.net", Comment: renderLegacyComment("tunnel")},
+	)
+	client := NewTunnelClient(logr.Discard(), newPagedAPIClient(t, api), "account", "tunnel-id", "tunnel", DNSOptions{})
+
+	cnameRecords, addressRecords, txtRecords, err := client.listDNSRecordsForZone(context.Background(), []exposure.Exposure{
+		{Hostname: "web.example.com", PathPrefix: "/"},
+	}, cloudflare.Zone{ID: "zone", Name: "example.com"})
+	require.NoError(t, err)
+
+	assert.Len(t, txtRecords, 3*dnsRecordsPageSize)
+	assert.Len(t, cnameRecords, 3*dnsRecordsPageSize+1)
+	assert.True(t, slices.ContainsFunc(cnameRecords, func(record cloudflare.DNSRecord) bool { return record.ID == "legacy" }))
+	assert.False(t, slices.ContainsFunc(cnameRecords, func(record cloudflare.DNSRecord) bool { return strings.HasPrefix(record.ID, "other-") }))
+	require.Len(t, addressRecords, 1)
+	assert.Equal(t, "web", addressRecords[0].ID)
+
+	var nameQueries []string
+	for _, query := range api.queries {
+		if !strings.HasPrefix(query, "/zones/zone/dns_records?") {
+			continue
+		}
+		assert.True(t, slices.ContainsFunc([]string{"name=", "name.startswith=", "content=", "comment="}, func(filter string) bool {
+			return strings.Contains(query, filter)
+		}), "unfiltered listing %s", query)
+		if strings.Contains(query, "name=web.example.com") {
+			nameQueries = append(nameQueries, query)
+		}
+	}
+	assert.Len(t, nameQueries, 1, "only the hostnames not pointing at the tunnel are listed by name")
+}
@@ -0,0 +1,71 @@
+package cloudflarecontroller
+
+import (
+	"regexp"
+	"strings"
+)
+
+// cloudflared matches the path of a tunnel rule as an unanchored regular
+// expression against the request path, the helpers below render Ingress path
+// types into such expressions.
+
+// prefixPathSuffix ends the expression of a prefix path, it matches the end of
+// the path or the start of the next path element.
+const prefixPathSuffix = "(/|$)"
+
+// ExactPathRegex returns the tunnel rule path matching the path only.
+func ExactPathRegex(path string) string {
+	return "^" + regexp.QuoteMeta(path) + "$"
+}
+
+// PrefixPathRegex returns the tunnel rule path matching the path and the paths
+// below it element by element: /api matches /api and /api/v1, not /apiv2. A
+// trailing slash is ignored.
+func PrefixPathRegex(path string) string {
+	trimmed := strings.TrimRight(path, "/")
+	if trimmed == "" {
+		// every request path starts with a slash
+		return "/"
+	}
+	return "^" + regexp.QuoteMeta(trimmed) + prefixPathSuffix
+}
+
+// pathPrecedence returns the length of the path matched by a tunnel rule path
+// and whether the match is exact. A raw expression counts its own length.
+func pathPrecedence(path string) (int, bool) {
+	if literal, ok := quotedPath(path, "$"); ok {
+		return len(literal), true
+	}
+	if literal, ok := quotedPath(path, prefixPathSuffix); ok {
+		return len(literal), false
+	}
+	return len(path), false
+}
+
+// quotedPath returns the literal path of an expression rendered by
+// ExactPathRegex or PrefixPathRegex, suffix telling which one.
+func quotedPath(path string, suffix string) (string, bool) {
+	inner, ok := strings.CutPrefix(path, "^")
+	if !ok {
+		return "", false
+	}
+	inner, ok = strings.CutSuffix(inner, suffix)
+	if !ok {
+		return "", false
+	}
+
+	var literal strings.Builder
+	escaped := false
+	for _, r := range inner {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		literal.WriteRune(r)
+	}
+	if regexp.QuoteMeta(literal.String()) != inner {
+		return "", false
+	}
+	return literal.String(), true
+}
@@ -0,0 +1,72 @@
+package cloudflarecontroller
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathRegex(t *testing.T) {
+	tests := []struct {
+		name      string
+		regex     string
+		matches   []string
+		unmatched []string
+	}{
+		{
+			name:      "exact",
+			regex:     ExactPathRegex("/api"),
+			matches:   []string{"/api"},
+			unmatched: []string{"/api/", "/api/v1", "/apiv2", "/v1/api"},
+		},
+		{
+			name:      "exact with metacharacters",
+			regex:     ExactPathRegex("/v1.0/items(1)"),
+			matches:   []string{"/v1.0/items(1)"},
+			unmatched: []string{"/v1x0/items1"},
+		},
+		{
+			name:      "prefix",
+			regex:     PrefixPathRegex("/api"),
+			matches:   []string{"/api", "/api/", "/api/v1"},
+			unmatched: []string{"/apiv2", "/v1/api"},
+		},
+		{
+			name:      "prefix with trailing slash",
+			regex:     PrefixPathRegex("/api/"),
+			matches:   []string{"/api", "/api/v1"},
+			unmatched: []string{"/apiv2"},
+		},
+		{
+			name:    "root prefix",
+			regex:   PrefixPathRegex("/"),
+			matches: []string{"/", "/api", "/apiv2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re := regexp.MustCompile(tt.regex)
+			for _, path := range tt.matches {
+				assert.True(t, re.MatchString(path), "%s must match %s", tt.regex, path)
+			}
+			for _, path := range tt.unmatched {
+				assert.False(t, re.MatchString(path), "%s must not match %s", tt.regex, path)
+			}
+		})
+	}
+}
+
+func TestPathPrecedence(t *testing.T) {
+	length, exact := pathPrecedence(ExactPathRegex("/v1.0$"))
+	assert.Equal(t, 6, length)
+	assert.True(t, exact)
+
+	length, exact = pathPrecedence(PrefixPathRegex("/api/"))
+	assert.Equal(t, 4, length)
+	assert.False(t, exact)
+
+	length, exact = pathPrecedence("^/users/[0-9]+$")
+	assert.Equal(t, 15, length)
+	assert.False(t, exact)
+}
@@ -0,0 +1,141 @@
+package cloudflarecontroller
+
+import (
+	"context"
+	"reflect"
+	"slices"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/metrics"
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/pkg/errors"
+)
+
+// Operations of a planned DNS record change.
+const (
+	DNSOperationKindCreate = "create"
+	DNSOperationKindUpdate = "update"
+	DNSOperationKindDelete = "delete"
+)
+
+// Plan lists the changes a put of the exposures would make on Cloudflare.
+type Plan struct {
+	TunnelID     string            `json:"tunnelID"`
+	TunnelName   string            `json:"tunnelName"`
+	IngressRules IngressRulesDiff  `json:"ingressRules"`
+	DNSRecords   []DNSRecordChange `json:"dnsRecords"`
+	// Invalid are the hostnames left out of the put, they are reported on
+	// their Ingress instead of failing the tunnel.
+	Invalid []ExposureError `json:"invalid,omitempty"`
+}
+
+// IsEmpty reports whether Cloudflare already matches the exposures.
+func (p Plan) IsEmpty() bool {
+	return p.IngressRules.IsEmpty() && len(p.DNSRecords) == 0
+}
+
+// IngressRulesDiff compares the tunnel ingress rules on Cloudflare with the
+// desired ones.
+type IngressRulesDiff struct {
+	Added   []cloudflare.UnvalidatedIngressRule `json:"added"`
+	Removed []cloudflare.UnvalidatedIngressRule `json:"removed"`
+	// Reordered is set when the same rules are evaluated in another order.
+	Reordered bool `json:"reordered"`
+}
+
+func (d IngressRulesDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && !d.Reordered
+}
+
+// size counts a reordering as one changed rule.
+func (d IngressRulesDiff) size() int {
+	result := len(d.Added) + len(d.Removed)
+	if result == 0 && d.Reordered {
+		return 1
+	}
+	return result
+}
+
+// DNSRecordChange is a planned DNS record operation.
+type DNSRecordChange struct {
+	Operation string `json:"operation"`
+	Zone      string `json:"zone"`
+	Type      string `json:"type"`
+	Hostname  string `json:"hostname"`
+	// Content is the content after the change, empty for a deletion.
+	Content string `json:"content,omitempty"`
+	// OldContent is the content before the change, empty for a creation.
+	OldContent string `json:"oldContent,omitempty"`
+	// DNSOnly is set when the record is created or updated unproxied.
+	DNSOnly bool `json:"dnsOnly,omitempty"`
+	// TTL in seconds after the change, zero is automatic.
+	TTL int `json:"ttl,omitempty"`
+}
+
+// Plan computes the changes a PutExposures call would make, it only reads
+// from Cloudflare.
+func (t *TunnelClient) Plan(ctx context.Context, exposures []exposure.Exposure) (Plan, error) {
+	zoneExposures, failures, err := t.groupExposuresByZone(ctx, exposures)
+	if err != nil {
+		return Plan{}, err
+	}
+	ingressRules, err := desiredIngressRules(ctx, withoutFailedExposures(exposures, failures))
+	if err != nil {
+		return Plan{}, err
+	}
+	current, err := t.cfClient.GetTunnelConfiguration(ctx, cloudflare.ResourceIdentifier(t.accountId), t.tunnelId)
+	if err != nil {
+		metrics.CloudflareAPIErrors.WithLabelValues("get_tunnel_configuration").Inc()
+		return Plan{}, errors.Wrap(err, "get cloudflare tunnel config")
+	}
+
+	result := Plan{
+		TunnelID:     t.tunnelId,
+		TunnelName:   t.tunnelName,
+		IngressRules: diffIngressRules(current.Config.Ingress, ingressRules),
+		Invalid:      failures,
+	}
+	for _, item := range zoneExposures {
+		plan, err := t.planDNSRecordsForZone(ctx, item.exposures, item.zone)
+		if err != nil {
+			return Plan{}, errors.Wrapf(err, "plan DNS records for zone %s", item.zone.Name)
+		}
+		result.DNSRecords = append(result.DNSRecords, plan.changes(item.zone.Name)...)
+		result.Invalid = append(result.Invalid, plan.invalid...)
+	}
+	return result, nil
+}
+
+// diffIngressRules returns the rules only present on one side.
+func diffIngressRules(current []cloudflare.UnvalidatedIngressRule, desired []cloudflare.UnvalidatedIngressRule) IngressRulesDiff {
+	if reflect.DeepEqual(current, desired) {
+		return IngressRulesDiff{}
+	}
+	result := IngressRulesDiff{}
+	for _, rule := range desired {
+		if !slices.ContainsFunc(current, func(other cloudflare.UnvalidatedIngressRule) bool { return reflect.DeepEqual(rule, other) }) {
+			result.Added = append(result.Added, rule)
+		}
+	}
+	for _, rule := range current {
+		if !slices.ContainsFunc(desired, func(other cloudflare.UnvalidatedIngressRule) bool { return reflect.DeepEqual(rule, other) }) {
+			result.Removed = append(result.Removed, rule)
+		}
+	}
+	result.Reordered = len(result.Added) == 0 && len(result.Removed) == 0
+	return result
+}
+
+func (p dnsPlan) changes(zone string) []DNSRecordChange {
+	var result []DNSRecordChange
+	for _, item := range p.toCreate {
+		result = append(result, DNSRecordChange{Operation: DNSOperationKindCreate, Zone: zone, Type: item.Type, Hostname: item.Hostname, Content: item.Content, DNSOnly: item.DNSOnly, TTL: item.TTL})
+	}
+	for _, item := range p.toUpdate {
+		result = append(result, DNSRecordChange{Operation: DNSOperationKindUpdate, Zone: zone, Type: item.Type, Hostname: item.OldRecord.Name, Content: item.Content, OldContent: item.OldRecord.Content, DNSOnly: item.DNSOnly, TTL: item.TTL})
+	}
+	for _, item := range p.toDelete {
+		result = append(result, DNSRecordChange{Operation: DNSOperationKindDelete, Zone: zone, Type: item.OldRecord.Type, Hostname: item.OldRecord.Name, OldContent: item.OldRecord.Content})
+	}
+	return result
+}
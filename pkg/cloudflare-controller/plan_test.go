@@ -0,0 +1,35 @@
+package cloudflarecontroller
+
+import (
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffIngressRules(t *testing.T) {
+	app := cloudflare.UnvalidatedIngressRule{Hostname: "app.example.com", Service: "http://app.default.svc.cluster.local:80"}
+	api := cloudflare.UnvalidatedIngressRule{Hostname: "api.example.com", Service: "http://api.default.svc.cluster.local:80"}
+	fallback := cloudflare.UnvalidatedIngressRule{Service: "http_status:404"}
+
+	assert.True(t, diffIngressRules([]cloudflare.UnvalidatedIngressRule{api, app, fallback}, []cloudflare.UnvalidatedIngressRule{api, app, fallback}).IsEmpty())
+	assert.Equal(t, IngressRulesDiff{Reordered: true}, diffIngressRules([]cloudflare.UnvalidatedIngressRule{app, api, fallback}, []cloudflare.UnvalidatedIngressRule{api, app, fallback}))
+	assert.Equal(t, IngressRulesDiff{
+		Added:   []cloudflare.UnvalidatedIngressRule{app},
+		Removed: []cloudflare.UnvalidatedIngressRule{api},
+	}, diffIngressRules([]cloudflare.UnvalidatedIngressRule{api, fallback}, []cloudflare.UnvalidatedIngressRule{app, fallback}))
+}
+
+func TestDNSPlanChanges(t *testing.T) {
+	plan := dnsPlan{
+		toCreate: []DNSOperationCreate{{Hostname: "app.example.com", Type: "CNAME", Content: "tunnel.cfargotunnel.com"}},
+		toUpdate: []DNSOperationUpdate{{OldRecord: cloudflare.DNSRecord{Name: "www.example.com", Type: "CNAME", Content: "origin.example.net"}, Type: "CNAME", Content: "tunnel.cfargotunnel.com"}},
+		toDelete: []DNSOperationDelete{{OldRecord: cloudflare.DNSRecord{Name: "_ctic_managed.old.example.com", Type: "TXT", Content: "owner"}}},
+	}
+
+	assert.Equal(t, []DNSRecordChange{
+		{Operation: DNSOperationKindCreate, Zone: "example.com", Type: "CNAME", Hostname: "app.example.com", Content: "tunnel.cfargotunnel.com"},
+		{Operation: DNSOperationKindUpdate, Zone: "example.com", Type: "CNAME", Hostname: "www.example.com", Content: "tunnel.cfargotunnel.com", OldContent: "origin.example.net"},
+		{Operation: DNSOperationKindDelete, Zone: "example.com", Type: "TXT", Hostname: "_ctic_managed.old.example.com", OldContent: "owner"},
+	}, plan.changes("example.com"))
+}
@@ -0,0 +1,250 @@
+package cloudflarecontroller
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/metrics"
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+)
+
+// PreflightRecordPrefix is the first label of the TXT record the preflight
+// creates and deletes again in every managed zone.
+const PreflightRecordPrefix = "_ctic_preflight"
+
+// preflightRetryDelay is the delay before a failed preflight runs again, it
+// doubles with every failure up to the interval.
+const preflightRetryDelay = 15 * time.Second
+
+// preflightRecordContent starts the content of the preflight records, a
+// leftover record is only deleted with it.
+const preflightRecordContent = "cloudflare-tunnel-ingress-controller preflight"
+
+// PreflightCheck is what a preflight check proves, it is also the check
+// label of the preflight metric.
+type PreflightCheck string
+
+const (
+	PreflightCheckCredentials PreflightCheck = "credentials"
+	PreflightCheckTunnelRead  PreflightCheck = "tunnel_read"
+	PreflightCheckTunnelWrite PreflightCheck = "tunnel_write"
+	PreflightCheckZones       PreflightCheck = "zones"
+	PreflightCheckDNSRead     PreflightCheck = "dns_read"
+	PreflightCheckDNSWrite    PreflightCheck = "dns_write"
+)
+
+// PreflightResult is the outcome of a check, Zone is the zone of the DNS
+// checks.
+type PreflightResult struct {
+	Check PreflightCheck
+	Zone  string
+	Err   error
+}
+
+// PreflightReport holds the results of PreflightTunnelClient.
+type PreflightReport struct {
+	Results []PreflightResult
+}
+
+func (r *PreflightReport) add(check PreflightCheck, zone string, err error) {
+	r.Results = append(r.Results, PreflightResult{Check: check, Zone: zone, Err: err})
+}
+
+// Err lists the failed checks, nil when every check passed.
+func (r PreflightReport) Err() error {
+	var failures []string
+	for _, result := range r.Results {
+		switch {
+		case result.Err == nil:
+		case result.Zone != "":
+			failures = append(failures, fmt.Sprintf("%s of zone %s: %s", result.Check, result.Zone, result.Err))
+		default:
+			failures = append(failures, fmt.Sprintf("%s: %s", result.Check, result.Err))
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	return errors.Errorf("%d cloudflare preflight checks failed: %s", len(failures), strings.Join(failures, "; "))
+}
+
+// CredentialVerifier fails when the credentials are not valid anymore.
+type CredentialVerifier func(ctx context.Context) error
+
+// NewCredentialVerifier verifies the current credentials of the source as
+// CheckAuth does.
+func NewCredentialVerifier(cfClient *cloudflare.API, source CredentialSource, accountId string) CredentialVerifier {
+	return func(ctx context.Context) error {
+		credentials, err := source.Credentials()
+		if err != nil {
+			return errors.Wrap(err, "get cloudflare credentials")
+		}
+		_, err = verifyCredentials(ctx, cfClient, credentials.mode(), accountId)
+		return errors.Wrapf(err, "verify cloudflare credentials of mode %s", credentials.mode())
+	}
+}
+
+// PreflightTunnelClient verifies the credentials when verify is not nil,
+// then probes what the tunnel client needs: reading the configuration of the
+// tunnel, fetching its token, which needs the edit permission without
+// changing the tunnel, listing every managed zone, and listing the TXT
+// records of every managed zone. With dnsWrite it also creates and
+// deletes a TXT record in every managed zone. A failed check does not skip
+// the next ones, so the report names every missing permission at once.
+func PreflightTunnelClient(ctx context.Context, logger logr.Logger, tunnelClient *TunnelClient, verify CredentialVerifier, dnsWrite bool) PreflightReport {
+	var report PreflightReport
+	if verify != nil {
+		report.add(PreflightCheckCredentials, "", verify(ctx))
+	}
+
+	account := cloudflare.AccountIdentifier(tunnelClient.accountId)
+	_, err := tunnelClient.cfClient.GetTunnelConfiguration(ctx, account, tunnelClient.tunnelId)
+	report.add(PreflightCheckTunnelRead, "", errors.Wrapf(err, "get configuration of tunnel %s", tunnelClient.tunnelName))
+	_, err = tunnelClient.cfClient.GetTunnelToken(ctx, account, tunnelClient.tunnelId)
+	report.add(PreflightCheckTunnelWrite, "", errors.Wrapf(err, "get token of tunnel %s", tunnelClient.tunnelName))
+
+	zones, err := tunnelClient.zones.list(ctx, logger, tunnelClient.cfClient)
+	if missing := tunnelClient.zones.missing(zones); err == nil && len(missing) > 0 {
+		err = errors.Errorf("zones %s not found, the credentials may lack %s on them", strings.Join(missing, ", "), PermissionZoneRead)
+	}
+	if err == nil && len(zones) == 0 {
+		err = errors.Errorf("no zone to manage DNS records in, the credentials may lack %s", PermissionZoneRead)
+	}
+	report.add(PreflightCheckZones, "", errors.Wrap(err, "list zones"))
+	for _, zone := range zones {
+		logger.V(3).Info("probe dns records of zone", "zone", zone.Name)
+		name := PreflightRecordPrefix + "." + zone.Name
+		records, err := listDNSRecords(ctx, tunnelClient.cfClient, zone.ID, cloudflare.ListDNSRecordsParams{Type: "TXT", Name: name})
+		report.add(PreflightCheckDNSRead, zone.Name, errors.Wrapf(err, "list TXT records %s", name))
+		if !dnsWrite {
+			continue
+		}
+		if err != nil {
+			report.add(PreflightCheckDNSWrite, zone.Name, errors.New("skipped, the DNS records cannot be listed"))
+			continue
+		}
+		report.add(PreflightCheckDNSWrite, zone.Name, probeDNSWrite(ctx, tunnelClient.cfClient, zone, name, records))
+	}
+	return report
+}
+
+// probeDNSWrite creates the preflight record then deletes it, after the
+// records a former preflight left behind. A record already deleted by the
+// preflight of another replica is fine.
+func probeDNSWrite(ctx context.Context, cfClient CloudflareAPI, zone cloudflare.Zone, name string, leftovers []cloudflare.DNSRecord) error {
+	rc := cloudflare.ZoneIdentifier(zone.ID)
+	for _, record := range leftovers {
+		if !strings.HasPrefix(record.Content, preflightRecordContent) {
+			continue
+		}
+		if err := cfClient.DeleteDNSRecord(ctx, rc, record.ID); err != nil && !isNotFound(err) {
+			return errors.Wrapf(err, "delete leftover TXT record %s", name)
+		}
+	}
+
+	// a random content keeps the records of concurrent preflights apart
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return errors.Wrap(err, "generate preflight record content")
+	}
+	record, err := cfClient.CreateDNSRecord(ctx, rc, cloudflare.CreateDNSRecordParams{
+		Type:    "TXT",
+		Name:    name,
+		Content: fmt.Sprintf("%s %x", preflightRecordContent, nonce),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "create TXT record %s", name)
+	}
+	if err := cfClient.DeleteDNSRecord(ctx, rc, record.ID); err != nil && !isNotFound(err) {
+		return errors.Wrapf(err, "delete TXT record %s", name)
+	}
+	return nil
+}
+
+// Preflight keeps the report of the last PreflightTunnelClient for the ready
+// check and the preflight metric.
+type Preflight struct {
+	logger       logr.Logger
+	tunnelClient *TunnelClient
+	verify       CredentialVerifier
+	dnsWrite     bool
+
+	mu     sync.Mutex
+	report PreflightReport
+}
+
+func NewPreflight(logger logr.Logger, tunnelClient *TunnelClient, verify CredentialVerifier, dnsWrite bool) *Preflight {
+	return &Preflight{logger: logger, tunnelClient: tunnelClient, verify: verify, dnsWrite: dnsWrite}
+}
+
+// Run checks the tunnel client again and publishes the report.
+func (p *Preflight) Run(ctx context.Context) PreflightReport {
+	report := PreflightTunnelClient(ctx, p.logger, p.tunnelClient, p.verify, p.dnsWrite)
+
+	// zones no longer managed drop out of the metric
+	metrics.PreflightCheckPassed.Reset()
+	for _, result := range report.Results {
+		passed := 1.0
+		if result.Err != nil {
+			passed = 0
+		}
+		metrics.PreflightCheckPassed.WithLabelValues(string(result.Check), result.Zone).Set(passed)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.report = report
+	return report
+}
+
+// RunEvery runs the preflight again every interval until done is closed. A
+// failed preflight runs again after a short backoff, so a transient error
+// does not keep the controller unready for a whole interval.
+func (p *Preflight) RunEvery(ctx context.Context, interval time.Duration, done <-chan struct{}) {
+	failures := 0
+	if p.Check(nil) != nil {
+		failures = 1
+	}
+	for {
+		timer := time.NewTimer(preflightDelay(interval, failures))
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := p.Run(ctx).Err(); err != nil {
+			failures++
+			p.logger.Error(err, "cloudflare preflight failed", "retry-after", preflightDelay(interval, failures).String())
+			continue
+		}
+		failures = 0
+	}
+}
+
+// preflightDelay returns the delay before the next preflight after the
+// failures in a row.
+func preflightDelay(interval time.Duration, failures int) time.Duration {
+	if failures == 0 {
+		return interval
+	}
+	delay := preflightRetryDelay
+	for i := 1; i < failures && delay < interval; i++ {
+		delay *= 2
+	}
+	return min(delay, interval)
+}
+
+// Check fails while the last preflight failed, it is a healthz.Checker.
+func (p *Preflight) Check(_ *http.Request) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.report.Err()
+}
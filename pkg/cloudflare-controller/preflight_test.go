@@ -0,0 +1,19 @@
+package cloudflarecontroller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreflightDelay(t *testing.T) {
+	interval := 10 * time.Minute
+	assert.Equal(t, interval, preflightDelay(interval, 0))
+	assert.Equal(t, 15*time.Second, preflightDelay(interval, 1))
+	assert.Equal(t, 30*time.Second, preflightDelay(interval, 2))
+	assert.Equal(t, 4*time.Minute, preflightDelay(interval, 5))
+	assert.Equal(t, interval, preflightDelay(interval, 7), "the backoff stops at the interval")
+	assert.Equal(t, interval, preflightDelay(interval, 1000))
+	assert.Equal(t, 5*time.Second, preflightDelay(5*time.Second, 1), "the backoff never exceeds a short interval")
+}
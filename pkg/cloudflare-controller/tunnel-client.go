@@ -18,19 +18,62 @@ import (
 
 type TunnelClientInterface interface {
 	PutExposures(ctx context.Context, exposures []exposure.Exposure) error
+	// DetectDrift compares the exposures with the tunnel config and the DNS
+	// records on Cloudflare, without changing them.
+	DetectDrift(ctx context.Context, exposures []exposure.Exposure) (Drift, error)
+	// Plan lists the changes PutExposures would make, without making them.
+	Plan(ctx context.Context, exposures []exposure.Exposure) (Plan, error)
 	TunnelDomain() string
 	FetchTunnelToken(ctx context.Context) (string, error)
 }
 
+// Drift counts the Cloudflare resources differing from the desired state.
+type Drift struct {
+	// IngressRules is the number of tunnel ingress rules missing, extra or
+	// out of order.
+	IngressRules int
+	// DNSRecords is the number of DNS records to create, update or delete.
+	DNSRecords int
+}
+
+// IsEmpty reports whether Cloudflare matches the desired state.
+func (d Drift) IsEmpty() bool {
+	return d.IngressRules == 0 && d.DNSRecords == 0
+}
+
 var _ TunnelClientInterface = &TunnelClient{}
 
 type TunnelClient struct {
 	logger             logr.Logger
-	cfClient           *cloudflare.API
+	cfClient           CloudflareAPI
 	accountId          string
 	tunnelId           string
 	tunnelName         string
 	dnsCommentTemplate *template.Template // nil if disabled (empty template string)
+	allowDNSTakeover   bool
+	ownerID            string
+	zones              *zoneCache
+}
+
+// DNSOptions configures the DNS records the tunnel client manages.
+type DNSOptions struct {
+	// CommentTemplate is the Go template of the comment of the records,
+	// empty disables the comments.
+	CommentTemplate string
+	// AllowTakeover lets the client replace the existing records without
+	// owner of every hostname, instead of reporting a conflict. The records
+	// of another tunnel or owner are never taken over.
+	AllowTakeover bool
+	// OwnerID is written in the ownership records, the records of another
+	// owner are never updated nor deleted. Empty writes no owner.
+	OwnerID string
+	// Zones and ZoneIDs are the names and IDs of the zones the records are
+	// managed in, both empty allows every zone the API token can read.
+	Zones   []string
+	ZoneIDs []string
+	// ZoneCacheTTL is how long the listed zones are reused, 0 lists them on
+	// every sync.
+	ZoneCacheTTL time.Duration
 }
 
 // DNSCommentTemplateData contains the variables available in the DNS comment template.
@@ -42,18 +85,21 @@ type DNSCommentTemplateData struct {
 	Hostname   string // DNS record hostname (e.g. "app.example.com")
 }
 
-func NewTunnelClient(logger logr.Logger, cfClient *cloudflare.API, accountId string, tunnelId string, tunnelName string, dnsCommentTemplate string) *TunnelClient {
+func NewTunnelClient(logger logr.Logger, cfClient CloudflareAPI, accountId string, tunnelId string, tunnelName string, dns DNSOptions) *TunnelClient {
 	tc := &TunnelClient{
-		logger:     logger,
-		cfClient:   cfClient,
-		accountId:  accountId,
-		tunnelId:   tunnelId,
-		tunnelName: tunnelName,
-	}
-	if dnsCommentTemplate != "" {
-		tmpl, err := template.New("dns-comment").Parse(dnsCommentTemplate)
+		logger:           logger,
+		cfClient:         cfClient,
+		accountId:        accountId,
+		tunnelId:         tunnelId,
+		tunnelName:       tunnelName,
+		allowDNSTakeover: dns.AllowTakeover,
+		ownerID:          dns.OwnerID,
+		zones:            newZoneCache(dns),
+	}
+	if dns.CommentTemplate != "" {
+		tmpl, err := template.New("dns-comment").Parse(dns.CommentTemplate)
 		if err != nil {
-			logger.Error(err, "failed to parse dns-comment-template, DNS comments will be disabled", "template", dnsCommentTemplate)
+			logger.Error(err, "failed to parse dns-comment-template, DNS comments will be disabled", "template", dns.CommentTemplate)
 		} else {
 			tc.dnsCommentTemplate = tmpl
 		}
@@ -91,14 +137,19 @@ func (t *TunnelClient) renderDNSComment(hostname string) string {
 }
 
 func (t *TunnelClient) PutExposures(ctx context.Context, exposures []exposure.Exposure) error {
-	err := t.updateTunnelIngressRules(ctx, exposures)
+	zoneExposures, failures, err := t.groupExposuresByZone(ctx, exposures)
 	if err != nil {
-		return errors.Wrap(err, "update tunnel ingress rules")
+		return err
 	}
 
-	err = t.updateDNSCNAMERecord(ctx, exposures)
+	err = t.updateTunnelIngressRules(ctx, withoutFailedExposures(exposures, failures))
 	if err != nil {
-		return errors.Wrap(err, "update DNS CNAME record")
+		return errors.Wrap(err, "update tunnel ingress rules")
+	}
+
+	failures = append(failures, t.updateDNSCNAMERecord(ctx, zoneExposures)...)
+	if len(failures) > 0 {
+		return &PartialSyncError{Failures: failures}
 	}
 
 	metrics.ManagedExposures.Set(float64(len(exposure.Active(exposures))))
@@ -110,28 +161,19 @@ func (t *TunnelClient) TunnelDomain() string {
 	return tunnelDomain(t.tunnelId)
 }
 
-func (t *TunnelClient) updateTunnelIngressRules(ctx context.Context, exposures []exposure.Exposure) error {
-	var ingressRules []cloudflare.UnvalidatedIngressRule
-
-	effectiveExposures := exposure.Active(exposures)
-
-	for _, item := range effectiveExposures {
-		ingress, err := fromExposureToCloudflareIngress(ctx, item)
-		if err != nil {
-			return errors.Wrapf(err, "transform to cloudflare ingress")
-		}
-		ingressRules = append(ingressRules, *ingress)
+func (t *TunnelClient) DetectDrift(ctx context.Context, exposures []exposure.Exposure) (Drift, error) {
+	plan, err := t.Plan(ctx, exposures)
+	if err != nil {
+		return Drift{}, err
 	}
+	return Drift{IngressRules: plan.IngressRules.size(), DNSRecords: len(plan.DNSRecords)}, nil
+}
 
-	// sort the rules: non-wildcard hostnames before wildcard hostnames (wildcards are fallbacks),
-	// then alphabetically by hostname, then by path length in descending order
-	// to ensure "precedence will be given first to the longest matching path".
-	slices.SortFunc(ingressRules, sortIngressRules)
-
-	// at last, append a default 404 service as default route
-	ingressRules = append(ingressRules, cloudflare.UnvalidatedIngressRule{
-		Service: "http_status:404",
-	})
+func (t *TunnelClient) updateTunnelIngressRules(ctx context.Context, exposures []exposure.Exposure) error {
+	ingressRules, err := desiredIngressRules(ctx, exposures)
+	if err != nil {
+		return err
+	}
 
 	t.logger.V(3).Info("update cloudflare tunnel config", "ingress-rules", ingressRules)
 
@@ -163,12 +205,63 @@ func (t *TunnelClient) updateTunnelIngressRules(ctx context.Context, exposures [
 	return nil
 }
 
-func (t *TunnelClient) updateDNSCNAMERecord(ctx context.Context, exposures []exposure.Exposure) error {
-	t.logger.V(3).Info("list zones")
-	zones, err := t.cfClient.ListZones(ctx)
+// desiredIngressRules renders the tunnel ingress rules of the active
+// exposures, in the order cloudflared evaluates them.
+func desiredIngressRules(ctx context.Context, exposures []exposure.Exposure) ([]cloudflare.UnvalidatedIngressRule, error) {
+	var ingressRules []cloudflare.UnvalidatedIngressRule
+	// the last rule must match every request, a bare 404 unless an exposure
+	// without hostname provides it
+	catchAll := &cloudflare.UnvalidatedIngressRule{
+		Service: "http_status:404",
+	}
+	hasCatchAll := false
+
+	effectiveExposures := exposure.Active(exposures)
+
+	for _, item := range effectiveExposures {
+		ingress, err := fromExposureToCloudflareIngress(ctx, item)
+		if err != nil {
+			return nil, errors.Wrapf(err, "transform to cloudflare ingress")
+		}
+		if item.Hostname == "" {
+			// the exposures come in a stable order, the first one wins
+			if !hasCatchAll {
+				catchAll = ingress
+				hasCatchAll = true
+			}
+			continue
+		}
+		ingressRules = append(ingressRules, *ingress)
+	}
+
+	// sort the rules: non-wildcard hostnames before wildcard hostnames (wildcards are fallbacks),
+	// then alphabetically by hostname, then by path length in descending order
+	// to ensure "precedence will be given first to the longest matching path".
+	slices.SortFunc(ingressRules, sortIngressRules)
+
+	ingressRules = append(ingressRules, *catchAll)
+	return ingressRules, nil
+}
+
+// ingressRulesDrift counts the rules only present on one side, a config
+// holding the same rules in another order counts as one drifted rule.
+func ingressRulesDrift(current []cloudflare.UnvalidatedIngressRule, desired []cloudflare.UnvalidatedIngressRule) int {
+	return diffIngressRules(current, desired).size()
+}
+
+type zoneExposures struct {
+	zone      cloudflare.Zone
+	exposures []exposure.Exposure
+}
+
+// groupExposuresByZone groups the exposures by the zone of their hostname.
+// An active exposure outside of every zone is reported as a failure and left
+// out, so it does not block the other exposures.
+func (t *TunnelClient) groupExposuresByZone(ctx context.Context, exposures []exposure.Exposure) ([]zoneExposures, []ExposureError, error) {
+	zones, err := t.zones.list(ctx, t.logger, t.cfClient)
 	if err != nil {
 		metrics.CloudflareAPIErrors.WithLabelValues("list_zones").Inc()
-		return errors.Wrap(err, "list cloudflare zones")
+		return nil, nil, err
 	}
 
 	var zoneNames []string
@@ -177,8 +270,13 @@ func (t *TunnelClient) updateDNSCNAMERecord(ctx context.Context, exposures []exp
 	}
 	t.logger.V(3).Info("zones", "zones", zoneNames)
 
+	var failures []ExposureError
 	var exposuresByZone = make(map[string][]exposure.Exposure)
 	for _, item := range exposures {
+		// the catch-all is reached through the DNS records of other hostnames
+		if item.Hostname == "" {
+			continue
+		}
 		ok, zone := zoneBelongedByExposure(item, zoneNames)
 		if ok {
 			exposuresByZone[zone] = append(exposuresByZone[zone], item)
@@ -188,63 +286,210 @@ func (t *TunnelClient) updateDNSCNAMERecord(ctx context.Context, exposures []exp
 			// require a zone match and skip it from DNS reconciliation.
 			t.logger.V(3).Info("DNS management disabled for exposure, skipping DNS reconciliation", "hostname", item.Hostname)
 			continue
-		} else {
-			return errors.Errorf("hostname %s not belong to any zone", item.Hostname)
+		} else if !item.IsDeleted {
+			failures = addExposureError(failures, item.Hostname, errors.Errorf("hostname %s not belong to any zone", item.Hostname))
 		}
 	}
 
+	var result []zoneExposures
 	for zoneName, items := range exposuresByZone {
 		ok, zone := findZoneByName(zoneName, zones)
 		if !ok {
-			return errors.Errorf("zone %s not found", zoneName)
+			return nil, nil, errors.Errorf("zone %s not found", zoneName)
 		}
-		err := t.updateDNSCNAMERecordForZone(ctx, items, zone)
+		result = append(result, zoneExposures{zone: zone, exposures: items})
+	}
+	return result, failures, nil
+}
+
+// updateDNSCNAMERecord syncs the DNS records zone by zone, a failing zone or
+// record fails the hostnames involved only.
+func (t *TunnelClient) updateDNSCNAMERecord(ctx context.Context, zoneExposures []zoneExposures) []ExposureError {
+	var failures []ExposureError
+	for _, item := range zoneExposures {
+		zoneFailures, err := t.updateDNSCNAMERecordForZone(ctx, item.exposures, item.zone)
 		if err != nil {
-			return errors.Wrapf(err, "update DNS CNAME record for zone %s", zoneName)
+			err = errors.Wrapf(err, "update DNS CNAME record for zone %s", item.zone.Name)
+			for _, active := range exposure.Active(item.exposures) {
+				if !active.DisableDNSManagement {
+					failures = addExposureError(failures, active.Hostname, err)
+				}
+			}
+			continue
 		}
+		failures = append(failures, zoneFailures...)
 	}
-	return nil
+	return failures
 }
 
-func (t *TunnelClient) updateDNSCNAMERecordForZone(ctx context.Context, exposures []exposure.Exposure, zone cloudflare.Zone) error {
-	cnameDnsRecords, _, err := t.cfClient.ListDNSRecords(ctx, cloudflare.ResourceIdentifier(zone.ID), cloudflare.ListDNSRecordsParams{
-		Type: "CNAME",
-	})
+// dnsPlan holds the DNS record changes bringing a zone to the desired state.
+type dnsPlan struct {
+	toCreate []DNSOperationCreate
+	toUpdate []DNSOperationUpdate
+	toDelete []DNSOperationDelete
+	// invalid are the hostnames left out of the plan
+	invalid []ExposureError
+}
+
+func (p dnsPlan) size() int {
+	return len(p.toCreate) + len(p.toUpdate) + len(p.toDelete)
+}
+
+func (t *TunnelClient) planDNSRecordsForZone(ctx context.Context, exposures []exposure.Exposure, zone cloudflare.Zone) (dnsPlan, error) {
+	cnameDnsRecords, addressDnsRecords, txtDnsRecords, err := t.listDNSRecordsForZone(ctx, exposures, zone)
 	if err != nil {
 		metrics.CloudflareAPIErrors.WithLabelValues("list_dns_records").Inc()
-		return errors.Wrapf(err, "list CNAME records for zone %s", zone.Name)
+		return dnsPlan{}, err
+	}
+
+	// the conflicting hostnames are left out, their records are neither
+	// created nor taken over
+	conflicts, err := wildcardConflicts(exposures, txtDnsRecords, t.tunnelName, t.ownerID)
+	if err != nil {
+		return dnsPlan{}, errors.Wrap(err, "detect wildcard conflicts")
+	}
+	takeovers, err := takeoverConflicts(exposures, slices.Concat(cnameDnsRecords, addressDnsRecords), txtDnsRecords, t.tunnelId, t.tunnelName, t.ownerID, t.allowDNSTakeover)
+	if err != nil {
+		return dnsPlan{}, errors.Wrap(err, "detect DNS record takeovers")
+	}
+	for _, item := range takeovers {
+		conflicts = addExposureError(conflicts, item.Hostname, errors.New(item.Reason))
+	}
+
+	toCreate, toUpdate, toDelete, err := syncDNSRecord(t.logger, withoutFailedExposures(exposures, conflicts), cnameDnsRecords, addressDnsRecords, txtDnsRecords, t.tunnelId, t.tunnelName, t.ownerID)
+	if err != nil {
+		return dnsPlan{}, errors.Wrap(err, "sync DNS records")
+	}
+
+	// Migrate legacy comment-based records (separate from normal sync)
+	legacyDeletes, err := migrateLegacyDNSRecords(t.logger, exposures, cnameDnsRecords, txtDnsRecords, t.tunnelName, t.ownerID)
+	if err != nil {
+		return dnsPlan{}, errors.Wrap(err, "migrate legacy DNS records")
+	}
+	toDelete = append(toDelete, legacyDeletes...)
+
+	// syncDNSRecord updates every existing record, only keep the updates
+	// changing something so an unchanged zone plans no operation
+	toUpdate = slices.DeleteFunc(toUpdate, t.isNoopDNSUpdate)
+
+	return dnsPlan{toCreate: toCreate, toUpdate: toUpdate, toDelete: toDelete, invalid: conflicts}, nil
+}
+
+// listDNSRecordsForZone lists the records of the zone the plan depends on
+// rather than every record of the zone: the ownership TXT records, the CNAME
+// records pointing at the tunnel or carrying its legacy comment, and the
+// records at the other hostnames of the exposures and of the owned TXT
+// records. A and AAAA records at a hostname block its CNAME, they are only
+// listed to detect the conflict or to delete them on takeover.
+func (t *TunnelClient) listDNSRecordsForZone(ctx context.Context, exposures []exposure.Exposure, zone cloudflare.Zone) ([]cloudflare.DNSRecord, []cloudflare.DNSRecord, []cloudflare.DNSRecord, error) {
+	txtRecords, err := listDNSRecordsWithPrefix(ctx, t.cfClient, zone.ID, "TXT", ManagedRecordTXTPrefix+".")
+	if err != nil {
+		return nil, nil, nil, errors.Wrapf(err, "list TXT records for zone %s", zone.Name)
 	}
 
-	allTxtDnsRecords, _, err := t.cfClient.ListDNSRecords(ctx, cloudflare.ResourceIdentifier(zone.ID), cloudflare.ListDNSRecordsParams{
-		Type: "TXT",
+	records, err := listDNSRecords(ctx, t.cfClient, zone.ID, cloudflare.ListDNSRecordsParams{
+		Type:    "CNAME",
+		Content: tunnelDomain(t.tunnelId),
 	})
 	if err != nil {
-		metrics.CloudflareAPIErrors.WithLabelValues("list_dns_records").Inc()
-		return errors.Wrapf(err, "list TXT records for zone %s", zone.Name)
+		return nil, nil, nil, errors.Wrapf(err, "list CNAME records for zone %s", zone.Name)
+	}
+	if t.ownerID == "" {
+		legacyRecords, err := listDNSRecords(ctx, t.cfClient, zone.ID, cloudflare.ListDNSRecordsParams{
+			Type:    "CNAME",
+			Comment: renderLegacyComment(t.tunnelName),
+		})
+		if err != nil {
+			return nil, nil, nil, errors.Wrapf(err, "list legacy CNAME records for zone %s", zone.Name)
+		}
+		records = append(records, legacyRecords...)
 	}
 
-	// Filter to only include TXT records managed by this controller
-	var txtDnsRecords []cloudflare.DNSRecord
-	for _, record := range allTxtDnsRecords {
-		if strings.HasPrefix(record.Name, ManagedRecordTXTPrefix+".") {
-			txtDnsRecords = append(txtDnsRecords, record)
+	// the hostnames pointing at the tunnel hold no other record, the other
+	// hostnames are listed one by one, they are new or left by the tunnel
+	var hostnames []string
+	for _, item := range exposures {
+		hostnames = append(hostnames, item.Hostname)
+	}
+	for _, record := range txtRecords {
+		if ownsTXTRecord(record, t.tunnelName, t.ownerID, false) {
+			hostnames = append(hostnames, exposureHostname(record.Name))
 		}
 	}
+	slices.Sort(hostnames)
+	for _, hostname := range slices.Compact(hostnames) {
+		if found, _ := dnsRecordsContainsHostname(records, hostname); found {
+			continue
+		}
+		found, err := listDNSRecords(ctx, t.cfClient, zone.ID, cloudflare.ListDNSRecordsParams{Name: hostname})
+		if err != nil {
+			return nil, nil, nil, errors.Wrapf(err, "list DNS records of %s in zone %s", hostname, zone.Name)
+		}
+		records = append(records, found...)
+	}
 
-	toCreate, toUpdate, toDelete, err := syncDNSRecord(t.logger, exposures, cnameDnsRecords, txtDnsRecords, t.tunnelId, t.tunnelName)
+	var cnameRecords, addressRecords []cloudflare.DNSRecord
+	seen := map[string]bool{}
+	for _, record := range records {
+		if seen[record.ID] {
+			continue
+		}
+		seen[record.ID] = true
+		switch record.Type {
+		case "CNAME":
+			cnameRecords = append(cnameRecords, record)
+		case "A", "AAAA":
+			addressRecords = append(addressRecords, record)
+		}
+	}
+	return cnameRecords, addressRecords, txtRecords, nil
+}
+
+// isNoopDNSUpdate reports whether the record already holds everything the
+// update would write, a disabled comment template leaves the comment as is.
+func (t *TunnelClient) isNoopDNSUpdate(item DNSOperationUpdate) bool {
+	record := item.OldRecord
+	recordProxied := record.Proxied != nil && *record.Proxied
+	comment := t.renderDNSComment(record.Name)
+	return record.Type == item.Type &&
+		record.Content == item.Content &&
+		recordProxied == proxied(item.Type, item.DNSOnly) &&
+		record.TTL == recordTTL(item.TTL) &&
+		(comment == "" || record.Comment == comment)
+}
+
+// updateDNSCNAMERecordForZone applies the DNS plan of the zone. A failing
+// record does not stop the others, its hostname is reported as a failure.
+func (t *TunnelClient) updateDNSCNAMERecordForZone(ctx context.Context, exposures []exposure.Exposure, zone cloudflare.Zone) ([]ExposureError, error) {
+	plan, err := t.planDNSRecordsForZone(ctx, exposures, zone)
 	if err != nil {
-		return errors.Wrap(err, "sync DNS records")
+		return nil, err
+	}
+
+	failures := plan.invalid
+	t.logger.V(3).Info("sync DNS records", "to-create", plan.toCreate, "to-update", plan.toUpdate, "to-delete", plan.toDelete)
+
+	// the deletes go first, a CNAME cannot be created next to the A and
+	// AAAA records it takes over
+	for _, item := range plan.toDelete {
+		t.logger.Info("delete DNS record", "id", item.OldRecord.ID, "type", item.OldRecord.Type, "hostname", item.OldRecord.Name, "content", item.OldRecord.Content)
+		err := t.cfClient.DeleteDNSRecord(ctx, cloudflare.ResourceIdentifier(zone.ID), item.OldRecord.ID)
+		if err != nil {
+			metrics.CloudflareAPIErrors.WithLabelValues("delete_dns_record").Inc()
+			failures = addExposureError(failures, exposureHostname(item.OldRecord.Name), errors.Wrapf(err, "delete DNS record for zone %s, hostname %s", zone.Name, item.OldRecord.Name))
+			continue
+		}
+		metrics.DNSRecordOperations.WithLabelValues("delete", item.OldRecord.Type).Inc()
 	}
-	t.logger.V(3).Info("sync DNS records", "to-create", toCreate, "to-update", toUpdate, "to-delete", toDelete)
 
-	for _, item := range toCreate {
+	for _, item := range plan.toCreate {
 		t.logger.Info("create DNS record", "type", item.Type, "hostname", item.Hostname, "content", item.Content)
 		params := cloudflare.CreateDNSRecordParams{
 			Type:    item.Type,
 			Name:    item.Hostname,
 			Content: item.Content,
-			Proxied: cloudflare.BoolPtr(item.Type == "CNAME"),
-			TTL:     1,
+			Proxied: cloudflare.BoolPtr(proxied(item.Type, item.DNSOnly)),
+			TTL:     recordTTL(item.TTL),
 		}
 		// Add comment to every managed record if template is configured.
 		// Comments are informational only; ownership is tracked via TXT record content.
@@ -255,20 +500,21 @@ func (t *TunnelClient) updateDNSCNAMERecordForZone(ctx context.Context, exposure
 		_, err := t.cfClient.CreateDNSRecord(ctx, cloudflare.ResourceIdentifier(zone.ID), params)
 		if err != nil {
 			metrics.CloudflareAPIErrors.WithLabelValues("create_dns_record").Inc()
-			return errors.Wrapf(err, "create DNS record for zone %s, hostname %s", zone.Name, item.Hostname)
+			failures = addExposureError(failures, exposureHostname(item.Hostname), errors.Wrapf(err, "create DNS record for zone %s, hostname %s", zone.Name, item.Hostname))
+			continue
 		}
 		metrics.DNSRecordOperations.WithLabelValues("create", item.Type).Inc()
 	}
 
-	for _, item := range toUpdate {
+	for _, item := range plan.toUpdate {
 		t.logger.Info("update DNS record", "id", item.OldRecord.ID, "type", item.Type, "hostname", item.OldRecord.Name, "content", item.Content)
 		params := cloudflare.UpdateDNSRecordParams{
 			ID:      item.OldRecord.ID,
 			Type:    item.Type,
 			Name:    item.OldRecord.Name,
 			Content: item.Content,
-			Proxied: cloudflare.BoolPtr(item.Type == "CNAME"),
-			TTL:     1,
+			Proxied: cloudflare.BoolPtr(proxied(item.Type, item.DNSOnly)),
+			TTL:     recordTTL(item.TTL),
 		}
 		// Add comment to every managed record if template is configured.
 		if comment := t.renderDNSComment(item.OldRecord.Name); comment != "" {
@@ -277,41 +523,28 @@ func (t *TunnelClient) updateDNSCNAMERecordForZone(ctx context.Context, exposure
 		_, err := t.cfClient.UpdateDNSRecord(ctx, cloudflare.ResourceIdentifier(zone.ID), params)
 		if err != nil {
 			metrics.CloudflareAPIErrors.WithLabelValues("update_dns_record").Inc()
-			return errors.Wrapf(err, "update DNS record for zone %s, hostname %s", zone.Name, item.OldRecord.Name)
+			failures = addExposureError(failures, exposureHostname(item.OldRecord.Name), errors.Wrapf(err, "update DNS record for zone %s, hostname %s", zone.Name, item.OldRecord.Name))
+			continue
 		}
 		metrics.DNSRecordOperations.WithLabelValues("update", item.Type).Inc()
 	}
 
-	// Migrate legacy comment-based records (separate from normal sync)
-	legacyDeletes, err := migrateLegacyDNSRecords(t.logger, exposures, cnameDnsRecords, txtDnsRecords, t.tunnelName)
-	if err != nil {
-		return errors.Wrap(err, "migrate legacy DNS records")
-	}
-	toDelete = append(toDelete, legacyDeletes...)
-
-	for _, item := range toDelete {
-		t.logger.Info("delete DNS record", "id", item.OldRecord.ID, "type", item.OldRecord.Type, "hostname", item.OldRecord.Name, "content", item.OldRecord.Content)
-		err := t.cfClient.DeleteDNSRecord(ctx, cloudflare.ResourceIdentifier(zone.ID), item.OldRecord.ID)
-		if err != nil {
-			metrics.CloudflareAPIErrors.WithLabelValues("delete_dns_record").Inc()
-			return errors.Wrapf(err, "delete DNS record for zone %s, hostname %s", zone.Name, item.OldRecord.Name)
-		}
-		metrics.DNSRecordOperations.WithLabelValues("delete", item.OldRecord.Type).Inc()
-	}
-
-	return nil
+	return failures, nil
 }
 
+// zoneBelongedByExposure returns the zone of the hostname, the longest one
+// when a subdomain is delegated to a zone of its own.
 func zoneBelongedByExposure(exposure exposure.Exposure, zones []string) (bool, string) {
 	hostnameDomain := Domain{Name: exposure.Hostname}
 
+	var result string
 	for _, zone := range zones {
 		zoneDomain := Domain{Name: zone}
-		if hostnameDomain.IsSubDomainOf(zoneDomain) || hostnameDomain.Name == zoneDomain.Name {
-			return true, zone
+		if (hostnameDomain.IsSubDomainOf(zoneDomain) || hostnameDomain.Name == zoneDomain.Name) && len(zone) > len(result) {
+			result = zone
 		}
 	}
-	return false, ""
+	return result != "", result
 }
 
 func findZoneByName(zoneName string, zones []cloudflare.Zone) (bool, cloudflare.Zone) {
@@ -329,7 +562,8 @@ func (t *TunnelClient) FetchTunnelToken(ctx context.Context) (string, error) {
 
 // sortIngressRules defines the sort order for Cloudflare tunnel ingress rules:
 // non-wildcard hostnames before wildcard hostnames (wildcards act as fallbacks),
-// then alphabetically by hostname, then by path length in descending order.
+// then alphabetically by hostname, then by the length of the matched path in
+// descending order.
 func sortIngressRules(a, b cloudflare.UnvalidatedIngressRule) int {
 	aIsWildcard := strings.HasPrefix(a.Hostname, "*.")
 	bIsWildcard := strings.HasPrefix(b.Hostname, "*.")
@@ -350,10 +584,23 @@ func sortIngressRules(a, b cloudflare.UnvalidatedIngressRule) int {
 	if v := strings.Compare(strings.ToLower(a.Hostname), strings.ToLower(b.Hostname)); v != 0 {
 		return v
 	}
-	if v := len(b.Path) - len(a.Path); v != 0 {
+	// the longest matching path wins, and an exact path wins over a prefix
+	// of the same length
+	aLength, aExact := pathPrecedence(a.Path)
+	bLength, bExact := pathPrecedence(b.Path)
+	if v := bLength - aLength; v != 0 {
 		return v
 	}
+	if aExact != bExact {
+		if aExact {
+			return -1
+		}
+		return 1
+	}
 	// lexical fallback keeps the comparator a total order, the rule list
 	// must be deterministic or reconciles would push spurious updates
-	return strings.Compare(a.Path, b.Path)
+	if v := strings.Compare(a.Path, b.Path); v != 0 {
+		return v
+	}
+	return strings.Compare(a.Service, b.Service)
 }
@@ -1,10 +1,13 @@
 package cloudflarecontroller
 
 import (
+	"context"
+	"reflect"
 	"slices"
 	"strings"
 	"testing"
 
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
 	"github.com/cloudflare/cloudflare-go"
 	"github.com/go-logr/logr"
 )
@@ -76,7 +79,7 @@ func TestRenderDNSComment(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tc := NewTunnelClient(logr.Discard(), nil, "acc", tt.tunnelId, tt.tunnelName, tt.templateStr)
+			tc := NewTunnelClient(logr.Discard(), nil, "acc", tt.tunnelId, tt.tunnelName, DNSOptions{CommentTemplate: tt.templateStr})
 			got := tc.renderDNSComment(tt.hostname)
 
 			if tt.wantEmpty && got != "" {
@@ -202,6 +205,21 @@ func Test_sortIngressRules(t *testing.T) {
 				{Hostname: "*.b.example.com", Path: "/"},
 			},
 		},
+		{
+			name: "exact path sorts before prefix of the same path, longer paths first",
+			input: []cloudflare.UnvalidatedIngressRule{
+				{Hostname: "app.example.com", Path: "/"},
+				{Hostname: "app.example.com", Path: "^/api(/|$)"},
+				{Hostname: "app.example.com", Path: "^/api$"},
+				{Hostname: "app.example.com", Path: "^/api/v1(/|$)"},
+			},
+			wantOrder: []cloudflare.UnvalidatedIngressRule{
+				{Hostname: "app.example.com", Path: "^/api/v1(/|$)"},
+				{Hostname: "app.example.com", Path: "^/api$"},
+				{Hostname: "app.example.com", Path: "^/api(/|$)"},
+				{Hostname: "app.example.com", Path: "/"},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -219,3 +237,153 @@ func Test_sortIngressRules(t *testing.T) {
 		})
 	}
 }
+
+func TestIngressRulesDrift(t *testing.T) {
+	app := cloudflare.UnvalidatedIngressRule{Hostname: "app.example.com", Service: "http://app.default.svc.cluster.local:80"}
+	api := cloudflare.UnvalidatedIngressRule{Hostname: "api.example.com", Service: "http://api.default.svc.cluster.local:80"}
+	fallback := cloudflare.UnvalidatedIngressRule{Service: "http_status:404"}
+	edited := app
+	edited.Service = "http://other.default.svc.cluster.local:80"
+
+	tests := []struct {
+		name    string
+		current []cloudflare.UnvalidatedIngressRule
+		desired []cloudflare.UnvalidatedIngressRule
+		want    int
+	}{
+		{
+			name:    "in sync",
+			current: []cloudflare.UnvalidatedIngressRule{api, app, fallback},
+			desired: []cloudflare.UnvalidatedIngressRule{api, app, fallback},
+			want:    0,
+		},
+		{
+			name:    "rule deleted in the dashboard",
+			current: []cloudflare.UnvalidatedIngressRule{api, fallback},
+			desired: []cloudflare.UnvalidatedIngressRule{api, app, fallback},
+			want:    1,
+		},
+		{
+			name:    "rule edited in the dashboard",
+			current: []cloudflare.UnvalidatedIngressRule{api, edited, fallback},
+			desired: []cloudflare.UnvalidatedIngressRule{api, app, fallback},
+			want:    2,
+		},
+		{
+			name:    "rules reordered",
+			current: []cloudflare.UnvalidatedIngressRule{app, api, fallback},
+			desired: []cloudflare.UnvalidatedIngressRule{api, app, fallback},
+			want:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ingressRulesDrift(tt.current, tt.desired); got != tt.want {
+				t.Errorf("ingressRulesDrift() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsNoopDNSUpdate(t *testing.T) {
+	client := NewTunnelClient(logr.Discard(), nil, "account", "tunnel-id", "my-tunnel", DNSOptions{CommentTemplate: "tunnel [{{.TunnelName}}]"})
+	record := cloudflare.DNSRecord{
+		Type:    "CNAME",
+		Name:    "app.example.com",
+		Content: "tunnel-id.cfargotunnel.com",
+		Proxied: cloudflare.BoolPtr(true),
+		TTL:     1,
+		Comment: "tunnel [my-tunnel]",
+	}
+	update := DNSOperationUpdate{OldRecord: record, Type: "CNAME", Content: "tunnel-id.cfargotunnel.com"}
+	if !client.isNoopDNSUpdate(update) {
+		t.Errorf("isNoopDNSUpdate() = false for an unchanged record")
+	}
+
+	repointed := update
+	repointed.OldRecord.Content = "other.cfargotunnel.com"
+	unproxied := update
+	unproxied.OldRecord.Proxied = cloudflare.BoolPtr(false)
+	commented := update
+	commented.OldRecord.Comment = "edited in the dashboard"
+	for name, item := range map[string]DNSOperationUpdate{"repointed": repointed, "unproxied": unproxied, "commented": commented} {
+		if client.isNoopDNSUpdate(item) {
+			t.Errorf("isNoopDNSUpdate() = true for a %s record", name)
+		}
+	}
+
+	dnsOnly := update
+	dnsOnly.DNSOnly = true
+	dnsOnly.TTL = 300
+	if client.isNoopDNSUpdate(dnsOnly) {
+		t.Errorf("isNoopDNSUpdate() = true for a proxied record becoming DNS-only")
+	}
+	dnsOnly.OldRecord.Proxied = cloudflare.BoolPtr(false)
+	dnsOnly.OldRecord.TTL = 300
+	if !client.isNoopDNSUpdate(dnsOnly) {
+		t.Errorf("isNoopDNSUpdate() = false for an unchanged DNS-only record")
+	}
+
+	withoutComments := NewTunnelClient(logr.Discard(), nil, "account", "tunnel-id", "my-tunnel", DNSOptions{})
+	if !withoutComments.isNoopDNSUpdate(commented) {
+		t.Errorf("isNoopDNSUpdate() = false for a comment left as is by a disabled template")
+	}
+}
+
+func TestDesiredIngressRulesCatchAll(t *testing.T) {
+	app := exposure.Exposure{Hostname: "app.example.com", ServiceTarget: "http://app.default.svc.cluster.local:80", PathPrefix: "^/api(/|$)"}
+	appFallback := exposure.Exposure{Hostname: "app.example.com", ServiceTarget: "http://app-fallback.default.svc.cluster.local:80"}
+	catchAll := exposure.Exposure{ServiceTarget: "http://maintenance.default.svc.cluster.local:80"}
+	otherCatchAll := exposure.Exposure{ServiceTarget: "http_status:503"}
+
+	tests := []struct {
+		name      string
+		exposures []exposure.Exposure
+		want      []cloudflare.UnvalidatedIngressRule
+	}{
+		{
+			name:      "404 without catch-all",
+			exposures: []exposure.Exposure{app},
+			want: []cloudflare.UnvalidatedIngressRule{
+				{Hostname: "app.example.com", Path: "^/api(/|$)", Service: "http://app.default.svc.cluster.local:80"},
+				{Service: "http_status:404"},
+			},
+		},
+		{
+			name:      "host fallback after the paths of the host, first catch-all wins",
+			exposures: []exposure.Exposure{appFallback, catchAll, app, otherCatchAll},
+			want: []cloudflare.UnvalidatedIngressRule{
+				{Hostname: "app.example.com", Path: "^/api(/|$)", Service: "http://app.default.svc.cluster.local:80"},
+				{Hostname: "app.example.com", Service: "http://app-fallback.default.svc.cluster.local:80"},
+				{Service: "http://maintenance.default.svc.cluster.local:80"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := desiredIngressRules(context.Background(), tt.exposures)
+			if err != nil {
+				t.Fatalf("desiredIngressRules() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("desiredIngressRules() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZoneBelongedByWildcardExposure(t *testing.T) {
+	zones := []string{"example.org", "example.com"}
+	ok, zone := zoneBelongedByExposure(exposure.Exposure{Hostname: "*.example.com"}, zones)
+	if !ok || zone != "example.com" {
+		t.Errorf("zoneBelongedByExposure() = %v, %v, want true, example.com", ok, zone)
+	}
+	ok, zone = zoneBelongedByExposure(exposure.Exposure{Hostname: "*.sub.example.com"}, zones)
+	if !ok || zone != "example.com" {
+		t.Errorf("zoneBelongedByExposure() = %v, %v, want true, example.com", ok, zone)
+	}
+	if ok, _ := zoneBelongedByExposure(exposure.Exposure{Hostname: "*.example.net"}, zones); ok {
+		t.Errorf("zoneBelongedByExposure() = true for a wildcard outside of every zone")
+	}
+}
@@ -0,0 +1,89 @@
+package cloudflarecontroller
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+)
+
+// zoneCache lists the zones the DNS records are managed in and reuses them
+// until the TTL expires.
+type zoneCache struct {
+	// names and ids restrict the zones, both empty allows every zone
+	names []string
+	ids   []string
+	ttl   time.Duration
+
+	mu        sync.Mutex
+	zones     []cloudflare.Zone
+	fetchedAt time.Time
+}
+
+func newZoneCache(dns DNSOptions) *zoneCache {
+	cache := &zoneCache{ids: dns.ZoneIDs, ttl: dns.ZoneCacheTTL}
+	for _, name := range dns.Zones {
+		cache.names = append(cache.names, strings.TrimSuffix(strings.ToLower(name), "."))
+	}
+	return cache
+}
+
+// list returns the allowed zones, listed again once the TTL expired.
+func (c *zoneCache) list(ctx context.Context, logger logr.Logger, cfClient CloudflareAPI) ([]cloudflare.Zone, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.fetchedAt.IsZero() && time.Since(c.fetchedAt) < c.ttl {
+		return c.zones, nil
+	}
+
+	logger.V(3).Info("list zones")
+	zones, err := listZones(ctx, cfClient)
+	if err != nil {
+		return nil, errors.Wrap(err, "list cloudflare zones")
+	}
+	zones = slices.DeleteFunc(zones, func(zone cloudflare.Zone) bool {
+		return !c.allows(zone)
+	})
+	for _, name := range c.names {
+		if !slices.ContainsFunc(zones, func(zone cloudflare.Zone) bool { return zone.Name == name }) {
+			logger.Info("zone not found, check the API token can read it", "zone", name)
+		}
+	}
+	for _, id := range c.ids {
+		if !slices.ContainsFunc(zones, func(zone cloudflare.Zone) bool { return zone.ID == id }) {
+			logger.Info("zone not found, check the API token can read it", "zone-id", id)
+		}
+	}
+
+	c.zones = zones
+	c.fetchedAt = time.Now()
+	return zones, nil
+}
+
+func (c *zoneCache) allows(zone cloudflare.Zone) bool {
+	if len(c.names) == 0 && len(c.ids) == 0 {
+		return true
+	}
+	return slices.Contains(c.names, strings.ToLower(zone.Name)) || slices.Contains(c.ids, zone.ID)
+}
+
+// missing returns the names and IDs of the zones to manage the zones lack.
+func (c *zoneCache) missing(zones []cloudflare.Zone) []string {
+	var missing []string
+	for _, name := range c.names {
+		if !slices.ContainsFunc(zones, func(zone cloudflare.Zone) bool { return zone.Name == name }) {
+			missing = append(missing, name)
+		}
+	}
+	for _, id := range c.ids {
+		if !slices.ContainsFunc(zones, func(zone cloudflare.Zone) bool { return zone.ID == id }) {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,59 @@
+package cloudflarecontroller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZoneBelongedByExposureLongestZone(t *testing.T) {
+	for _, zones := range [][]string{
+		{"example.com", "sub.example.com"},
+		{"sub.example.com", "example.com"},
+	} {
+		ok, zone := zoneBelongedByExposure(exposure.Exposure{Hostname: "app.sub.example.com"}, zones)
+		assert.True(t, ok)
+		assert.Equal(t, "sub.example.com", zone)
+
+		ok, zone = zoneBelongedByExposure(exposure.Exposure{Hostname: "app.example.com"}, zones)
+		assert.True(t, ok)
+		assert.Equal(t, "example.com", zone)
+	}
+}
+
+func TestZoneCache(t *testing.T) {
+	api := &pagedAPI{zones: []cloudflare.Zone{
+		{ID: "zone-1", Name: "example.com"},
+		{ID: "zone-2", Name: "sub.example.com"},
+		{ID: "zone-3", Name: "example.net"},
+	}}
+	cfClient := newPagedAPIClient(t, api)
+
+	cache := newZoneCache(DNSOptions{Zones: []string{"Example.com."}, ZoneIDs: []string{"zone-3"}, ZoneCacheTTL: time.Hour})
+	zones, err := cache.list(context.Background(), logr.Discard(), cfClient)
+	require.NoError(t, err)
+	var names []string
+	for _, zone := range zones {
+		names = append(names, zone.Name)
+	}
+	assert.Equal(t, []string{"example.com", "example.net"}, names)
+
+	api.zones = append(api.zones, cloudflare.Zone{ID: "zone-4", Name: "example.org"})
+	cache.names = nil
+	cache.ids = nil
+	zones, err = cache.list(context.Background(), logr.Discard(), cfClient)
+	require.NoError(t, err)
+	assert.Len(t, zones, 2, "the zones are reused until the TTL expires")
+	assert.Len(t, api.queries, 1)
+
+	cache.fetchedAt = time.Now().Add(-2 * time.Hour)
+	zones, err = cache.list(context.Background(), logr.Discard(), cfClient)
+	require.NoError(t, err)
+	assert.Len(t, zones, 4)
+}
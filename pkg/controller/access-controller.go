@@ -0,0 +1,496 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/apis/v1alpha1"
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ reconcile.Reconciler = &AccessController{}
+
+// AccessCleanupFinalizer holds a CloudflareAccess object until its Access
+// application is deleted.
+const AccessCleanupFinalizer = "cloudflare-tunnel-ingress-controller.strrl.dev/access-cleanup"
+
+// accessTargetIndex indexes CloudflareAccess objects by the Ingresses they
+// target, as "<kind>/<name>" within the object namespace.
+const accessTargetIndex = "spec.targetRefs"
+
+// accessResyncPeriod is how often an Access application is rewritten to
+// correct drift made on the Cloudflare side.
+const accessResyncPeriod = 10 * time.Minute
+
+// accessConflictRecheckPeriod is how often a conflicted object checks whether
+// the object it lost against is gone.
+const accessConflictRecheckPeriod = time.Minute
+
+const (
+	EventReasonAccessSynced     = "AccessSynced"
+	EventReasonAccessSyncFailed = "AccessSyncFailed"
+	EventReasonAccessInvalid    = "AccessInvalid"
+	EventReasonAccessWithheld   = "AccessWithheld"
+)
+
+// AccessController manages one Cloudflare Access application per
+// CloudflareAccess object, covering every hostname of its target Ingresses.
+type AccessController struct {
+	logger        logr.Logger
+	kubeClient    client.Client
+	recorder      record.EventRecorder
+	clusterDomain string
+	accessClient  cloudflarecontroller.AccessClientInterface
+	// hostnamePoliciesEnabled leaves the hostnames the HostnamePolicies do
+	// not allow out of the applications, as the ingress controller does not
+	// expose them
+	hostnamePoliciesEnabled bool
+}
+
+func NewAccessController(logger logr.Logger, kubeClient client.Client, recorder record.EventRecorder, clusterDomain string, accessClient cloudflarecontroller.AccessClientInterface, hostnamePoliciesEnabled bool) *AccessController {
+	return &AccessController{logger: logger, kubeClient: kubeClient, recorder: recorder, clusterDomain: clusterDomain, accessClient: accessClient, hostnamePoliciesEnabled: hostnamePoliciesEnabled}
+}
+
+func (a *AccessController) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	access := v1alpha1.CloudflareAccess{}
+	err := a.kubeClient.Get(ctx, request.NamespacedName, &access)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, errors.Wrapf(err, "fetch cloudflare access %s", request.NamespacedName)
+	}
+
+	applicationName := a.accessClient.AccessApplicationName(access.Namespace, access.Name)
+
+	if access.DeletionTimestamp != nil {
+		if !slices.Contains(access.Finalizers, AccessCleanupFinalizer) {
+			return reconcile.Result{}, nil
+		}
+		if err := a.accessClient.DeleteAccessApplication(ctx, access.Status.ApplicationID, applicationName); err != nil {
+			a.recorder.Event(&access, v1.EventTypeWarning, EventReasonAccessSyncFailed, err.Error())
+			return reconcile.Result{}, errors.Wrapf(err, "delete access application of %s", request.NamespacedName)
+		}
+		access.Finalizers = slices.DeleteFunc(access.Finalizers, func(f string) bool {
+			return f == AccessCleanupFinalizer
+		})
+		if err := a.kubeClient.Update(ctx, &access); err != nil {
+			return reconcile.Result{}, errors.Wrapf(err, "clean finalizer for cloudflare access %s", request.NamespacedName)
+		}
+		return reconcile.Result{}, nil
+	}
+
+	if !slices.Contains(access.Finalizers, AccessCleanupFinalizer) {
+		access.Finalizers = append(access.Finalizers, AccessCleanupFinalizer)
+		if err := a.kubeClient.Update(ctx, &access); err != nil {
+			return reconcile.Result{}, errors.Wrapf(err, "attach finalizer to cloudflare access %s", request.NamespacedName)
+		}
+	}
+
+	status := access.Status.DeepCopy()
+	status.ObservedGeneration = access.Generation
+	setCondition := func(conditionType string, conditionStatus metav1.ConditionStatus, reason string, message string) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               conditionType,
+			Status:             conditionStatus,
+			Reason:             reason,
+			Message:            message,
+			ObservedGeneration: access.Generation,
+		})
+	}
+
+	// fail closed: an invalid policy never reaches Cloudflare, the existing
+	// application stays as it is and the hostnames are withheld from the
+	// tunnel until the spec is fixed
+	if err := validateAccessSpec(access.Spec); err != nil {
+		a.recorder.Event(&access, v1.EventTypeWarning, EventReasonAccessInvalid, err.Error())
+		setCondition(v1alpha1.AccessConditionAccepted, metav1.ConditionFalse, v1alpha1.AccessReasonInvalidPolicy, err.Error())
+		setCondition(v1alpha1.AccessConditionProgrammed, metav1.ConditionFalse, v1alpha1.AccessReasonInvalidPolicy, "the spec is invalid, hostnames of the targets are not exposed")
+		return reconcile.Result{}, a.updateStatus(ctx, access, *status)
+	}
+
+	hostnames, missingTargets, err := a.resolveTargetHostnames(ctx, access)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrapf(err, "resolve targets of cloudflare access %s", request.NamespacedName)
+	}
+
+	conflict, err := a.findConflict(ctx, access, hostnames)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrapf(err, "detect conflicts of cloudflare access %s", request.NamespacedName)
+	}
+	if conflict != "" {
+		setCondition(v1alpha1.AccessConditionAccepted, metav1.ConditionFalse, v1alpha1.AccessReasonConflicted, conflict)
+		setCondition(v1alpha1.AccessConditionProgrammed, metav1.ConditionFalse, v1alpha1.AccessReasonConflicted, conflict)
+		// the winner may go away without touching this object, look again
+		return reconcile.Result{RequeueAfter: accessConflictRecheckPeriod}, a.updateStatus(ctx, access, *status)
+	}
+	setCondition(v1alpha1.AccessConditionAccepted, metav1.ConditionTrue, v1alpha1.AccessReasonAccepted, "")
+
+	if len(missingTargets) > 0 {
+		setCondition(v1alpha1.AccessConditionResolvedRefs, metav1.ConditionFalse, v1alpha1.AccessReasonTargetNotFound, fmt.Sprintf("targets not found: %s", strings.Join(missingTargets, ", ")))
+	} else {
+		setCondition(v1alpha1.AccessConditionResolvedRefs, metav1.ConditionTrue, v1alpha1.AccessReasonResolvedRefs, "")
+	}
+
+	if len(hostnames) == 0 {
+		setCondition(v1alpha1.AccessConditionResolvedRefs, metav1.ConditionFalse, v1alpha1.AccessReasonNoHostnames, "the targets have no hostname")
+		setCondition(v1alpha1.AccessConditionProgrammed, metav1.ConditionFalse, v1alpha1.AccessReasonNoHostnames, "the targets have no hostname")
+		return reconcile.Result{}, a.updateStatus(ctx, access, *status)
+	}
+
+	ref, err := a.accessClient.PutAccessApplication(ctx, cloudflarecontroller.AccessApplication{
+		Name:                     applicationName,
+		ID:                       access.Status.ApplicationID,
+		Hostnames:                hostnames,
+		SessionDuration:          access.Spec.SessionDuration,
+		AllowedIdentityProviders: access.Spec.AllowedIdentityProviders,
+		AutoRedirectToIdentity:   access.Spec.AutoRedirectToIdentity,
+		Policies:                 fromAccessPolicies(access.Spec.Policies),
+	})
+	if ref.ID != "" {
+		status.ApplicationID = ref.ID
+		status.AUD = ref.AUD
+	}
+	if err != nil {
+		a.recorder.Event(&access, v1.EventTypeWarning, EventReasonAccessSyncFailed, err.Error())
+		setCondition(v1alpha1.AccessConditionProgrammed, metav1.ConditionFalse, v1alpha1.AccessReasonCloudflareError, err.Error())
+		if statusErr := a.updateStatus(ctx, access, *status); statusErr != nil {
+			a.logger.Error(statusErr, "update cloudflare access status", "cloudflare-access", request.NamespacedName)
+		}
+		return reconcile.Result{}, errors.Wrapf(err, "put access application of %s", request.NamespacedName)
+	}
+
+	status.Hostnames = hostnames
+	setCondition(v1alpha1.AccessConditionProgrammed, metav1.ConditionTrue, v1alpha1.AccessReasonProgrammed, "")
+	if !meta.IsStatusConditionTrue(access.Status.Conditions, v1alpha1.AccessConditionProgrammed) || !slices.Equal(access.Status.Hostnames, hostnames) {
+		a.recorder.Event(&access, v1.EventTypeNormal, EventReasonAccessSynced, fmt.Sprintf("access application %s covers %s", applicationName, strings.Join(hostnames, ", ")))
+	}
+	if err := a.updateStatus(ctx, access, *status); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{RequeueAfter: accessResyncPeriod}, nil
+}
+
+func (a *AccessController) updateStatus(ctx context.Context, access v1alpha1.CloudflareAccess, status v1alpha1.CloudflareAccessStatus) error {
+	if equality.Semantic.DeepEqual(access.Status, status) {
+		return nil
+	}
+	access.Status = status
+	if err := a.kubeClient.Status().Update(ctx, &access); err != nil {
+		return errors.Wrapf(err, "update cloudflare access %s/%s status", access.Namespace, access.Name)
+	}
+	return nil
+}
+
+// resolveTargetHostnames returns the sorted hostnames of the target Ingresses
+// and the targets that could not be found. Ingresses being deleted contribute
+// no hostname, nor do the hostnames the namespace does not allow.
+func (a *AccessController) resolveTargetHostnames(ctx context.Context, access v1alpha1.CloudflareAccess) ([]string, []string, error) {
+	var allowed *AllowedHostnames
+	if a.hostnamePoliciesEnabled {
+		var err error
+		allowed, err = policyAllowedHostnames(ctx, a.kubeClient, access.Namespace)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	allowlist, err := namespaceAllowlist(ctx, a.kubeClient, access.Namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var hostnames []string
+	var missing []string
+	for _, targetRef := range access.Spec.TargetRefs {
+		ingress := networkingv1.Ingress{}
+		err := a.kubeClient.Get(ctx, types.NamespacedName{Namespace: access.Namespace, Name: targetRef.Name}, &ingress)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				missing = append(missing, fmt.Sprintf("%s/%s", targetRef.Kind, targetRef.Name))
+				continue
+			}
+			return nil, nil, errors.Wrapf(err, "fetch ingress %s/%s", access.Namespace, targetRef.Name)
+		}
+		if ingress.DeletionTimestamp != nil {
+			continue
+		}
+
+		exposures, err := FromIngressToExposure(ctx, a.logger, a.kubeClient, a.recorder, ingress, a.clusterDomain, allowed)
+		if err != nil {
+			// the ingress controller reports the transform failure on the
+			// ingress, its hostnames are not exposed either
+			a.logger.V(1).Info("extract exposures from target ingress", "ingress", fmt.Sprintf("%s/%s", ingress.Namespace, ingress.Name), "error", err.Error())
+		}
+		exposures, _ = allowlist.filter(ingress.Namespace, exposures)
+		for _, item := range exposure.Active(exposures) {
+			if !slices.Contains(hostnames, item.Hostname) {
+				hostnames = append(hostnames, item.Hostname)
+			}
+		}
+	}
+	slices.Sort(hostnames)
+	return hostnames, missing, nil
+}
+
+// findConflict reports the older CloudflareAccess object already covering
+// one of the hostnames, the oldest object by creation timestamp wins and
+// ties are broken by namespace and name.
+func (a *AccessController) findConflict(ctx context.Context, access v1alpha1.CloudflareAccess, hostnames []string) (string, error) {
+	list := v1alpha1.CloudflareAccessList{}
+	if err := a.kubeClient.List(ctx, &list); err != nil {
+		return "", errors.Wrap(err, "list cloudflare accesses")
+	}
+	for _, other := range list.Items {
+		if other.Namespace == access.Namespace && other.Name == access.Name {
+			continue
+		}
+		if other.DeletionTimestamp != nil || !accessIsOlder(other, access) {
+			continue
+		}
+		for _, hostname := range other.Status.Hostnames {
+			if slices.Contains(hostnames, hostname) {
+				return fmt.Sprintf("hostname %s is already covered by %s/%s", hostname, other.Namespace, other.Name), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func accessIsOlder(left v1alpha1.CloudflareAccess, right v1alpha1.CloudflareAccess) bool {
+	if !left.CreationTimestamp.Equal(&right.CreationTimestamp) {
+		return left.CreationTimestamp.Before(&right.CreationTimestamp)
+	}
+	return left.Namespace+"/"+left.Name < right.Namespace+"/"+right.Name
+}
+
+// validateAccessSpec checks what the CRD schema cannot express, so a policy
+// Cloudflare would reject, or worse interpret more loosely than intended, is
+// caught before any API call.
+func validateAccessSpec(spec v1alpha1.CloudflareAccessSpec) error {
+	if len(spec.Policies) == 0 {
+		return errors.New("at least one policy is required")
+	}
+	names := map[string]bool{}
+	for _, policy := range spec.Policies {
+		if policy.Name == "" {
+			return errors.New("policy name must not be empty")
+		}
+		if names[policy.Name] {
+			return errors.Errorf("policy name %s is not unique", policy.Name)
+		}
+		names[policy.Name] = true
+
+		switch policy.Decision {
+		case "", v1alpha1.AccessDecisionAllow, v1alpha1.AccessDecisionDeny, v1alpha1.AccessDecisionBypass, v1alpha1.AccessDecisionNonIdentity:
+		default:
+			return errors.Errorf("policy %s: unknown decision %s", policy.Name, policy.Decision)
+		}
+
+		if accessRulesEmpty(policy.Include) {
+			return errors.Errorf("policy %s: include must have at least one rule", policy.Name)
+		}
+		if err := validateAccessRules(policy.Include); err != nil {
+			return errors.Wrapf(err, "policy %s: include", policy.Name)
+		}
+		if policy.Require != nil {
+			if err := validateAccessRules(*policy.Require); err != nil {
+				return errors.Wrapf(err, "policy %s: require", policy.Name)
+			}
+		}
+		if policy.Exclude != nil {
+			if err := validateAccessRules(*policy.Exclude); err != nil {
+				return errors.Wrapf(err, "policy %s: exclude", policy.Name)
+			}
+		}
+	}
+	if spec.AutoRedirectToIdentity != nil && *spec.AutoRedirectToIdentity && len(spec.AllowedIdentityProviders) != 1 {
+		return errors.New("autoRedirectToIdentity requires exactly one allowedIdentityProviders entry")
+	}
+	return nil
+}
+
+func accessRulesEmpty(rules v1alpha1.AccessRules) bool {
+	return len(rules.Emails) == 0 && len(rules.EmailDomains) == 0 && len(rules.Groups) == 0 &&
+		len(rules.IPRanges) == 0 && len(rules.ServiceTokens) == 0 && !rules.AnyValidServiceToken
+}
+
+func validateAccessRules(rules v1alpha1.AccessRules) error {
+	for _, email := range rules.Emails {
+		local, domain, found := strings.Cut(email, "@")
+		if !found || local == "" || domain == "" || strings.ContainsAny(email, " \t") {
+			return errors.Errorf("invalid email %q", email)
+		}
+	}
+	for _, domain := range rules.EmailDomains {
+		if domain == "" || strings.ContainsAny(domain, "@ \t") || !strings.Contains(domain, ".") {
+			return errors.Errorf("invalid email domain %q, expected a domain like example.com", domain)
+		}
+	}
+	for _, group := range rules.Groups {
+		if strings.TrimSpace(group) == "" {
+			return errors.New("group ID must not be empty")
+		}
+	}
+	for _, ipRange := range rules.IPRanges {
+		if net.ParseIP(ipRange) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(ipRange); err != nil {
+			return errors.Errorf("invalid IP range %q, expected an IP or a CIDR", ipRange)
+		}
+	}
+	for _, token := range rules.ServiceTokens {
+		if strings.TrimSpace(token) == "" {
+			return errors.New("service token ID must not be empty")
+		}
+	}
+	return nil
+}
+
+func fromAccessPolicies(policies []v1alpha1.AccessPolicy) []cloudflarecontroller.AccessPolicy {
+	var result []cloudflarecontroller.AccessPolicy
+	for _, policy := range policies {
+		decision := string(policy.Decision)
+		if decision == "" {
+			decision = string(v1alpha1.AccessDecisionAllow)
+		}
+		item := cloudflarecontroller.AccessPolicy{
+			Name:     policy.Name,
+			Decision: decision,
+			Include:  fromAccessRules(policy.Include),
+		}
+		if policy.Require != nil {
+			item.Require = fromAccessRules(*policy.Require)
+		}
+		if policy.Exclude != nil {
+			item.Exclude = fromAccessRules(*policy.Exclude)
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+func fromAccessRules(rules v1alpha1.AccessRules) cloudflarecontroller.AccessRules {
+	return cloudflarecontroller.AccessRules{
+		Emails:               rules.Emails,
+		EmailDomains:         rules.EmailDomains,
+		Groups:               rules.Groups,
+		IPRanges:             rules.IPRanges,
+		ServiceTokens:        rules.ServiceTokens,
+		AnyValidServiceToken: rules.AnyValidServiceToken,
+	}
+}
+
+// withholdUnprotectedExposures drops the exposures of an Ingress targeted by
+// CloudflareAccess objects until one of them has programmed an Access
+// application covering the hostname. A protected hostname is never exposed
+// without authentication, not even while the application is being created.
+func withholdUnprotectedExposures(ctx context.Context, kubeClient client.Client, recorder record.EventRecorder, ingress networkingv1.Ingress, exposures []exposure.Exposure) ([]exposure.Exposure, error) {
+	list := v1alpha1.CloudflareAccessList{}
+	err := kubeClient.List(ctx, &list,
+		client.InNamespace(ingress.Namespace),
+		client.MatchingFields{accessTargetIndex: "Ingress/" + ingress.Name},
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "list cloudflare accesses targeting ingress %s/%s", ingress.Namespace, ingress.Name)
+	}
+	if len(list.Items) == 0 {
+		return exposures, nil
+	}
+
+	var covered []string
+	for _, access := range list.Items {
+		if access.DeletionTimestamp != nil || access.Status.ObservedGeneration != access.Generation {
+			continue
+		}
+		if !meta.IsStatusConditionTrue(access.Status.Conditions, v1alpha1.AccessConditionProgrammed) {
+			continue
+		}
+		covered = append(covered, access.Status.Hostnames...)
+	}
+
+	var result []exposure.Exposure
+	var withheld []string
+	for _, item := range exposures {
+		if item.IsDeleted || slices.Contains(covered, item.Hostname) {
+			result = append(result, item)
+			continue
+		}
+		if !slices.Contains(withheld, item.Hostname) {
+			withheld = append(withheld, item.Hostname)
+		}
+	}
+	if len(withheld) > 0 {
+		recorder.Event(&ingress, v1.EventTypeWarning, EventReasonAccessWithheld,
+			fmt.Sprintf("hostnames %s are not exposed until their Cloudflare Access application is programmed", strings.Join(withheld, ", ")))
+	}
+	return result, nil
+}
+
+// accessTargetIndexValues is the index function of accessTargetIndex.
+func accessTargetIndexValues(object client.Object) []string {
+	access, ok := object.(*v1alpha1.CloudflareAccess)
+	if !ok {
+		return nil
+	}
+	var result []string
+	for _, targetRef := range access.Spec.TargetRefs {
+		result = append(result, targetRef.Kind+"/"+targetRef.Name)
+	}
+	return result
+}
+
+// accessesForIngress maps an Ingress to the CloudflareAccess objects
+// targeting it.
+func accessesForIngress(kubeClient client.Client) func(ctx context.Context, object client.Object) []reconcile.Request {
+	return func(ctx context.Context, object client.Object) []reconcile.Request {
+		list := v1alpha1.CloudflareAccessList{}
+		err := kubeClient.List(ctx, &list,
+			client.InNamespace(object.GetNamespace()),
+			client.MatchingFields{accessTargetIndex: "Ingress/" + object.GetName()},
+		)
+		if err != nil {
+			return nil
+		}
+		var result []reconcile.Request
+		for _, access := range list.Items {
+			result = append(result, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&access)})
+		}
+		return result
+	}
+}
+
+// ingressesForAccess maps a CloudflareAccess object to the Ingresses it
+// targets, so they are exposed or withheld as soon as its status changes.
+func ingressesForAccess(_ context.Context, object client.Object) []reconcile.Request {
+	access, ok := object.(*v1alpha1.CloudflareAccess)
+	if !ok {
+		return nil
+	}
+	var result []reconcile.Request
+	for _, targetRef := range access.Spec.TargetRefs {
+		if targetRef.Kind != "Ingress" {
+			continue
+		}
+		result = append(result, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: access.Namespace, Name: targetRef.Name}})
+	}
+	return result
+}
@@ -0,0 +1,372 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/apis/v1alpha1"
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+type fakeAccessClient struct {
+	applications map[string]cloudflarecontroller.AccessApplication
+	deleted      []string
+	err          error
+}
+
+func (f *fakeAccessClient) PutAccessApplication(_ context.Context, application cloudflarecontroller.AccessApplication) (cloudflarecontroller.AccessApplicationRef, error) {
+	if f.err != nil {
+		return cloudflarecontroller.AccessApplicationRef{}, f.err
+	}
+	f.applications[application.Name] = application
+	return cloudflarecontroller.AccessApplicationRef{ID: "id-" + application.Name, AUD: "aud-" + application.Name}, nil
+}
+
+func (f *fakeAccessClient) DeleteAccessApplication(_ context.Context, _ string, name string) error {
+	delete(f.applications, name)
+	f.deleted = append(f.deleted, name)
+	return nil
+}
+
+func (f *fakeAccessClient) AccessApplicationName(namespace string, name string) string {
+	return "ctic:test:" + namespace + "/" + name
+}
+
+func newAccessTestClient(t *testing.T, objects ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objects...).
+		WithStatusSubresource(&v1alpha1.CloudflareAccess{}).
+		WithIndex(&v1alpha1.CloudflareAccess{}, accessTargetIndex, accessTargetIndexValues).
+		Build()
+}
+
+func testAccessIngress(name string, host string) *networkingv1.Ingress {
+	pathType := networkingv1.PathTypePrefix
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: host,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     "/",
+									PathType: &pathType,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "app",
+											Port: networkingv1.ServiceBackendPort{Number: 80},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func testAccessService() *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+		Spec: v1.ServiceSpec{
+			ClusterIP: "10.0.0.1",
+			Ports:     []v1.ServicePort{{Port: 80}},
+		},
+	}
+}
+
+func testCloudflareAccess(name string, targets ...string) *v1alpha1.CloudflareAccess {
+	access := &v1alpha1.CloudflareAccess{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Generation: 1},
+		Spec: v1alpha1.CloudflareAccessSpec{
+			Policies: []v1alpha1.AccessPolicy{
+				{
+					Name:    "staff",
+					Include: v1alpha1.AccessRules{EmailDomains: []string{"example.com"}},
+				},
+			},
+		},
+	}
+	for _, target := range targets {
+		access.Spec.TargetRefs = append(access.Spec.TargetRefs, v1alpha1.TargetRef{Kind: "Ingress", Name: target})
+	}
+	return access
+}
+
+func reconcileAccess(t *testing.T, controller *AccessController, name string) (reconcile.Result, error) {
+	t.Helper()
+	return controller.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: name}})
+}
+
+func TestValidateAccessSpec(t *testing.T) {
+	valid := testCloudflareAccess("valid", "app").Spec
+	require.NoError(t, validateAccessSpec(valid))
+
+	for _, tc := range []struct {
+		name   string
+		mutate func(spec *v1alpha1.CloudflareAccessSpec)
+	}{
+		{
+			name: "duplicate policy name",
+			mutate: func(spec *v1alpha1.CloudflareAccessSpec) {
+				spec.Policies = append(spec.Policies, spec.Policies[0])
+			},
+		},
+		{
+			name: "empty include",
+			mutate: func(spec *v1alpha1.CloudflareAccessSpec) {
+				spec.Policies[0].Include = v1alpha1.AccessRules{}
+			},
+		},
+		{
+			name: "email without at sign",
+			mutate: func(spec *v1alpha1.CloudflareAccessSpec) {
+				spec.Policies[0].Include.Emails = []string{"alice.example.com"}
+			},
+		},
+		{
+			name: "email domain with at sign",
+			mutate: func(spec *v1alpha1.CloudflareAccessSpec) {
+				spec.Policies[0].Include.EmailDomains = []string{"@example.com"}
+			},
+		},
+		{
+			name: "malformed cidr in exclude",
+			mutate: func(spec *v1alpha1.CloudflareAccessSpec) {
+				spec.Policies[0].Exclude = &v1alpha1.AccessRules{IPRanges: []string{"10.0.0.0/33"}}
+			},
+		},
+		{
+			name: "unknown decision",
+			mutate: func(spec *v1alpha1.CloudflareAccessSpec) {
+				spec.Policies[0].Decision = "permit"
+			},
+		},
+		{
+			name: "auto redirect without single identity provider",
+			mutate: func(spec *v1alpha1.CloudflareAccessSpec) {
+				autoRedirect := true
+				spec.AutoRedirectToIdentity = &autoRedirect
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := testCloudflareAccess("invalid", "app").Spec
+			tc.mutate(&spec)
+			assert.Error(t, validateAccessSpec(spec))
+		})
+	}
+}
+
+func TestAccessControllerReconcileProgramsApplication(t *testing.T) {
+	kubeClient := newAccessTestClient(t, testAccessService(), testAccessIngress("app", "app.example.com"), testCloudflareAccess("app-access", "app"))
+	accessClient := &fakeAccessClient{applications: map[string]cloudflarecontroller.AccessApplication{}}
+	controller := NewAccessController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cluster.local", accessClient, false)
+
+	result, err := reconcileAccess(t, controller, "app-access")
+	require.NoError(t, err)
+	assert.Equal(t, accessResyncPeriod, result.RequeueAfter)
+
+	application, ok := accessClient.applications["ctic:test:default/app-access"]
+	require.True(t, ok)
+	assert.Equal(t, []string{"app.example.com"}, application.Hostnames)
+	require.Len(t, application.Policies, 1)
+	assert.Equal(t, "allow", application.Policies[0].Decision)
+	assert.Equal(t, []string{"example.com"}, application.Policies[0].Include.EmailDomains)
+
+	access := v1alpha1.CloudflareAccess{}
+	require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "app-access"}, &access))
+	assert.Contains(t, access.Finalizers, AccessCleanupFinalizer)
+	assert.Equal(t, "id-ctic:test:default/app-access", access.Status.ApplicationID)
+	assert.Equal(t, []string{"app.example.com"}, access.Status.Hostnames)
+	assert.True(t, meta.IsStatusConditionTrue(access.Status.Conditions, v1alpha1.AccessConditionProgrammed))
+	assert.True(t, meta.IsStatusConditionTrue(access.Status.Conditions, v1alpha1.AccessConditionResolvedRefs))
+}
+
+func TestAccessControllerSkipsHostnamesNotAllowed(t *testing.T) {
+	ingress := testAccessIngress("app", "app.example.com")
+	for _, host := range []string{"other.example.com", "web.example.net"} {
+		rule := *ingress.Spec.Rules[0].DeepCopy()
+		rule.Host = host
+		ingress.Spec.Rules = append(ingress.Spec.Rules, rule)
+	}
+	policy := &v1alpha1.HostnamePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec: v1alpha1.HostnamePolicySpec{
+			Namespaces:            []string{"default"},
+			AllowedDomainSuffixes: []string{"example.com", "example.net"},
+		},
+	}
+	kubeClient := newAccessTestClient(t, testAccessService(), ingress, testCloudflareAccess("app-access", "app"), policy,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Annotations: map[string]string{AnnotationAllowedHostnames: "app.example.com,web.example.net"}}},
+	)
+	accessClient := &fakeAccessClient{applications: map[string]cloudflarecontroller.AccessApplication{}}
+	controller := NewAccessController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cluster.local", accessClient, true)
+
+	_, err := reconcileAccess(t, controller, "app-access")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app.example.com", "web.example.net"}, accessClient.applications["ctic:test:default/app-access"].Hostnames, "the annotation drops other.example.com")
+
+	// the ingress controller rejects an Ingress with a host the policies
+	// do not allow, none of its hostnames is exposed
+	policy.Spec.AllowedDomainSuffixes = []string{"example.com"}
+	require.NoError(t, kubeClient.Update(context.Background(), policy))
+	_, err = reconcileAccess(t, controller, "app-access")
+	require.NoError(t, err)
+	got := v1alpha1.CloudflareAccess{}
+	require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "app-access"}, &got))
+	programmed := meta.FindStatusCondition(got.Status.Conditions, v1alpha1.AccessConditionProgrammed)
+	require.NotNil(t, programmed)
+	assert.Equal(t, v1alpha1.AccessReasonNoHostnames, programmed.Reason)
+}
+
+func TestAccessControllerInvalidSpecLeavesApplicationUntouched(t *testing.T) {
+	access := testCloudflareAccess("app-access", "app")
+	access.Spec.Policies[0].Include.IPRanges = []string{"not-an-ip"}
+	kubeClient := newAccessTestClient(t, testAccessService(), testAccessIngress("app", "app.example.com"), access)
+	accessClient := &fakeAccessClient{applications: map[string]cloudflarecontroller.AccessApplication{}}
+	controller := NewAccessController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cluster.local", accessClient, false)
+
+	_, err := reconcileAccess(t, controller, "app-access")
+	require.NoError(t, err)
+	assert.Empty(t, accessClient.applications)
+
+	got := v1alpha1.CloudflareAccess{}
+	require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "app-access"}, &got))
+	accepted := meta.FindStatusCondition(got.Status.Conditions, v1alpha1.AccessConditionAccepted)
+	require.NotNil(t, accepted)
+	assert.Equal(t, metav1.ConditionFalse, accepted.Status)
+	assert.Equal(t, v1alpha1.AccessReasonInvalidPolicy, accepted.Reason)
+	assert.False(t, meta.IsStatusConditionTrue(got.Status.Conditions, v1alpha1.AccessConditionProgrammed))
+}
+
+func TestAccessControllerCloudflareErrorIsReported(t *testing.T) {
+	kubeClient := newAccessTestClient(t, testAccessService(), testAccessIngress("app", "app.example.com"), testCloudflareAccess("app-access", "app"))
+	accessClient := &fakeAccessClient{applications: map[string]cloudflarecontroller.AccessApplication{}, err: errors.New("forbidden")}
+	controller := NewAccessController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cluster.local", accessClient, false)
+
+	_, err := reconcileAccess(t, controller, "app-access")
+	require.Error(t, err)
+
+	got := v1alpha1.CloudflareAccess{}
+	require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "app-access"}, &got))
+	programmed := meta.FindStatusCondition(got.Status.Conditions, v1alpha1.AccessConditionProgrammed)
+	require.NotNil(t, programmed)
+	assert.Equal(t, metav1.ConditionFalse, programmed.Status)
+	assert.Equal(t, v1alpha1.AccessReasonCloudflareError, programmed.Reason)
+}
+
+func TestAccessControllerYoungerObjectIsConflicted(t *testing.T) {
+	older := testCloudflareAccess("older", "app")
+	older.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	older.Status.Hostnames = []string{"app.example.com"}
+	younger := testCloudflareAccess("younger", "app")
+	younger.CreationTimestamp = metav1.NewTime(time.Now())
+	kubeClient := newAccessTestClient(t, testAccessService(), testAccessIngress("app", "app.example.com"), older, younger)
+	accessClient := &fakeAccessClient{applications: map[string]cloudflarecontroller.AccessApplication{}}
+	controller := NewAccessController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cluster.local", accessClient, false)
+
+	result, err := reconcileAccess(t, controller, "younger")
+	require.NoError(t, err)
+	assert.Equal(t, accessConflictRecheckPeriod, result.RequeueAfter)
+	assert.Empty(t, accessClient.applications)
+
+	got := v1alpha1.CloudflareAccess{}
+	require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "younger"}, &got))
+	accepted := meta.FindStatusCondition(got.Status.Conditions, v1alpha1.AccessConditionAccepted)
+	require.NotNil(t, accepted)
+	assert.Equal(t, v1alpha1.AccessReasonConflicted, accepted.Reason)
+}
+
+func TestAccessControllerDeletionRemovesApplication(t *testing.T) {
+	access := testCloudflareAccess("app-access", "app")
+	access.Finalizers = []string{AccessCleanupFinalizer}
+	access.Status.ApplicationID = "app-id"
+	kubeClient := newAccessTestClient(t, access)
+	require.NoError(t, kubeClient.Delete(context.Background(), access))
+	accessClient := &fakeAccessClient{applications: map[string]cloudflarecontroller.AccessApplication{}}
+	controller := NewAccessController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cluster.local", accessClient, false)
+
+	_, err := reconcileAccess(t, controller, "app-access")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ctic:test:default/app-access"}, accessClient.deleted)
+
+	err = kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "app-access"}, &v1alpha1.CloudflareAccess{})
+	assert.True(t, apierrors.IsNotFound(err), "expected the object to be gone once the finalizer is released")
+}
+
+func TestWithholdUnprotectedExposures(t *testing.T) {
+	exposures := []exposure.Exposure{
+		{Hostname: "app.example.com", ServiceTarget: "http://app.default.svc.cluster.local:80", PathPrefix: "/"},
+		{Hostname: "old.example.com", IsDeleted: true},
+	}
+	ingress := testAccessIngress("app", "app.example.com")
+
+	t.Run("untargeted ingress is exposed", func(t *testing.T) {
+		kubeClient := newAccessTestClient(t)
+		result, err := withholdUnprotectedExposures(context.Background(), kubeClient, record.NewFakeRecorder(8), *ingress, exposures)
+		require.NoError(t, err)
+		assert.Equal(t, exposures, result)
+	})
+
+	t.Run("not yet programmed access withholds the hostname", func(t *testing.T) {
+		kubeClient := newAccessTestClient(t, testCloudflareAccess("app-access", "app"))
+		recorder := record.NewFakeRecorder(8)
+		result, err := withholdUnprotectedExposures(context.Background(), kubeClient, recorder, *ingress, exposures)
+		require.NoError(t, err)
+		require.Len(t, result, 1)
+		assert.True(t, result[0].IsDeleted, "deletions must still reach Cloudflare")
+		assert.Contains(t, <-recorder.Events, EventReasonAccessWithheld)
+	})
+
+	t.Run("access programmed for an older generation withholds the hostname", func(t *testing.T) {
+		access := testCloudflareAccess("app-access", "app")
+		access.Generation = 2
+		access.Status.ObservedGeneration = 1
+		access.Status.Hostnames = []string{"app.example.com"}
+		access.Status.Conditions = []metav1.Condition{{Type: v1alpha1.AccessConditionProgrammed, Status: metav1.ConditionTrue, Reason: v1alpha1.AccessReasonProgrammed}}
+		kubeClient := newAccessTestClient(t, access)
+		result, err := withholdUnprotectedExposures(context.Background(), kubeClient, record.NewFakeRecorder(8), *ingress, exposures)
+		require.NoError(t, err)
+		assert.Len(t, result, 1)
+	})
+
+	t.Run("programmed access exposes the hostname", func(t *testing.T) {
+		access := testCloudflareAccess("app-access", "app")
+		access.Status.ObservedGeneration = 1
+		access.Status.Hostnames = []string{"app.example.com"}
+		access.Status.Conditions = []metav1.Condition{{Type: v1alpha1.AccessConditionProgrammed, Status: metav1.ConditionTrue, Reason: v1alpha1.AccessReasonProgrammed}}
+		kubeClient := newAccessTestClient(t, access)
+		result, err := withholdUnprotectedExposures(context.Background(), kubeClient, record.NewFakeRecorder(8), *ingress, exposures)
+		require.NoError(t, err)
+		assert.Equal(t, exposures, result)
+	})
+}
@@ -1,29 +1,207 @@
 package controller
 
 import (
+	"context"
+	"time"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/apis/v1alpha1"
 	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
 	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
 )
 
 type IngressControllerOptions struct {
 	IngressClassName    string
 	ControllerClassName string
 	ClusterDomain       string
-	CFTunnelClient      *cloudflarecontroller.TunnelClient
+	// CatchAllService serves the requests matching no Ingress, unless an
+	// Ingress without rules provides a defaultBackend
+	CatchAllService string
+	// HostnameConflictPolicy resolves a host and path claimed by Ingresses of
+	// several namespaces, first-owner or reject
+	HostnameConflictPolicy string
+	CFTunnelClient         cloudflarecontroller.TunnelClientInterface
+	// TunnelRegistry routes IngressClasses with TunnelParameters to their own
+	// tunnel, nil when the TunnelParameters CRD is not installed
+	TunnelRegistry *TunnelRegistry
+	// EnableCloudflareAccess requires the CloudflareAccess CRD to be installed
+	EnableCloudflareAccess bool
+	// EnableHostnamePolicies requires the HostnamePolicy CRD to be installed
+	EnableHostnamePolicies bool
+	// SyncDebounce delays the sync of a tunnel after an event, so a burst of
+	// events results in a single sync
+	SyncDebounce time.Duration
+	Drift        DriftOptions
 }
 
 func RegisterIngressController(logger logr.Logger, mgr manager.Manager, options IngressControllerOptions) error {
-	controller := NewIngressController(logger.WithName("ingress-controller"), mgr.GetClient(), mgr.GetEventRecorderFor("cloudflare-tunnel-ingress-controller"), options.IngressClassName, options.ControllerClassName, options.ClusterDomain, options.CFTunnelClient)
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &networkingv1.Ingress{}, ingressServiceIndex, ingressServiceIndexValues)
+	if err != nil {
+		logger.WithName("register-controller").Error(err, "could not index ingress backend services")
+		return err
+	}
+
+	controller := NewIngressController(logger.WithName("ingress-controller"), mgr.GetClient(), mgr.GetEventRecorderFor("cloudflare-tunnel-ingress-controller"), options.IngressClassName, options.ControllerClassName, options.ClusterDomain, options.CatchAllService, options.HostnameConflictPolicy, options.CFTunnelClient, options.TunnelRegistry, options.EnableCloudflareAccess, options.EnableHostnamePolicies, options.Drift)
+	controllerBuilder := builder.
+		ControllerManagedBy(mgr).
+		Named("ingress").
+		Watches(&networkingv1.Ingress{}, enqueueTunnelSync(options.SyncDebounce, controller.tunnelKeysForIngress), builder.WithPredicates(ingressSourceChanged)).
+		Watches(&v1.Service{}, enqueueTunnelSync(options.SyncDebounce, controller.tunnelKeysForService)).
+		Watches(&networkingv1.IngressClass{}, enqueueTunnelSync(options.SyncDebounce, controller.tunnelKeysForIngressClass)).
+		Watches(&v1.Namespace{}, enqueueTunnelSync(options.SyncDebounce, controller.tunnelKeysForNamespace), builder.WithPredicates(predicate.Or(predicate.AnnotationChangedPredicate{}, predicate.LabelChangedPredicate{})))
+	if options.EnableCloudflareAccess {
+		controllerBuilder = controllerBuilder.Watches(&v1alpha1.CloudflareAccess{}, enqueueTunnelSync(options.SyncDebounce, controller.tunnelKeysForAccess))
+	}
+	if options.EnableHostnamePolicies {
+		controllerBuilder = controllerBuilder.Watches(&v1alpha1.HostnamePolicy{}, enqueueTunnelSync(options.SyncDebounce, controller.tunnelKeysForHostnamePolicy))
+	}
+	if options.TunnelRegistry != nil {
+		controllerBuilder = controllerBuilder.Watches(&v1alpha1.TunnelParameters{}, enqueueTunnelSync(options.SyncDebounce, tunnelKeysForTunnelParameters))
+	}
+	err = controllerBuilder.Complete(controller)
+
+	if err != nil {
+		logger.WithName("register-controller").Error(err, "could not register ingress controller")
+		return err
+	}
+
+	return nil
+}
+
+// enqueueTunnelSync maps the events of an object to the tunnels it affects,
+// both before and after an update. The sync is delayed by the debounce
+// period, the queue collapses the events arriving in the meantime into the
+// pending sync of the tunnel.
+func enqueueTunnelSync(debounce time.Duration, tunnelKeys func(ctx context.Context, object client.Object) []string) handler.EventHandler {
+	enqueue := func(ctx context.Context, queue workqueue.TypedRateLimitingInterface[reconcile.Request], objects ...client.Object) {
+		for _, object := range objects {
+			if object == nil {
+				continue
+			}
+			for _, key := range tunnelKeys(ctx, object) {
+				queue.AddAfter(reconcile.Request{NamespacedName: types.NamespacedName{Name: key}}, debounce)
+			}
+		}
+	}
+	return handler.Funcs{
+		CreateFunc: func(ctx context.Context, e event.CreateEvent, queue workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+			enqueue(ctx, queue, e.Object)
+		},
+		UpdateFunc: func(ctx context.Context, e event.UpdateEvent, queue workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+			enqueue(ctx, queue, e.ObjectOld, e.ObjectNew)
+		},
+		DeleteFunc: func(ctx context.Context, e event.DeleteEvent, queue workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+			enqueue(ctx, queue, e.Object)
+		},
+		GenericFunc: func(ctx context.Context, e event.GenericEvent, queue workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+			enqueue(ctx, queue, e.Object)
+		},
+	}
+}
+
+type GatewayControllerOptions struct {
+	ControllerClassName string
+	ClusterDomain       string
+	// TunnelNamePrefix prefixes the default tunnel name of every Gateway
+	TunnelNamePrefix    string
+	Namespace           string
+	CloudflaredConfig   CloudflaredConfig
+	TunnelClientFactory cloudflarecontroller.TunnelClientFactory
+	// HostnameConflictPolicy resolves a host and path claimed by routes of
+	// several namespaces attached to a Gateway, first-owner or reject
+	HostnameConflictPolicy string
+	// EnableHostnamePolicies requires the HostnamePolicy CRD to be installed
+	EnableHostnamePolicies bool
+}
+
+func RegisterGatewayControllers(logger logr.Logger, mgr manager.Manager, options GatewayControllerOptions) error {
+	gatewayClassController := NewGatewayClassController(logger.WithName("gateway-class-controller"), mgr.GetClient(), options.ControllerClassName)
 	err := builder.
 		ControllerManagedBy(mgr).
-		For(&networkingv1.Ingress{}).
+		For(&gatewayv1.GatewayClass{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(gatewayClassController)
+	if err != nil {
+		logger.WithName("register-controller").Error(err, "could not register gateway class controller")
+		return err
+	}
+
+	gatewayController := NewGatewayController(logger.WithName("gateway-controller"), mgr.GetClient(), mgr.GetEventRecorderFor("cloudflare-tunnel-ingress-controller"), options.ControllerClassName, options.ClusterDomain, options.TunnelNamePrefix, options.Namespace, options.CloudflaredConfig, options.TunnelClientFactory, options.HostnameConflictPolicy, options.EnableHostnamePolicies)
+	gatewayBuilder := builder.
+		ControllerManagedBy(mgr).
+		For(&gatewayv1.Gateway{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Watches(&gatewayv1.HTTPRoute{}, handler.EnqueueRequestsFromMapFunc(gatewaysForRoute)).
+		Watches(&gatewayv1.GatewayClass{}, handler.EnqueueRequestsFromMapFunc(gatewaysForGatewayClass(mgr.GetClient()))).
+		Watches(&v1.Namespace{}, handler.EnqueueRequestsFromMapFunc(allGateways(mgr.GetClient())), builder.WithPredicates(predicate.Or(predicate.AnnotationChangedPredicate{}, predicate.LabelChangedPredicate{})))
+	if options.EnableHostnamePolicies {
+		gatewayBuilder = gatewayBuilder.Watches(&v1alpha1.HostnamePolicy{}, handler.EnqueueRequestsFromMapFunc(allGateways(mgr.GetClient())))
+	}
+	err = gatewayBuilder.Complete(gatewayController)
+	if err != nil {
+		logger.WithName("register-controller").Error(err, "could not register gateway controller")
+		return err
+	}
+
+	return nil
+}
+
+type AccessControllerOptions struct {
+	ClusterDomain string
+	AccessClient  cloudflarecontroller.AccessClientInterface
+	// EnableHostnamePolicies requires the HostnamePolicy CRD to be installed
+	EnableHostnamePolicies bool
+}
+
+// RegisterAccessController registers the CloudflareAccess controller and the
+// field index shared with the ingress controller, it must run before
+// RegisterIngressController.
+func RegisterAccessController(logger logr.Logger, mgr manager.Manager, options AccessControllerOptions) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1alpha1.CloudflareAccess{}, accessTargetIndex, accessTargetIndexValues)
+	if err != nil {
+		logger.WithName("register-controller").Error(err, "could not index cloudflare access targets")
+		return err
+	}
+
+	controller := NewAccessController(logger.WithName("access-controller"), mgr.GetClient(), mgr.GetEventRecorderFor("cloudflare-tunnel-ingress-controller"), options.ClusterDomain, options.AccessClient, options.EnableHostnamePolicies)
+	err = builder.
+		ControllerManagedBy(mgr).
+		For(&v1alpha1.CloudflareAccess{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&networkingv1.Ingress{}, handler.EnqueueRequestsFromMapFunc(accessesForIngress(mgr.GetClient()))).
 		Complete(controller)
+	if err != nil {
+		logger.WithName("register-controller").Error(err, "could not register cloudflare access controller")
+		return err
+	}
+
+	return nil
+}
+
+type TunnelParametersControllerOptions struct {
+	// Namespace is where the cloudflared connectors run and the credential
+	// Secrets live
+	Namespace         string
+	CloudflaredConfig CloudflaredConfig
+	TunnelRegistry    *TunnelRegistry
+}
 
+func RegisterTunnelParametersController(logger logr.Logger, mgr manager.Manager, options TunnelParametersControllerOptions) error {
+	controller := NewTunnelParametersController(logger.WithName("tunnel-parameters-controller"), mgr.GetClient(), mgr.GetEventRecorderFor("cloudflare-tunnel-ingress-controller"), options.Namespace, options.CloudflaredConfig, options.TunnelRegistry)
+	err := builder.
+		ControllerManagedBy(mgr).
+		For(&v1alpha1.TunnelParameters{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(controller)
 	if err != nil {
-		logger.WithName("register-controller").Error(err, "could not register ingress controller")
+		logger.WithName("register-controller").Error(err, "could not register tunnel parameters controller")
 		return err
 	}
 
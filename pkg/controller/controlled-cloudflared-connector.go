@@ -27,10 +27,10 @@ const connectorManagedByLabelKey = "strrl.dev/cloudflare-tunnel-ingress-controll
 
 // connectorLabels are the immutable labels identifying the managed cloudflared
 // connector resources; they also serve as the Deployment selector.
-func connectorLabels() map[string]string {
+func connectorLabels(name string) map[string]string {
 	return map[string]string{
-		"app":                      connectorAppName,
-		connectorManagedByLabelKey: connectorAppName,
+		"app":                      name,
+		connectorManagedByLabelKey: name,
 	}
 }
 
@@ -53,6 +53,25 @@ type CloudflaredConfig struct {
 	// uninstalled. Nil (for example when running outside the cluster) leaves
 	// the resources unowned.
 	Owner *metav1.OwnerReference
+	// Name names the connector Deployment and its token Secret, empty means
+	// the default connector of the tunnel given on the command line. Every
+	// Gateway and TunnelParameters object gets its own connector with a
+	// distinct name.
+	Name string
+}
+
+func (c CloudflaredConfig) connectorName() string {
+	if c.Name == "" {
+		return connectorAppName
+	}
+	return c.Name
+}
+
+func (c CloudflaredConfig) tokenSecretName() string {
+	if c.Name == "" {
+		return tunnelTokenSecretName
+	}
+	return c.Name + "-token"
 }
 
 // ResolveControllerOwnerReference builds the owner reference pointing at the
@@ -83,14 +102,15 @@ func hasOwnerReference(refs []metav1.OwnerReference, owner *metav1.OwnerReferenc
 // adoptConnectorResources appends the owner reference to connector resources
 // created by older controller versions. It only talks to the Kubernetes API,
 // so adoption succeeds even while Cloudflare is unreachable.
-func adoptConnectorResources(ctx context.Context, kubeClient client.Client, namespace string, owner *metav1.OwnerReference) error {
+func adoptConnectorResources(ctx context.Context, kubeClient client.Client, namespace string, config CloudflaredConfig) error {
 	logger := log.FromContext(ctx)
+	owner := config.Owner
 
 	list := appsv1.DeploymentList{}
 	err := kubeClient.List(ctx, &list, &client.ListOptions{
 		Namespace: namespace,
 		LabelSelector: labels.SelectorFromSet(labels.Set{
-			connectorManagedByLabelKey: connectorAppName,
+			connectorManagedByLabelKey: config.connectorName(),
 		}),
 	})
 	if err != nil {
@@ -109,7 +129,7 @@ func adoptConnectorResources(ctx context.Context, kubeClient client.Client, name
 	}
 
 	secret := &v1.Secret{}
-	err = kubeClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: tunnelTokenSecretName}, secret)
+	err = kubeClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: config.tokenSecretName()}, secret)
 	if apierrors.IsNotFound(err) {
 		return nil
 	}
@@ -121,7 +141,7 @@ func adoptConnectorResources(ctx context.Context, kubeClient client.Client, name
 		if err := kubeClient.Update(ctx, secret); err != nil {
 			return errors.Wrap(err, "adopt tunnel token secret")
 		}
-		logger.Info("adopted tunnel token secret", "namespace", namespace, "name", tunnelTokenSecretName)
+		logger.Info("adopted tunnel token secret", "namespace", namespace, "name", config.tokenSecretName())
 	}
 	return nil
 }
@@ -138,7 +158,7 @@ func CreateOrUpdateControlledCloudflared(
 	// adopt pre-existing resources before any external call, lifecycle
 	// ownership must not depend on Cloudflare availability
 	if config.Owner != nil {
-		if err := adoptConnectorResources(ctx, kubeClient, namespace, config.Owner); err != nil {
+		if err := adoptConnectorResources(ctx, kubeClient, namespace, config); err != nil {
 			return errors.Wrap(err, "adopt connector resources")
 		}
 	}
@@ -148,7 +168,7 @@ func CreateOrUpdateControlledCloudflared(
 		return errors.Wrap(err, "fetch tunnel token")
 	}
 
-	tokenSecretVersion, err := createOrUpdateTunnelTokenSecret(ctx, kubeClient, namespace, token, config.Owner)
+	tokenSecretVersion, err := createOrUpdateTunnelTokenSecret(ctx, kubeClient, namespace, config, token)
 	if err != nil {
 		return errors.Wrap(err, "create or update tunnel token secret")
 	}
@@ -157,11 +177,11 @@ func CreateOrUpdateControlledCloudflared(
 	err = kubeClient.List(ctx, &list, &client.ListOptions{
 		Namespace: namespace,
 		LabelSelector: labels.SelectorFromSet(labels.Set{
-			connectorManagedByLabelKey: connectorAppName,
+			connectorManagedByLabelKey: config.connectorName(),
 		}),
 	})
 	if err != nil {
-		return errors.Wrapf(err, "list controlled-cloudflared-connector in namespace %s", namespace)
+		return errors.Wrapf(err, "list %s in namespace %s", config.connectorName(), namespace)
 	}
 
 	if len(list.Items) > 0 {
@@ -211,9 +231,9 @@ func CreateOrUpdateControlledCloudflared(
 			}
 			err = kubeClient.Update(ctx, existingDeployment)
 			if err != nil {
-				return errors.Wrap(err, "update controlled-cloudflared-connector deployment")
+				return errors.Wrapf(err, "update %s deployment", config.connectorName())
 			}
-			logger.Info("Updated controlled-cloudflared-connector deployment", "namespace", namespace)
+			logger.Info("Updated cloudflared connector deployment", "namespace", namespace, "name", config.connectorName())
 		}
 
 		return nil
@@ -226,9 +246,32 @@ func CreateOrUpdateControlledCloudflared(
 	}.build()
 	err = kubeClient.Create(ctx, deployment)
 	if err != nil {
-		return errors.Wrap(err, "create controlled-cloudflared-connector deployment")
+		return errors.Wrapf(err, "create %s deployment", config.connectorName())
+	}
+	logger.Info("Created cloudflared connector deployment", "namespace", namespace, "name", config.connectorName())
+	return nil
+}
+
+// DeleteControlledCloudflared removes the connector Deployment and token
+// Secret with the given name. Connectors bound to a Gateway live in the
+// controller namespace, so garbage collection cannot follow the Gateway
+// across namespaces and they are removed explicitly.
+func DeleteControlledCloudflared(ctx context.Context, kubeClient client.Client, namespace string, name string) error {
+	config := CloudflaredConfig{Name: name}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: config.connectorName()},
+	}
+	if err := kubeClient.Delete(ctx, deployment); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "delete %s deployment", config.connectorName())
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: config.tokenSecretName()},
+	}
+	if err := kubeClient.Delete(ctx, secret); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "delete tunnel token secret %s", config.tokenSecretName())
 	}
-	logger.Info("Created controlled-cloudflared-connector deployment", "namespace", namespace)
 	return nil
 }
 
@@ -236,31 +279,31 @@ func createOrUpdateTunnelTokenSecret(
 	ctx context.Context,
 	kubeClient client.Client,
 	namespace string,
+	config CloudflaredConfig,
 	token string,
-	owner *metav1.OwnerReference,
 ) (string, error) {
 	logger := log.FromContext(ctx)
 
 	existingSecret := &v1.Secret{}
 	err := kubeClient.Get(ctx, client.ObjectKey{
 		Namespace: namespace,
-		Name:      tunnelTokenSecretName,
+		Name:      config.tokenSecretName(),
 	}, existingSecret)
 
 	desiredSecret := &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      tunnelTokenSecretName,
+			Name:      config.tokenSecretName(),
 			Namespace: namespace,
 			Labels: map[string]string{
-				connectorManagedByLabelKey: connectorAppName,
+				connectorManagedByLabelKey: config.connectorName(),
 			},
 		},
 		StringData: map[string]string{
 			tunnelTokenSecretKey: token,
 		},
 	}
-	if owner != nil {
-		desiredSecret.OwnerReferences = []metav1.OwnerReference{*owner}
+	if config.Owner != nil {
+		desiredSecret.OwnerReferences = []metav1.OwnerReference{*config.Owner}
 	}
 
 	if err != nil {
@@ -271,7 +314,7 @@ func createOrUpdateTunnelTokenSecret(
 		if err != nil {
 			return "", errors.Wrap(err, "create tunnel token secret")
 		}
-		logger.Info("Created tunnel token secret", "namespace", namespace)
+		logger.Info("Created tunnel token secret", "namespace", namespace, "name", config.tokenSecretName())
 		return desiredSecret.ResourceVersion, nil
 	}
 
@@ -284,7 +327,7 @@ func createOrUpdateTunnelTokenSecret(
 	if err != nil {
 		return "", errors.Wrap(err, "update tunnel token secret")
 	}
-	logger.Info("Updated tunnel token secret", "namespace", namespace)
+	logger.Info("Updated tunnel token secret", "namespace", namespace, "name", config.tokenSecretName())
 	return existingSecret.ResourceVersion, nil
 }
 
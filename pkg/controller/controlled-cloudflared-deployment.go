@@ -13,7 +13,7 @@ type controlledCloudflaredDeployment struct {
 }
 
 func (d controlledCloudflaredDeployment) build() *appsv1.Deployment {
-	const appName = connectorAppName
+	appName := d.config.connectorName()
 
 	customization := d.config.Customization
 	if customization == nil {
@@ -27,7 +27,7 @@ func (d controlledCloudflaredDeployment) build() *appsv1.Deployment {
 		podLabels[k] = v
 	}
 	podLabels["app"] = appName
-	podLabels[connectorManagedByLabelKey] = connectorAppName
+	podLabels[connectorManagedByLabelKey] = appName
 
 	podAnnotations := map[string]string{}
 	for k, v := range customization.PodAnnotations {
@@ -51,7 +51,7 @@ func (d controlledCloudflaredDeployment) build() *appsv1.Deployment {
 				ValueFrom: &v1.EnvVarSource{
 					SecretKeyRef: &v1.SecretKeySelector{
 						LocalObjectReference: v1.LocalObjectReference{
-							Name: tunnelTokenSecretName,
+							Name: d.config.tokenSecretName(),
 						},
 						Key: tunnelTokenSecretKey,
 					},
@@ -111,14 +111,14 @@ func (d controlledCloudflaredDeployment) build() *appsv1.Deployment {
 		ObjectMeta: metav1.ObjectMeta{
 			Name:            appName,
 			Namespace:       d.namespace,
-			Labels:          connectorLabels(),
+			Labels:          connectorLabels(appName),
 			Annotations:     deploymentAnnotations,
 			OwnerReferences: ownerReferences,
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: &d.config.Replicas,
 			Selector: &metav1.LabelSelector{
-				MatchLabels: connectorLabels(),
+				MatchLabels: connectorLabels(appName),
 			},
 			Template: v1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// exposureCache keeps the exposures computed from every controlled Ingress,
+// so a tunnel sync only transforms the Ingresses that changed.
+type exposureCache struct {
+	mu      sync.Mutex
+	entries map[types.NamespacedName]*cachedExposures
+}
+
+type cachedExposures struct {
+	// source is the part of the Ingress the exposures are computed from,
+	// status, finalizer and sync status updates leave it unchanged
+	source ingressSource
+	// serviceVersions are the resource versions of the backend Services, a
+	// missing Service has an empty version
+	serviceVersions map[string]string
+	// allowed are the hostnames the HostnamePolicies allowed
+	allowed *AllowedHostnames
+	// tunnelKey is the tunnel the Ingress was last synced to
+	tunnelKey string
+	exposures []exposure.Exposure
+	// err is why the exposures of the Ingress could not be computed
+	err error
+	// synced is false until the exposures are put into the tunnel
+	synced bool
+}
+
+type ingressSource struct {
+	annotations map[string]string
+	spec        networkingv1.IngressSpec
+	deleted     bool
+}
+
+func newIngressSource(ingress networkingv1.Ingress) ingressSource {
+	// the status annotations are written by the controller itself
+	annotations := maps.Clone(ingress.Annotations)
+	delete(annotations, AnnotationSyncStatus)
+	delete(annotations, AnnotationSyncSummary)
+	return ingressSource{
+		annotations: annotations,
+		spec:        ingress.Spec,
+		deleted:     ingress.DeletionTimestamp != nil,
+	}
+}
+
+func (s ingressSource) equal(other ingressSource) bool {
+	return s.deleted == other.deleted &&
+		maps.Equal(s.annotations, other.annotations) &&
+		equality.Semantic.DeepEqual(s.spec, other.spec)
+}
+
+// ingressSourceChanged drops the Ingress updates leaving the source of the
+// exposures unchanged, the writes of the controller itself among them.
+var ingressSourceChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldIngress, oldOk := e.ObjectOld.(*networkingv1.Ingress)
+		newIngress, newOk := e.ObjectNew.(*networkingv1.Ingress)
+		if !oldOk || !newOk {
+			return true
+		}
+		return !newIngressSource(*oldIngress).equal(newIngressSource(*newIngress))
+	},
+}
+
+func newExposureCache() *exposureCache {
+	return &exposureCache{entries: map[types.NamespacedName]*cachedExposures{}}
+}
+
+func (c *exposureCache) get(name types.NamespacedName) *cachedExposures {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[name]
+}
+
+func (c *exposureCache) put(name types.NamespacedName, entry *cachedExposures) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[name] = entry
+}
+
+// prune drops the entries of the tunnel whose Ingress is no longer routed to
+// it, deleted Ingresses included.
+func (c *exposureCache) prune(tunnelKey string, ingresses []networkingv1.Ingress) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, entry := range c.entries {
+		if entry.tunnelKey != tunnelKey {
+			continue
+		}
+		if !slices.ContainsFunc(ingresses, func(ingress networkingv1.Ingress) bool {
+			return ingress.Namespace == name.Namespace && ingress.Name == name.Name
+		}) {
+			delete(c.entries, name)
+		}
+	}
+}
+
+// ingressServiceIndex indexes Ingresses by the names of their backend
+// Services, within the Ingress namespace.
+const ingressServiceIndex = "spec.backend.service"
+
+// ingressServiceIndexValues is the index function of ingressServiceIndex.
+func ingressServiceIndexValues(object client.Object) []string {
+	ingress, ok := object.(*networkingv1.Ingress)
+	if !ok {
+		return nil
+	}
+	return ingressServiceNames(*ingress)
+}
+
+// ingressServiceNames returns the names of the backend Services of the
+// Ingress, in the namespace of the Ingress.
+func ingressServiceNames(ingress networkingv1.Ingress) []string {
+	var result []string
+	if ingress.Spec.DefaultBackend != nil && ingress.Spec.DefaultBackend.Service != nil {
+		result = append(result, ingress.Spec.DefaultBackend.Service.Name)
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service == nil || slices.Contains(result, path.Backend.Service.Name) {
+				continue
+			}
+			result = append(result, path.Backend.Service.Name)
+		}
+	}
+	return result
+}
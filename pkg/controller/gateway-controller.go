@@ -0,0 +1,918 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"sync"
+
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+var _ reconcile.Reconciler = &GatewayClassController{}
+var _ reconcile.Reconciler = &GatewayController{}
+
+// AnnotationGatewayTunnelName overrides the name of the Cloudflare tunnel
+// backing a Gateway, by default it is derived from the controller tunnel name
+// and the Gateway namespace and name.
+const AnnotationGatewayTunnelName = "cloudflare-tunnel-ingress-controller.strrl.dev/tunnel-name"
+
+// GatewayClassController accepts the GatewayClasses whose controllerName
+// matches the controller class.
+type GatewayClassController struct {
+	logger              logr.Logger
+	kubeClient          client.Client
+	controllerClassName string
+}
+
+func NewGatewayClassController(logger logr.Logger, kubeClient client.Client, controllerClassName string) *GatewayClassController {
+	return &GatewayClassController{logger: logger, kubeClient: kubeClient, controllerClassName: controllerClassName}
+}
+
+func (g *GatewayClassController) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	gatewayClass := gatewayv1.GatewayClass{}
+	err := g.kubeClient.Get(ctx, request.NamespacedName, &gatewayClass)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, errors.Wrapf(err, "fetch gateway class %s", request.Name)
+	}
+
+	if string(gatewayClass.Spec.ControllerName) != g.controllerClassName {
+		return reconcile.Result{}, nil
+	}
+
+	newGatewayClass := gatewayClass.DeepCopy()
+	meta.SetStatusCondition(&newGatewayClass.Status.Conditions, metav1.Condition{
+		Type:               string(gatewayv1.GatewayClassConditionStatusAccepted),
+		Status:             metav1.ConditionTrue,
+		Reason:             string(gatewayv1.GatewayClassReasonAccepted),
+		Message:            "gateway class is accepted by " + g.controllerClassName,
+		ObservedGeneration: gatewayClass.Generation,
+	})
+	if equality.Semantic.DeepEqual(gatewayClass.Status, newGatewayClass.Status) {
+		return reconcile.Result{}, nil
+	}
+	if err := g.kubeClient.Status().Update(ctx, newGatewayClass); err != nil {
+		return reconcile.Result{}, errors.Wrapf(err, "update gateway class %s status", request.Name)
+	}
+	g.logger.Info("gateway class accepted", "gateway-class", request.Name)
+	return reconcile.Result{}, nil
+}
+
+// GatewayController programs one Cloudflare tunnel per Gateway, with one
+// managed cloudflared connector, from the HTTPRoutes attached to it. Route
+// changes are reconciled through their parent Gateways.
+type GatewayController struct {
+	logger              logr.Logger
+	kubeClient          client.Client
+	recorder            record.EventRecorder
+	controllerClassName string
+	clusterDomain       string
+	// tunnelNamePrefix prefixes the default tunnel name of every Gateway
+	tunnelNamePrefix string
+	// namespace is where the cloudflared connectors of the Gateways run
+	namespace           string
+	cloudflaredConfig   CloudflaredConfig
+	tunnelClientFactory cloudflarecontroller.TunnelClientFactory
+	// hostnameConflictPolicy resolves the hosts and paths claimed by routes
+	// of several namespaces attached to the Gateway
+	hostnameConflictPolicy string
+	// hostnamePoliciesEnabled restricts the hostnames of the routes to the
+	// ones the HostnamePolicies of their namespace allow
+	hostnamePoliciesEnabled bool
+
+	mu            sync.Mutex
+	tunnelClients map[types.NamespacedName]gatewayTunnel
+}
+
+type gatewayTunnel struct {
+	name   string
+	client cloudflarecontroller.TunnelClientInterface
+}
+
+func NewGatewayController(logger logr.Logger, kubeClient client.Client, recorder record.EventRecorder, controllerClassName string, clusterDomain string, tunnelNamePrefix string, namespace string, cloudflaredConfig CloudflaredConfig, tunnelClientFactory cloudflarecontroller.TunnelClientFactory, hostnameConflictPolicy string, hostnamePoliciesEnabled bool) *GatewayController {
+	return &GatewayController{
+		logger:                  logger,
+		kubeClient:              kubeClient,
+		recorder:                recorder,
+		controllerClassName:     controllerClassName,
+		clusterDomain:           clusterDomain,
+		tunnelNamePrefix:        tunnelNamePrefix,
+		namespace:               namespace,
+		cloudflaredConfig:       cloudflaredConfig,
+		tunnelClientFactory:     tunnelClientFactory,
+		hostnameConflictPolicy:  hostnameConflictPolicy,
+		hostnamePoliciesEnabled: hostnamePoliciesEnabled,
+		tunnelClients:           map[types.NamespacedName]gatewayTunnel{},
+	}
+}
+
+// attachedRoute is an HTTPRoute bound to the reconciled Gateway, with the
+// outcome of the attachment reported in the route parent status.
+type attachedRoute struct {
+	route     gatewayv1.HTTPRoute
+	parentRef gatewayv1.ParentReference
+	// listeners are the names of the listeners the route is attached to
+	listeners []gatewayv1.SectionName
+	exposures []exposure.Exposure
+	// conditionErr is set when the route is not programmed because of an
+	// invalid or unresolvable spec
+	conditionErr *routeConditionError
+	// rejected is why some hostnames of the route are not programmed, the
+	// allowlist of its namespace or a claim of another namespace
+	rejected error
+}
+
+func (g *GatewayController) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	gateway := gatewayv1.Gateway{}
+	err := g.kubeClient.Get(ctx, request.NamespacedName, &gateway)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, errors.Wrapf(err, "fetch gateway %s", request.NamespacedName)
+	}
+
+	// a deleted Gateway holding the finalizer is cleaned up whatever its class
+	// resolves to now, the class may be gone or handed to another controller
+	if gateway.DeletionTimestamp != nil {
+		return reconcile.Result{}, g.cleanupGateway(ctx, gateway)
+	}
+
+	controlled, err := g.isControlledByThisController(ctx, gateway)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrapf(err, "check if gateway %s is controlled by this controller", request.NamespacedName)
+	}
+	if !controlled {
+		g.logger.V(1).Info("gateway is NOT controlled by this controller", "gateway", request.NamespacedName)
+		// release what was programmed before the class changed
+		return reconcile.Result{}, g.cleanupGateway(ctx, gateway)
+	}
+
+	if !slices.Contains(gateway.Finalizers, IngressControllerFinalizer) {
+		gateway.Finalizers = append(gateway.Finalizers, IngressControllerFinalizer)
+		if err := g.kubeClient.Update(ctx, &gateway); err != nil {
+			return reconcile.Result{}, errors.Wrapf(err, "attach finalizer to gateway %s", request.NamespacedName)
+		}
+	}
+
+	listenerStatuses := validateListeners(gateway)
+
+	routes, err := g.listAttachedRoutes(ctx, gateway, listenerStatuses)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrapf(err, "list routes attached to gateway %s", request.NamespacedName)
+	}
+
+	// the routes of several namespaces claim hostnames like Ingresses do
+	owners := make([]client.Object, len(routes))
+	served := make([][]exposure.Exposure, len(routes))
+	for idx := range routes {
+		owners[idx] = &routes[idx].route
+		served[idx] = routes[idx].exposures
+	}
+	claimed, conflicts := hostnameConflicts(g.hostnameConflictPolicy, owners, served)
+	for idx := range routes {
+		routes[idx].exposures = claimed[idx]
+		routes[idx].rejected = utilerrors.NewAggregate([]error{routes[idx].rejected, conflicts[idx]})
+	}
+
+	var allExposures []exposure.Exposure
+	for _, item := range routes {
+		allExposures = append(allExposures, item.exposures...)
+	}
+	g.logger.V(3).Info("all gateway exposures", "gateway", request.NamespacedName, "exposures", allExposures)
+
+	tunnelClient, err := g.tunnelClientFor(ctx, gateway)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrapf(err, "bootstrap tunnel for gateway %s", request.NamespacedName)
+	}
+
+	syncErr := tunnelClient.PutExposures(ctx, allExposures)
+	if syncErr != nil {
+		g.recorder.Event(&gateway, v1.EventTypeWarning, EventReasonSyncFailed, syncErr.Error())
+	} else {
+		g.recorder.Event(&gateway, v1.EventTypeNormal, EventReasonSynced, "cloudflare tunnel config and DNS records are up to date")
+
+		// the records of deleted and detached routes are gone, release them
+		if err := g.releaseRoutes(ctx, gateway, routes); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	connectorConfig := g.cloudflaredConfig
+	connectorConfig.Name = gatewayConnectorName(gateway)
+	connectorErr := CreateOrUpdateControlledCloudflared(ctx, g.kubeClient, tunnelClient, g.namespace, connectorConfig)
+	if connectorErr != nil {
+		g.logger.Error(connectorErr, "create or update cloudflared connector for gateway", "gateway", request.NamespacedName)
+	}
+
+	for _, item := range routes {
+		if item.route.DeletionTimestamp != nil {
+			continue
+		}
+		if err := g.updateRouteStatus(ctx, gateway, item, syncErr); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	if err := g.updateGatewayStatus(ctx, gateway, tunnelClient.TunnelDomain(), listenerStatuses, routes, syncErr); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if syncErr != nil {
+		return reconcile.Result{}, errors.Wrap(syncErr, "put exposures")
+	}
+	if connectorErr != nil {
+		return reconcile.Result{}, errors.Wrap(connectorErr, "create or update cloudflared connector")
+	}
+	g.logger.V(3).Info("reconcile completed", "gateway", request.NamespacedName)
+	return reconcile.Result{}, nil
+}
+
+func (g *GatewayController) isControlledByThisController(ctx context.Context, gateway gatewayv1.Gateway) (bool, error) {
+	gatewayClass := gatewayv1.GatewayClass{}
+	err := g.kubeClient.Get(ctx, types.NamespacedName{Name: string(gateway.Spec.GatewayClassName)}, &gatewayClass)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "fetch gateway class %s", gateway.Spec.GatewayClassName)
+	}
+	return string(gatewayClass.Spec.ControllerName) == g.controllerClassName, nil
+}
+
+// cleanupGateway removes the DNS records of every route of a deleted Gateway
+// and its cloudflared connector, then releases the finalizers. The tunnel
+// itself is kept, cloudflared connections drain only after the connector is
+// gone and Cloudflare refuses to delete a tunnel with active connections.
+func (g *GatewayController) cleanupGateway(ctx context.Context, gateway gatewayv1.Gateway) error {
+	key := client.ObjectKeyFromObject(&gateway)
+	if !slices.Contains(gateway.Finalizers, IngressControllerFinalizer) {
+		return nil
+	}
+
+	routes, err := g.listAttachedRoutes(ctx, gateway, validateListeners(gateway))
+	if err != nil {
+		return errors.Wrapf(err, "list routes attached to gateway %s", key)
+	}
+
+	var deletedExposures []exposure.Exposure
+	for _, item := range routes {
+		for _, hostname := range routeHostnames(item) {
+			deletedExposures = append(deletedExposures, exposure.Exposure{Hostname: hostname, IsDeleted: true})
+		}
+	}
+
+	tunnelClient, err := g.tunnelClientFor(ctx, gateway)
+	if err != nil {
+		return errors.Wrapf(err, "bootstrap tunnel for gateway %s", key)
+	}
+	if err := tunnelClient.PutExposures(ctx, deletedExposures); err != nil {
+		g.recorder.Event(&gateway, v1.EventTypeWarning, EventReasonSyncFailed, err.Error())
+		return errors.Wrap(err, "put exposures")
+	}
+
+	if err := DeleteControlledCloudflared(ctx, g.kubeClient, g.namespace, gatewayConnectorName(gateway)); err != nil {
+		return errors.Wrapf(err, "delete cloudflared connector of gateway %s", key)
+	}
+
+	if err := g.releaseRoutes(ctx, gateway, nil); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	delete(g.tunnelClients, key)
+	g.mu.Unlock()
+
+	gateway.Finalizers = slices.DeleteFunc(gateway.Finalizers, func(f string) bool {
+		return f == IngressControllerFinalizer
+	})
+	if err := g.kubeClient.Update(ctx, &gateway); err != nil {
+		return errors.Wrapf(err, "clean finalizer for gateway %s", key)
+	}
+	return nil
+}
+
+// routeHostnames returns the distinct hostnames of the exposures of a route.
+func routeHostnames(item attachedRoute) []string {
+	var result []string
+	for _, e := range item.exposures {
+		if !slices.Contains(result, e.Hostname) {
+			result = append(result, e.Hostname)
+		}
+	}
+	return result
+}
+
+func (g *GatewayController) tunnelClientFor(ctx context.Context, gateway gatewayv1.Gateway) (cloudflarecontroller.TunnelClientInterface, error) {
+	key := client.ObjectKeyFromObject(&gateway)
+	tunnelName := g.gatewayTunnelName(gateway)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if cached, ok := g.tunnelClients[key]; ok && cached.name == tunnelName {
+		return cached.client, nil
+	}
+
+	g.logger.Info("bootstrap tunnel for gateway", "gateway", key, "tunnel-name", tunnelName)
+	tunnelClient, err := g.tunnelClientFactory(ctx, tunnelName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "bootstrap tunnel %s", tunnelName)
+	}
+	g.tunnelClients[key] = gatewayTunnel{name: tunnelName, client: tunnelClient}
+	return tunnelClient, nil
+}
+
+func (g *GatewayController) gatewayTunnelName(gateway gatewayv1.Gateway) string {
+	if name, ok := getAnnotation(gateway.Annotations, AnnotationGatewayTunnelName); ok && name != "" {
+		return name
+	}
+	return fmt.Sprintf("%s-%s-%s", g.tunnelNamePrefix, gateway.Namespace, gateway.Name)
+}
+
+// gatewayConnectorName names the cloudflared connector of a Gateway, it is
+// shortened with a hash to stay a valid label value.
+func gatewayConnectorName(gateway gatewayv1.Gateway) string {
+	name := fmt.Sprintf("cloudflared-%s-%s", gateway.Namespace, gateway.Name)
+	if len(name) <= 63 {
+		return name
+	}
+	sum := sha256.Sum256([]byte(gateway.Namespace + "/" + gateway.Name))
+	return fmt.Sprintf("%s-%x", name[:54], sum[:4])
+}
+
+// validateListeners reports the listeners the tunnel can serve. TLS always
+// terminates at the Cloudflare edge, so HTTP and HTTPS listeners are served
+// alike and anything needing the raw connection is rejected.
+func validateListeners(gateway gatewayv1.Gateway) []gatewayv1.ListenerStatus {
+	var result []gatewayv1.ListenerStatus
+	for _, listener := range gateway.Spec.Listeners {
+		status := gatewayv1.ListenerStatus{
+			Name: listener.Name,
+			SupportedKinds: []gatewayv1.RouteGroupKind{{
+				Group: ptr.To(gatewayv1.Group(gatewayv1.GroupName)),
+				Kind:  "HTTPRoute",
+			}},
+		}
+
+		accepted := metav1.Condition{
+			Type:               string(gatewayv1.ListenerConditionAccepted),
+			Status:             metav1.ConditionTrue,
+			Reason:             string(gatewayv1.ListenerReasonAccepted),
+			Message:            "listener is served by the cloudflare edge",
+			ObservedGeneration: gateway.Generation,
+		}
+		resolvedRefs := metav1.Condition{
+			Type:               string(gatewayv1.ListenerConditionResolvedRefs),
+			Status:             metav1.ConditionTrue,
+			Reason:             string(gatewayv1.ListenerReasonResolvedRefs),
+			ObservedGeneration: gateway.Generation,
+		}
+
+		switch listener.Protocol {
+		case gatewayv1.HTTPProtocolType:
+		case gatewayv1.HTTPSProtocolType:
+			if listener.TLS != nil && listener.TLS.Mode != nil && *listener.TLS.Mode == gatewayv1.TLSModePassthrough {
+				accepted.Status = metav1.ConditionFalse
+				accepted.Reason = string(gatewayv1.ListenerReasonUnsupportedValue)
+				accepted.Message = "TLS passthrough is not supported, TLS always terminates at the cloudflare edge"
+			} else if listener.TLS != nil && len(listener.TLS.CertificateRefs) > 0 {
+				accepted.Message = "certificates are managed at the cloudflare edge, certificateRefs are ignored"
+			}
+		default:
+			accepted.Status = metav1.ConditionFalse
+			accepted.Reason = string(gatewayv1.ListenerReasonUnsupportedProtocol)
+			accepted.Message = fmt.Sprintf("protocol %s is not supported, only HTTP and HTTPS are", listener.Protocol)
+			status.SupportedKinds = []gatewayv1.RouteGroupKind{}
+		}
+
+		if listener.AllowedRoutes != nil && len(listener.AllowedRoutes.Kinds) > 0 && !listenerAllowsHTTPRoute(listener) {
+			resolvedRefs.Status = metav1.ConditionFalse
+			resolvedRefs.Reason = string(gatewayv1.ListenerReasonInvalidRouteKinds)
+			resolvedRefs.Message = "only HTTPRoute is supported"
+			status.SupportedKinds = []gatewayv1.RouteGroupKind{}
+		}
+
+		meta.SetStatusCondition(&status.Conditions, accepted)
+		meta.SetStatusCondition(&status.Conditions, resolvedRefs)
+		result = append(result, status)
+	}
+	return result
+}
+
+func listenerAllowsHTTPRoute(listener gatewayv1.Listener) bool {
+	if listener.AllowedRoutes == nil || len(listener.AllowedRoutes.Kinds) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(listener.AllowedRoutes.Kinds, func(kind gatewayv1.RouteGroupKind) bool {
+		return kind.Kind == "HTTPRoute" && (kind.Group == nil || *kind.Group == gatewayv1.GroupName)
+	})
+}
+
+func listenerIsValid(status gatewayv1.ListenerStatus) bool {
+	return meta.IsStatusConditionTrue(status.Conditions, string(gatewayv1.ListenerConditionAccepted)) &&
+		meta.IsStatusConditionTrue(status.Conditions, string(gatewayv1.ListenerConditionResolvedRefs))
+}
+
+// listAttachedRoutes returns every HTTPRoute with a parentRef to the Gateway,
+// with the exposures it contributes or the reason it contributes none.
+func (g *GatewayController) listAttachedRoutes(ctx context.Context, gateway gatewayv1.Gateway, listenerStatuses []gatewayv1.ListenerStatus) ([]attachedRoute, error) {
+	list := gatewayv1.HTTPRouteList{}
+	if err := g.kubeClient.List(ctx, &list); err != nil {
+		return nil, errors.Wrap(err, "list http routes")
+	}
+
+	var result []attachedRoute
+	for _, route := range list.Items {
+		for _, parentRef := range route.Spec.ParentRefs {
+			if !parentRefTargetsGateway(parentRef, route.Namespace, gateway) {
+				continue
+			}
+			item, err := g.attachRoute(ctx, gateway, listenerStatuses, route, parentRef)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, item)
+			break
+		}
+	}
+	return result, nil
+}
+
+func (g *GatewayController) attachRoute(ctx context.Context, gateway gatewayv1.Gateway, listenerStatuses []gatewayv1.ListenerStatus, route gatewayv1.HTTPRoute, parentRef gatewayv1.ParentReference) (attachedRoute, error) {
+	item := attachedRoute{route: route, parentRef: parentRef}
+
+	var hostnames []string
+	var allowedByAnyListener bool
+	for i, listener := range gateway.Spec.Listeners {
+		if parentRef.SectionName != nil && *parentRef.SectionName != listener.Name {
+			continue
+		}
+		if parentRef.Port != nil && *parentRef.Port != listener.Port {
+			continue
+		}
+		if !listenerIsValid(listenerStatuses[i]) || !listenerAllowsHTTPRoute(listener) {
+			continue
+		}
+		allowed, err := g.listenerAllowsNamespace(ctx, gateway, listener, route.Namespace)
+		if err != nil {
+			return item, err
+		}
+		if !allowed {
+			continue
+		}
+		allowedByAnyListener = true
+
+		listenerHostnames := intersectHostnames(listener.Hostname, route.Spec.Hostnames)
+		if len(listenerHostnames) == 0 {
+			continue
+		}
+		item.listeners = append(item.listeners, listener.Name)
+		for _, hostname := range listenerHostnames {
+			if !slices.Contains(hostnames, hostname) {
+				hostnames = append(hostnames, hostname)
+			}
+		}
+	}
+
+	if !allowedByAnyListener {
+		item.conditionErr = &routeConditionError{
+			conditionType: gatewayv1.RouteConditionAccepted,
+			reason:        gatewayv1.RouteReasonNotAllowedByListeners,
+			message:       "no listener of the gateway allows this route",
+		}
+		return item, nil
+	}
+	if len(item.listeners) == 0 {
+		item.conditionErr = &routeConditionError{
+			conditionType: gatewayv1.RouteConditionAccepted,
+			reason:        gatewayv1.RouteReasonNoMatchingListenerHostname,
+			message:       "no hostname of the route matches a listener hostname",
+		}
+		return item, nil
+	}
+
+	// a deleted route only needs its hostnames to release the DNS records,
+	// its backends may already be gone
+	if route.DeletionTimestamp != nil {
+		for _, hostname := range hostnames {
+			item.exposures = append(item.exposures, exposure.Exposure{Hostname: hostname, IsDeleted: true})
+		}
+		return item, nil
+	}
+
+	if g.hostnamePoliciesEnabled {
+		allowed, err := policyAllowedHostnames(ctx, g.kubeClient, route.Namespace)
+		if err != nil {
+			return item, err
+		}
+		// like an Ingress, a route with a disallowed hostname is rejected
+		// as a whole
+		for _, hostname := range hostnames {
+			if !allowed.Allows(hostname) {
+				item.conditionErr = &routeConditionError{
+					conditionType: gatewayv1.RouteConditionAccepted,
+					reason:        RouteReasonHostnameNotAllowed,
+					message:       fmt.Sprintf("hostname %s is not allowed by the hostname policies of namespace %s", hostname, route.Namespace),
+				}
+				return item, nil
+			}
+		}
+	}
+
+	exposures, err := FromHTTPRouteToExposure(ctx, g.logger, g.kubeClient, route, hostnames, g.clusterDomain)
+	if err != nil {
+		var conditionErr *routeConditionError
+		if errors.As(err, &conditionErr) {
+			item.conditionErr = conditionErr
+			return item, nil
+		}
+		return item, errors.Wrapf(err, "transform http route %s/%s", route.Namespace, route.Name)
+	}
+	allowlist, err := namespaceAllowlist(ctx, g.kubeClient, route.Namespace)
+	if err != nil {
+		return item, err
+	}
+	item.exposures, item.rejected = allowlist.filter(route.Namespace, exposures)
+
+	if err := g.attachRouteFinalizer(ctx, &item.route); err != nil {
+		return item, err
+	}
+	return item, nil
+}
+
+func (g *GatewayController) listenerAllowsNamespace(ctx context.Context, gateway gatewayv1.Gateway, listener gatewayv1.Listener, routeNamespace string) (bool, error) {
+	from := gatewayv1.NamespacesFromSame
+	var selector *metav1.LabelSelector
+	if listener.AllowedRoutes != nil && listener.AllowedRoutes.Namespaces != nil {
+		if listener.AllowedRoutes.Namespaces.From != nil {
+			from = *listener.AllowedRoutes.Namespaces.From
+		}
+		selector = listener.AllowedRoutes.Namespaces.Selector
+	}
+
+	switch from {
+	case gatewayv1.NamespacesFromAll:
+		return true, nil
+	case gatewayv1.NamespacesFromSame:
+		return routeNamespace == gateway.Namespace, nil
+	case gatewayv1.NamespacesFromSelector:
+		if selector == nil {
+			return false, nil
+		}
+		labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+		if err != nil {
+			return false, nil
+		}
+		namespace := v1.Namespace{}
+		if err := g.kubeClient.Get(ctx, types.NamespacedName{Name: routeNamespace}, &namespace); err != nil {
+			return false, errors.Wrapf(err, "fetch namespace %s", routeNamespace)
+		}
+		return labelSelector.Matches(labels.Set(namespace.Labels)), nil
+	default:
+		return false, nil
+	}
+}
+
+func parentRefTargetsGateway(parentRef gatewayv1.ParentReference, routeNamespace string, gateway gatewayv1.Gateway) bool {
+	if parentRef.Group != nil && *parentRef.Group != gatewayv1.GroupName {
+		return false
+	}
+	if parentRef.Kind != nil && *parentRef.Kind != "Gateway" {
+		return false
+	}
+	namespace := routeNamespace
+	if parentRef.Namespace != nil {
+		namespace = string(*parentRef.Namespace)
+	}
+	return namespace == gateway.Namespace && string(parentRef.Name) == gateway.Name
+}
+
+func (g *GatewayController) attachRouteFinalizer(ctx context.Context, route *gatewayv1.HTTPRoute) error {
+	if slices.Contains(route.Finalizers, IngressControllerFinalizer) {
+		return nil
+	}
+	route.Finalizers = append(route.Finalizers, IngressControllerFinalizer)
+	if err := g.kubeClient.Update(ctx, route); err != nil {
+		return errors.Wrapf(err, "attach finalizer for http route %s/%s", route.Namespace, route.Name)
+	}
+	return nil
+}
+
+// releaseRoutes releases the HTTPRoutes of the Gateway that it does not
+// program anymore, once their DNS records are gone. A route is of the Gateway
+// when it references it or still has a status entry for it, routes are the
+// ones attached to it now. The status entry of a detached route is dropped,
+// and the finalizer is kept while another Gateway of this controller still
+// has an entry for the route.
+func (g *GatewayController) releaseRoutes(ctx context.Context, gateway gatewayv1.Gateway, routes []attachedRoute) error {
+	list := gatewayv1.HTTPRouteList{}
+	if err := g.kubeClient.List(ctx, &list); err != nil {
+		return errors.Wrap(err, "list http routes")
+	}
+
+	isGatewayEntry := func(route gatewayv1.HTTPRoute) func(gatewayv1.RouteParentStatus) bool {
+		return func(parent gatewayv1.RouteParentStatus) bool {
+			return string(parent.ControllerName) == g.controllerClassName && parentRefTargetsGateway(parent.ParentRef, route.Namespace, gateway)
+		}
+	}
+	for _, route := range list.Items {
+		if !slices.Contains(route.Finalizers, IngressControllerFinalizer) {
+			continue
+		}
+		attached := slices.IndexFunc(routes, func(item attachedRoute) bool {
+			return item.route.Namespace == route.Namespace && item.route.Name == route.Name
+		})
+		if attached >= 0 && routes[attached].conditionErr == nil && route.DeletionTimestamp == nil {
+			continue
+		}
+		referenced := slices.ContainsFunc(route.Spec.ParentRefs, func(parentRef gatewayv1.ParentReference) bool {
+			return parentRefTargetsGateway(parentRef, route.Namespace, gateway)
+		})
+		if !referenced && !slices.ContainsFunc(route.Status.Parents, isGatewayEntry(route)) {
+			continue
+		}
+
+		// an unattached route keeps no status entry for the Gateway, a
+		// deleted one is about to be gone anyway
+		parents := slices.DeleteFunc(slices.Clone(route.Status.Parents), isGatewayEntry(route))
+		if attached < 0 && route.DeletionTimestamp == nil && len(parents) != len(route.Status.Parents) {
+			route.Status.Parents = parents
+			if err := g.kubeClient.Status().Update(ctx, &route); err != nil {
+				return errors.Wrapf(err, "update http route %s/%s status", route.Namespace, route.Name)
+			}
+		}
+
+		if slices.ContainsFunc(parents, func(parent gatewayv1.RouteParentStatus) bool {
+			return string(parent.ControllerName) == g.controllerClassName
+		}) {
+			continue
+		}
+		if err := g.cleanRouteFinalizer(ctx, route); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *GatewayController) cleanRouteFinalizer(ctx context.Context, route gatewayv1.HTTPRoute) error {
+	if !slices.Contains(route.Finalizers, IngressControllerFinalizer) {
+		return nil
+	}
+	route.Finalizers = slices.DeleteFunc(route.Finalizers, func(f string) bool {
+		return f == IngressControllerFinalizer
+	})
+	if err := g.kubeClient.Update(ctx, &route); err != nil {
+		return errors.Wrapf(err, "clean finalizer for http route %s/%s", route.Namespace, route.Name)
+	}
+	return nil
+}
+
+func (g *GatewayController) updateRouteStatus(ctx context.Context, gateway gatewayv1.Gateway, item attachedRoute, syncErr error) error {
+	route := gatewayv1.HTTPRoute{}
+	if err := g.kubeClient.Get(ctx, client.ObjectKeyFromObject(&item.route), &route); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "fetch http route %s/%s", item.route.Namespace, item.route.Name)
+	}
+
+	accepted := metav1.Condition{
+		Type:               string(gatewayv1.RouteConditionAccepted),
+		Status:             metav1.ConditionTrue,
+		Reason:             string(gatewayv1.RouteReasonAccepted),
+		ObservedGeneration: route.Generation,
+	}
+	resolvedRefs := metav1.Condition{
+		Type:               string(gatewayv1.RouteConditionResolvedRefs),
+		Status:             metav1.ConditionTrue,
+		Reason:             string(gatewayv1.RouteReasonResolvedRefs),
+		ObservedGeneration: route.Generation,
+	}
+	programmed := metav1.Condition{
+		Type:               string(RouteConditionProgrammed),
+		Status:             metav1.ConditionTrue,
+		Reason:             "Programmed",
+		Message:            "cloudflare tunnel config and DNS records are up to date",
+		ObservedGeneration: route.Generation,
+	}
+
+	switch {
+	case item.conditionErr != nil:
+		if item.conditionErr.conditionType == gatewayv1.RouteConditionResolvedRefs {
+			resolvedRefs.Status = metav1.ConditionFalse
+			resolvedRefs.Reason = string(item.conditionErr.reason)
+			resolvedRefs.Message = item.conditionErr.message
+		} else {
+			accepted.Status = metav1.ConditionFalse
+			accepted.Reason = string(item.conditionErr.reason)
+			accepted.Message = item.conditionErr.message
+		}
+		programmed.Status = metav1.ConditionFalse
+		programmed.Reason = "Invalid"
+		programmed.Message = item.conditionErr.message
+	case syncErr != nil:
+		programmed.Status = metav1.ConditionFalse
+		programmed.Reason = "Pending"
+		programmed.Message = syncErr.Error()
+	case item.rejected != nil:
+		programmed.Status = metav1.ConditionFalse
+		programmed.Reason = "Rejected"
+		programmed.Message = item.rejected.Error()
+	}
+
+	newRoute := route.DeepCopy()
+	index := slices.IndexFunc(newRoute.Status.Parents, func(parent gatewayv1.RouteParentStatus) bool {
+		return string(parent.ControllerName) == g.controllerClassName && parentRefTargetsGateway(parent.ParentRef, route.Namespace, gateway)
+	})
+	if index < 0 {
+		newRoute.Status.Parents = append(newRoute.Status.Parents, gatewayv1.RouteParentStatus{
+			ParentRef:      item.parentRef,
+			ControllerName: gatewayv1.GatewayController(g.controllerClassName),
+		})
+		index = len(newRoute.Status.Parents) - 1
+	}
+	parent := &newRoute.Status.Parents[index]
+	parent.ParentRef = item.parentRef
+	meta.SetStatusCondition(&parent.Conditions, accepted)
+	meta.SetStatusCondition(&parent.Conditions, resolvedRefs)
+	meta.SetStatusCondition(&parent.Conditions, programmed)
+
+	if equality.Semantic.DeepEqual(route.Status, newRoute.Status) {
+		return nil
+	}
+	if err := g.kubeClient.Status().Update(ctx, newRoute); err != nil {
+		return errors.Wrapf(err, "update http route %s/%s status", route.Namespace, route.Name)
+	}
+	return nil
+}
+
+func (g *GatewayController) updateGatewayStatus(ctx context.Context, gateway gatewayv1.Gateway, tunnelDomain string, listenerStatuses []gatewayv1.ListenerStatus, routes []attachedRoute, syncErr error) error {
+	newGateway := gateway.DeepCopy()
+
+	newGateway.Status.Addresses = []gatewayv1.GatewayStatusAddress{{
+		Type:  ptr.To(gatewayv1.HostnameAddressType),
+		Value: tunnelDomain,
+	}}
+
+	validListeners := 0
+	for i := range listenerStatuses {
+		status := &listenerStatuses[i]
+		for _, item := range routes {
+			if item.conditionErr == nil && item.route.DeletionTimestamp == nil && slices.Contains(item.listeners, status.Name) {
+				status.AttachedRoutes++
+			}
+		}
+
+		programmed := metav1.Condition{
+			Type:               string(gatewayv1.ListenerConditionProgrammed),
+			Status:             metav1.ConditionTrue,
+			Reason:             string(gatewayv1.ListenerReasonProgrammed),
+			ObservedGeneration: gateway.Generation,
+		}
+		if !listenerIsValid(*status) {
+			programmed.Status = metav1.ConditionFalse
+			programmed.Reason = string(gatewayv1.ListenerReasonInvalid)
+		} else {
+			validListeners++
+			if syncErr != nil {
+				programmed.Status = metav1.ConditionFalse
+				programmed.Reason = string(gatewayv1.ListenerReasonPending)
+				programmed.Message = syncErr.Error()
+			}
+		}
+		// keep the transition times of conditions that did not change
+		if index := slices.IndexFunc(gateway.Status.Listeners, func(s gatewayv1.ListenerStatus) bool { return s.Name == status.Name }); index >= 0 {
+			conditions := slices.Clone(gateway.Status.Listeners[index].Conditions)
+			for _, condition := range status.Conditions {
+				meta.SetStatusCondition(&conditions, condition)
+			}
+			status.Conditions = conditions
+		}
+		meta.SetStatusCondition(&status.Conditions, programmed)
+	}
+	newGateway.Status.Listeners = listenerStatuses
+
+	accepted := metav1.Condition{
+		Type:               string(gatewayv1.GatewayConditionAccepted),
+		Status:             metav1.ConditionTrue,
+		Reason:             string(gatewayv1.GatewayReasonAccepted),
+		ObservedGeneration: gateway.Generation,
+	}
+	if validListeners < len(listenerStatuses) {
+		accepted.Reason = string(gatewayv1.GatewayReasonListenersNotValid)
+		accepted.Message = "some listeners are not valid, see the listener conditions"
+		if validListeners == 0 {
+			accepted.Status = metav1.ConditionFalse
+		}
+	}
+	meta.SetStatusCondition(&newGateway.Status.Conditions, accepted)
+
+	programmed := metav1.Condition{
+		Type:               string(gatewayv1.GatewayConditionProgrammed),
+		Status:             metav1.ConditionTrue,
+		Reason:             string(gatewayv1.GatewayReasonProgrammed),
+		Message:            "cloudflare tunnel config and DNS records are up to date",
+		ObservedGeneration: gateway.Generation,
+	}
+	if syncErr != nil {
+		programmed.Status = metav1.ConditionFalse
+		programmed.Reason = string(gatewayv1.GatewayReasonPending)
+		programmed.Message = syncErr.Error()
+	}
+	meta.SetStatusCondition(&newGateway.Status.Conditions, programmed)
+
+	if equality.Semantic.DeepEqual(gateway.Status, newGateway.Status) {
+		return nil
+	}
+	if err := g.kubeClient.Status().Update(ctx, newGateway); err != nil {
+		return errors.Wrapf(err, "update gateway %s/%s status", gateway.Namespace, gateway.Name)
+	}
+	return nil
+}
+
+// gatewaysForRoute maps an HTTPRoute to the Gateways it references or has a
+// status entry for, so route changes are reconciled through their parents and
+// a Gateway the route was detached from releases it.
+func gatewaysForRoute(_ context.Context, object client.Object) []reconcile.Request {
+	route, ok := object.(*gatewayv1.HTTPRoute)
+	if !ok {
+		return nil
+	}
+	parentRefs := slices.Clone(route.Spec.ParentRefs)
+	for _, parent := range route.Status.Parents {
+		parentRefs = append(parentRefs, parent.ParentRef)
+	}
+	var result []reconcile.Request
+	for _, parentRef := range parentRefs {
+		if parentRef.Group != nil && *parentRef.Group != gatewayv1.GroupName {
+			continue
+		}
+		if parentRef.Kind != nil && *parentRef.Kind != "Gateway" {
+			continue
+		}
+		namespace := route.Namespace
+		if parentRef.Namespace != nil {
+			namespace = string(*parentRef.Namespace)
+		}
+		request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: string(parentRef.Name)}}
+		if !slices.Contains(result, request) {
+			result = append(result, request)
+		}
+	}
+	return result
+}
+
+// gatewaysForGatewayClass maps a GatewayClass to the Gateways of the class, so
+// they are picked up once the class is handed over to this controller.
+func gatewaysForGatewayClass(kubeClient client.Client) handler.MapFunc {
+	return func(ctx context.Context, object client.Object) []reconcile.Request {
+		list := gatewayv1.GatewayList{}
+		if err := kubeClient.List(ctx, &list); err != nil {
+			return nil
+		}
+		var result []reconcile.Request
+		for _, gateway := range list.Items {
+			if string(gateway.Spec.GatewayClassName) == object.GetName() {
+				result = append(result, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&gateway)})
+			}
+		}
+		return result
+	}
+}
+
+// allGateways maps an object to every Gateway, for the cluster wide objects
+// whose change may affect the routes of any of them.
+func allGateways(kubeClient client.Client) handler.MapFunc {
+	return func(ctx context.Context, _ client.Object) []reconcile.Request {
+		list := gatewayv1.GatewayList{}
+		if err := kubeClient.List(ctx, &list); err != nil {
+			return nil
+		}
+		result := make([]reconcile.Request, 0, len(list.Items))
+		for _, gateway := range list.Items {
+			result = append(result, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&gateway)})
+		}
+		return result
+	}
+}
@@ -0,0 +1,473 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/apis/v1alpha1"
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+const testControllerClass = "strrl.dev/cloudflare-tunnel-ingress-controller"
+
+type fakeGatewayTunnelClient struct {
+	name      string
+	exposures []exposure.Exposure
+	puts      int
+	drift     cloudflarecontroller.Drift
+	planned   []exposure.Exposure
+	putErr    error
+}
+
+func (f *fakeGatewayTunnelClient) PutExposures(_ context.Context, exposures []exposure.Exposure) error {
+	var partial *cloudflarecontroller.PartialSyncError
+	if f.putErr != nil && !errors.As(f.putErr, &partial) {
+		return f.putErr
+	}
+	f.puts++
+	f.exposures = exposures
+	return f.putErr
+}
+
+func (f *fakeGatewayTunnelClient) DetectDrift(_ context.Context, _ []exposure.Exposure) (cloudflarecontroller.Drift, error) {
+	return f.drift, nil
+}
+
+func (f *fakeGatewayTunnelClient) Plan(_ context.Context, exposures []exposure.Exposure) (cloudflarecontroller.Plan, error) {
+	f.planned = exposures
+	return cloudflarecontroller.Plan{TunnelID: f.name, TunnelName: f.name}, nil
+}
+
+func (f *fakeGatewayTunnelClient) TunnelDomain() string {
+	return f.name + ".cfargotunnel.com"
+}
+
+func (f *fakeGatewayTunnelClient) FetchTunnelToken(_ context.Context) (string, error) {
+	return "token-" + f.name, nil
+}
+
+func newGatewayTestClient(t *testing.T, objects ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, gatewayv1.Install(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objects...).
+		WithStatusSubresource(&gatewayv1.GatewayClass{}, &gatewayv1.Gateway{}, &gatewayv1.HTTPRoute{}).
+		Build()
+}
+
+func newTestGatewayController(kubeClient client.Client, tunnels map[string]*fakeGatewayTunnelClient) *GatewayController {
+	factory := func(_ context.Context, tunnelName string) (cloudflarecontroller.TunnelClientInterface, error) {
+		tunnel := &fakeGatewayTunnelClient{name: tunnelName}
+		tunnels[tunnelName] = tunnel
+		return tunnel, nil
+	}
+	return NewGatewayController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), testControllerClass, "cluster.local", "main", "cloudflare", CloudflaredConfig{
+		Image:    "cloudflared:test",
+		Replicas: 1,
+		Protocol: "auto",
+	}, factory, HostnameConflictPolicyFirstOwner, false)
+}
+
+func testGatewayFixtures() []client.Object {
+	return []client.Object{
+		&gatewayv1.GatewayClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "cloudflare-tunnel"},
+			Spec:       gatewayv1.GatewayClassSpec{ControllerName: testControllerClass},
+		},
+		&gatewayv1.Gateway{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+			Spec: gatewayv1.GatewaySpec{
+				GatewayClassName: "cloudflare-tunnel",
+				Listeners: []gatewayv1.Listener{
+					{Name: "http", Protocol: gatewayv1.HTTPProtocolType, Port: 80, Hostname: ptr.To(gatewayv1.Hostname("*.example.com"))},
+					{Name: "tcp", Protocol: gatewayv1.TCPProtocolType, Port: 22},
+				},
+			},
+		},
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+			Spec:       v1.ServiceSpec{ClusterIP: "10.0.0.1", Ports: []v1.ServicePort{{Port: 80}}},
+		},
+		&gatewayv1.HTTPRoute{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+			Spec: gatewayv1.HTTPRouteSpec{
+				CommonRouteSpec: gatewayv1.CommonRouteSpec{ParentRefs: []gatewayv1.ParentReference{{Name: "web"}}},
+				Hostnames:       []gatewayv1.Hostname{"app.example.com"},
+				Rules: []gatewayv1.HTTPRouteRule{{
+					BackendRefs: []gatewayv1.HTTPBackendRef{{BackendRef: gatewayv1.BackendRef{
+						BackendObjectReference: gatewayv1.BackendObjectReference{Name: "app", Port: ptr.To(gatewayv1.PortNumber(80))},
+					}}},
+				}},
+			},
+		},
+		&gatewayv1.HTTPRoute{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "broken"},
+			Spec: gatewayv1.HTTPRouteSpec{
+				CommonRouteSpec: gatewayv1.CommonRouteSpec{ParentRefs: []gatewayv1.ParentReference{{Name: "web"}}},
+				Hostnames:       []gatewayv1.Hostname{"broken.example.com"},
+				Rules: []gatewayv1.HTTPRouteRule{{
+					BackendRefs: []gatewayv1.HTTPBackendRef{{BackendRef: gatewayv1.BackendRef{
+						BackendObjectReference: gatewayv1.BackendObjectReference{Name: "missing", Port: ptr.To(gatewayv1.PortNumber(80))},
+					}}},
+				}},
+			},
+		},
+	}
+}
+
+func TestGatewayClassControllerAcceptsMatchingClass(t *testing.T) {
+	kubeClient := newGatewayTestClient(t,
+		&gatewayv1.GatewayClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "ours"},
+			Spec:       gatewayv1.GatewayClassSpec{ControllerName: testControllerClass},
+		},
+		&gatewayv1.GatewayClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "theirs"},
+			Spec:       gatewayv1.GatewayClassSpec{ControllerName: "example.com/other"},
+		},
+	)
+	controller := NewGatewayClassController(logr.Discard(), kubeClient, testControllerClass)
+
+	for _, name := range []string{"ours", "theirs"} {
+		_, err := controller.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: name}})
+		require.NoError(t, err)
+	}
+
+	ours := gatewayv1.GatewayClass{}
+	require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Name: "ours"}, &ours))
+	assert.True(t, meta.IsStatusConditionTrue(ours.Status.Conditions, string(gatewayv1.GatewayClassConditionStatusAccepted)))
+
+	theirs := gatewayv1.GatewayClass{}
+	require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Name: "theirs"}, &theirs))
+	assert.Nil(t, meta.FindStatusCondition(theirs.Status.Conditions, string(gatewayv1.GatewayClassConditionStatusAccepted)))
+}
+
+func TestGatewayControllerReconcile(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := newGatewayTestClient(t, testGatewayFixtures()...)
+	tunnels := map[string]*fakeGatewayTunnelClient{}
+	controller := newTestGatewayController(kubeClient, tunnels)
+
+	gatewayKey := types.NamespacedName{Namespace: "default", Name: "web"}
+	_, err := controller.Reconcile(ctx, reconcile.Request{NamespacedName: gatewayKey})
+	require.NoError(t, err)
+
+	// one tunnel per gateway, named after the gateway
+	tunnel, ok := tunnels["main-default-web"]
+	require.True(t, ok, "expected the tunnel main-default-web to be bootstrapped, got %v", tunnels)
+
+	// the broken route is isolated, only the valid one is exposed
+	require.Len(t, tunnel.exposures, 1)
+	assert.Equal(t, "app.example.com", tunnel.exposures[0].Hostname)
+	assert.Equal(t, "http://app.default.svc.cluster.local:80", tunnel.exposures[0].ServiceTarget)
+
+	gateway := gatewayv1.Gateway{}
+	require.NoError(t, kubeClient.Get(ctx, gatewayKey, &gateway))
+	assert.Contains(t, gateway.Finalizers, IngressControllerFinalizer)
+	require.Len(t, gateway.Status.Addresses, 1)
+	assert.Equal(t, "main-default-web.cfargotunnel.com", gateway.Status.Addresses[0].Value)
+
+	accepted := meta.FindStatusCondition(gateway.Status.Conditions, string(gatewayv1.GatewayConditionAccepted))
+	require.NotNil(t, accepted)
+	assert.Equal(t, metav1.ConditionTrue, accepted.Status)
+	assert.Equal(t, string(gatewayv1.GatewayReasonListenersNotValid), accepted.Reason)
+	assert.True(t, meta.IsStatusConditionTrue(gateway.Status.Conditions, string(gatewayv1.GatewayConditionProgrammed)))
+
+	require.Len(t, gateway.Status.Listeners, 2)
+	assert.Equal(t, int32(1), gateway.Status.Listeners[0].AttachedRoutes)
+	tcpAccepted := meta.FindStatusCondition(gateway.Status.Listeners[1].Conditions, string(gatewayv1.ListenerConditionAccepted))
+	require.NotNil(t, tcpAccepted)
+	assert.Equal(t, string(gatewayv1.ListenerReasonUnsupportedProtocol), tcpAccepted.Reason)
+
+	app := gatewayv1.HTTPRoute{}
+	require.NoError(t, kubeClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: "app"}, &app))
+	require.Len(t, app.Status.Parents, 1)
+	assert.Equal(t, gatewayv1.GatewayController(testControllerClass), app.Status.Parents[0].ControllerName)
+	assert.True(t, meta.IsStatusConditionTrue(app.Status.Parents[0].Conditions, string(gatewayv1.RouteConditionAccepted)))
+	assert.True(t, meta.IsStatusConditionTrue(app.Status.Parents[0].Conditions, string(RouteConditionProgrammed)))
+
+	broken := gatewayv1.HTTPRoute{}
+	require.NoError(t, kubeClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: "broken"}, &broken))
+	require.Len(t, broken.Status.Parents, 1)
+	resolvedRefs := meta.FindStatusCondition(broken.Status.Parents[0].Conditions, string(gatewayv1.RouteConditionResolvedRefs))
+	require.NotNil(t, resolvedRefs)
+	assert.Equal(t, metav1.ConditionFalse, resolvedRefs.Status)
+	assert.Equal(t, string(gatewayv1.RouteReasonBackendNotFound), resolvedRefs.Reason)
+	assert.False(t, meta.IsStatusConditionTrue(broken.Status.Parents[0].Conditions, string(RouteConditionProgrammed)))
+
+	connector := appsv1.Deployment{}
+	require.NoError(t, kubeClient.Get(ctx, types.NamespacedName{Namespace: "cloudflare", Name: "cloudflared-default-web"}, &connector))
+}
+
+func TestGatewayControllerReleasesDetachedRoutes(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := newGatewayTestClient(t, testGatewayFixtures()...)
+	tunnels := map[string]*fakeGatewayTunnelClient{}
+	controller := newTestGatewayController(kubeClient, tunnels)
+
+	gatewayKey := types.NamespacedName{Namespace: "default", Name: "web"}
+	appKey := types.NamespacedName{Namespace: "default", Name: "app"}
+	_, err := controller.Reconcile(ctx, reconcile.Request{NamespacedName: gatewayKey})
+	require.NoError(t, err)
+
+	// a hostname no listener matches keeps the status entry but not the records
+	app := gatewayv1.HTTPRoute{}
+	require.NoError(t, kubeClient.Get(ctx, appKey, &app))
+	require.Contains(t, app.Finalizers, IngressControllerFinalizer)
+	app.Spec.Hostnames = []gatewayv1.Hostname{"app.example.org"}
+	require.NoError(t, kubeClient.Update(ctx, &app))
+	_, err = controller.Reconcile(ctx, reconcile.Request{NamespacedName: gatewayKey})
+	require.NoError(t, err)
+
+	assert.Empty(t, tunnels["main-default-web"].exposures)
+	require.NoError(t, kubeClient.Get(ctx, appKey, &app))
+	assert.NotContains(t, app.Finalizers, IngressControllerFinalizer)
+	require.Len(t, app.Status.Parents, 1)
+	accepted := meta.FindStatusCondition(app.Status.Parents[0].Conditions, string(gatewayv1.RouteConditionAccepted))
+	require.NotNil(t, accepted)
+	assert.Equal(t, string(gatewayv1.RouteReasonNoMatchingListenerHostname), accepted.Reason)
+
+	// moving the route to another gateway drops the status entry as well
+	app.Spec.Hostnames = []gatewayv1.Hostname{"app.example.com"}
+	require.NoError(t, kubeClient.Update(ctx, &app))
+	_, err = controller.Reconcile(ctx, reconcile.Request{NamespacedName: gatewayKey})
+	require.NoError(t, err)
+	require.NoError(t, kubeClient.Get(ctx, appKey, &app))
+	require.Contains(t, app.Finalizers, IngressControllerFinalizer)
+	assert.Equal(t, []reconcile.Request{{NamespacedName: gatewayKey}}, gatewaysForRoute(ctx, &app))
+
+	app.Spec.ParentRefs = []gatewayv1.ParentReference{{Name: "other"}}
+	require.NoError(t, kubeClient.Update(ctx, &app))
+	requests := gatewaysForRoute(ctx, &app)
+	assert.Contains(t, requests, reconcile.Request{NamespacedName: gatewayKey}, "the former gateway is reconciled through the status entry")
+	_, err = controller.Reconcile(ctx, reconcile.Request{NamespacedName: gatewayKey})
+	require.NoError(t, err)
+
+	assert.Empty(t, tunnels["main-default-web"].exposures)
+	require.NoError(t, kubeClient.Get(ctx, appKey, &app))
+	assert.NotContains(t, app.Finalizers, IngressControllerFinalizer)
+	assert.Empty(t, app.Status.Parents)
+}
+
+func TestGatewayControllerDeletion(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := newGatewayTestClient(t, testGatewayFixtures()...)
+	tunnels := map[string]*fakeGatewayTunnelClient{}
+	controller := newTestGatewayController(kubeClient, tunnels)
+
+	gatewayKey := types.NamespacedName{Namespace: "default", Name: "web"}
+	_, err := controller.Reconcile(ctx, reconcile.Request{NamespacedName: gatewayKey})
+	require.NoError(t, err)
+
+	gateway := gatewayv1.Gateway{}
+	require.NoError(t, kubeClient.Get(ctx, gatewayKey, &gateway))
+	require.NoError(t, kubeClient.Delete(ctx, &gateway))
+
+	_, err = controller.Reconcile(ctx, reconcile.Request{NamespacedName: gatewayKey})
+	require.NoError(t, err)
+
+	tunnel := tunnels["main-default-web"]
+	require.Len(t, tunnel.exposures, 1)
+	assert.Equal(t, "app.example.com", tunnel.exposures[0].Hostname)
+	assert.True(t, tunnel.exposures[0].IsDeleted)
+
+	err = kubeClient.Get(ctx, gatewayKey, &gateway)
+	assert.True(t, client.IgnoreNotFound(err) == nil && err != nil, "expected the gateway to be gone once the finalizer is released, got %v", err)
+
+	connector := appsv1.Deployment{}
+	err = kubeClient.Get(ctx, types.NamespacedName{Namespace: "cloudflare", Name: "cloudflared-default-web"}, &connector)
+	assert.True(t, client.IgnoreNotFound(err) == nil && err != nil, "expected the connector to be deleted, got %v", err)
+
+	app := gatewayv1.HTTPRoute{}
+	require.NoError(t, kubeClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: "app"}, &app))
+	assert.NotContains(t, app.Finalizers, IngressControllerFinalizer)
+}
+
+func TestGatewayControllerDeletionAfterClassIsGone(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := newGatewayTestClient(t, testGatewayFixtures()...)
+	tunnels := map[string]*fakeGatewayTunnelClient{}
+	controller := newTestGatewayController(kubeClient, tunnels)
+
+	gatewayKey := types.NamespacedName{Namespace: "default", Name: "web"}
+	_, err := controller.Reconcile(ctx, reconcile.Request{NamespacedName: gatewayKey})
+	require.NoError(t, err)
+	app := gatewayv1.HTTPRoute{}
+	require.NoError(t, kubeClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: "app"}, &app))
+	require.Contains(t, app.Finalizers, IngressControllerFinalizer)
+
+	gatewayClass := gatewayv1.GatewayClass{}
+	require.NoError(t, kubeClient.Get(ctx, types.NamespacedName{Name: "cloudflare-tunnel"}, &gatewayClass))
+	require.NoError(t, kubeClient.Delete(ctx, &gatewayClass))
+	gateway := gatewayv1.Gateway{}
+	require.NoError(t, kubeClient.Get(ctx, gatewayKey, &gateway))
+	require.NoError(t, kubeClient.Delete(ctx, &gateway))
+
+	_, err = controller.Reconcile(ctx, reconcile.Request{NamespacedName: gatewayKey})
+	require.NoError(t, err)
+
+	tunnel := tunnels["main-default-web"]
+	require.Len(t, tunnel.exposures, 1)
+	assert.True(t, tunnel.exposures[0].IsDeleted)
+	err = kubeClient.Get(ctx, gatewayKey, &gateway)
+	assert.True(t, client.IgnoreNotFound(err) == nil && err != nil, "expected the gateway to be gone once the finalizer is released, got %v", err)
+
+	// the routes and the connector are released without the class as well
+	require.NoError(t, kubeClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: "app"}, &app))
+	assert.NotContains(t, app.Finalizers, IngressControllerFinalizer)
+	connector := appsv1.Deployment{}
+	err = kubeClient.Get(ctx, types.NamespacedName{Namespace: "cloudflare", Name: "cloudflared-default-web"}, &connector)
+	assert.True(t, client.IgnoreNotFound(err) == nil && err != nil, "expected the connector to be deleted, got %v", err)
+}
+
+func TestGatewayControllerRejectsHostnamesNotAllowed(t *testing.T) {
+	ctx := context.Background()
+	objects := append(testTeamBRouteFixtures("shop.team-b.example.com", "shop.example.com"),
+		&v1alpha1.HostnamePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec: v1alpha1.HostnamePolicySpec{
+				Namespaces:            []string{"default"},
+				AllowedDomainSuffixes: []string{"example.com"},
+			},
+		},
+		&v1alpha1.HostnamePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "team-b"},
+			Spec: v1alpha1.HostnamePolicySpec{
+				Namespaces:            []string{"team-b"},
+				AllowedDomainSuffixes: []string{"team-b.example.com"},
+			},
+		},
+	)
+	kubeClient := newGatewayTestClient(t, objects...)
+	tunnels := map[string]*fakeGatewayTunnelClient{}
+	controller := newTestGatewayController(kubeClient, tunnels)
+	controller.hostnamePoliciesEnabled = true
+
+	gatewayKey := types.NamespacedName{Namespace: "default", Name: "web"}
+	_, err := controller.Reconcile(ctx, reconcile.Request{NamespacedName: gatewayKey})
+	require.NoError(t, err)
+
+	// the route of team-b claims a hostname outside of its policy, it is
+	// rejected as a whole
+	tunnel := tunnels["main-default-web"]
+	require.Len(t, tunnel.exposures, 1)
+	assert.Equal(t, "app.example.com", tunnel.exposures[0].Hostname)
+
+	shop := gatewayv1.HTTPRoute{}
+	require.NoError(t, kubeClient.Get(ctx, types.NamespacedName{Namespace: "team-b", Name: "shop"}, &shop))
+	assert.NotContains(t, shop.Finalizers, IngressControllerFinalizer)
+	require.Len(t, shop.Status.Parents, 1)
+	accepted := meta.FindStatusCondition(shop.Status.Parents[0].Conditions, string(gatewayv1.RouteConditionAccepted))
+	require.NotNil(t, accepted)
+	assert.Equal(t, metav1.ConditionFalse, accepted.Status)
+	assert.Equal(t, string(RouteReasonHostnameNotAllowed), accepted.Reason)
+	assert.Equal(t, "hostname shop.example.com is not allowed by the hostname policies of namespace team-b", accepted.Message)
+	assert.False(t, meta.IsStatusConditionTrue(shop.Status.Parents[0].Conditions, string(RouteConditionProgrammed)))
+
+	gateway := gatewayv1.Gateway{}
+	require.NoError(t, kubeClient.Get(ctx, gatewayKey, &gateway))
+	assert.Equal(t, int32(1), gateway.Status.Listeners[0].AttachedRoutes)
+}
+
+func TestGatewayControllerResolvesHostnameClaims(t *testing.T) {
+	ctx := context.Background()
+	objects := append(testTeamBRouteFixtures("shop.example.com", "admin.example.com", "app.example.com"),
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "team-b",
+			Annotations: map[string]string{AnnotationAllowedHostnames: "shop.example.com, app.example.com"},
+		}},
+	)
+	kubeClient := newGatewayTestClient(t, objects...)
+	tunnels := map[string]*fakeGatewayTunnelClient{}
+	controller := newTestGatewayController(kubeClient, tunnels)
+
+	gatewayKey := types.NamespacedName{Namespace: "default", Name: "web"}
+	_, err := controller.Reconcile(ctx, reconcile.Request{NamespacedName: gatewayKey})
+	require.NoError(t, err)
+
+	// the allowlist of team-b drops admin, and default/app created first
+	// keeps app
+	var hostnames []string
+	for _, item := range tunnels["main-default-web"].exposures {
+		hostnames = append(hostnames, item.Hostname)
+	}
+	assert.ElementsMatch(t, []string{"app.example.com", "shop.example.com"}, hostnames)
+
+	app := gatewayv1.HTTPRoute{}
+	require.NoError(t, kubeClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: "app"}, &app))
+	assert.True(t, meta.IsStatusConditionTrue(app.Status.Parents[0].Conditions, string(RouteConditionProgrammed)))
+
+	shop := gatewayv1.HTTPRoute{}
+	require.NoError(t, kubeClient.Get(ctx, types.NamespacedName{Namespace: "team-b", Name: "shop"}, &shop))
+	require.Len(t, shop.Status.Parents, 1)
+	assert.True(t, meta.IsStatusConditionTrue(shop.Status.Parents[0].Conditions, string(gatewayv1.RouteConditionAccepted)))
+	programmed := meta.FindStatusCondition(shop.Status.Parents[0].Conditions, string(RouteConditionProgrammed))
+	require.NotNil(t, programmed)
+	assert.Equal(t, metav1.ConditionFalse, programmed.Status)
+	assert.Equal(t, "Rejected", programmed.Reason)
+	assert.Contains(t, programmed.Message, "admin.example.com not allowed in namespace team-b by annotation "+AnnotationAllowedHostnames)
+	assert.Contains(t, programmed.Message, "app.example.com is already claimed by httproute default/app")
+}
+
+// testTeamBRouteFixtures returns the gateway fixtures with the routes of every
+// namespace allowed, and a route of the team-b namespace with the hostnames.
+func testTeamBRouteFixtures(hostnames ...gatewayv1.Hostname) []client.Object {
+	objects := testGatewayFixtures()
+	gateway := objects[1].(*gatewayv1.Gateway)
+	gateway.Spec.Listeners[0].AllowedRoutes = &gatewayv1.AllowedRoutes{
+		Namespaces: &gatewayv1.RouteNamespaces{From: ptr.To(gatewayv1.NamespacesFromAll)},
+	}
+	return append(objects,
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "shop"},
+			Spec:       v1.ServiceSpec{ClusterIP: "10.0.0.2", Ports: []v1.ServicePort{{Port: 80}}},
+		},
+		&gatewayv1.HTTPRoute{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "shop"},
+			Spec: gatewayv1.HTTPRouteSpec{
+				CommonRouteSpec: gatewayv1.CommonRouteSpec{ParentRefs: []gatewayv1.ParentReference{{Name: "web", Namespace: ptr.To(gatewayv1.Namespace("default"))}}},
+				Hostnames:       hostnames,
+				Rules: []gatewayv1.HTTPRouteRule{{
+					BackendRefs: []gatewayv1.HTTPBackendRef{{BackendRef: gatewayv1.BackendRef{
+						BackendObjectReference: gatewayv1.BackendObjectReference{Name: "shop", Port: ptr.To(gatewayv1.PortNumber(80))},
+					}}},
+				}},
+			},
+		},
+	)
+}
+
+func TestGatewayConnectorNameIsShortened(t *testing.T) {
+	gateway := gatewayv1.Gateway{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "a-very-long-namespace-name-for-testing",
+		Name:      "a-very-long-gateway-name-for-testing",
+	}}
+	name := gatewayConnectorName(gateway)
+	assert.LessOrEqual(t, len(name), 63)
+	assert.NotEqual(t, name, gatewayConnectorName(gatewayv1.Gateway{ObjectMeta: metav1.ObjectMeta{
+		Namespace: gateway.Namespace,
+		Name:      gateway.Name + "-2",
+	}}))
+}
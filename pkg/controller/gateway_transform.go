@@ -0,0 +1,253 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+// RouteConditionProgrammed reports whether the route has been pushed to the
+// Cloudflare tunnel of its parent Gateway. Gateway API only defines it for
+// Gateways and listeners, routes carry it as an implementation specific
+// condition.
+const RouteConditionProgrammed gatewayv1.RouteConditionType = "Programmed"
+
+// RouteReasonHostnameNotAllowed rejects a route with a hostname the
+// HostnamePolicies of its namespace do not allow.
+const RouteReasonHostnameNotAllowed gatewayv1.RouteConditionReason = "HostnameNotAllowed"
+
+// routeConditionError is a transform failure that maps onto a route status
+// condition, it is reported on the route instead of as a warning event.
+type routeConditionError struct {
+	conditionType gatewayv1.RouteConditionType
+	reason        gatewayv1.RouteConditionReason
+	message       string
+}
+
+func (e *routeConditionError) Error() string {
+	return e.message
+}
+
+func unsupportedRouteValue(format string, args ...any) error {
+	return &routeConditionError{
+		conditionType: gatewayv1.RouteConditionAccepted,
+		reason:        gatewayv1.RouteReasonUnsupportedValue,
+		message:       fmt.Sprintf(format, args...),
+	}
+}
+
+func unresolvedRouteRef(reason gatewayv1.RouteConditionReason, format string, args ...any) error {
+	return &routeConditionError{
+		conditionType: gatewayv1.RouteConditionResolvedRefs,
+		reason:        reason,
+		message:       fmt.Sprintf(format, args...),
+	}
+}
+
+// FromHTTPRouteToExposure transforms the rules of an HTTPRoute into exposures
+// for the given hostnames, which are the route hostnames already intersected
+// with the hostnames of the listeners the route attaches to.
+//
+// A Cloudflare tunnel ingress rule can only match hostname and path, so header,
+// query parameter and method matches, filters, and traffic splitting across
+// several backends are rejected instead of being silently dropped.
+func FromHTTPRouteToExposure(ctx context.Context, logger logr.Logger, kubeClient client.Client, route gatewayv1.HTTPRoute, hostnames []string, clusterDomain string) ([]exposure.Exposure, error) {
+	if len(hostnames) == 0 {
+		return nil, unsupportedRouteValue("route %s/%s has no hostname, cloudflare tunnel rules always match a hostname, set spec.hostnames on the route or a hostname on the listener", route.Namespace, route.Name)
+	}
+
+	isDeleted := route.DeletionTimestamp != nil
+
+	var result []exposure.Exposure
+	for ruleIndex, rule := range route.Spec.Rules {
+		if len(rule.Filters) > 0 {
+			return nil, unsupportedRouteValue("rule %d: filter %s is not supported, cloudflare tunnel rules can only match hostname and path", ruleIndex, rule.Filters[0].Type)
+		}
+
+		serviceTarget, err := resolveHTTPRouteBackend(ctx, kubeClient, route, ruleIndex, rule.BackendRefs, clusterDomain)
+		if err != nil {
+			return nil, err
+		}
+
+		paths, err := pathsFromHTTPRouteMatches(ruleIndex, rule.Matches)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, hostname := range hostnames {
+			for _, path := range paths {
+				result = append(result, exposure.Exposure{
+					Hostname:      hostname,
+					ServiceTarget: serviceTarget,
+					PathPrefix:    path,
+					IsDeleted:     isDeleted,
+				})
+			}
+		}
+	}
+
+	logger.V(3).Info("transformed http route", "route", fmt.Sprintf("%s/%s", route.Namespace, route.Name), "exposures", result)
+	return result, nil
+}
+
+// pathsFromHTTPRouteMatches returns the tunnel rule path of every match,
+// cloudflared treats the rule path as a regular expression.
+func pathsFromHTTPRouteMatches(ruleIndex int, matches []gatewayv1.HTTPRouteMatch) ([]string, error) {
+	// a rule without matches matches every request, like a "/" prefix
+	if len(matches) == 0 {
+		return []string{"/"}, nil
+	}
+
+	var result []string
+	for _, match := range matches {
+		if len(match.Headers) > 0 || len(match.QueryParams) > 0 || match.Method != nil {
+			return nil, unsupportedRouteValue("rule %d: header, query parameter and method matches are not supported, cloudflare tunnel rules can only match hostname and path", ruleIndex)
+		}
+
+		if match.Path == nil {
+			result = append(result, "/")
+			continue
+		}
+
+		matchType := gatewayv1.PathMatchPathPrefix
+		if match.Path.Type != nil {
+			matchType = *match.Path.Type
+		}
+		value := "/"
+		if match.Path.Value != nil {
+			value = *match.Path.Value
+		}
+
+		switch matchType {
+		case gatewayv1.PathMatchPathPrefix:
+			result = append(result, cloudflarecontroller.PrefixPathRegex(value))
+		case gatewayv1.PathMatchExact:
+			result = append(result, cloudflarecontroller.ExactPathRegex(value))
+		case gatewayv1.PathMatchRegularExpression:
+			if _, err := regexp.Compile(value); err != nil {
+				return nil, unsupportedRouteValue("rule %d: invalid regular expression %q: %s", ruleIndex, value, err)
+			}
+			result = append(result, value)
+		default:
+			return nil, unsupportedRouteValue("rule %d: path match type %s is not supported", ruleIndex, matchType)
+		}
+	}
+	return result, nil
+}
+
+// resolveHTTPRouteBackend resolves the single Service backend of a rule into
+// the service target of the tunnel rule.
+func resolveHTTPRouteBackend(ctx context.Context, kubeClient client.Client, route gatewayv1.HTTPRoute, ruleIndex int, backendRefs []gatewayv1.HTTPBackendRef, clusterDomain string) (string, error) {
+	if len(backendRefs) == 0 {
+		return "", unsupportedRouteValue("rule %d has no backendRefs", ruleIndex)
+	}
+	// a tunnel rule points at exactly one origin, weighted traffic splitting
+	// has no equivalent
+	if len(backendRefs) > 1 {
+		return "", unsupportedRouteValue("rule %d: multiple backendRefs are not supported, a cloudflare tunnel rule has exactly one origin", ruleIndex)
+	}
+
+	backendRef := backendRefs[0]
+	if len(backendRef.Filters) > 0 {
+		return "", unsupportedRouteValue("rule %d: backendRef filters are not supported", ruleIndex)
+	}
+
+	if backendRef.Group != nil && *backendRef.Group != "" {
+		return "", unresolvedRouteRef(gatewayv1.RouteReasonInvalidKind, "rule %d: backendRef group %s is not supported, only core Services are", ruleIndex, *backendRef.Group)
+	}
+	if backendRef.Kind != nil && *backendRef.Kind != "Service" {
+		return "", unresolvedRouteRef(gatewayv1.RouteReasonInvalidKind, "rule %d: backendRef kind %s is not supported, only Services are", ruleIndex, *backendRef.Kind)
+	}
+
+	// ReferenceGrant is not supported yet, so cross namespace references are
+	// never permitted
+	if backendRef.Namespace != nil && string(*backendRef.Namespace) != route.Namespace {
+		return "", unresolvedRouteRef(gatewayv1.RouteReasonRefNotPermitted, "rule %d: backendRef to namespace %s is not permitted, cross namespace references are not supported", ruleIndex, *backendRef.Namespace)
+	}
+
+	if backendRef.Port == nil {
+		return "", unsupportedRouteValue("rule %d: backendRef to Service %s has no port", ruleIndex, backendRef.Name)
+	}
+
+	namespacedName := types.NamespacedName{
+		Namespace: route.Namespace,
+		Name:      string(backendRef.Name),
+	}
+	service := v1.Service{}
+	err := kubeClient.Get(ctx, namespacedName, &service)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", unresolvedRouteRef(gatewayv1.RouteReasonBackendNotFound, "rule %d: service %s not found", ruleIndex, namespacedName)
+		}
+		return "", errors.Wrapf(err, "fetch service %s", namespacedName)
+	}
+
+	host, err := getHostFromService(&service, clusterDomain)
+	if err != nil {
+		return "", unresolvedRouteRef(gatewayv1.RouteReasonBackendNotFound, "rule %d: %s", ruleIndex, err)
+	}
+
+	// the Service port appProtocol takes the role of the backend-protocol
+	// annotation of an Ingress
+	scheme := "http"
+	for _, port := range service.Spec.Ports {
+		if port.Port == *backendRef.Port && port.AppProtocol != nil && *port.AppProtocol == "https" {
+			scheme = "https"
+		}
+	}
+
+	return fmt.Sprintf("%s://%s:%d", scheme, host, *backendRef.Port), nil
+}
+
+// intersectHostnames returns the hostnames a route serves on a listener, as
+// defined by the Gateway API: a listener without hostname accepts every route
+// hostname, a route without hostnames inherits the listener hostname, and
+// otherwise only the route hostnames matching the listener hostname remain.
+func intersectHostnames(listenerHostname *gatewayv1.Hostname, routeHostnames []gatewayv1.Hostname) []string {
+	var result []string
+	if listenerHostname == nil || *listenerHostname == "" {
+		for _, hostname := range routeHostnames {
+			result = append(result, strings.ToLower(string(hostname)))
+		}
+		return result
+	}
+
+	listener := strings.ToLower(string(*listenerHostname))
+	if len(routeHostnames) == 0 {
+		return []string{listener}
+	}
+
+	for _, hostname := range routeHostnames {
+		if matched, ok := matchListenerHostname(listener, strings.ToLower(string(hostname))); ok && !slices.Contains(result, matched) {
+			result = append(result, matched)
+		}
+	}
+	return result
+}
+
+// matchListenerHostname matches a route hostname against a listener hostname,
+// either may be a wildcard. It returns the more specific of both on a match.
+func matchListenerHostname(listener string, route string) (string, bool) {
+	if listener == route {
+		return route, true
+	}
+	if strings.HasPrefix(listener, "*.") && strings.HasSuffix(route, listener[1:]) {
+		return route, true
+	}
+	if strings.HasPrefix(route, "*.") && strings.HasSuffix(listener, route[1:]) {
+		return listener, true
+	}
+	return "", false
+}
@@ -0,0 +1,258 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+func TestIntersectHostnames(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		listener *gatewayv1.Hostname
+		route    []gatewayv1.Hostname
+		want     []string
+	}{
+		{
+			name:  "listener_without_hostname",
+			route: []gatewayv1.Hostname{"a.example.com", "B.example.com"},
+			want:  []string{"a.example.com", "b.example.com"},
+		},
+		{
+			name:     "route_without_hostname",
+			listener: ptr.To(gatewayv1.Hostname("a.example.com")),
+			want:     []string{"a.example.com"},
+		},
+		{
+			name:     "wildcard_listener",
+			listener: ptr.To(gatewayv1.Hostname("*.example.com")),
+			route:    []gatewayv1.Hostname{"a.example.com", "a.other.com"},
+			want:     []string{"a.example.com"},
+		},
+		{
+			name:     "wildcard_route",
+			listener: ptr.To(gatewayv1.Hostname("a.example.com")),
+			route:    []gatewayv1.Hostname{"*.example.com"},
+			want:     []string{"a.example.com"},
+		},
+		{
+			name:     "no_match",
+			listener: ptr.To(gatewayv1.Hostname("a.example.com")),
+			route:    []gatewayv1.Hostname{"b.example.com"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, intersectHostnames(tc.listener, tc.route))
+		})
+	}
+}
+
+func TestPathsFromHTTPRouteMatches(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		matches []gatewayv1.HTTPRouteMatch
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "no_matches",
+			want: []string{"/"},
+		},
+		{
+			name: "prefix",
+			matches: []gatewayv1.HTTPRouteMatch{{Path: &gatewayv1.HTTPPathMatch{
+				Type:  ptr.To(gatewayv1.PathMatchPathPrefix),
+				Value: ptr.To("/api"),
+			}}},
+			want: []string{`^/api(/|$)`},
+		},
+		{
+			name: "prefix_with_metacharacters",
+			matches: []gatewayv1.HTTPRouteMatch{{Path: &gatewayv1.HTTPPathMatch{
+				Type:  ptr.To(gatewayv1.PathMatchPathPrefix),
+				Value: ptr.To("/v1.0+beta/"),
+			}}},
+			want: []string{`^/v1\.0\+beta(/|$)`},
+		},
+		{
+			name: "root_prefix",
+			matches: []gatewayv1.HTTPRouteMatch{{Path: &gatewayv1.HTTPPathMatch{
+				Type:  ptr.To(gatewayv1.PathMatchPathPrefix),
+				Value: ptr.To("/"),
+			}}},
+			want: []string{"/"},
+		},
+		{
+			name: "exact",
+			matches: []gatewayv1.HTTPRouteMatch{{Path: &gatewayv1.HTTPPathMatch{
+				Type:  ptr.To(gatewayv1.PathMatchExact),
+				Value: ptr.To("/v1.0/health"),
+			}}},
+			want: []string{`^/v1\.0/health$`},
+		},
+		{
+			name: "regular_expression",
+			matches: []gatewayv1.HTTPRouteMatch{{Path: &gatewayv1.HTTPPathMatch{
+				Type:  ptr.To(gatewayv1.PathMatchRegularExpression),
+				Value: ptr.To("/users/[0-9]+"),
+			}}},
+			want: []string{"/users/[0-9]+"},
+		},
+		{
+			name: "invalid_regular_expression",
+			matches: []gatewayv1.HTTPRouteMatch{{Path: &gatewayv1.HTTPPathMatch{
+				Type:  ptr.To(gatewayv1.PathMatchRegularExpression),
+				Value: ptr.To("/users/[0-9"),
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "header_match",
+			matches: []gatewayv1.HTTPRouteMatch{{Headers: []gatewayv1.HTTPHeaderMatch{{
+				Name:  "x-version",
+				Value: "2",
+			}}}},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := pathsFromHTTPRouteMatches(0, tc.matches)
+			if tc.wantErr {
+				var conditionErr *routeConditionError
+				require.True(t, errors.As(err, &conditionErr), "expected a route condition error, got %v", err)
+				assert.Equal(t, gatewayv1.RouteReasonUnsupportedValue, conditionErr.reason)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestPathsFromHTTPRouteMatchesMatchPathElements(t *testing.T) {
+	paths, err := pathsFromHTTPRouteMatches(0, []gatewayv1.HTTPRouteMatch{
+		{Path: &gatewayv1.HTTPPathMatch{Type: ptr.To(gatewayv1.PathMatchPathPrefix), Value: ptr.To("/api")}},
+		{Path: &gatewayv1.HTTPPathMatch{Type: ptr.To(gatewayv1.PathMatchExact), Value: ptr.To("/v1.0")}},
+	})
+	require.NoError(t, err)
+	require.Len(t, paths, 2)
+
+	prefix := regexp.MustCompile(paths[0])
+	assert.True(t, prefix.MatchString("/api"))
+	assert.True(t, prefix.MatchString("/api/v1"))
+	assert.False(t, prefix.MatchString("/apiv2"))
+	assert.False(t, prefix.MatchString("/v2/api"))
+
+	exact := regexp.MustCompile(paths[1])
+	assert.True(t, exact.MatchString("/v1.0"))
+	assert.False(t, exact.MatchString("/v1x0"), "the dot is not a wildcard")
+	assert.False(t, exact.MatchString("/v1.0/health"))
+}
+
+func TestFromHTTPRouteToExposure(t *testing.T) {
+	service := v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec: v1.ServiceSpec{
+			ClusterIP: "10.0.0.1",
+			Ports: []v1.ServicePort{
+				{Port: 80},
+				{Port: 443, AppProtocol: ptr.To("https")},
+			},
+		},
+	}
+	kubeClient := fake.NewClientBuilder().WithObjects(&service).Build()
+
+	route := func(backendRef gatewayv1.BackendRef) gatewayv1.HTTPRoute {
+		return gatewayv1.HTTPRoute{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+			Spec: gatewayv1.HTTPRouteSpec{
+				Rules: []gatewayv1.HTTPRouteRule{{
+					BackendRefs: []gatewayv1.HTTPBackendRef{{BackendRef: backendRef}},
+				}},
+			},
+		}
+	}
+
+	t.Run("http_backend", func(t *testing.T) {
+		exposures, err := FromHTTPRouteToExposure(context.Background(), logr.Discard(), kubeClient,
+			route(gatewayv1.BackendRef{BackendObjectReference: gatewayv1.BackendObjectReference{Name: "web", Port: ptr.To(gatewayv1.PortNumber(80))}}),
+			[]string{"a.example.com", "b.example.com"}, "cluster.local")
+		require.NoError(t, err)
+		require.Len(t, exposures, 2)
+		assert.Equal(t, "a.example.com", exposures[0].Hostname)
+		assert.Equal(t, "b.example.com", exposures[1].Hostname)
+		assert.Equal(t, "http://web.default.svc.cluster.local:80", exposures[0].ServiceTarget)
+		assert.Equal(t, "/", exposures[0].PathPrefix)
+	})
+
+	t.Run("https_app_protocol", func(t *testing.T) {
+		exposures, err := FromHTTPRouteToExposure(context.Background(), logr.Discard(), kubeClient,
+			route(gatewayv1.BackendRef{BackendObjectReference: gatewayv1.BackendObjectReference{Name: "web", Port: ptr.To(gatewayv1.PortNumber(443))}}),
+			[]string{"a.example.com"}, "cluster.local")
+		require.NoError(t, err)
+		require.Len(t, exposures, 1)
+		assert.Equal(t, "https://web.default.svc.cluster.local:443", exposures[0].ServiceTarget)
+	})
+
+	for _, tc := range []struct {
+		name       string
+		backendRef gatewayv1.BackendRef
+		hostnames  []string
+		wantType   gatewayv1.RouteConditionType
+		wantReason gatewayv1.RouteConditionReason
+	}{
+		{
+			name:       "no_hostname",
+			backendRef: gatewayv1.BackendRef{BackendObjectReference: gatewayv1.BackendObjectReference{Name: "web", Port: ptr.To(gatewayv1.PortNumber(80))}},
+			wantType:   gatewayv1.RouteConditionAccepted,
+			wantReason: gatewayv1.RouteReasonUnsupportedValue,
+		},
+		{
+			name:       "missing_service",
+			backendRef: gatewayv1.BackendRef{BackendObjectReference: gatewayv1.BackendObjectReference{Name: "missing", Port: ptr.To(gatewayv1.PortNumber(80))}},
+			hostnames:  []string{"a.example.com"},
+			wantType:   gatewayv1.RouteConditionResolvedRefs,
+			wantReason: gatewayv1.RouteReasonBackendNotFound,
+		},
+		{
+			name: "cross_namespace",
+			backendRef: gatewayv1.BackendRef{BackendObjectReference: gatewayv1.BackendObjectReference{
+				Name:      "web",
+				Namespace: ptr.To(gatewayv1.Namespace("other")),
+				Port:      ptr.To(gatewayv1.PortNumber(80)),
+			}},
+			hostnames:  []string{"a.example.com"},
+			wantType:   gatewayv1.RouteConditionResolvedRefs,
+			wantReason: gatewayv1.RouteReasonRefNotPermitted,
+		},
+		{
+			name: "not_a_service",
+			backendRef: gatewayv1.BackendRef{BackendObjectReference: gatewayv1.BackendObjectReference{
+				Name: "web",
+				Kind: ptr.To(gatewayv1.Kind("ConfigMap")),
+				Port: ptr.To(gatewayv1.PortNumber(80)),
+			}},
+			hostnames:  []string{"a.example.com"},
+			wantType:   gatewayv1.RouteConditionResolvedRefs,
+			wantReason: gatewayv1.RouteReasonInvalidKind,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := FromHTTPRouteToExposure(context.Background(), logr.Discard(), kubeClient, route(tc.backendRef), tc.hostnames, "cluster.local")
+			var conditionErr *routeConditionError
+			require.True(t, errors.As(err, &conditionErr), "expected a route condition error, got %v", err)
+			assert.Equal(t, tc.wantType, conditionErr.conditionType)
+			assert.Equal(t, tc.wantReason, conditionErr.reason)
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package controller
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+// Policies resolving a host and path claimed by Ingresses of several
+// namespaces.
+const (
+	// HostnameConflictPolicyFirstOwner keeps the claim of the Ingress created
+	// first, the Ingresses of the other namespaces lose it.
+	HostnameConflictPolicyFirstOwner = "first-owner"
+	// HostnameConflictPolicyReject drops the claim of every Ingress.
+	HostnameConflictPolicyReject = "reject"
+)
+
+// ValidateHostnameConflictPolicy checks the value of the
+// --hostname-conflict-policy flag.
+func ValidateHostnameConflictPolicy(policy string) error {
+	if policy != HostnameConflictPolicyFirstOwner && policy != HostnameConflictPolicyReject {
+		return errors.Errorf("unknown hostname conflict policy %q, expected %s or %s", policy, HostnameConflictPolicyFirstOwner, HostnameConflictPolicyReject)
+	}
+	return nil
+}
+
+// parseHostnameAllowlist reads the allowed-hostnames annotation of a
+// namespace: an exact hostname allows itself, *.example.com every hostname
+// below example.com, and * everything, the catch-all included. Nil, allowing
+// every hostname, when the annotation is absent.
+//
+// The annotation adds to the HostnamePolicies, a hostname must be allowed by
+// both. A disallowed hostname fails the whole Ingress or route by the
+// policies, it is only dropped by the annotation.
+func parseHostnameAllowlist(annotations map[string]string) *AllowedHostnames {
+	value, ok := annotations[AnnotationAllowedHostnames]
+	if !ok {
+		return nil
+	}
+	result := &AllowedHostnames{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "*" {
+			return nil
+		}
+		if domain, ok := strings.CutPrefix(entry, "*."); ok {
+			result.Wildcards = append(result.Wildcards, domain)
+		} else if entry != "" {
+			result.Hostnames = append(result.Hostnames, entry)
+		}
+	}
+	return result
+}
+
+// filter drops the active exposures of the hostnames the allowed-hostnames
+// annotation does not allow, and reports them. Deleted exposures are kept, so
+// their DNS records are cleaned up.
+func (a *AllowedHostnames) filter(namespace string, exposures []exposure.Exposure) ([]exposure.Exposure, error) {
+	var kept []exposure.Exposure
+	var rejected []string
+	for _, item := range exposures {
+		if item.IsDeleted || a.Allows(item.Hostname) {
+			kept = append(kept, item)
+			continue
+		}
+		hostname := item.Hostname
+		if hostname == "" {
+			hostname = "the catch-all"
+		}
+		if !slices.Contains(rejected, hostname) {
+			rejected = append(rejected, hostname)
+		}
+	}
+	if len(rejected) == 0 {
+		return exposures, nil
+	}
+	return kept, errors.Errorf("%s not allowed in namespace %s by annotation %s", strings.Join(rejected, ", "), namespace, AnnotationAllowedHostnames)
+}
+
+// namespaceAllowlist returns the hostnames the allowed-hostnames annotation of
+// the namespace allows.
+func namespaceAllowlist(ctx context.Context, kubeClient client.Client, namespace string) (*AllowedHostnames, error) {
+	ns := v1.Namespace{}
+	if err := kubeClient.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "fetch namespace %s", namespace)
+	}
+	return parseHostnameAllowlist(ns.Annotations), nil
+}
+
+// hostnameConflicts resolves the hosts and paths claimed by Ingresses and
+// exposed Services, or by HTTPRoutes, of several namespaces of the tunnel with
+// the policy, and
+// drops the claims the owners lose. The owners of one namespace share their
+// claims, so they keep routing the same host and path as before. A Service
+// claims every path of its hostname.
+func hostnameConflicts(policy string, owners []client.Object, served [][]exposure.Exposure) ([][]exposure.Exposure, map[int]error) {
+	type claim struct {
+		hostname string
+		path     string
+	}
+	claimants := map[claim][]int{}
+	var claims []claim
+	for idx := range owners {
+		for _, item := range exposure.Active(served[idx]) {
+			// the catch-all is resolved by catchAllConflicts
+			if isCatchAll(item) {
+				continue
+			}
+			key := claim{hostname: strings.ToLower(item.Hostname), path: item.PathPrefix}
+			if !slices.Contains(claimants[key], idx) {
+				if len(claimants[key]) == 0 {
+					claims = append(claims, key)
+				}
+				claimants[key] = append(claimants[key], idx)
+			}
+		}
+	}
+
+	result := slices.Clone(served)
+	reasons := map[int][]string{}
+	for _, key := range claims {
+		// a claim without path overlaps every path of the hostname, its
+		// owners only lose it to the owners of another namespace
+		indexes := slices.Clone(claimants[key])
+		for other, otherIndexes := range claimants {
+			if other.hostname != key.hostname || other == key || (key.path != "" && other.path != "") {
+				continue
+			}
+			for _, idx := range otherIndexes {
+				if !slices.Contains(indexes, idx) {
+					indexes = append(indexes, idx)
+				}
+			}
+		}
+		if !spansNamespaces(owners, indexes) {
+			continue
+		}
+		slices.SortFunc(indexes, func(a, b int) int {
+			return cmp.Or(
+				owners[a].GetCreationTimestamp().Time.Compare(owners[b].GetCreationTimestamp().Time),
+				strings.Compare(owners[a].GetNamespace()+"/"+owners[a].GetName(), owners[b].GetNamespace()+"/"+owners[b].GetName()),
+			)
+		})
+		claimed := key.hostname
+		if key.path != "" && key.path != "/" {
+			claimed += " path " + key.path
+		}
+		owner := owners[indexes[0]]
+		for _, idx := range claimants[key] {
+			var reason string
+			switch policy {
+			case HostnameConflictPolicyReject:
+				reason = fmt.Sprintf("%s is claimed by several namespaces, rejected for all of them", claimed)
+			default:
+				if owners[idx].GetNamespace() == owner.GetNamespace() {
+					continue
+				}
+				reason = fmt.Sprintf("%s is already claimed by %s", claimed, claimOwnerName(owner))
+			}
+			reasons[idx] = append(reasons[idx], reason)
+			result[idx] = slices.DeleteFunc(slices.Clone(result[idx]), func(item exposure.Exposure) bool {
+				return !item.IsDeleted && strings.EqualFold(item.Hostname, key.hostname) && item.PathPrefix == key.path
+			})
+		}
+	}
+
+	conflicts := map[int]error{}
+	for idx, items := range reasons {
+		conflicts[idx] = errors.New(strings.Join(items, "; "))
+	}
+	return result, conflicts
+}
+
+// claimOwners lists the Ingresses then the Services of a tunnel, in the order
+// of their exposures given to hostnameConflicts.
+func claimOwners(partition []networkingv1.Ingress, services []v1.Service) []client.Object {
+	owners := make([]client.Object, 0, len(partition)+len(services))
+	for idx := range partition {
+		owners = append(owners, &partition[idx])
+	}
+	for idx := range services {
+		owners = append(owners, &services[idx])
+	}
+	return owners
+}
+
+// claimOwnerName names the Ingress, the Service or the HTTPRoute owning a
+// claim.
+func claimOwnerName(owner client.Object) string {
+	kind := "ingress"
+	switch owner.(type) {
+	case *v1.Service:
+		kind = "service"
+	case *gatewayv1.HTTPRoute:
+		kind = "httproute"
+	}
+	return fmt.Sprintf("%s %s/%s", kind, owner.GetNamespace(), owner.GetName())
+}
+
+func spansNamespaces(owners []client.Object, indexes []int) bool {
+	return slices.ContainsFunc(indexes, func(idx int) bool {
+		return owners[idx].GetNamespace() != owners[indexes[0]].GetNamespace()
+	})
+}
+
+// tunnelKeysForNamespace maps a Namespace to the tunnels of its Ingresses and
+// exposed Services, so a change of its allowlist or labels is synced.
+func (i *IngressController) tunnelKeysForNamespace(ctx context.Context, object client.Object) []string {
+	return i.tunnelKeysInNamespace(ctx, object.GetName())
+}
+
+// tunnelKeysInNamespace returns the tunnels of the Ingresses and exposed
+// Services of the namespace, of every namespace when it is empty.
+func (i *IngressController) tunnelKeysInNamespace(ctx context.Context, namespace string) []string {
+	var result []string
+	add := func(keys ...string) {
+		for _, key := range keys {
+			if !slices.Contains(result, key) {
+				result = append(result, key)
+			}
+		}
+	}
+
+	ingresses := networkingv1.IngressList{}
+	if err := i.kubeClient.List(ctx, &ingresses, client.InNamespace(namespace)); err != nil {
+		i.logger.Error(err, "list ingresses of namespace, sync skipped", "namespace", namespace)
+		return nil
+	}
+	for _, ingress := range ingresses.Items {
+		add(i.tunnelKeysForIngress(ctx, &ingress)...)
+	}
+
+	services := v1.ServiceList{}
+	if err := i.kubeClient.List(ctx, &services, client.InNamespace(namespace)); err != nil {
+		i.logger.Error(err, "list services of namespace, sync skipped", "namespace", namespace)
+		return result
+	}
+	classes, err := i.listControlledIngressClasses(ctx)
+	if err != nil {
+		i.logger.Error(err, "list ingress classes, sync skipped", "namespace", namespace)
+		return result
+	}
+	for _, service := range services.Items {
+		if key, ok := i.serviceTunnelKey(service, classes); ok {
+			add(key)
+		}
+	}
+	return result
+}
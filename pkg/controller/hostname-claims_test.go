@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestHostnameAllowlist(t *testing.T) {
+	assert.Nil(t, parseHostnameAllowlist(nil))
+	assert.True(t, parseHostnameAllowlist(nil).Allows("app.example.com"))
+
+	allowlist := parseHostnameAllowlist(map[string]string{AnnotationAllowedHostnames: " App.example.com, *.team-a.example.com ,"})
+	assert.Equal(t, &AllowedHostnames{Hostnames: []string{"app.example.com"}, Wildcards: []string{"team-a.example.com"}}, allowlist)
+	assert.True(t, allowlist.Allows("APP.example.com"))
+	assert.True(t, allowlist.Allows("api.team-a.example.com"))
+	assert.True(t, allowlist.Allows("a.b.team-a.example.com"))
+	assert.False(t, allowlist.Allows("team-a.example.com"))
+	assert.False(t, allowlist.Allows("evilteam-a.example.com"))
+	assert.False(t, allowlist.Allows(""), "the catch-all is only allowed by *")
+	assert.True(t, parseHostnameAllowlist(map[string]string{AnnotationAllowedHostnames: "app.example.com,*"}).Allows(""))
+
+	empty := parseHostnameAllowlist(map[string]string{AnnotationAllowedHostnames: ""})
+	assert.False(t, empty.Allows("app.example.com"), "an empty annotation allows nothing")
+
+	kept, err := allowlist.filter("team-a", []exposure.Exposure{
+		{Hostname: "app.example.com", PathPrefix: "/"},
+		{Hostname: "web.example.com", PathPrefix: "/"},
+		{Hostname: "web.example.com", PathPrefix: "/api"},
+		{Hostname: "old.example.com", PathPrefix: "/", IsDeleted: true},
+	})
+	require.Error(t, err)
+	assert.Equal(t, "web.example.com not allowed in namespace team-a by annotation "+AnnotationAllowedHostnames, err.Error())
+	assert.Equal(t, []exposure.Exposure{
+		{Hostname: "app.example.com", PathPrefix: "/"},
+		{Hostname: "old.example.com", PathPrefix: "/", IsDeleted: true},
+	}, kept)
+}
+
+func testClaimIngress(namespace string, name string, host string, created time.Time) *networkingv1.Ingress {
+	ingress := testTunnelIngress(name, host, "cloudflare-tunnel")
+	ingress.Namespace = namespace
+	ingress.CreationTimestamp = metav1.NewTime(created)
+	return ingress
+}
+
+func TestHostnameConflicts(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	partition := []networkingv1.Ingress{
+		*testClaimIngress("team-b", "app", "app.example.com", created.Add(time.Hour)),
+		*testClaimIngress("team-a", "app", "app.example.com", created),
+		*testClaimIngress("team-a", "app-canary", "app.example.com", created.Add(2*time.Hour)),
+	}
+	served := [][]exposure.Exposure{
+		{{Hostname: "app.example.com", PathPrefix: "/"}, {Hostname: "b.example.com", PathPrefix: "/"}},
+		{{Hostname: "app.example.com", PathPrefix: "/"}},
+		{{Hostname: "App.example.com", PathPrefix: "/"}, {Hostname: "app.example.com", PathPrefix: "/api"}},
+	}
+
+	result, conflicts := hostnameConflicts(HostnameConflictPolicyFirstOwner, claimOwners(partition, nil), served)
+	assert.Equal(t, []exposure.Exposure{{Hostname: "b.example.com", PathPrefix: "/"}}, result[0])
+	assert.Equal(t, served[1], result[1])
+	assert.Equal(t, served[2], result[2], "the ingresses of the owner namespace share the claim")
+	require.Len(t, conflicts, 1)
+	assert.EqualError(t, conflicts[0], "app.example.com is already claimed by ingress team-a/app")
+	assert.Len(t, served[0], 2, "the exposures of the caller are not modified")
+
+	result, conflicts = hostnameConflicts(HostnameConflictPolicyReject, claimOwners(partition, nil), served)
+	assert.Equal(t, []exposure.Exposure{{Hostname: "b.example.com", PathPrefix: "/"}}, result[0])
+	assert.Empty(t, result[1])
+	assert.Equal(t, []exposure.Exposure{{Hostname: "app.example.com", PathPrefix: "/api"}}, result[2])
+	assert.Len(t, conflicts, 3)
+	assert.EqualError(t, conflicts[1], "app.example.com is claimed by several namespaces, rejected for all of them")
+
+	// an exposed Service claims every path of its hostname
+	service := testAccessService()
+	service.Namespace = "team-c"
+	service.CreationTimestamp = metav1.NewTime(created.Add(-time.Hour))
+	withService := append(slices.Clone(served), []exposure.Exposure{{Hostname: "app.example.com", ServiceTarget: "tcp://app.team-c.svc.cluster.local:80"}})
+	result, conflicts = hostnameConflicts(HostnameConflictPolicyFirstOwner, claimOwners(partition, []v1.Service{*service}), withService)
+	assert.Equal(t, []exposure.Exposure{{Hostname: "b.example.com", PathPrefix: "/"}}, result[0])
+	assert.Empty(t, result[1])
+	assert.Empty(t, result[2])
+	assert.Equal(t, withService[3], result[3])
+	assert.EqualError(t, conflicts[2], "app.example.com is already claimed by service team-c/app; app.example.com path /api is already claimed by service team-c/app")
+
+	service.CreationTimestamp = metav1.NewTime(created.Add(time.Minute))
+	result, conflicts = hostnameConflicts(HostnameConflictPolicyFirstOwner, claimOwners(partition, []v1.Service{*service}), withService)
+	assert.Empty(t, result[3])
+	assert.EqualError(t, conflicts[3], "app.example.com is already claimed by ingress team-a/app")
+	assert.Equal(t, served[1], result[1])
+}
+
+func TestIngressControllerHostnameClaims(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	appService := func(namespace string) *v1.Service {
+		service := testAccessService()
+		service.Namespace = namespace
+		return service
+	}
+	kubeClient := newTunnelParametersTestClient(t,
+		testTunnelIngressClass("cloudflare-tunnel", ""),
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Annotations: map[string]string{AnnotationAllowedHostnames: "app.example.com,*.team-b.example.com"}}},
+		appService("team-a"), appService("team-b"),
+		testClaimIngress("team-a", "app", "app.example.com", created),
+		testClaimIngress("team-b", "app", "app.example.com", created.Add(time.Hour)),
+		testClaimIngress("team-b", "api", "api.team-b.example.com", created),
+		testClaimIngress("team-b", "web", "web.example.com", created),
+		&v1.Service{ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "team-b",
+			Name:              "db",
+			CreationTimestamp: metav1.NewTime(created.Add(time.Minute)),
+			Annotations:       map[string]string{AnnotationExposeHostname: "app.example.com"},
+		}, Spec: v1.ServiceSpec{ClusterIP: "10.0.0.2", Ports: []v1.ServicePort{{Port: 5432}}}},
+	)
+	tunnel := &fakeGatewayTunnelClient{name: "default"}
+	recorder := record.NewFakeRecorder(32)
+	controller := NewIngressController(logr.Discard(), kubeClient, recorder, "cloudflare-tunnel", testControllerClass, "cluster.local", "", HostnameConflictPolicyFirstOwner, tunnel, nil, false, false, DriftOptions{})
+
+	assert.Equal(t, []string{defaultTunnelKey}, controller.tunnelKeysForNamespace(context.Background(), &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}}))
+
+	syncTunnel(t, controller, defaultTunnelKey)
+	var hostnames []string
+	for _, item := range tunnel.exposures {
+		hostnames = append(hostnames, item.Hostname)
+	}
+	assert.Equal(t, []string{"app.example.com", "api.team-b.example.com"}, hostnames)
+
+	getStatus := func(namespace string, name string) *IngressSyncStatus {
+		ingress := networkingv1.Ingress{}
+		require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: name}, &ingress))
+		return ingressSyncStatus(ingress)
+	}
+	assert.True(t, getStatus("team-a", "app").Synced)
+	assert.True(t, getStatus("team-b", "api").Synced)
+	assert.Equal(t, "app.example.com is already claimed by ingress team-a/app", getStatus("team-b", "app").LastError)
+	assert.Equal(t, "web.example.com not allowed in namespace team-b by annotation "+AnnotationAllowedHostnames, getStatus("team-b", "web").LastError)
+
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	lost := slices.DeleteFunc(events, func(event string) bool {
+		return event != v1.EventTypeWarning+" "+EventReasonSyncFailed+" app.example.com is already claimed by ingress team-a/app"
+	})
+	assert.Len(t, lost, 2, "the ingress and the service of team-b lose the hostname")
+}
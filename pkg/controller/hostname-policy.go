@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/apis/v1alpha1"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AllowedHostnames are the hostnames the HostnamePolicies selecting a
+// namespace, or its allowed-hostnames annotation, allow. A nil value allows
+// every hostname.
+type AllowedHostnames struct {
+	// Hostnames are allowed as they are
+	Hostnames []string
+	// Suffixes are the domains whose hostnames are allowed, the domain
+	// itself included
+	Suffixes []string
+	// Wildcards are the domains whose hostnames are allowed, the domain
+	// itself excluded
+	Wildcards []string
+	// CatchAll allows an Ingress to serve the catch-all of its tunnel
+	CatchAll bool
+}
+
+// Allows tells whether the hostname may be claimed, the empty hostname being
+// the catch-all.
+func (a *AllowedHostnames) Allows(hostname string) bool {
+	if a == nil {
+		return true
+	}
+	if hostname == "" {
+		return a.CatchAll
+	}
+	hostname = strings.ToLower(hostname)
+	if slices.Contains(a.Hostnames, hostname) || slices.ContainsFunc(a.Wildcards, func(domain string) bool {
+		return strings.HasSuffix(hostname, "."+domain)
+	}) {
+		return true
+	}
+	return slices.ContainsFunc(a.Suffixes, func(suffix string) bool {
+		return hostname == suffix || strings.HasSuffix(hostname, "."+suffix)
+	})
+}
+
+func (a *AllowedHostnames) equal(b *AllowedHostnames) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.CatchAll == b.CatchAll && slices.Equal(a.Hostnames, b.Hostnames) && slices.Equal(a.Suffixes, b.Suffixes) && slices.Equal(a.Wildcards, b.Wildcards)
+}
+
+// selectsNamespace tells whether the policy applies to the namespace.
+func selectsNamespace(policy v1alpha1.HostnamePolicy, namespace v1.Namespace) (bool, error) {
+	if slices.Contains(policy.Spec.Namespaces, namespace.Name) {
+		return true, nil
+	}
+	if policy.Spec.NamespaceSelector == nil {
+		return false, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(policy.Spec.NamespaceSelector)
+	if err != nil {
+		return false, errors.Wrapf(err, "parse namespace selector of hostname policy %s", policy.Name)
+	}
+	return selector.Matches(labels.Set(namespace.Labels)), nil
+}
+
+// allowedHostnames returns the hostnames the HostnamePolicies allow in the
+// namespace, nil when the policies are not enabled.
+func (i *IngressController) allowedHostnames(ctx context.Context, namespace string) (*AllowedHostnames, error) {
+	if !i.hostnamePoliciesEnabled {
+		return nil, nil
+	}
+	return policyAllowedHostnames(ctx, i.kubeClient, namespace)
+}
+
+// policyAllowedHostnames returns the hostnames the HostnamePolicies allow in
+// the namespace.
+func policyAllowedHostnames(ctx context.Context, kubeClient client.Client, namespace string) (*AllowedHostnames, error) {
+	policies := v1alpha1.HostnamePolicyList{}
+	if err := kubeClient.List(ctx, &policies); err != nil {
+		return nil, errors.Wrap(err, "list hostname policies")
+	}
+	ns := v1.Namespace{}
+	if err := kubeClient.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, errors.Wrapf(err, "fetch namespace %s", namespace)
+		}
+		ns.Name = namespace
+	}
+
+	// sorted, so the cached exposures of the namespace are only computed
+	// again when the allowed hostnames change
+	result := &AllowedHostnames{}
+	for _, policy := range policies.Items {
+		selected, err := selectsNamespace(policy, ns)
+		if err != nil {
+			return nil, err
+		}
+		if !selected {
+			continue
+		}
+		for _, suffix := range policy.Spec.AllowedDomainSuffixes {
+			suffix = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(suffix)), ".")
+			if suffix != "" && !slices.Contains(result.Suffixes, suffix) {
+				result.Suffixes = append(result.Suffixes, suffix)
+			}
+		}
+		result.CatchAll = result.CatchAll || policy.Spec.AllowCatchAll
+	}
+	slices.Sort(result.Suffixes)
+	return result, nil
+}
+
+// tunnelKeysForHostnamePolicy maps a HostnamePolicy to every tunnel, the
+// namespaces it selected before the change are unknown.
+func (i *IngressController) tunnelKeysForHostnamePolicy(ctx context.Context, _ client.Object) []string {
+	return i.tunnelKeysInNamespace(ctx, metav1.NamespaceAll)
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/apis/v1alpha1"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestAllowedHostnamesAllows(t *testing.T) {
+	var unrestricted *AllowedHostnames
+	assert.True(t, unrestricted.Allows("app.example.com"))
+	assert.True(t, unrestricted.Allows(""))
+
+	allowed := &AllowedHostnames{Suffixes: []string{"team-a.example.com"}}
+	assert.True(t, allowed.Allows("team-a.example.com"))
+	assert.True(t, allowed.Allows("App.Team-A.example.com"))
+	assert.True(t, allowed.Allows("*.team-a.example.com"))
+	assert.False(t, allowed.Allows("example.com"))
+	assert.False(t, allowed.Allows("evilteam-a.example.com"))
+	assert.False(t, allowed.Allows(""), "the catch-all needs allowCatchAll")
+	assert.False(t, (&AllowedHostnames{}).Allows("app.example.com"), "a namespace without policy may claim nothing")
+
+	assert.True(t, unrestricted.equal(nil))
+	assert.False(t, allowed.equal(nil))
+	assert.True(t, allowed.equal(&AllowedHostnames{Suffixes: []string{"team-a.example.com"}}))
+	assert.False(t, allowed.equal(&AllowedHostnames{Suffixes: []string{"team-a.example.com"}, CatchAll: true}))
+}
+
+func TestIngressControllerAllowedHostnames(t *testing.T) {
+	kubeClient := newTunnelParametersTestClient(t,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"team": "a"}}},
+		&v1alpha1.HostnamePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "team-a"},
+			Spec: v1alpha1.HostnamePolicySpec{
+				NamespaceSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+				AllowedDomainSuffixes: []string{"Team-A.example.com."},
+			},
+		},
+		&v1alpha1.HostnamePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "platform"},
+			Spec: v1alpha1.HostnamePolicySpec{
+				Namespaces:            []string{"default"},
+				AllowedDomainSuffixes: []string{"example.net"},
+				AllowCatchAll:         true,
+			},
+		},
+		&v1alpha1.HostnamePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "team-b"},
+			Spec: v1alpha1.HostnamePolicySpec{
+				Namespaces:            []string{"team-b"},
+				AllowedDomainSuffixes: []string{"team-b.example.com"},
+			},
+		},
+	)
+	controller := NewIngressController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cloudflare-tunnel", testControllerClass, "cluster.local", "", HostnameConflictPolicyFirstOwner, &fakeGatewayTunnelClient{name: "default"}, nil, false, true, DriftOptions{})
+
+	allowed, err := controller.allowedHostnames(context.Background(), "default")
+	require.NoError(t, err)
+	assert.Equal(t, &AllowedHostnames{Suffixes: []string{"example.net", "team-a.example.com"}, CatchAll: true}, allowed)
+
+	allowed, err = controller.allowedHostnames(context.Background(), "unlabelled")
+	require.NoError(t, err)
+	assert.Equal(t, &AllowedHostnames{}, allowed)
+
+	controller.hostnamePoliciesEnabled = false
+	allowed, err = controller.allowedHostnames(context.Background(), "unlabelled")
+	require.NoError(t, err)
+	assert.Nil(t, allowed)
+}
+
+func TestIngressControllerRejectsDisallowedHosts(t *testing.T) {
+	kubeClient := newTunnelParametersTestClient(t,
+		testTunnelIngressClass("cloudflare-tunnel", ""),
+		testAccessService(),
+		&v1alpha1.HostnamePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec: v1alpha1.HostnamePolicySpec{
+				Namespaces:            []string{"default"},
+				AllowedDomainSuffixes: []string{"team-a.example.com"},
+			},
+		},
+		testTunnelIngress("allowed", "app.team-a.example.com", "cloudflare-tunnel"),
+		testTunnelIngress("disallowed", "app.example.com", "cloudflare-tunnel"),
+		testExposedService("bastion", map[string]string{AnnotationExposeHostname: "ssh.example.com", AnnotationExposePort: "ssh"}),
+	)
+	tunnel := &fakeGatewayTunnelClient{name: "default"}
+	recorder := record.NewFakeRecorder(32)
+	controller := NewIngressController(logr.Discard(), kubeClient, recorder, "cloudflare-tunnel", testControllerClass, "cluster.local", "", HostnameConflictPolicyFirstOwner, tunnel, nil, false, true, DriftOptions{})
+
+	syncTunnel(t, controller, defaultTunnelKey)
+	require.Len(t, tunnel.exposures, 1)
+	assert.Equal(t, "app.team-a.example.com", tunnel.exposures[0].Hostname)
+
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	assert.Contains(t, events, "Warning TransformFailed host app.example.com in ingress default/disallowed is not allowed by the hostname policies of namespace default")
+	assert.Contains(t, events, "Warning TransformFailed hostname ssh.example.com of service default/bastion is not allowed by the hostname policies of namespace default")
+
+	// allowing the hostname computes the cached exposures again
+	policy := v1alpha1.HostnamePolicy{}
+	require.NoError(t, kubeClient.Get(context.Background(), client.ObjectKey{Name: "default"}, &policy))
+	policy.Spec.AllowedDomainSuffixes = []string{"example.com"}
+	require.NoError(t, kubeClient.Update(context.Background(), &policy))
+	syncTunnel(t, controller, defaultTunnelKey)
+	assert.Len(t, tunnel.exposures, 3)
+}
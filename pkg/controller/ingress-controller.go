@@ -3,15 +3,24 @@ package controller
 import (
 	"context"
 	"fmt"
+	"maps"
+	"reflect"
 	"slices"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/apis/v1alpha1"
 	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
 	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/metrics"
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	v1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -23,6 +32,18 @@ var _ reconcile.Reconciler = &IngressController{}
 const WellKnownIngressAnnotation = "kubernetes.io/ingress.class"
 const IngressControllerFinalizer = "strrl.dev/cloudflare-tunnel-ingress-controller-controlled"
 
+// The ingress controller reconciles tunnels rather than Ingresses, the name of
+// the request is the tunnel key: the name of the TunnelParameters object, or
+// one of the keys below. Neither is a valid object name, so they never
+// collide with TunnelParameters.
+const (
+	// defaultTunnelKey is the tunnel given on the command line
+	defaultTunnelKey = "@default"
+	// invalidClassTunnelKeyPrefix prefixes the name of an IngressClass with
+	// invalid parameters, its Ingresses are not put into any tunnel
+	invalidClassTunnelKeyPrefix = "class/"
+)
+
 type IngressController struct {
 	logger              logr.Logger
 	kubeClient          client.Client
@@ -30,131 +51,820 @@ type IngressController struct {
 	ingressClassName    string
 	controllerClassName string
 	clusterDomain       string
-	tunnelClient        *cloudflarecontroller.TunnelClient
+	// catchAllService serves the requests no rule matches, unless an Ingress
+	// of the tunnel provides a catch-all, empty for the cloudflared 404
+	catchAllService string
+	// hostnameConflictPolicy resolves the hosts and paths claimed by
+	// Ingresses of several namespaces
+	hostnameConflictPolicy string
+	// tunnelClient serves the IngressClasses without TunnelParameters
+	tunnelClient cloudflarecontroller.TunnelClientInterface
+	// tunnels serves the IngressClasses referencing TunnelParameters, nil
+	// when the TunnelParameters CRD is not enabled
+	tunnels *TunnelRegistry
+	// accessEnabled withholds the hostnames protected by CloudflareAccess
+	// objects until their Access application is programmed
+	accessEnabled bool
+	// hostnamePoliciesEnabled restricts the hostnames of every namespace to
+	// the ones its HostnamePolicies allow
+	hostnamePoliciesEnabled bool
+
+	// resyncPeriod is how often a tunnel is compared with Cloudflare even
+	// without events, zero disables the drift check
+	resyncPeriod time.Duration
+	// driftReportOnly reports the drift found by the check without repairing
+	// it
+	driftReportOnly bool
+
+	exposures *exposureCache
+	mu        sync.Mutex
+	// applied holds the exposures put by the last successful sync of every
+	// tunnel
+	applied map[string]appliedExposures
+	// serviceEvents holds the message of the last event recorded on every
+	// exposed Service
+	serviceEvents map[types.NamespacedName]string
+}
+
+type appliedExposures struct {
+	client    cloudflarecontroller.TunnelClientInterface
+	exposures []exposure.Exposure
+	// checkedAt is when Cloudflare last matched the exposures, by a put or
+	// by a drift check
+	checkedAt time.Time
+	// partial holds the hostnames the put failed to sync, they are retried
+	// on the next sync of the tunnel
+	partial *cloudflarecontroller.PartialSyncError
 }
 
-func NewIngressController(logger logr.Logger, kubeClient client.Client, recorder record.EventRecorder, ingressClassName string, controllerClassName string, clusterDomain string, tunnelClient *cloudflarecontroller.TunnelClient) *IngressController {
-	return &IngressController{logger: logger, kubeClient: kubeClient, recorder: recorder, ingressClassName: ingressClassName, controllerClassName: controllerClassName, clusterDomain: clusterDomain, tunnelClient: tunnelClient}
+// DriftOptions configures the periodic comparison of the tunnels with the
+// Cloudflare state.
+type DriftOptions struct {
+	// ResyncPeriod is how often every tunnel is checked for drift, zero
+	// disables the check.
+	ResyncPeriod time.Duration
+	// ReportOnly records the drift in the metrics and the logs without
+	// repairing it.
+	ReportOnly bool
+}
+
+func NewIngressController(logger logr.Logger, kubeClient client.Client, recorder record.EventRecorder, ingressClassName string, controllerClassName string, clusterDomain string, catchAllService string, hostnameConflictPolicy string, tunnelClient cloudflarecontroller.TunnelClientInterface, tunnels *TunnelRegistry, accessEnabled bool, hostnamePoliciesEnabled bool, drift DriftOptions) *IngressController {
+	return &IngressController{
+		logger:                  logger,
+		kubeClient:              kubeClient,
+		recorder:                recorder,
+		ingressClassName:        ingressClassName,
+		controllerClassName:     controllerClassName,
+		clusterDomain:           clusterDomain,
+		catchAllService:         catchAllService,
+		hostnameConflictPolicy:  hostnameConflictPolicy,
+		tunnelClient:            tunnelClient,
+		tunnels:                 tunnels,
+		accessEnabled:           accessEnabled,
+		hostnamePoliciesEnabled: hostnamePoliciesEnabled,
+		resyncPeriod:            drift.ResyncPeriod,
+		driftReportOnly:         drift.ReportOnly,
+		exposures:               newExposureCache(),
+		applied:                 map[string]appliedExposures{},
+		serviceEvents:           map[types.NamespacedName]string{},
+	}
 }
 
 func (i *IngressController) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
-	origin := networkingv1.Ingress{}
-	err := i.kubeClient.Get(ctx, request.NamespacedName, &origin)
+	key := request.Name
+	classes, err := i.listControlledIngressClasses(ctx)
 	if err != nil {
-		if apierrors.IsNotFound(err) {
-			return reconcile.Result{}, nil
-		}
-		return reconcile.Result{}, errors.Wrapf(err, "fetch ingress %s", request.NamespacedName)
+		return reconcile.Result{}, errors.Wrapf(err, "fetch controlled ingress classes with controller name %s", i.controllerClassName)
+	}
+	ingresses, err := i.listControlledIngresses(ctx)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "list controlled ingresses")
 	}
 
-	controlled, err := i.isControlledByThisController(ctx, origin)
-	if err != nil && !apierrors.IsNotFound(err) {
-		return reconcile.Result{}, errors.Wrapf(err, "check if ingress %s is controlled by this controller", request.NamespacedName)
+	var partition []networkingv1.Ingress
+	for _, ingress := range ingresses {
+		if ingressKey, _ := i.tunnelKey(ingress, classes); ingressKey == key {
+			partition = append(partition, ingress)
+		}
+	}
+	// the informer cache lists in no particular order, keep the exposures
+	// stable so unchanged tunnels are detected
+	slices.SortFunc(partition, func(a, b networkingv1.Ingress) int {
+		return strings.Compare(a.Namespace+"/"+a.Name, b.Namespace+"/"+b.Name)
+	})
+	services, released, err := i.exposedServices(ctx, key, classes)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "list exposed services")
+	}
+	if len(partition) == 0 && len(services) == 0 && strings.HasPrefix(key, invalidClassTunnelKeyPrefix) {
+		// the class was fixed, its Ingresses moved to another tunnel
+		return reconcile.Result{}, nil
+	}
+	i.logger.Info("sync cloudflare tunnel", "tunnel", key, "ingresses", len(partition), "services", len(services))
+
+	params, gone, err := i.tunnelParameters(ctx, key)
+	switch {
+	case err != nil:
+		return reconcile.Result{}, err
+	case gone:
+		// the tunnel was emptied with the parameters, the deleted objects
+		// have nothing left to clean up
+		if err := i.releaseDeleted(ctx, partition, services, released); err != nil {
+			return reconcile.Result{}, err
+		}
+	case isReleased(params):
+		return reconcile.Result{}, i.releaseTunnel(ctx, *params, partition, services, released)
 	}
 
-	if !controlled {
-		i.logger.V(1).Info("ingress is NOT controlled by this controller",
-			"ingress", request.NamespacedName,
-			"controlled-ingress-class", i.ingressClassName,
-			"controlled-controller-class", i.controllerClassName,
-		)
-		return reconcile.Result{
-			Requeue: false,
-		}, nil
-	}
-
-	i.logger.V(1).Info("ingress is controlled by this controller",
-		"ingress", request.NamespacedName,
-		"controlled-ingress-class", i.ingressClassName,
-		"controlled-controller-class", i.controllerClassName,
-	)
+	tunnelClient, err := i.resolveTunnel(ctx, key, classes)
+	if err != nil {
+		err = errors.Wrapf(err, "resolve tunnel %s", key)
+		for _, ingress := range partition {
+			i.recorder.Event(&ingress, v1.EventTypeWarning, EventReasonSyncFailed, err.Error())
+			if ingress.DeletionTimestamp == nil {
+				if statusErr := i.updateSyncStatus(ctx, ingress, newIngressSyncStatus(ingress, "", nil, err)); statusErr != nil {
+					i.logger.Error(statusErr, "update sync status", "ingress", client.ObjectKeyFromObject(&ingress))
+				}
+			}
+		}
+		for _, service := range services {
+			i.reportService(service, v1.EventTypeWarning, EventReasonSyncFailed, err.Error())
+		}
+		return reconcile.Result{}, err
+	}
 
-	i.logger.Info("update cloudflare tunnel config", "triggered-by", request.NamespacedName)
+	for idx := range partition {
+		ingress := &partition[idx]
+		if ingress.DeletionTimestamp == nil {
+			if err := i.attachFinalizer(ctx, ingress); err != nil {
+				return reconcile.Result{}, errors.Wrapf(err, "attach finalizer to ingress %s/%s", ingress.Namespace, ingress.Name)
+			}
+		}
+	}
+	for idx := range services {
+		service := &services[idx]
+		if service.DeletionTimestamp == nil {
+			if err := i.attachFinalizer(ctx, service); err != nil {
+				return reconcile.Result{}, errors.Wrapf(err, "attach finalizer to service %s/%s", service.Namespace, service.Name)
+			}
+		}
+	}
 
-	err = i.attachFinalizer(ctx, *(origin.DeepCopy()))
+	desired, err := i.tunnelExposures(ctx, key, partition, services)
 	if err != nil {
-		return reconcile.Result{}, errors.Wrapf(err, "attach finalizer to ingress %s", request.NamespacedName)
+		return reconcile.Result{}, err
+	}
+	entries, served, rejected, exposed := desired.entries, desired.served, desired.rejected, desired.exposed
+	for idx, service := range services {
+		if desired.unexposed[idx] != nil {
+			i.reportService(service, v1.EventTypeWarning, EventReasonSyncFailed, desired.unexposed[idx].Error())
+		}
+	}
+	allExposures := desired.exposures
+	i.logger.V(3).Info("all exposures", "tunnel", key, "exposures", allExposures)
+
+	// a partial failure only fails the Ingresses of the failed hostnames,
+	// the rest of the tunnel is synced
+	var partial *cloudflarecontroller.PartialSyncError
+	err = i.putExposures(ctx, key, tunnelClient, allExposures)
+	if err != nil && !errors.As(err, &partial) {
+		// report on the Ingresses changed since the last sync, the others
+		// are still served with their previous rules
+		for idx, ingress := range partition {
+			if entries[idx].synced {
+				continue
+			}
+			i.recorder.Event(&ingress, v1.EventTypeWarning, EventReasonSyncFailed, err.Error())
+			if ingress.DeletionTimestamp == nil {
+				if statusErr := i.updateSyncStatus(ctx, ingress, newIngressSyncStatus(ingress, tunnelClient.TunnelDomain(), served[idx], err)); statusErr != nil {
+					i.logger.Error(statusErr, "update sync status", "ingress", client.ObjectKeyFromObject(&ingress))
+				}
+			}
+		}
+		for idx, service := range services {
+			if len(exposed[idx]) > 0 && service.DeletionTimestamp == nil {
+				i.reportService(service, v1.EventTypeWarning, EventReasonSyncFailed, err.Error())
+			}
+		}
+		return reconcile.Result{}, err
 	}
 
-	ingresses, err := i.listControlledIngresses(ctx)
-	if err != nil {
-		return reconcile.Result{}, errors.Wrap(err, "list controlled ingresses")
+	var errs []error
+	if partial != nil {
+		// failing the sync requeues the tunnel with backoff, which retries
+		// the failed hostnames
+		i.logger.Info("some hostnames failed to sync", "tunnel", key, "error", partial.Error())
+		errs = append(errs, partial)
 	}
+	for idx, ingress := range partition {
+		failedErr := failedHostnamesError(partial, served[idx])
+		if failedErr == nil {
+			failedErr = rejected[idx]
+		}
+		if failedErr != nil {
+			// not marked as synced, so the event is reported again until
+			// the hostnames are fixed
+			i.recorder.Event(&ingress, v1.EventTypeWarning, EventReasonSyncFailed, failedErr.Error())
+		} else if !entries[idx].synced {
+			entries[idx].synced = true
+			if ingress.DeletionTimestamp == nil {
+				i.recorder.Event(&ingress, v1.EventTypeNormal, EventReasonSynced, "cloudflare tunnel config and DNS records are up to date")
+			}
+		}
 
-	var allExposures []exposure.Exposure
-	for _, ingress := range ingresses {
-		// best effort to extract exposures from all ingresses
-		exposures, err := FromIngressToExposure(ctx, i.logger, i.kubeClient, i.recorder, ingress, i.clusterDomain)
+		if ingress.DeletionTimestamp != nil {
+			if err := i.cleanFinalizer(ctx, &ingress); err != nil {
+				errs = append(errs, errors.Wrapf(err, "clean finalizer from ingress %s/%s", ingress.Namespace, ingress.Name))
+			}
+			continue
+		}
+		if err := i.updateStatus(ctx, ingress, tunnelClient.TunnelDomain()); err != nil {
+			errs = append(errs, err)
+		}
+		// a partially served Ingress is not synced, its other rules are
+		// live nonetheless
+		syncErr := failedErr
+		if syncErr == nil {
+			syncErr = entries[idx].err
+		}
+		if syncErr == nil {
+			syncErr = withheldError(entries[idx].exposures, served[idx])
+		}
+		if err := i.updateSyncStatus(ctx, ingress, newIngressSyncStatus(ingress, tunnelClient.TunnelDomain(), served[idx], syncErr)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for idx, service := range services {
+		if service.DeletionTimestamp != nil {
+			if err := i.cleanFinalizer(ctx, &service); err != nil {
+				errs = append(errs, errors.Wrapf(err, "clean finalizer from service %s/%s", service.Namespace, service.Name))
+				continue
+			}
+			i.forgetService(service)
+			continue
+		}
+		if len(exposed[idx]) == 0 {
+			// the annotations are invalid, already reported
+			continue
+		}
+		if failedErr := failedHostnamesError(partial, exposed[idx]); failedErr != nil {
+			i.reportService(service, v1.EventTypeWarning, EventReasonSyncFailed, failedErr.Error())
+			continue
+		}
+		item := exposed[idx][0]
+		i.reportService(service, v1.EventTypeNormal, EventReasonSynced, fmt.Sprintf("%s is exposed at %s, connect with: %s", item.ServiceTarget, item.Hostname, clientCommand(service, item)))
+	}
+	// the exposure of a Service is gone with its annotation, it only holds
+	// the finalizer
+	for _, service := range released {
+		if err := i.cleanFinalizer(ctx, &service); err != nil {
+			errs = append(errs, errors.Wrapf(err, "clean finalizer from service %s/%s", service.Namespace, service.Name))
+			continue
+		}
+		i.forgetService(service)
+	}
+	i.exposures.prune(key, partition)
+
+	i.logger.V(3).Info("sync completed", "tunnel", key)
+	return reconcile.Result{RequeueAfter: i.resyncPeriod}, utilerrors.NewAggregate(errs)
+}
+
+// desiredExposures are the exposures a sync puts into a tunnel, with why some
+// exposures of its Ingresses and Services are not served.
+type desiredExposures struct {
+	entries []*cachedExposures
+	// served are the exposures of every Ingress put into the tunnel
+	served [][]exposure.Exposure
+	// rejected are why some exposures of an Ingress are not served
+	rejected []error
+	// exposed are the exposures of every Service put into the tunnel
+	exposed [][]exposure.Exposure
+	// unexposed are why the exposure of a Service is not served
+	unexposed []error
+	// exposures are the exposures of the tunnel, the catch-all included
+	exposures []exposure.Exposure
+}
+
+// tunnelExposures computes the exposures of the Ingresses and the exposed
+// Services of a tunnel, for the sync as for the plan. Nothing is written to
+// Kubernetes, transform failures are only reported with events.
+func (i *IngressController) tunnelExposures(ctx context.Context, key string, partition []networkingv1.Ingress, services []v1.Service) (desiredExposures, error) {
+	result := desiredExposures{
+		entries:   make([]*cachedExposures, len(partition)),
+		served:    make([][]exposure.Exposure, len(partition)),
+		rejected:  make([]error, len(partition)),
+		exposed:   make([][]exposure.Exposure, len(services)),
+		unexposed: make([]error, len(services)),
+	}
+	var err error
+	for idx, ingress := range partition {
+		result.entries[idx], err = i.ingressExposures(ctx, ingress, key)
+		if err != nil {
+			return result, errors.Wrapf(err, "extract exposures from ingress %s/%s", ingress.Namespace, ingress.Name)
+		}
+		result.served[idx], result.rejected[idx], err = i.allowedExposures(ctx, ingress.Namespace, result.entries[idx].exposures)
+		if err != nil {
+			return result, err
+		}
+		if i.accessEnabled {
+			result.served[idx], err = withholdUnprotectedExposures(ctx, i.kubeClient, i.recorder, ingress, result.served[idx])
+			if err != nil {
+				return result, errors.Wrapf(err, "check cloudflare access protection of ingress %s/%s", ingress.Namespace, ingress.Name)
+			}
+		}
+	}
+	for idx, service := range services {
+		allowed, err := i.allowedHostnames(ctx, service.Namespace)
+		if err != nil {
+			return result, err
+		}
+		result.exposed[idx], err = FromServiceToExposure(service, i.clusterDomain, allowed)
+		if err != nil {
+			i.logger.Error(err, "extract exposure from service, skipped", "tunnel", key, "service", client.ObjectKeyFromObject(&service))
+			i.reportService(service, v1.EventTypeWarning, EventReasonTransformFailed, err.Error())
+			continue
+		}
+		result.exposed[idx], result.unexposed[idx], err = i.allowedExposures(ctx, service.Namespace, result.exposed[idx])
 		if err != nil {
-			i.logger.Error(err, "extract exposures from ingress, skipped", "triggered-by", request.NamespacedName, "ingress", fmt.Sprintf("%s/%s", ingress.Namespace, ingress.Name))
-			i.recorder.Event(&ingress, v1.EventTypeWarning, EventReasonTransformFailed, err.Error())
+			return result, err
 		}
-		allExposures = append(allExposures, exposures...)
 	}
-	i.logger.V(3).Info("all exposures", "exposures", allExposures)
 
-	err = i.tunnelClient.PutExposures(ctx, allExposures)
+	// the Ingresses and the Services of the tunnel claim hostnames together
+	claimed, conflicts := hostnameConflicts(i.hostnameConflictPolicy, claimOwners(partition, services), slices.Concat(result.served, result.exposed))
+	result.served, result.exposed = claimed[:len(partition)], claimed[len(partition):]
+	for idx, err := range catchAllConflicts(partition, result.served) {
+		conflicts[idx] = utilerrors.NewAggregate([]error{conflicts[idx], err})
+	}
+	for idx := range partition {
+		result.rejected[idx] = utilerrors.NewAggregate([]error{result.rejected[idx], conflicts[idx]})
+		result.exposures = append(result.exposures, result.served[idx]...)
+	}
+	for idx := range services {
+		result.unexposed[idx] = utilerrors.NewAggregate([]error{result.unexposed[idx], conflicts[len(partition)+idx]})
+		result.exposures = append(result.exposures, result.exposed[idx]...)
+	}
+	result.exposures = i.withCatchAll(result.exposures)
+	return result, nil
+}
+
+// allowedExposures drops the exposures the allowlist of the namespace does not
+// allow, rejected reports them.
+func (i *IngressController) allowedExposures(ctx context.Context, namespace string, exposures []exposure.Exposure) (allowed []exposure.Exposure, rejected error, err error) {
+	allowlist, err := namespaceAllowlist(ctx, i.kubeClient, namespace)
 	if err != nil {
-		i.recorder.Event(&origin, v1.EventTypeWarning, EventReasonSyncFailed, err.Error())
-		return reconcile.Result{}, errors.Wrap(err, "put exposures")
+		return nil, nil, err
+	}
+	allowed, rejected = allowlist.filter(namespace, exposures)
+	return allowed, rejected, nil
+}
+
+// withCatchAll appends the catch-all service of the controller, unless an
+// Ingress provides the catch-all of the tunnel.
+func (i *IngressController) withCatchAll(exposures []exposure.Exposure) []exposure.Exposure {
+	if i.catchAllService == "" || slices.ContainsFunc(exposure.Active(exposures), isCatchAll) {
+		return exposures
 	}
-	if origin.DeletionTimestamp == nil {
-		i.recorder.Event(&origin, v1.EventTypeNormal, EventReasonSynced, "cloudflare tunnel config and DNS records are up to date")
+	return append(exposures, exposure.Exposure{ServiceTarget: i.catchAllService})
+}
+
+// catchAllConflicts reports the Ingresses whose catch-all is ignored, the
+// first Ingress of the tunnel providing one wins.
+func catchAllConflicts(partition []networkingv1.Ingress, served [][]exposure.Exposure) map[int]error {
+	result := map[int]error{}
+	owner := -1
+	for idx := range partition {
+		if !slices.ContainsFunc(exposure.Active(served[idx]), isCatchAll) {
+			continue
+		}
+		if owner < 0 {
+			owner = idx
+			continue
+		}
+		result[idx] = errors.Errorf("default backend is ignored, ingress %s/%s already provides the catch-all of the tunnel", partition[owner].Namespace, partition[owner].Name)
 	}
+	return result
+}
+
+func isCatchAll(item exposure.Exposure) bool {
+	return item.Hostname == ""
+}
+
+// putExposures puts the exposures into the tunnel, unless they are the ones
+// put by the previous sync of the same tunnel. Unchanged exposures are
+// compared with Cloudflare once per resync period, to catch changes made
+// outside of the controller.
+func (i *IngressController) putExposures(ctx context.Context, key string, tunnelClient cloudflarecontroller.TunnelClientInterface, exposures []exposure.Exposure) error {
+	i.mu.Lock()
+	applied, ok := i.applied[key]
+	i.mu.Unlock()
+	tunnelID := strings.TrimSuffix(tunnelClient.TunnelDomain(), ".cfargotunnel.com")
+
+	var drift cloudflarecontroller.Drift
+	if ok && applied.client == tunnelClient && reflect.DeepEqual(applied.exposures, exposures) {
+		if applied.partial != nil {
+			i.logger.Info("retry the hostnames failed to sync", "tunnel", key)
+			return i.forcePutExposures(ctx, key, tunnelClient, exposures, drift)
+		}
+		if i.resyncPeriod <= 0 || time.Since(applied.checkedAt) < i.resyncPeriod {
+			i.logger.V(1).Info("exposures unchanged since the last sync, skipped", "tunnel", key)
+			return nil
+		}
 
-	if origin.DeletionTimestamp != nil {
-		err = i.cleanFinalizer(ctx, origin)
+		var err error
+		drift, err = tunnelClient.DetectDrift(ctx, exposures)
 		if err != nil {
-			return reconcile.Result{}, errors.Wrapf(err, "clean finalizer from ingress %s", request.NamespacedName)
+			return errors.Wrap(err, "detect drift")
+		}
+		metrics.Drift.WithLabelValues(tunnelID, metrics.DriftKindIngressRule).Set(float64(drift.IngressRules))
+		metrics.Drift.WithLabelValues(tunnelID, metrics.DriftKindDNSRecord).Set(float64(drift.DNSRecords))
+		if drift.IsEmpty() || i.driftReportOnly {
+			if !drift.IsEmpty() {
+				i.logger.Info("cloudflare drifted from the desired state, report only", "tunnel", key, "ingress-rules", drift.IngressRules, "dns-records", drift.DNSRecords)
+			}
+			i.mu.Lock()
+			applied.checkedAt = time.Now()
+			i.applied[key] = applied
+			i.mu.Unlock()
+			return nil
+		}
+		i.logger.Info("cloudflare drifted from the desired state, repair", "tunnel", key, "ingress-rules", drift.IngressRules, "dns-records", drift.DNSRecords)
+	}
+	return i.forcePutExposures(ctx, key, tunnelClient, exposures, drift)
+}
+
+// forcePutExposures puts the exposures into the tunnel and remembers them as
+// applied, drift is the drift the put repairs.
+func (i *IngressController) forcePutExposures(ctx context.Context, key string, tunnelClient cloudflarecontroller.TunnelClientInterface, exposures []exposure.Exposure, drift cloudflarecontroller.Drift) error {
+	tunnelID := strings.TrimSuffix(tunnelClient.TunnelDomain(), ".cfargotunnel.com")
+	err := tunnelClient.PutExposures(ctx, exposures)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var partial *cloudflarecontroller.PartialSyncError
+	if err != nil && !errors.As(err, &partial) {
+		delete(i.applied, key)
+		return errors.Wrap(err, "put exposures")
+	}
+	i.applied[key] = appliedExposures{client: tunnelClient, exposures: exposures, checkedAt: time.Now(), partial: partial}
+	if partial != nil {
+		return partial
+	}
+	metrics.DriftRepairs.WithLabelValues(tunnelID, metrics.DriftKindIngressRule).Add(float64(drift.IngressRules))
+	metrics.DriftRepairs.WithLabelValues(tunnelID, metrics.DriftKindDNSRecord).Add(float64(drift.DNSRecords))
+	metrics.Drift.WithLabelValues(tunnelID, metrics.DriftKindIngressRule).Set(0)
+	metrics.Drift.WithLabelValues(tunnelID, metrics.DriftKindDNSRecord).Set(0)
+	return nil
+}
+
+// ingressExposures returns the exposures of the Ingress, computed again only
+// when the Ingress or one of its backend Services changed since the last
+// call.
+func (i *IngressController) ingressExposures(ctx context.Context, ingress networkingv1.Ingress, key string) (*cachedExposures, error) {
+	serviceVersions := map[string]string{}
+	for _, name := range ingressServiceNames(ingress) {
+		service := v1.Service{}
+		err := i.kubeClient.Get(ctx, types.NamespacedName{Namespace: ingress.Namespace, Name: name}, &service)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, errors.Wrapf(err, "fetch service %s/%s", ingress.Namespace, name)
 		}
+		serviceVersions[name] = service.ResourceVersion
+	}
+
+	ingressName := client.ObjectKeyFromObject(&ingress)
+	source := newIngressSource(ingress)
+	allowed, err := i.allowedHostnames(ctx, ingress.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if cached := i.exposures.get(ingressName); cached != nil && cached.source.equal(source) && maps.Equal(cached.serviceVersions, serviceVersions) && cached.allowed.equal(allowed) {
+		cached.tunnelKey = key
+		return cached, nil
 	}
 
-	hostname := i.tunnelClient.TunnelDomain()
-	newOrigin := origin.DeepCopy()
-	matchesHostname := func(ingress networkingv1.IngressLoadBalancerIngress) bool {
+	// best effort to extract exposures from all ingresses
+	exposures, err := FromIngressToExposure(ctx, i.logger, i.kubeClient, i.recorder, ingress, i.clusterDomain, allowed)
+	if err != nil {
+		i.logger.Error(err, "extract exposures from ingress, skipped", "tunnel", key, "ingress", ingressName)
+		i.recorder.Event(&ingress, v1.EventTypeWarning, EventReasonTransformFailed, err.Error())
+	}
+	entry := &cachedExposures{
+		source:          source,
+		serviceVersions: serviceVersions,
+		allowed:         allowed,
+		tunnelKey:       key,
+		exposures:       exposures,
+		err:             err,
+	}
+	i.exposures.put(ingressName, entry)
+	return entry, nil
+}
+
+func (i *IngressController) updateStatus(ctx context.Context, ingress networkingv1.Ingress, hostname string) error {
+	if slices.ContainsFunc(ingress.Status.LoadBalancer.Ingress, func(ingress networkingv1.IngressLoadBalancerIngress) bool {
 		return ingress.Hostname == hostname
+	}) {
+		return nil
 	}
-	if !slices.ContainsFunc(origin.Status.LoadBalancer.Ingress, matchesHostname) {
-		newOrigin.Status.LoadBalancer.Ingress = []networkingv1.IngressLoadBalancerIngress{{
-			Hostname: hostname,
-			Ports: []networkingv1.IngressPortStatus{{
-				Protocol: v1.ProtocolTCP,
-				Port:     443,
-			}},
-		}}
+	ingress.Status.LoadBalancer.Ingress = []networkingv1.IngressLoadBalancerIngress{{
+		Hostname: hostname,
+		Ports: []networkingv1.IngressPortStatus{{
+			Protocol: v1.ProtocolTCP,
+			Port:     443,
+		}},
+	}}
+	if err := i.kubeClient.Status().Update(ctx, &ingress); err != nil {
+		return errors.Wrapf(err, "update ingress %s/%s status", ingress.Namespace, ingress.Name)
 	}
-	if err = i.kubeClient.Status().Update(ctx, newOrigin); err != nil {
-		return reconcile.Result{}, errors.Wrapf(err, "failed to update ingress status")
+	return nil
+}
+
+// tunnelKey returns the key of the tunnel the Ingress is routed to, and false
+// when the Ingress is not controlled by this controller.
+func (i *IngressController) tunnelKey(ingress networkingv1.Ingress, classes []networkingv1.IngressClass) (string, bool) {
+	className := i.ingressClassName
+	if ingress.GetAnnotations()[WellKnownIngressAnnotation] != i.ingressClassName {
+		if ingress.Spec.IngressClassName == nil {
+			return "", false
+		}
+		className = *ingress.Spec.IngressClassName
 	}
+	return i.classTunnelKey(className, classes)
+}
 
-	i.logger.V(3).Info("reconcile completed", "triggered-by", request.NamespacedName)
-	return reconcile.Result{}, nil
+// classTunnelKey returns the key of the tunnel of the IngressClass, and false
+// when the class is not controlled by this controller.
+func (i *IngressController) classTunnelKey(className string, classes []networkingv1.IngressClass) (string, bool) {
+	classIndex := slices.IndexFunc(classes, func(ingressClass networkingv1.IngressClass) bool {
+		return ingressClass.Name == className
+	})
+	if classIndex < 0 {
+		// the annotation does not require an IngressClass object
+		return defaultTunnelKey, className == i.ingressClassName
+	}
+	return ingressClassTunnelKey(classes[classIndex]), true
 }
 
-func (i *IngressController) isControlledByThisController(ctx context.Context, target networkingv1.Ingress) (bool, error) {
-	if i.ingressClassName == target.GetAnnotations()[WellKnownIngressAnnotation] {
-		return true, nil
+// ingressClassTunnelKey returns the key of the tunnel the Ingresses of the
+// class are routed to.
+func ingressClassTunnelKey(ingressClass networkingv1.IngressClass) string {
+	params, err := ingressClassTunnelParameters(ingressClass)
+	if err != nil {
+		// an Ingress meant for another trust zone must never fall back to
+		// the default tunnel
+		return invalidClassTunnelKeyPrefix + ingressClass.Name
 	}
+	if params == "" {
+		return defaultTunnelKey
+	}
+	return params
+}
 
-	if target.Spec.IngressClassName == nil {
-		return false, nil
+func (i *IngressController) resolveTunnel(ctx context.Context, key string, classes []networkingv1.IngressClass) (cloudflarecontroller.TunnelClientInterface, error) {
+	if key == defaultTunnelKey {
+		return i.tunnelClient, nil
+	}
+	if className, ok := strings.CutPrefix(key, invalidClassTunnelKeyPrefix); ok {
+		for _, ingressClass := range classes {
+			if ingressClass.Name != className {
+				continue
+			}
+			if _, err := ingressClassTunnelParameters(ingressClass); err != nil {
+				return nil, err
+			}
+		}
+		return nil, errors.Errorf("ingress class %s has invalid parameters", className)
 	}
 
-	controlledIngressClasses, err := i.listControlledIngressClasses(ctx)
+	if i.tunnels == nil {
+		return nil, errors.Errorf("tunnel parameters %s are referenced but the TunnelParameters CRD is not enabled", key)
+	}
+	params := v1alpha1.TunnelParameters{}
+	if err := i.kubeClient.Get(ctx, types.NamespacedName{Name: key}, &params); err != nil {
+		return nil, errors.Wrapf(err, "fetch tunnel parameters %s", key)
+	}
+	tunnelClient, err := i.tunnels.ClientFor(ctx, params)
 	if err != nil {
-		return false, errors.Wrapf(err, "fetch controlled ingress classes with controller name %s", i.controllerClassName)
+		return nil, errors.Wrapf(err, "resolve tunnel of tunnel parameters %s", key)
 	}
+	return tunnelClient, nil
+}
 
-	var controlledIngressClassNames []string
-	for _, controlledIngressClass := range controlledIngressClasses {
-		controlledIngressClassNames = append(controlledIngressClassNames, controlledIngressClass.Name)
+// releaseTunnel empties the tunnel of deleted TunnelParameters: every
+// hostname of its Ingresses and Services and of the last sync is put as
+// deleted, which removes the tunnel ingress rules and the DNS records owned by
+// the tunnel. The Ingresses and Services are released, then the parameters.
+// Without credentials left to reach Cloudflare the tunnel is left as is.
+func (i *IngressController) releaseTunnel(ctx context.Context, params v1alpha1.TunnelParameters, partition []networkingv1.Ingress, services []v1.Service, released []v1.Service) error {
+	key := params.Name
+	tunnelClient, err := i.tunnels.ClientFor(ctx, params)
+	switch {
+	case apierrors.IsNotFound(err):
+		i.recorder.Event(&params, v1.EventTypeWarning, EventReasonSyncFailed, fmt.Sprintf("tunnel %s is left in cloudflare with its DNS records: %s", params.Spec.TunnelName, err))
+	case err != nil:
+		i.recorder.Event(&params, v1.EventTypeWarning, EventReasonSyncFailed, err.Error())
+		return errors.Wrapf(err, "resolve tunnel of tunnel parameters %s", key)
+	default:
+		deleted, err := i.releasedExposures(ctx, key, partition, services)
+		if err != nil {
+			return err
+		}
+		i.logger.Info("empty the tunnel of deleted tunnel parameters", "tunnel", key, "hostnames", len(deleted))
+		if err := tunnelClient.PutExposures(ctx, deleted); err != nil {
+			i.recorder.Event(&params, v1.EventTypeWarning, EventReasonSyncFailed, err.Error())
+			return errors.Wrapf(err, "remove the exposures of tunnel parameters %s", key)
+		}
+	}
+
+	for idx := range partition {
+		if err := i.cleanFinalizer(ctx, &partition[idx]); err != nil {
+			return errors.Wrapf(err, "clean finalizer from ingress %s/%s", partition[idx].Namespace, partition[idx].Name)
+		}
+	}
+	for _, service := range slices.Concat(services, released) {
+		if err := i.cleanFinalizer(ctx, &service); err != nil {
+			return errors.Wrapf(err, "clean finalizer from service %s/%s", service.Namespace, service.Name)
+		}
+		i.forgetService(service)
 	}
+	i.mu.Lock()
+	delete(i.applied, key)
+	i.mu.Unlock()
+	i.exposures.prune(key, nil)
+	i.tunnels.Forget(key)
 
-	if slices.Contains(controlledIngressClassNames, *target.Spec.IngressClassName) {
-		return true, nil
+	params.Finalizers = slices.DeleteFunc(params.Finalizers, func(f string) bool {
+		return f == IngressControllerFinalizer
+	})
+	if err := i.kubeClient.Update(ctx, &params); err != nil {
+		return errors.Wrapf(err, "clean finalizer for tunnel parameters %s", key)
+	}
+	return nil
+}
+
+// releasedExposures deletes every hostname of a tunnel whose TunnelParameters
+// are deleted, the ones of its objects and the ones applied last.
+func (i *IngressController) releasedExposures(ctx context.Context, key string, partition []networkingv1.Ingress, services []v1.Service) ([]exposure.Exposure, error) {
+	var hostnames []string
+	for _, ingress := range partition {
+		entry, err := i.ingressExposures(ctx, ingress, key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "extract exposures from ingress %s/%s", ingress.Namespace, ingress.Name)
+		}
+		for _, item := range entry.exposures {
+			hostnames = append(hostnames, item.Hostname)
+		}
+	}
+	for _, service := range services {
+		exposures, _ := FromServiceToExposure(service, i.clusterDomain, nil)
+		for _, item := range exposures {
+			hostnames = append(hostnames, item.Hostname)
+		}
 	}
+	i.mu.Lock()
+	for _, item := range i.applied[key].exposures {
+		hostnames = append(hostnames, item.Hostname)
+	}
+	i.mu.Unlock()
 
-	return false, nil
+	slices.Sort(hostnames)
+	var deleted []exposure.Exposure
+	for _, hostname := range slices.Compact(hostnames) {
+		if hostname != "" {
+			deleted = append(deleted, exposure.Exposure{Hostname: hostname, IsDeleted: true})
+		}
+	}
+	return deleted, nil
+}
+
+// tunnelParameters returns the TunnelParameters of a tunnel, nil for the
+// tunnels without, and whether they are gone.
+func (i *IngressController) tunnelParameters(ctx context.Context, key string) (*v1alpha1.TunnelParameters, bool, error) {
+	if i.tunnels == nil || key == defaultTunnelKey || strings.HasPrefix(key, invalidClassTunnelKeyPrefix) {
+		return nil, false, nil
+	}
+	params := v1alpha1.TunnelParameters{}
+	err := i.kubeClient.Get(ctx, types.NamespacedName{Name: key}, &params)
+	if apierrors.IsNotFound(err) {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "fetch tunnel parameters %s", key)
+	}
+	return &params, false, nil
+}
+
+// isReleased tells whether the tunnel of the TunnelParameters is emptied
+// before the parameters are gone.
+func isReleased(params *v1alpha1.TunnelParameters) bool {
+	return params != nil && params.DeletionTimestamp != nil && slices.Contains(params.Finalizers, IngressControllerFinalizer)
+}
+
+// releaseDeleted releases the deleted Ingresses and Services of a tunnel that
+// is gone, and the Services no longer exposed.
+func (i *IngressController) releaseDeleted(ctx context.Context, partition []networkingv1.Ingress, services []v1.Service, released []v1.Service) error {
+	for idx := range partition {
+		if partition[idx].DeletionTimestamp == nil {
+			continue
+		}
+		if err := i.cleanFinalizer(ctx, &partition[idx]); err != nil {
+			return errors.Wrapf(err, "clean finalizer from ingress %s/%s", partition[idx].Namespace, partition[idx].Name)
+		}
+	}
+	for _, service := range slices.Concat(services, released) {
+		if isExposedService(service) && service.DeletionTimestamp == nil {
+			continue
+		}
+		if err := i.cleanFinalizer(ctx, &service); err != nil {
+			return errors.Wrapf(err, "clean finalizer from service %s/%s", service.Namespace, service.Name)
+		}
+		i.forgetService(service)
+	}
+	return nil
+}
+
+// tunnelKeysForIngress maps an Ingress to the tunnel it is routed to.
+func (i *IngressController) tunnelKeysForIngress(ctx context.Context, object client.Object) []string {
+	ingress, ok := object.(*networkingv1.Ingress)
+	if !ok {
+		return nil
+	}
+	classes, err := i.listControlledIngressClasses(ctx)
+	if err != nil {
+		i.logger.Error(err, "list ingress classes, sync skipped", "ingress", client.ObjectKeyFromObject(ingress))
+		return nil
+	}
+	key, controlled := i.tunnelKey(*ingress, classes)
+	if !controlled {
+		return nil
+	}
+	return []string{key}
+}
+
+// tunnelKeysForAccess maps a CloudflareAccess object to the tunnels of the
+// Ingresses it protects.
+func (i *IngressController) tunnelKeysForAccess(ctx context.Context, object client.Object) []string {
+	var result []string
+	for _, request := range ingressesForAccess(ctx, object) {
+		ingress := networkingv1.Ingress{}
+		if err := i.kubeClient.Get(ctx, request.NamespacedName, &ingress); err != nil {
+			continue
+		}
+		result = append(result, i.tunnelKeysForIngress(ctx, &ingress)...)
+	}
+	return result
+}
+
+// tunnelKeysForService maps a Service to the tunnels of the Ingresses using
+// it as backend, so a renamed port or a switch to ExternalName is synced
+// without waiting for an Ingress event, and an exposed Service to its own
+// tunnel.
+func (i *IngressController) tunnelKeysForService(ctx context.Context, object client.Object) []string {
+	var result []string
+	if service, ok := object.(*v1.Service); ok && isExposedService(*service) {
+		classes, err := i.listControlledIngressClasses(ctx)
+		if err != nil {
+			i.logger.Error(err, "list ingress classes, sync skipped", "service", client.ObjectKeyFromObject(object))
+			return nil
+		}
+		if key, controlled := i.serviceTunnelKey(*service, classes); controlled {
+			result = append(result, key)
+		}
+	}
+
+	list := networkingv1.IngressList{}
+	err := i.kubeClient.List(ctx, &list,
+		client.InNamespace(object.GetNamespace()),
+		client.MatchingFields{ingressServiceIndex: object.GetName()},
+	)
+	if err != nil {
+		i.logger.Error(err, "list ingresses of service, sync skipped", "service", client.ObjectKeyFromObject(object))
+		return result
+	}
+	for _, ingress := range list.Items {
+		for _, key := range i.tunnelKeysForIngress(ctx, &ingress) {
+			if !slices.Contains(result, key) {
+				result = append(result, key)
+			}
+		}
+	}
+	return result
+}
+
+// tunnelKeysForIngressClass maps an IngressClass to the tunnel its Ingresses
+// are routed to. Together with the previous version of the class on update,
+// Ingresses moved by a change of parameters leave their previous tunnel.
+func (i *IngressController) tunnelKeysForIngressClass(_ context.Context, object client.Object) []string {
+	ingressClass, ok := object.(*networkingv1.IngressClass)
+	if !ok || ingressClass.Spec.Controller != i.controllerClassName {
+		return nil
+	}
+	return []string{ingressClassTunnelKey(*ingressClass)}
+}
+
+// tunnelKeysForTunnelParameters maps a TunnelParameters object to its tunnel.
+func tunnelKeysForTunnelParameters(_ context.Context, object client.Object) []string {
+	return []string{object.GetName()}
 }
 
 func (i *IngressController) listControlledIngressClasses(ctx context.Context) ([]networkingv1.IngressClass, error) {
@@ -214,28 +924,30 @@ func (i *IngressController) listControlledIngresses(ctx context.Context) ([]netw
 	return result, nil
 }
 
-func (i *IngressController) attachFinalizer(ctx context.Context, ingress networkingv1.Ingress) error {
-	if slices.Contains(ingress.Finalizers, IngressControllerFinalizer) {
+// attachFinalizer updates the Ingress or the Service in place, so later
+// updates in the same sync do not conflict.
+func (i *IngressController) attachFinalizer(ctx context.Context, object client.Object) error {
+	if slices.Contains(object.GetFinalizers(), IngressControllerFinalizer) {
 		return nil
 	}
-	ingress.Finalizers = append(ingress.Finalizers, IngressControllerFinalizer)
-	err := i.kubeClient.Update(ctx, &ingress)
+	object.SetFinalizers(append(object.GetFinalizers(), IngressControllerFinalizer))
+	err := i.kubeClient.Update(ctx, object)
 	if err != nil {
-		return errors.Wrapf(err, "attach finalizer for %s/%s", ingress.Namespace, ingress.Name)
+		return errors.Wrapf(err, "attach finalizer for %s/%s", object.GetNamespace(), object.GetName())
 	}
 	return nil
 }
 
-func (i *IngressController) cleanFinalizer(ctx context.Context, ingress networkingv1.Ingress) error {
-	if !slices.Contains(ingress.Finalizers, IngressControllerFinalizer) {
+func (i *IngressController) cleanFinalizer(ctx context.Context, object client.Object) error {
+	if !slices.Contains(object.GetFinalizers(), IngressControllerFinalizer) {
 		return nil
 	}
-	ingress.Finalizers = slices.DeleteFunc(ingress.Finalizers, func(f string) bool {
+	object.SetFinalizers(slices.DeleteFunc(object.GetFinalizers(), func(f string) bool {
 		return f == IngressControllerFinalizer
-	})
-	err := i.kubeClient.Update(ctx, &ingress)
+	}))
+	err := i.kubeClient.Update(ctx, object)
 	if err != nil {
-		return errors.Wrapf(err, "clean finalizer for %s/%s", ingress.Namespace, ingress.Name)
+		return errors.Wrapf(err, "clean finalizer for %s/%s", object.GetNamespace(), object.GetName())
 	}
 	return nil
 }
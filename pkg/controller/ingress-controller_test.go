@@ -0,0 +1,385 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller/fake"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/metrics"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newTestIngressController(kubeClient client.Client, tunnel *fakeGatewayTunnelClient) *IngressController {
+	return NewIngressController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cloudflare-tunnel", testControllerClass, "cluster.local", "", HostnameConflictPolicyFirstOwner, tunnel, nil, false, false, DriftOptions{})
+}
+
+func syncTunnel(t *testing.T, controller *IngressController, key string) {
+	t.Helper()
+	_, err := controller.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: key}})
+	require.NoError(t, err)
+}
+
+func TestIngressControllerSkipsUnchangedTunnel(t *testing.T) {
+	kubeClient := newTunnelParametersTestClient(t,
+		testTunnelIngressClass("cloudflare-tunnel", ""),
+		testAccessService(),
+		testTunnelIngress("web", "web.example.com", "cloudflare-tunnel"),
+	)
+	tunnel := &fakeGatewayTunnelClient{name: "default"}
+	controller := newTestIngressController(kubeClient, tunnel)
+
+	syncTunnel(t, controller, defaultTunnelKey)
+	require.Equal(t, 1, tunnel.puts)
+
+	ingress := networkingv1.Ingress{}
+	require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "web"}, &ingress))
+	assert.Contains(t, ingress.Finalizers, IngressControllerFinalizer)
+	require.Len(t, ingress.Status.LoadBalancer.Ingress, 1)
+	assert.Equal(t, "default.cfargotunnel.com", ingress.Status.LoadBalancer.Ingress[0].Hostname)
+
+	syncTunnel(t, controller, defaultTunnelKey)
+	assert.Equal(t, 1, tunnel.puts, "unchanged exposures must not be put again")
+
+	ingress.Spec.Rules[0].Host = "www.example.com"
+	require.NoError(t, kubeClient.Update(context.Background(), &ingress))
+	syncTunnel(t, controller, defaultTunnelKey)
+	assert.Equal(t, 2, tunnel.puts)
+	require.Len(t, tunnel.exposures, 1)
+	assert.Equal(t, "www.example.com", tunnel.exposures[0].Hostname)
+}
+
+func TestIngressControllerCachesExposures(t *testing.T) {
+	service := testAccessService()
+	kubeClient := newTunnelParametersTestClient(t,
+		testTunnelIngressClass("cloudflare-tunnel", ""),
+		service,
+		testTunnelIngress("web", "web.example.com", "cloudflare-tunnel"),
+	)
+	tunnel := &fakeGatewayTunnelClient{name: "default"}
+	controller := newTestIngressController(kubeClient, tunnel)
+	name := types.NamespacedName{Namespace: "default", Name: "web"}
+
+	syncTunnel(t, controller, defaultTunnelKey)
+	first := controller.exposures.get(name)
+	require.NotNil(t, first)
+	assert.True(t, first.synced)
+
+	syncTunnel(t, controller, defaultTunnelKey)
+	assert.Same(t, first, controller.exposures.get(name))
+
+	service.Spec.Ports = []v1.ServicePort{{Name: "http", Port: 80}}
+	require.NoError(t, kubeClient.Update(context.Background(), service))
+	syncTunnel(t, controller, defaultTunnelKey)
+	assert.NotSame(t, first, controller.exposures.get(name), "a changed backend Service must invalidate the exposures")
+
+	ingress := networkingv1.Ingress{}
+	require.NoError(t, kubeClient.Get(context.Background(), name, &ingress))
+	require.NoError(t, kubeClient.Delete(context.Background(), &ingress))
+	syncTunnel(t, controller, defaultTunnelKey)
+	require.Len(t, tunnel.exposures, 1)
+	assert.True(t, tunnel.exposures[0].IsDeleted)
+	// the finalizer is gone, the next sync drops the entry
+	syncTunnel(t, controller, defaultTunnelKey)
+	assert.Empty(t, tunnel.exposures)
+	assert.Nil(t, controller.exposures.get(name))
+}
+
+func TestEnqueueTunnelSync(t *testing.T) {
+	kubeClient := newTunnelParametersTestClient(t,
+		testTunnelIngressClass("cloudflare-tunnel", ""),
+		testTunnelIngressClass("cloudflare-tunnel-staging", "staging"),
+	)
+	controller := newTestIngressController(kubeClient, &fakeGatewayTunnelClient{name: "default"})
+	eventHandler := enqueueTunnelSync(0, controller.tunnelKeysForIngress)
+	queue := workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[reconcile.Request]())
+	defer queue.ShutDown()
+
+	oldIngress := testTunnelIngress("web", "web.example.com", "cloudflare-tunnel")
+	newIngress := oldIngress.DeepCopy()
+	newIngress.Spec.IngressClassName = ptr.To("cloudflare-tunnel-staging")
+	eventHandler.Update(context.Background(), event.UpdateEvent{ObjectOld: oldIngress, ObjectNew: newIngress}, queue)
+	eventHandler.Create(context.Background(), event.CreateEvent{Object: oldIngress}, queue)
+	foreign := testTunnelIngress("foreign", "foreign.example.com", "nginx")
+	eventHandler.Create(context.Background(), event.CreateEvent{Object: foreign}, queue)
+
+	// the Ingress leaves the default tunnel and joins the staging one, the
+	// second event on the default tunnel collapses into the pending sync
+	require.Equal(t, 2, queue.Len())
+	var keys []string
+	for queue.Len() > 0 {
+		request, _ := queue.Get()
+		keys = append(keys, request.Name)
+		queue.Done(request)
+	}
+	assert.ElementsMatch(t, []string{defaultTunnelKey, "staging"}, keys)
+}
+
+func TestTunnelKeysForService(t *testing.T) {
+	other := testTunnelIngress("other", "other.example.com", "cloudflare-tunnel-staging")
+	other.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name = "other"
+	kubeClient := newTunnelParametersTestClient(t,
+		testTunnelIngressClass("cloudflare-tunnel", ""),
+		testTunnelIngressClass("cloudflare-tunnel-staging", "staging"),
+		testTunnelIngress("web", "web.example.com", "cloudflare-tunnel"),
+		testTunnelIngress("preview", "preview.example.com", "cloudflare-tunnel-staging"),
+		testTunnelIngress("www", "www.example.com", "cloudflare-tunnel"),
+		other,
+	)
+	controller := newTestIngressController(kubeClient, &fakeGatewayTunnelClient{name: "default"})
+
+	keys := controller.tunnelKeysForService(context.Background(), testAccessService())
+	assert.ElementsMatch(t, []string{defaultTunnelKey, "staging"}, keys)
+
+	unused := testAccessService()
+	unused.Name = "unused"
+	assert.Empty(t, controller.tunnelKeysForService(context.Background(), unused))
+}
+
+func TestTunnelKeysForIngressClass(t *testing.T) {
+	controller := newTestIngressController(newTunnelParametersTestClient(t), &fakeGatewayTunnelClient{name: "default"})
+
+	assert.Equal(t, []string{defaultTunnelKey}, controller.tunnelKeysForIngressClass(context.Background(), testTunnelIngressClass("cloudflare-tunnel", "")))
+	assert.Equal(t, []string{"staging"}, controller.tunnelKeysForIngressClass(context.Background(), testTunnelIngressClass("cloudflare-tunnel-staging", "staging")))
+
+	invalid := testTunnelIngressClass("cloudflare-tunnel-invalid", "staging")
+	invalid.Spec.Parameters.Kind = "Unknown"
+	assert.Equal(t, []string{invalidClassTunnelKeyPrefix + "cloudflare-tunnel-invalid"}, controller.tunnelKeysForIngressClass(context.Background(), invalid))
+
+	foreign := testTunnelIngressClass("nginx", "")
+	foreign.Spec.Controller = "k8s.io/ingress-nginx"
+	assert.Empty(t, controller.tunnelKeysForIngressClass(context.Background(), foreign))
+}
+
+func TestIngressControllerDriftCheck(t *testing.T) {
+	for _, reportOnly := range []bool{false, true} {
+		t.Run(fmt.Sprintf("report-only=%t", reportOnly), func(t *testing.T) {
+			kubeClient := newTunnelParametersTestClient(t,
+				testTunnelIngressClass("cloudflare-tunnel", ""),
+				testAccessService(),
+				testTunnelIngress("web", "web.example.com", "cloudflare-tunnel"),
+			)
+			tunnel := &fakeGatewayTunnelClient{name: "drift"}
+			controller := NewIngressController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cloudflare-tunnel", testControllerClass, "cluster.local", "", HostnameConflictPolicyFirstOwner, tunnel, nil, false, false, DriftOptions{
+				ResyncPeriod: time.Nanosecond,
+				ReportOnly:   reportOnly,
+			})
+
+			result, err := controller.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: defaultTunnelKey}})
+			require.NoError(t, err)
+			assert.Equal(t, time.Nanosecond, result.RequeueAfter)
+			require.Equal(t, 1, tunnel.puts)
+
+			// nothing drifted, nothing is put again
+			syncTunnel(t, controller, defaultTunnelKey)
+			assert.Equal(t, 1, tunnel.puts)
+
+			tunnel.drift = cloudflarecontroller.Drift{IngressRules: 1, DNSRecords: 2}
+			syncTunnel(t, controller, defaultTunnelKey)
+			if reportOnly {
+				assert.Equal(t, 1, tunnel.puts)
+				assert.Equal(t, float64(2), testutil.ToFloat64(metrics.Drift.WithLabelValues("drift", metrics.DriftKindDNSRecord)))
+			} else {
+				assert.Equal(t, 2, tunnel.puts)
+				assert.Equal(t, float64(0), testutil.ToFloat64(metrics.Drift.WithLabelValues("drift", metrics.DriftKindDNSRecord)))
+			}
+		})
+	}
+}
+
+func TestIngressControllerSyncStatus(t *testing.T) {
+	kubeClient := newTunnelParametersTestClient(t,
+		testTunnelIngressClass("cloudflare-tunnel", ""),
+		testAccessService(),
+		testTunnelIngress("web", "web.example.com", "cloudflare-tunnel"),
+	)
+	tunnel := &fakeGatewayTunnelClient{name: "default"}
+	controller := newTestIngressController(kubeClient, tunnel)
+	name := types.NamespacedName{Namespace: "default", Name: "web"}
+	getIngress := func() networkingv1.Ingress {
+		ingress := networkingv1.Ingress{}
+		require.NoError(t, kubeClient.Get(context.Background(), name, &ingress))
+		return ingress
+	}
+
+	syncTunnel(t, controller, defaultTunnelKey)
+	ingress := getIngress()
+	status := ingressSyncStatus(ingress)
+	require.NotNil(t, status)
+	assert.True(t, status.Synced)
+	assert.Equal(t, "default.cfargotunnel.com", status.Tunnel)
+	assert.NotNil(t, status.LastSyncTime)
+	assert.Equal(t, []IngressSyncRule{{Hostname: "web.example.com", Path: "/", Service: "http://app.default.svc.cluster.local:80"}}, status.Rules)
+	assert.Equal(t, []cloudflarecontroller.OwnedDNSRecord{
+		{Type: "CNAME", Name: "web.example.com", Content: "default.cfargotunnel.com"},
+		{Type: "TXT", Name: "_ctic_managed.web.example.com"},
+	}, status.DNSRecords)
+	assert.Equal(t, "Synced: 1 rules, 2 dns records", ingress.Annotations[AnnotationSyncSummary])
+	// the status annotations are not an input of the exposures
+	cached := controller.exposures.get(name)
+	assert.False(t, ingressSourceChanged.Update(event.UpdateEvent{ObjectOld: testTunnelIngress("web", "web.example.com", "cloudflare-tunnel"), ObjectNew: &ingress}))
+
+	syncTunnel(t, controller, defaultTunnelKey)
+	unchanged := getIngress()
+	assert.Equal(t, ingress.ResourceVersion, unchanged.ResourceVersion, "an unchanged status must not be written")
+	assert.Same(t, cached, controller.exposures.get(name))
+
+	tunnel.putErr = fmt.Errorf("zone not found")
+	unchanged.Spec.Rules[0].Host = "www.example.com"
+	require.NoError(t, kubeClient.Update(context.Background(), &unchanged))
+	_, err := controller.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: defaultTunnelKey}})
+	require.Error(t, err)
+	failed := ingressSyncStatus(getIngress())
+	require.NotNil(t, failed)
+	assert.False(t, failed.Synced)
+	assert.Contains(t, failed.LastError, "zone not found")
+	assert.Equal(t, status.LastSyncTime, failed.LastSyncTime)
+	assert.Equal(t, "Failed: put exposures: zone not found", getIngress().Annotations[AnnotationSyncSummary])
+}
+
+func TestIngressControllerIsolatesFailedHostnames(t *testing.T) {
+	kubeClient := newTunnelParametersTestClient(t,
+		testTunnelIngressClass("cloudflare-tunnel", ""),
+		testAccessService(),
+		testTunnelIngress("web", "web.example.com", "cloudflare-tunnel"),
+		testTunnelIngress("typo", "typo.exmaple.com", "cloudflare-tunnel"),
+	)
+	tunnel := &fakeGatewayTunnelClient{name: "default", putErr: &cloudflarecontroller.PartialSyncError{
+		Failures: []cloudflarecontroller.ExposureError{{Hostname: "typo.exmaple.com", Reason: "hostname typo.exmaple.com not belong to any zone"}},
+	}}
+	controller := newTestIngressController(kubeClient, tunnel)
+	getStatus := func(name string) *IngressSyncStatus {
+		ingress := networkingv1.Ingress{}
+		require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: name}, &ingress))
+		return ingressSyncStatus(ingress)
+	}
+
+	// the failed hostname does not fail the other ones, the sync fails to be
+	// requeued with backoff
+	_, err := controller.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: defaultTunnelKey}})
+	assert.ErrorContains(t, err, "hostname typo.exmaple.com not belong to any zone")
+	assert.Equal(t, 1, tunnel.puts)
+	assert.Len(t, tunnel.exposures, 2)
+	web := getStatus("web")
+	require.NotNil(t, web)
+	assert.True(t, web.Synced)
+	typo := getStatus("typo")
+	require.NotNil(t, typo)
+	assert.False(t, typo.Synced)
+	assert.Equal(t, "hostname typo.exmaple.com not belong to any zone", typo.LastError)
+	assert.Contains(t, <-controller.recorder.(*record.FakeRecorder).Events, "hostname typo.exmaple.com not belong to any zone")
+
+	// the requeued sync retries the unchanged exposures
+	_, err = controller.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: defaultTunnelKey}})
+	assert.Error(t, err)
+	assert.Equal(t, 2, tunnel.puts)
+	assert.False(t, getStatus("typo").Synced)
+
+	tunnel.putErr = nil
+	syncTunnel(t, controller, defaultTunnelKey)
+	assert.Equal(t, 3, tunnel.puts)
+	syncTunnel(t, controller, defaultTunnelKey)
+	assert.Equal(t, 3, tunnel.puts, "a successful put is not retried")
+
+	typoIngress := networkingv1.Ingress{}
+	require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "typo"}, &typoIngress))
+	typoIngress.Spec.Rules[0].Host = "typo.example.com"
+	require.NoError(t, kubeClient.Update(context.Background(), &typoIngress))
+	syncTunnel(t, controller, defaultTunnelKey)
+	assert.Equal(t, 4, tunnel.puts)
+	assert.True(t, getStatus("typo").Synced)
+	assert.True(t, getStatus("web").Synced)
+}
+
+func TestIngressControllerCatchAll(t *testing.T) {
+	catchAllIngress := func(name string) *networkingv1.Ingress {
+		return &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec: networkingv1.IngressSpec{
+				IngressClassName: ptr.To("cloudflare-tunnel"),
+				DefaultBackend: &networkingv1.IngressBackend{
+					Service: &networkingv1.IngressServiceBackend{Name: "app", Port: networkingv1.ServiceBackendPort{Number: 80}},
+				},
+			},
+		}
+	}
+	kubeClient := newTunnelParametersTestClient(t,
+		testTunnelIngressClass("cloudflare-tunnel", ""),
+		testAccessService(),
+		testTunnelIngress("web", "web.example.com", "cloudflare-tunnel"),
+	)
+	tunnel := &fakeGatewayTunnelClient{name: "default"}
+	controller := NewIngressController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cloudflare-tunnel", testControllerClass, "cluster.local", "http_status:503", HostnameConflictPolicyFirstOwner, tunnel, nil, false, false, DriftOptions{})
+
+	// the catch-all of the controller applies when no Ingress provides one
+	syncTunnel(t, controller, defaultTunnelKey)
+	require.Len(t, tunnel.exposures, 2)
+	assert.Equal(t, exposure.Exposure{ServiceTarget: "http_status:503"}, tunnel.exposures[1])
+
+	require.NoError(t, kubeClient.Create(context.Background(), catchAllIngress("fallback")))
+	require.NoError(t, kubeClient.Create(context.Background(), catchAllIngress("maintenance")))
+	syncTunnel(t, controller, defaultTunnelKey)
+	require.Len(t, tunnel.exposures, 3)
+	assert.Empty(t, tunnel.exposures[0].Hostname)
+	assert.Equal(t, "http://app.default.svc.cluster.local:80", tunnel.exposures[0].ServiceTarget)
+
+	getStatus := func(name string) *IngressSyncStatus {
+		ingress := networkingv1.Ingress{}
+		require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: name}, &ingress))
+		return ingressSyncStatus(ingress)
+	}
+	assert.True(t, getStatus("fallback").Synced)
+	ignored := getStatus("maintenance")
+	require.NotNil(t, ignored)
+	assert.False(t, ignored.Synced)
+	assert.Equal(t, "default backend is ignored, ingress default/fallback already provides the catch-all of the tunnel", ignored.LastError)
+}
+
+func TestIngressControllerOnFakeCloudflare(t *testing.T) {
+	ctx := context.Background()
+	api := fake.NewCloudflare("account")
+	api.AddZone("example.com")
+	tunnelClient, err := cloudflarecontroller.BootstrapTunnelClientWithTunnelName(ctx, logr.Discard(), api, "account", "tunnel", cloudflarecontroller.DNSOptions{})
+	require.NoError(t, err)
+
+	kubeClient := newTunnelParametersTestClient(t,
+		testTunnelIngressClass("cloudflare-tunnel", ""),
+		testAccessService(),
+		testTunnelIngress("web", "web.example.com", "cloudflare-tunnel"),
+	)
+	controller := NewIngressController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cloudflare-tunnel", testControllerClass, "cluster.local", "", HostnameConflictPolicyFirstOwner, tunnelClient, nil, false, false, DriftOptions{})
+	syncTunnel(t, controller, defaultTunnelKey)
+
+	tunnelID := api.Tunnels()[0].ID
+	ingress := api.TunnelConfiguration(tunnelID).Ingress
+	require.Len(t, ingress, 2)
+	assert.Equal(t, "web.example.com", ingress[0].Hostname)
+	assert.Equal(t, "http://app.default.svc.cluster.local:80", ingress[0].Service)
+	records := api.DNSRecords("example.com")
+	require.Len(t, records, 2)
+	assert.Equal(t, "CNAME", records[1].Type)
+	assert.Equal(t, tunnelClient.TunnelDomain(), records[1].Content)
+
+	web := networkingv1.Ingress{}
+	require.NoError(t, kubeClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &web))
+	require.NoError(t, kubeClient.Delete(ctx, &web))
+	syncTunnel(t, controller, defaultTunnelKey)
+	assert.Empty(t, api.DNSRecords("example.com"))
+	assert.Len(t, api.TunnelConfiguration(tunnelID).Ingress, 1)
+}
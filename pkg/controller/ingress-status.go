@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/pkg/errors"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AnnotationSyncStatus holds the IngressSyncStatus of the Ingress as JSON,
+// written by the controller.
+const AnnotationSyncStatus = "cloudflare-tunnel-ingress-controller.strrl.dev/sync-status"
+
+// AnnotationSyncSummary holds a one line summary of AnnotationSyncStatus,
+// "Synced: ..." or "Failed: ...", written by the controller.
+const AnnotationSyncSummary = "cloudflare-tunnel-ingress-controller.strrl.dev/sync-summary"
+
+// maxSyncSummaryLength keeps the summary readable in a kubectl column.
+const maxSyncSummaryLength = 256
+
+// IngressSyncStatus reports how the Ingress is served by Cloudflare.
+type IngressSyncStatus struct {
+	// Synced is true when Cloudflare serves every rule of the Ingress.
+	Synced bool `json:"synced"`
+	// ObservedGeneration is the generation of the Ingress the status
+	// describes.
+	ObservedGeneration int64 `json:"observedGeneration"`
+	// Tunnel is the domain of the tunnel serving the Ingress.
+	Tunnel string `json:"tunnel,omitempty"`
+	// LastSyncTime is when Cloudflare last accepted a change of the Ingress.
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// Rules are the tunnel ingress rules generated from the Ingress.
+	Rules []IngressSyncRule `json:"rules,omitempty"`
+	// DNSRecords are the DNS records owned for the Ingress.
+	DNSRecords []cloudflarecontroller.OwnedDNSRecord `json:"dnsRecords,omitempty"`
+	// LastError is why the Ingress is not synced.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// IngressSyncRule is a tunnel ingress rule generated from the Ingress.
+type IngressSyncRule struct {
+	Hostname string `json:"hostname"`
+	Path     string `json:"path,omitempty"`
+	Service  string `json:"service"`
+}
+
+func newIngressSyncStatus(ingress networkingv1.Ingress, tunnelDomain string, exposures []exposure.Exposure, syncErr error) IngressSyncStatus {
+	status := IngressSyncStatus{
+		Synced:             syncErr == nil,
+		ObservedGeneration: ingress.Generation,
+		Tunnel:             tunnelDomain,
+	}
+	for _, item := range exposure.Active(exposures) {
+		status.Rules = append(status.Rules, IngressSyncRule{Hostname: item.Hostname, Path: item.PathPrefix, Service: item.ServiceTarget})
+	}
+	if tunnelDomain != "" {
+		status.DNSRecords = cloudflarecontroller.OwnedDNSRecords(exposures, tunnelDomain)
+	}
+	if syncErr != nil {
+		status.LastError = syncErr.Error()
+	}
+	return status
+}
+
+// ingressSyncStatus returns the status last written on the Ingress, nil when
+// there is none or it cannot be parsed.
+func ingressSyncStatus(ingress networkingv1.Ingress) *IngressSyncStatus {
+	value, ok := ingress.Annotations[AnnotationSyncStatus]
+	if !ok {
+		return nil
+	}
+	status := IngressSyncStatus{}
+	if err := json.Unmarshal([]byte(value), &status); err != nil {
+		return nil
+	}
+	return &status
+}
+
+func (s IngressSyncStatus) summary() string {
+	var result string
+	if s.Synced {
+		result = fmt.Sprintf("Synced: %d rules, %d dns records", len(s.Rules), len(s.DNSRecords))
+	} else {
+		result = "Failed: " + s.LastError
+	}
+	if len(result) > maxSyncSummaryLength {
+		result = result[:maxSyncSummaryLength-3] + "..."
+	}
+	return result
+}
+
+// updateSyncStatus writes the status annotations when they changed. The last
+// sync time moves only when a synced status changes, so unchanged Ingresses
+// are not written on every sync.
+func (i *IngressController) updateSyncStatus(ctx context.Context, ingress networkingv1.Ingress, status IngressSyncStatus) error {
+	previous := ingressSyncStatus(ingress)
+	if previous != nil {
+		status.LastSyncTime = previous.LastSyncTime
+	}
+	encoded, err := json.Marshal(status)
+	if err != nil {
+		return errors.Wrap(err, "encode sync status")
+	}
+	if ingress.Annotations[AnnotationSyncStatus] == string(encoded) {
+		return nil
+	}
+	if status.Synced {
+		status.LastSyncTime = &metav1.Time{Time: time.Now().Truncate(time.Second)}
+		encoded, err = json.Marshal(status)
+		if err != nil {
+			return errors.Wrap(err, "encode sync status")
+		}
+	}
+
+	patch := client.MergeFrom(ingress.DeepCopy())
+	annotations := maps.Clone(ingress.Annotations)
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[AnnotationSyncStatus] = string(encoded)
+	annotations[AnnotationSyncSummary] = status.summary()
+	ingress.Annotations = annotations
+	if err := i.kubeClient.Patch(ctx, &ingress, patch); err != nil {
+		return errors.Wrapf(err, "update sync status of ingress %s/%s", ingress.Namespace, ingress.Name)
+	}
+	return nil
+}
+
+// withheldError reports the hostnames dropped from the exposures, nil when
+// every hostname is served.
+func withheldError(exposures []exposure.Exposure, served []exposure.Exposure) error {
+	var withheld []string
+	for _, item := range exposure.Active(exposures) {
+		isServed := slices.ContainsFunc(served, func(other exposure.Exposure) bool { return other.Hostname == item.Hostname })
+		if !isServed && !slices.Contains(withheld, item.Hostname) {
+			withheld = append(withheld, item.Hostname)
+		}
+	}
+	if len(withheld) == 0 {
+		return nil
+	}
+	return errors.Errorf("hostnames %s are not exposed until their Cloudflare Access application is programmed", strings.Join(withheld, ", "))
+}
+
+// failedHostnamesError reports the hostnames of the exposures that failed to
+// sync, nil when none did.
+func failedHostnamesError(partial *cloudflarecontroller.PartialSyncError, exposures []exposure.Exposure) error {
+	if partial == nil {
+		return nil
+	}
+	var reasons []string
+	for _, item := range exposure.Active(exposures) {
+		reason := partial.Reason(item.Hostname)
+		if reason != "" && !slices.Contains(reasons, reason) {
+			reasons = append(reasons, reason)
+		}
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(reasons, "; "))
+}
@@ -0,0 +1,294 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/apis/v1alpha1"
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+)
+
+// Formats of the plan report.
+const (
+	PlanFormatText = "text"
+	PlanFormatJSON = "json"
+)
+
+// TunnelPlan lists the Cloudflare changes the next sync of a tunnel makes.
+type TunnelPlan struct {
+	// Tunnel is the key of the tunnel, the name of its TunnelParameters or
+	// @default for the tunnel given on the command line.
+	Tunnel    string   `json:"tunnel"`
+	Ingresses []string `json:"ingresses"`
+	Services  []string `json:"services,omitempty"`
+	cloudflarecontroller.Plan
+	// Rejected are why some hostnames of the Ingresses and Services are
+	// not served, as reported by the sync.
+	Rejected []string `json:"rejected,omitempty"`
+	// Error is why the tunnel could not be planned.
+	Error string `json:"error,omitempty"`
+}
+
+// PlanIngressController computes the plan of every tunnel served by the
+// ingress controller. The cluster must not be started yet, it only serves
+// the reads of the plan: nothing is written to Kubernetes, and Cloudflare is
+// only read.
+func PlanIngressController(ctx context.Context, logger logr.Logger, kubeCluster cluster.Cluster, options IngressControllerOptions) ([]TunnelPlan, error) {
+	if options.EnableCloudflareAccess {
+		err := kubeCluster.GetFieldIndexer().IndexField(ctx, &v1alpha1.CloudflareAccess{}, accessTargetIndex, accessTargetIndexValues)
+		if err != nil {
+			return nil, errors.Wrap(err, "index cloudflare access targets")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	startErr := make(chan error, 1)
+	go func() {
+		startErr <- kubeCluster.Start(ctx)
+	}()
+	if !kubeCluster.GetCache().WaitForCacheSync(ctx) {
+		select {
+		case err := <-startErr:
+			return nil, errors.Wrap(err, "start kubernetes cache")
+		default:
+			return nil, errors.New("kubernetes cache did not sync")
+		}
+	}
+
+	// events are dropped, the plan must not leave traces on the Ingresses
+	controller := NewIngressController(logger, kubeCluster.GetClient(), &record.FakeRecorder{}, options.IngressClassName, options.ControllerClassName, options.ClusterDomain, options.CatchAllService, options.HostnameConflictPolicy, options.CFTunnelClient, options.TunnelRegistry, options.EnableCloudflareAccess, options.EnableHostnamePolicies, DriftOptions{})
+	return controller.Plan(ctx)
+}
+
+// Plan computes the Cloudflare changes the next sync of every tunnel would
+// make, without making them.
+func (i *IngressController) Plan(ctx context.Context) ([]TunnelPlan, error) {
+	classes, err := i.listControlledIngressClasses(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetch controlled ingress classes with controller name %s", i.controllerClassName)
+	}
+	ingresses, err := i.listControlledIngresses(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "list controlled ingresses")
+	}
+
+	// a tunnel without Ingresses may still have records to delete
+	partitions := map[string][]networkingv1.Ingress{defaultTunnelKey: nil}
+	if i.tunnels != nil {
+		list := v1alpha1.TunnelParametersList{}
+		if err := i.kubeClient.List(ctx, &list); err != nil {
+			return nil, errors.Wrap(err, "list tunnel parameters")
+		}
+		for _, params := range list.Items {
+			partitions[params.Name] = nil
+		}
+	}
+	for _, ingress := range ingresses {
+		key, _ := i.tunnelKey(ingress, classes)
+		partitions[key] = append(partitions[key], ingress)
+	}
+	services := v1.ServiceList{}
+	if err := i.kubeClient.List(ctx, &services); err != nil {
+		return nil, errors.Wrap(err, "list services")
+	}
+	exposedServices := map[string][]string{}
+	for _, service := range services.Items {
+		if key, ok := i.serviceTunnelKey(service, classes); ok {
+			if _, found := partitions[key]; !found {
+				partitions[key] = nil
+			}
+			exposedServices[key] = append(exposedServices[key], service.Namespace+"/"+service.Name)
+		}
+	}
+
+	var result []TunnelPlan
+	for _, key := range slices.Sorted(maps.Keys(partitions)) {
+		partition := partitions[key]
+		slices.SortFunc(partition, func(a, b networkingv1.Ingress) int {
+			return strings.Compare(a.Namespace+"/"+a.Name, b.Namespace+"/"+b.Name)
+		})
+		plan := TunnelPlan{Tunnel: key}
+		for _, ingress := range partition {
+			plan.Ingresses = append(plan.Ingresses, ingress.Namespace+"/"+ingress.Name)
+		}
+		plan.Services = slices.Sorted(slices.Values(exposedServices[key]))
+		plan.Plan, plan.Rejected, err = i.planTunnel(ctx, key, classes, partition)
+		if err != nil {
+			plan.Error = err.Error()
+		}
+		result = append(result, plan)
+	}
+	return result, nil
+}
+
+// planTunnel computes the exposures of the tunnel with the functions of
+// Reconcile, and plans them instead of putting them. Rejected are why some
+// exposures of the Ingresses and Services are not served.
+func (i *IngressController) planTunnel(ctx context.Context, key string, classes []networkingv1.IngressClass, partition []networkingv1.Ingress) (plan cloudflarecontroller.Plan, rejected []string, err error) {
+	services, _, err := i.exposedServices(ctx, key, classes)
+	if err != nil {
+		return plan, nil, errors.Wrap(err, "list exposed services")
+	}
+	params, _, err := i.tunnelParameters(ctx, key)
+	if err != nil {
+		return plan, nil, err
+	}
+	if isReleased(params) {
+		tunnelClient, err := i.tunnels.ClientFor(ctx, *params)
+		if err != nil {
+			return plan, nil, errors.Wrapf(err, "resolve tunnel of tunnel parameters %s", key)
+		}
+		deleted, err := i.releasedExposures(ctx, key, partition, services)
+		if err != nil {
+			return plan, nil, err
+		}
+		plan, err = tunnelClient.Plan(ctx, deleted)
+		return plan, nil, errors.Wrapf(err, "plan tunnel %s", key)
+	}
+
+	tunnelClient, err := i.resolveTunnel(ctx, key, classes)
+	if err != nil {
+		return plan, nil, errors.Wrapf(err, "resolve tunnel %s", key)
+	}
+	desired, err := i.tunnelExposures(ctx, key, partition, services)
+	if err != nil {
+		return plan, nil, err
+	}
+	for idx := range partition {
+		for _, err := range []error{desired.entries[idx].err, desired.rejected[idx]} {
+			if err != nil {
+				rejected = append(rejected, fmt.Sprintf("%s: %s", claimOwnerName(&partition[idx]), err))
+			}
+		}
+	}
+	for idx := range services {
+		if desired.unexposed[idx] != nil {
+			rejected = append(rejected, fmt.Sprintf("%s: %s", claimOwnerName(&services[idx]), desired.unexposed[idx]))
+		}
+	}
+
+	plan, err = tunnelClient.Plan(ctx, desired.exposures)
+	if err != nil {
+		return plan, nil, errors.Wrapf(err, "plan tunnel %s", key)
+	}
+	return plan, rejected, nil
+}
+
+// WritePlanReport writes the plans in the given format, text for humans or
+// json for tools.
+func WritePlanReport(w io.Writer, plans []TunnelPlan, format string) error {
+	switch format {
+	case PlanFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return errors.Wrap(encoder.Encode(plans), "encode plan report")
+	case PlanFormatText:
+	default:
+		return errors.Errorf("unknown plan format %q, expected %s or %s", format, PlanFormatText, PlanFormatJSON)
+	}
+
+	var b strings.Builder
+	ruleChanges, recordChanges, skipped, failed := 0, 0, 0, 0
+	for _, plan := range plans {
+		fmt.Fprintf(&b, "tunnel %s", plan.Tunnel)
+		if plan.TunnelName != "" {
+			fmt.Fprintf(&b, " (%s, %s)", plan.TunnelName, plan.TunnelID)
+		}
+		fmt.Fprintf(&b, ", %d ingresses", len(plan.Ingresses))
+		if len(plan.Services) > 0 {
+			fmt.Fprintf(&b, ", %d services", len(plan.Services))
+		}
+		b.WriteString("\n")
+		if plan.Error != "" {
+			failed++
+			fmt.Fprintf(&b, "  error: %s\n", plan.Error)
+			continue
+		}
+		if len(plan.Rejected) > 0 {
+			b.WriteString("  rejected:\n")
+			for _, reason := range plan.Rejected {
+				fmt.Fprintf(&b, "    ! %s\n", reason)
+			}
+		}
+		if len(plan.Invalid) > 0 {
+			b.WriteString("  skipped hostnames:\n")
+			for _, item := range plan.Invalid {
+				fmt.Fprintf(&b, "    ! %s: %s\n", item.Hostname, item.Reason)
+			}
+			skipped += len(plan.Invalid)
+		}
+		if plan.IsEmpty() {
+			b.WriteString("  no changes\n")
+			continue
+		}
+
+		rules := plan.IngressRules
+		if !rules.IsEmpty() {
+			b.WriteString("  ingress rules:\n")
+			for _, rule := range rules.Added {
+				fmt.Fprintf(&b, "    + %s\n", formatIngressRule(rule))
+			}
+			for _, rule := range rules.Removed {
+				fmt.Fprintf(&b, "    - %s\n", formatIngressRule(rule))
+			}
+			if rules.Reordered {
+				b.WriteString("    ~ same rules in another order\n")
+			}
+			ruleChanges += max(len(rules.Added)+len(rules.Removed), 1)
+		}
+		if len(plan.DNSRecords) > 0 {
+			b.WriteString("  dns records:\n")
+			for _, record := range plan.DNSRecords {
+				switch record.Operation {
+				case cloudflarecontroller.DNSOperationKindCreate:
+					fmt.Fprintf(&b, "    + %s %s -> %s%s (zone %s)\n", record.Type, record.Hostname, record.Content, dnsRecordAttributes(record), record.Zone)
+				case cloudflarecontroller.DNSOperationKindUpdate:
+					fmt.Fprintf(&b, "    ~ %s %s: %s -> %s%s (zone %s)\n", record.Type, record.Hostname, record.OldContent, record.Content, dnsRecordAttributes(record), record.Zone)
+				case cloudflarecontroller.DNSOperationKindDelete:
+					fmt.Fprintf(&b, "    - %s %s, was %s (zone %s)\n", record.Type, record.Hostname, record.OldContent, record.Zone)
+				}
+			}
+			recordChanges += len(plan.DNSRecords)
+		}
+	}
+	fmt.Fprintf(&b, "\n%d ingress rule changes, %d dns record changes, %d hostnames skipped, %d tunnels failed to plan\n", ruleChanges, recordChanges, skipped, failed)
+
+	_, err := io.WriteString(w, b.String())
+	return errors.Wrap(err, "write plan report")
+}
+
+// dnsRecordAttributes describes the attributes of the record differing from
+// a proxied record with the automatic TTL.
+func dnsRecordAttributes(record cloudflarecontroller.DNSRecordChange) string {
+	var attributes []string
+	if record.DNSOnly {
+		attributes = append(attributes, "dns-only")
+	}
+	if record.TTL != 0 {
+		attributes = append(attributes, fmt.Sprintf("ttl %d", record.TTL))
+	}
+	if len(attributes) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(attributes, ", ") + "]"
+}
+
+func formatIngressRule(rule cloudflare.UnvalidatedIngressRule) string {
+	if rule.Hostname == "" && rule.Path == "" {
+		return "(catch-all) -> " + rule.Service
+	}
+	return rule.Hostname + rule.Path + " -> " + rule.Service
+}
@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+)
+
+func TestIngressControllerPlan(t *testing.T) {
+	kubeClient := newTunnelParametersTestClient(t,
+		testTunnelParameters("staging", "staging-tunnel"),
+		testTunnelParameters("idle", "idle-tunnel"),
+		testTunnelIngressClass("cloudflare-tunnel", ""),
+		testTunnelIngressClass("cloudflare-tunnel-staging", "staging"),
+		testTunnelIngressClass("cloudflare-tunnel-broken", "missing"),
+		testAccessService(),
+		testTunnelIngress("web", "web.example.com", "cloudflare-tunnel"),
+		testTunnelIngress("preview", "preview.example.com", "cloudflare-tunnel-staging"),
+		testTunnelIngress("secret", "secret.example.com", "cloudflare-tunnel-broken"),
+	)
+	registry, provider := newTestTunnelRegistry(kubeClient)
+	defaultTunnel := &fakeGatewayTunnelClient{name: "default"}
+	controller := NewIngressController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cloudflare-tunnel", testControllerClass, "cluster.local", "", HostnameConflictPolicyFirstOwner, defaultTunnel, registry, false, false, DriftOptions{})
+
+	plans, err := controller.Plan(context.Background())
+	require.NoError(t, err)
+	require.Len(t, plans, 4)
+
+	assert.Equal(t, defaultTunnelKey, plans[0].Tunnel)
+	assert.Equal(t, []string{"default/web"}, plans[0].Ingresses)
+	require.Len(t, defaultTunnel.planned, 1)
+	assert.Equal(t, "web.example.com", defaultTunnel.planned[0].Hostname)
+
+	// a tunnel without Ingresses is planned too, its records may be stale
+	assert.Equal(t, "idle", plans[1].Tunnel)
+	assert.Empty(t, plans[1].Error)
+	assert.Empty(t, provider.tunnels["idle-tunnel"].planned)
+
+	assert.Equal(t, "missing", plans[2].Tunnel)
+	assert.Contains(t, plans[2].Error, "fetch tunnel parameters missing")
+
+	assert.Equal(t, "staging", plans[3].Tunnel)
+	assert.Equal(t, "staging-tunnel", plans[3].TunnelName)
+	require.Len(t, provider.tunnels["staging-tunnel"].planned, 1)
+	assert.Equal(t, "preview.example.com", provider.tunnels["staging-tunnel"].planned[0].Hostname)
+
+	// nothing is put, nothing is written to the Ingresses
+	assert.Zero(t, defaultTunnel.puts)
+	assert.Zero(t, provider.tunnels["staging-tunnel"].puts)
+	ingress := networkingv1.Ingress{}
+	require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "web"}, &ingress))
+	assert.Empty(t, ingress.Finalizers)
+	assert.Empty(t, ingress.Status.LoadBalancer.Ingress)
+}
+
+func TestIngressControllerPlanFollowsReconcile(t *testing.T) {
+	ctx := context.Background()
+	params := testTunnelParameters("staging", "staging-tunnel")
+	params.Finalizers = []string{IngressControllerFinalizer}
+	catchAll := func(name string) *networkingv1.Ingress {
+		return &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec: networkingv1.IngressSpec{
+				IngressClassName: ptr.To("cloudflare-tunnel"),
+				DefaultBackend: &networkingv1.IngressBackend{
+					Service: &networkingv1.IngressServiceBackend{Name: "app", Port: networkingv1.ServiceBackendPort{Number: 80}},
+				},
+			},
+		}
+	}
+	kubeClient := newTunnelParametersTestClient(t,
+		params,
+		testTunnelIngressClass("cloudflare-tunnel", ""),
+		testTunnelIngressClass("cloudflare-tunnel-staging", "staging"),
+		testAccessService(),
+		catchAll("first"),
+		catchAll("second"),
+		testTunnelIngress("preview", "preview.example.com", "cloudflare-tunnel-staging"),
+	)
+	require.NoError(t, kubeClient.Delete(ctx, params))
+	registry, provider := newTestTunnelRegistry(kubeClient)
+	defaultTunnel := &fakeGatewayTunnelClient{name: "default"}
+	controller := NewIngressController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cloudflare-tunnel", testControllerClass, "cluster.local", "", HostnameConflictPolicyFirstOwner, defaultTunnel, registry, false, false, DriftOptions{})
+
+	plans, err := controller.Plan(ctx)
+	require.NoError(t, err)
+	require.Len(t, plans, 2)
+
+	// the second catch-all is reported as the sync does
+	assert.Equal(t, []string{"ingress default/second: default backend is ignored, ingress default/first already provides the catch-all of the tunnel"}, plans[0].Rejected)
+
+	// the tunnel of deleted TunnelParameters is emptied
+	assert.Equal(t, "staging", plans[1].Tunnel)
+	assert.Empty(t, plans[1].Error)
+	assert.Equal(t, []exposure.Exposure{{Hostname: "preview.example.com", IsDeleted: true}}, provider.tunnels["staging-tunnel"].planned)
+	assert.Zero(t, provider.tunnels["staging-tunnel"].puts)
+}
+
+func TestWritePlanReport(t *testing.T) {
+	plans := []TunnelPlan{
+		{
+			Tunnel:    defaultTunnelKey,
+			Ingresses: []string{"default/web"},
+			Plan: cloudflarecontroller.Plan{
+				TunnelID:   "1234",
+				TunnelName: "my-tunnel",
+				IngressRules: cloudflarecontroller.IngressRulesDiff{
+					Added:   []cloudflare.UnvalidatedIngressRule{{Hostname: "web.example.com", Path: "/api", Service: "http://web.default.svc.cluster.local:80"}},
+					Removed: []cloudflare.UnvalidatedIngressRule{{Hostname: "old.example.com", Service: "http://old.default.svc.cluster.local:80"}},
+				},
+				DNSRecords: []cloudflarecontroller.DNSRecordChange{
+					{Operation: cloudflarecontroller.DNSOperationKindCreate, Zone: "example.com", Type: "CNAME", Hostname: "web.example.com", Content: "1234.cfargotunnel.com"},
+					{Operation: cloudflarecontroller.DNSOperationKindUpdate, Zone: "example.com", Type: "CNAME", Hostname: "www.example.com", Content: "1234.cfargotunnel.com", OldContent: "origin.example.net", DNSOnly: true, TTL: 300},
+					{Operation: cloudflarecontroller.DNSOperationKindDelete, Zone: "example.com", Type: "CNAME", Hostname: "old.example.com", OldContent: "1234.cfargotunnel.com"},
+				},
+			},
+		},
+		{Tunnel: "staging", Rejected: []string{"ingress default/blog: blog.example.com not allowed in namespace default by annotation " + AnnotationAllowedHostnames}, Plan: cloudflarecontroller.Plan{
+			TunnelID:   "5678",
+			TunnelName: "staging-tunnel",
+			Invalid:    []cloudflarecontroller.ExposureError{{Hostname: "typo.exmaple.com", Reason: "hostname typo.exmaple.com not belong to any zone"}},
+		}},
+		{Tunnel: "missing", Error: "fetch tunnel parameters missing: not found"},
+	}
+
+	text := bytes.Buffer{}
+	require.NoError(t, WritePlanReport(&text, plans, PlanFormatText))
+	assert.Equal(t, `tunnel @default (my-tunnel, 1234), 1 ingresses
+  ingress rules:
+    + web.example.com/api -> http://web.default.svc.cluster.local:80
+    - old.example.com -> http://old.default.svc.cluster.local:80
+  dns records:
+    + CNAME web.example.com -> 1234.cfargotunnel.com (zone example.com)
+    ~ CNAME www.example.com: origin.example.net -> 1234.cfargotunnel.com [dns-only, ttl 300] (zone example.com)
+    - CNAME old.example.com, was 1234.cfargotunnel.com (zone example.com)
+tunnel staging (staging-tunnel, 5678), 0 ingresses
+  rejected:
+    ! ingress default/blog: blog.example.com not allowed in namespace default by annotation `+AnnotationAllowedHostnames+`
+  skipped hostnames:
+    ! typo.exmaple.com: hostname typo.exmaple.com not belong to any zone
+  no changes
+tunnel missing, 0 ingresses
+  error: fetch tunnel parameters missing: not found
+
+2 ingress rule changes, 3 dns record changes, 1 hostnames skipped, 1 tunnels failed to plan
+`, text.String())
+
+	output := bytes.Buffer{}
+	require.NoError(t, WritePlanReport(&output, plans, PlanFormatJSON))
+	var decoded []map[string]any
+	require.NoError(t, json.Unmarshal(output.Bytes(), &decoded))
+	require.Len(t, decoded, 3)
+	assert.Equal(t, "my-tunnel", decoded[0]["tunnelName"])
+	assert.Len(t, decoded[0]["dnsRecords"], 3)
+	assert.Equal(t, "fetch tunnel parameters missing: not found", decoded[2]["error"])
+
+	assert.Error(t, WritePlanReport(&output, plans, "yaml"))
+}
@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Protocols a Service can be exposed with, cloudflared proxies each of them
+// as a stream of bytes, the client side cloudflared speaks the same protocol.
+const (
+	ExposeProtocolTCP     = "tcp"
+	ExposeProtocolSSH     = "ssh"
+	ExposeProtocolRDP     = "rdp"
+	ExposeProtocolSMB     = "smb"
+	ExposeProtocolUnix    = "unix"
+	ExposeProtocolUnixTLS = "unix+tls"
+)
+
+var exposeProtocols = []string{ExposeProtocolTCP, ExposeProtocolSSH, ExposeProtocolRDP, ExposeProtocolSMB, ExposeProtocolUnix, ExposeProtocolUnixTLS}
+
+// isExposedService tells whether the Service asks to be exposed.
+func isExposedService(service v1.Service) bool {
+	_, ok := service.Annotations[AnnotationExposeHostname]
+	return ok
+}
+
+// FromServiceToExposure returns the exposure of a Service annotated with
+// expose-hostname. The tunnel rule has no path, cloudflared only matches
+// paths of http origins. A nil allowed allows every hostname.
+func FromServiceToExposure(service v1.Service, clusterDomain string, allowed *AllowedHostnames) ([]exposure.Exposure, error) {
+	name := client.ObjectKeyFromObject(&service)
+	hostname, ok := getAnnotation(service.Annotations, AnnotationExposeHostname)
+	if !ok {
+		return nil, nil
+	}
+	if problems := validation.IsDNS1123Subdomain(hostname); len(problems) > 0 {
+		return nil, errors.Errorf("hostname %q of service %s is invalid: %s", hostname, name, strings.Join(problems, ", "))
+	}
+	if !allowed.Allows(hostname) {
+		return nil, errors.Errorf("hostname %s of service %s is not allowed by the hostname policies of namespace %s", hostname, name, service.Namespace)
+	}
+
+	protocol := ExposeProtocolTCP
+	if value, ok := getAnnotation(service.Annotations, AnnotationExposeProtocol); ok {
+		protocol = value
+	}
+	if !slices.Contains(exposeProtocols, protocol) {
+		return nil, errors.Errorf("protocol %q of service %s is not supported, expected one of %s", protocol, name, strings.Join(exposeProtocols, ", "))
+	}
+
+	socket, hasSocket := getAnnotation(service.Annotations, AnnotationExposeUnixSocket)
+	var serviceTarget string
+	switch protocol {
+	case ExposeProtocolUnix, ExposeProtocolUnixTLS:
+		if !hasSocket || !path.IsAbs(socket) {
+			return nil, errors.Errorf("protocol %s of service %s requires an absolute socket path in annotation %s", protocol, name, AnnotationExposeUnixSocket)
+		}
+		serviceTarget = protocol + ":" + socket
+	default:
+		if hasSocket {
+			return nil, errors.Errorf("annotation %s of service %s is only used with protocol %s or %s", AnnotationExposeUnixSocket, name, ExposeProtocolUnix, ExposeProtocolUnixTLS)
+		}
+		host, err := getHostFromService(&service, clusterDomain)
+		if err != nil {
+			return nil, err
+		}
+		port, err := exposedServicePort(service)
+		if err != nil {
+			return nil, err
+		}
+		serviceTarget = fmt.Sprintf("%s://%s:%d", protocol, host, port)
+	}
+
+	return []exposure.Exposure{{
+		Hostname:      hostname,
+		ServiceTarget: serviceTarget,
+		IsDeleted:     service.DeletionTimestamp != nil,
+	}}, nil
+}
+
+// exposedServicePort returns the port selected by the expose-port annotation,
+// the only port of the Service when it is not set.
+func exposedServicePort(service v1.Service) (int32, error) {
+	name := client.ObjectKeyFromObject(&service)
+	value, ok := getAnnotation(service.Annotations, AnnotationExposePort)
+	if !ok {
+		if len(service.Spec.Ports) != 1 {
+			return 0, errors.Errorf("service %s has %d ports, select one with annotation %s", name, len(service.Spec.Ports), AnnotationExposePort)
+		}
+		value = service.Spec.Ports[0].Name
+		if value == "" {
+			value = strconv.Itoa(int(service.Spec.Ports[0].Port))
+		}
+	}
+
+	number, err := strconv.ParseInt(value, 10, 32)
+	index := slices.IndexFunc(service.Spec.Ports, func(port v1.ServicePort) bool {
+		if err == nil {
+			return port.Port == int32(number)
+		}
+		return port.Name == value
+	})
+	if index < 0 {
+		if err == nil && service.Spec.Type == v1.ServiceTypeExternalName {
+			// the ports of an ExternalName Service are informative only
+			return int32(number), nil
+		}
+		return 0, errors.Errorf("service %s has no port %s", name, value)
+	}
+	port := service.Spec.Ports[index]
+	if port.Protocol != "" && port.Protocol != v1.ProtocolTCP {
+		return 0, errors.Errorf("port %s of service %s is %s, cloudflared only proxies TCP", value, name, port.Protocol)
+	}
+	return port.Port, nil
+}
+
+// clientCommand returns the command connecting a client to the exposure of the
+// Service through cloudflared access.
+func clientCommand(service v1.Service, item exposure.Exposure) string {
+	protocol := ExposeProtocolTCP
+	if value, ok := getAnnotation(service.Annotations, AnnotationExposeProtocol); ok {
+		protocol = value
+	}
+	// the port of the Service is a fair default for the local port, a UNIX
+	// socket has none
+	localPort := "LOCAL_PORT"
+	if target, err := url.Parse(item.ServiceTarget); err == nil && target.Port() != "" {
+		localPort = target.Port()
+	}
+
+	switch protocol {
+	case ExposeProtocolSSH:
+		return fmt.Sprintf(`ssh -o ProxyCommand="cloudflared access ssh --hostname %%h" USER@%s`, item.Hostname)
+	case ExposeProtocolRDP:
+		return fmt.Sprintf("cloudflared access rdp --hostname %s --url rdp://localhost:%s, then connect the RDP client to localhost:%s", item.Hostname, localPort, localPort)
+	case ExposeProtocolSMB:
+		return fmt.Sprintf("cloudflared access smb --hostname %s --url localhost:%s, then mount //localhost:%s", item.Hostname, localPort, localPort)
+	default:
+		return fmt.Sprintf("cloudflared access tcp --hostname %s --url localhost:%s, then connect the client to localhost:%s", item.Hostname, localPort, localPort)
+	}
+}
+
+// serviceTunnelKey returns the key of the tunnel the Service is exposed by,
+// and false when the Service is not exposed by this controller.
+func (i *IngressController) serviceTunnelKey(service v1.Service, classes []networkingv1.IngressClass) (string, bool) {
+	if !isExposedService(service) {
+		return "", false
+	}
+	className := i.ingressClassName
+	if value, ok := getAnnotation(service.Annotations, AnnotationExposeIngressClass); ok {
+		className = value
+	}
+	return i.classTunnelKey(className, classes)
+}
+
+// exposedServices returns the Services exposed by the tunnel, sorted by
+// name, and the Services holding the finalizer after they stopped asking to
+// be exposed.
+func (i *IngressController) exposedServices(ctx context.Context, key string, classes []networkingv1.IngressClass) ([]v1.Service, []v1.Service, error) {
+	list := v1.ServiceList{}
+	if err := i.kubeClient.List(ctx, &list); err != nil {
+		return nil, nil, errors.Wrap(err, "list services")
+	}
+	var exposed, released []v1.Service
+	for _, service := range list.Items {
+		if serviceKey, ok := i.serviceTunnelKey(service, classes); ok && serviceKey == key {
+			exposed = append(exposed, service)
+		} else if !isExposedService(service) && slices.Contains(service.Finalizers, IngressControllerFinalizer) {
+			released = append(released, service)
+		}
+	}
+	slices.SortFunc(exposed, func(a, b v1.Service) int {
+		return strings.Compare(a.Namespace+"/"+a.Name, b.Namespace+"/"+b.Name)
+	})
+	return exposed, released, nil
+}
+
+// reportService records an event on the Service when the message differs from
+// the last one, a Service is synced with its whole tunnel and would otherwise
+// get the same event on every sync.
+func (i *IngressController) reportService(service v1.Service, eventType string, reason string, message string) {
+	name := types.NamespacedName{Namespace: service.Namespace, Name: service.Name}
+	i.mu.Lock()
+	unchanged := i.serviceEvents[name] == message
+	i.serviceEvents[name] = message
+	i.mu.Unlock()
+	if !unchanged {
+		i.recorder.Event(&service, eventType, reason, message)
+	}
+}
+
+// forgetService drops the last event of a Service that is no longer exposed.
+func (i *IngressController) forgetService(service v1.Service) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.serviceEvents, types.NamespacedName{Namespace: service.Namespace, Name: service.Name})
+}
@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func testExposedService(name string, annotations map[string]string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Annotations: annotations},
+		Spec: v1.ServiceSpec{
+			ClusterIP: "10.0.0.2",
+			Ports: []v1.ServicePort{
+				{Name: "ssh", Port: 22, Protocol: v1.ProtocolTCP},
+				{Name: "syslog", Port: 514, Protocol: v1.ProtocolUDP},
+			},
+		},
+	}
+}
+
+func TestFromServiceToExposure(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        string
+		wantErr     string
+	}{
+		{
+			name:        "not exposed",
+			annotations: nil,
+		},
+		{
+			name:        "ssh by port name",
+			annotations: map[string]string{AnnotationExposeHostname: "ssh.example.com", AnnotationExposeProtocol: "ssh", AnnotationExposePort: "ssh"},
+			want:        "ssh://bastion.default.svc.cluster.local:22",
+		},
+		{
+			name:        "tcp by port number",
+			annotations: map[string]string{AnnotationExposeHostname: "db.example.com", AnnotationExposePort: "22"},
+			want:        "tcp://bastion.default.svc.cluster.local:22",
+		},
+		{
+			name:        "unix socket",
+			annotations: map[string]string{AnnotationExposeHostname: "docker.example.com", AnnotationExposeProtocol: "unix", AnnotationExposeUnixSocket: "/var/run/docker.sock"},
+			want:        "unix:/var/run/docker.sock",
+		},
+		{
+			name:        "unsupported protocol",
+			annotations: map[string]string{AnnotationExposeHostname: "web.example.com", AnnotationExposeProtocol: "http", AnnotationExposePort: "ssh"},
+			wantErr:     `protocol "http" of service default/bastion is not supported, expected one of tcp, ssh, rdp, smb, unix, unix+tls`,
+		},
+		{
+			name:        "wildcard hostname",
+			annotations: map[string]string{AnnotationExposeHostname: "*.example.com", AnnotationExposePort: "ssh"},
+			wantErr:     `hostname "*.example.com" of service default/bastion is invalid`,
+		},
+		{
+			name:        "ambiguous port",
+			annotations: map[string]string{AnnotationExposeHostname: "ssh.example.com"},
+			wantErr:     "service default/bastion has 2 ports, select one with annotation " + AnnotationExposePort,
+		},
+		{
+			name:        "udp port",
+			annotations: map[string]string{AnnotationExposeHostname: "syslog.example.com", AnnotationExposePort: "syslog"},
+			wantErr:     "port syslog of service default/bastion is UDP, cloudflared only proxies TCP",
+		},
+		{
+			name:        "unix socket without path",
+			annotations: map[string]string{AnnotationExposeHostname: "docker.example.com", AnnotationExposeProtocol: "unix"},
+			wantErr:     "protocol unix of service default/bastion requires an absolute socket path in annotation " + AnnotationExposeUnixSocket,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exposures, err := FromServiceToExposure(*testExposedService("bastion", tt.annotations), "cluster.local", nil)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			if tt.want == "" {
+				assert.Empty(t, exposures)
+				return
+			}
+			require.Len(t, exposures, 1)
+			assert.Equal(t, tt.annotations[AnnotationExposeHostname], exposures[0].Hostname)
+			assert.Equal(t, tt.want, exposures[0].ServiceTarget)
+			assert.Empty(t, exposures[0].PathPrefix)
+		})
+	}
+}
+
+func TestClientCommand(t *testing.T) {
+	ssh := testExposedService("bastion", map[string]string{AnnotationExposeProtocol: "ssh"})
+	assert.Equal(t, `ssh -o ProxyCommand="cloudflared access ssh --hostname %h" USER@ssh.example.com`,
+		clientCommand(*ssh, exposure.Exposure{Hostname: "ssh.example.com", ServiceTarget: "ssh://bastion.default.svc.cluster.local:22"}))
+
+	tcp := testExposedService("postgres", nil)
+	assert.Equal(t, "cloudflared access tcp --hostname db.example.com --url localhost:5432, then connect the client to localhost:5432",
+		clientCommand(*tcp, exposure.Exposure{Hostname: "db.example.com", ServiceTarget: "tcp://postgres.default.svc.cluster.local:5432"}))
+
+	unix := testExposedService("docker", map[string]string{AnnotationExposeProtocol: "unix"})
+	assert.Equal(t, "cloudflared access tcp --hostname docker.example.com --url localhost:LOCAL_PORT, then connect the client to localhost:LOCAL_PORT",
+		clientCommand(*unix, exposure.Exposure{Hostname: "docker.example.com", ServiceTarget: "unix:/var/run/docker.sock"}))
+}
+
+func TestIngressControllerExposesServices(t *testing.T) {
+	bastion := testExposedService("bastion", map[string]string{AnnotationExposeHostname: "ssh.example.com", AnnotationExposeProtocol: "ssh", AnnotationExposePort: "ssh"})
+	staging := testExposedService("staging-bastion", map[string]string{AnnotationExposeHostname: "ssh.staging.example.com", AnnotationExposePort: "ssh", AnnotationExposeIngressClass: "cloudflare-tunnel-staging"})
+	broken := testExposedService("broken", map[string]string{AnnotationExposeHostname: "broken.example.com"})
+	kubeClient := newTunnelParametersTestClient(t,
+		testTunnelIngressClass("cloudflare-tunnel", ""),
+		testTunnelIngressClass("cloudflare-tunnel-staging", "staging"),
+		testAccessService(),
+		testTunnelIngress("web", "web.example.com", "cloudflare-tunnel"),
+		bastion, staging, broken,
+	)
+	tunnel := &fakeGatewayTunnelClient{name: "default"}
+	recorder := record.NewFakeRecorder(32)
+	controller := NewIngressController(logr.Discard(), kubeClient, recorder, "cloudflare-tunnel", testControllerClass, "cluster.local", "", HostnameConflictPolicyFirstOwner, tunnel, nil, false, false, DriftOptions{})
+
+	assert.Equal(t, []string{"staging"}, controller.tunnelKeysForService(context.Background(), staging))
+
+	syncTunnel(t, controller, defaultTunnelKey)
+	require.Len(t, tunnel.exposures, 2)
+	assert.Equal(t, "web.example.com", tunnel.exposures[0].Hostname)
+	assert.Equal(t, exposure.Exposure{Hostname: "ssh.example.com", ServiceTarget: "ssh://bastion.default.svc.cluster.local:22"}, tunnel.exposures[1])
+
+	getService := func(name string) v1.Service {
+		service := v1.Service{}
+		require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: name}, &service))
+		return service
+	}
+	assert.Contains(t, getService("bastion").Finalizers, IngressControllerFinalizer)
+
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	assert.Contains(t, events, `Normal CloudflareSynced ssh://bastion.default.svc.cluster.local:22 is exposed at ssh.example.com, connect with: ssh -o ProxyCommand="cloudflared access ssh --hostname %h" USER@ssh.example.com`)
+	assert.Contains(t, events, "Warning TransformFailed service default/broken has 2 ports, select one with annotation "+AnnotationExposePort)
+
+	// the events are not repeated by the next sync
+	syncTunnel(t, controller, defaultTunnelKey)
+	assert.Empty(t, recorder.Events)
+
+	// a deleted Service releases its hostname before its finalizer
+	service := getService("bastion")
+	require.NoError(t, kubeClient.Delete(context.Background(), &service))
+	syncTunnel(t, controller, defaultTunnelKey)
+	require.Len(t, tunnel.exposures, 2)
+	assert.True(t, tunnel.exposures[1].IsDeleted)
+	assert.Error(t, kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "bastion"}, &service))
+}
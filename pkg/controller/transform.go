@@ -3,11 +3,13 @@ package controller
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"strconv"
 	"time"
 
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
 	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/exposure"
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
@@ -26,7 +28,12 @@ const (
 	EventReasonSynced          = "CloudflareSynced"
 )
 
-func FromIngressToExposure(ctx context.Context, logger logr.Logger, kubeClient client.Client, recorder record.EventRecorder, ingress networkingv1.Ingress, clusterDomain string) ([]exposure.Exposure, error) {
+// FromIngressToExposure returns the exposures of the Ingress: one per path of
+// every rule, one per host for the defaultBackend of an Ingress with rules,
+// and the catch-all of the tunnel for the defaultBackend of an Ingress
+// without rules. Hosts not in allowed are rejected, a nil allowed allows
+// every host.
+func FromIngressToExposure(ctx context.Context, logger logr.Logger, kubeClient client.Client, recorder record.EventRecorder, ingress networkingv1.Ingress, clusterDomain string, allowed *AllowedHostnames) ([]exposure.Exposure, error) {
 	isDeleted := ingress.DeletionTimestamp != nil
 
 	if len(ingress.Spec.TLS) > 0 {
@@ -34,148 +41,252 @@ func FromIngressToExposure(ctx context.Context, logger logr.Logger, kubeClient c
 		recorder.Event(&ingress, v1.EventTypeWarning, EventReasonTLSIgnored, "ingress has tls specified, SSL Passthrough is not supported, it will be ignored")
 	}
 
-	var result []exposure.Exposure
-	for _, rule := range ingress.Spec.Rules {
-		if rule.Host == "" {
-			return nil, errors.Errorf("host in ingress %s/%s is empty", ingress.GetNamespace(), ingress.GetName())
-		}
+	scheme := "http"
 
-		// rule.HTTP is optional in the Ingress API, a rule may carry only a
-		// host, skip it and keep processing the remaining rules
-		if rule.HTTP == nil {
-			logger.Info("ingress rule has no http section, skipped",
-				"ingress", fmt.Sprintf("%s/%s", ingress.GetNamespace(), ingress.GetName()),
-				"host", rule.Host,
+	if backendProtocol, ok := getAnnotation(ingress.Annotations, AnnotationBackendProtocol); ok {
+		scheme = backendProtocol
+	}
+
+	var httpHostHeader *string
+
+	if header, ok := getAnnotation(ingress.Annotations, AnnotationHTTPHostHeader); ok {
+		httpHostHeader = ptr.To(header)
+	}
+
+	var originServerName *string
+
+	if name, ok := getAnnotation(ingress.Annotations, AnnotationOriginServerName); ok {
+		originServerName = ptr.To(name)
+	}
+
+	disableDNSManagement := false
+
+	if value, ok := getAnnotation(ingress.Annotations, AnnotationDisableDNSManagement); ok {
+		switch value {
+		case AnnotationDisableDNSManagementTrue:
+			disableDNSManagement = true
+		case AnnotationDisableDNSManagementFalse:
+			disableDNSManagement = false
+		default:
+			return nil, errors.Errorf(
+				"invalid value for annotation %s, available values: \"%s\" or \"%s\"",
+				AnnotationDisableDNSManagement,
+				AnnotationDisableDNSManagementTrue,
+				AnnotationDisableDNSManagementFalse,
 			)
-			recorder.Eventf(&ingress, v1.EventTypeWarning, EventReasonRuleSkipped, "rule for host %s has no http section, skipped", rule.Host)
-			continue
 		}
+	}
 
-		hostname := rule.Host
-		scheme := "http"
-
-		if backendProtocol, ok := getAnnotation(ingress.Annotations, AnnotationBackendProtocol); ok {
-			scheme = backendProtocol
+	allowDNSTakeover := false
+
+	if value, ok := getAnnotation(ingress.Annotations, AnnotationDNSTakeover); ok {
+		switch value {
+		case AnnotationDNSTakeoverTrue:
+			allowDNSTakeover = true
+		case AnnotationDNSTakeoverFalse:
+			allowDNSTakeover = false
+		default:
+			return nil, errors.Errorf(
+				"invalid value for annotation %s, available values: \"%s\" or \"%s\"",
+				AnnotationDNSTakeover,
+				AnnotationDNSTakeoverTrue,
+				AnnotationDNSTakeoverFalse,
+			)
 		}
+	}
 
-		var httpHostHeader *string
+	dnsOnly, dnsTTL, err := parseDNSRecordSettings(ingress.Annotations)
+	if err != nil {
+		return nil, err
+	}
 
-		if header, ok := getAnnotation(ingress.Annotations, AnnotationHTTPHostHeader); ok {
-			httpHostHeader = ptr.To(header)
+	useRegex := false
+
+	if value, ok := getAnnotation(ingress.Annotations, AnnotationUseRegex); ok {
+		switch value {
+		case AnnotationUseRegexTrue:
+			useRegex = true
+		case AnnotationUseRegexFalse:
+			useRegex = false
+		default:
+			return nil, errors.Errorf(
+				"invalid value for annotation %s, available values: \"%s\" or \"%s\"",
+				AnnotationUseRegex,
+				AnnotationUseRegexTrue,
+				AnnotationUseRegexFalse,
+			)
 		}
+	}
 
-		var originServerName *string
+	originRequest, err := parseOriginRequestSettings(ingress.Annotations, scheme)
+	if err != nil {
+		return nil, err
+	}
 
-		if name, ok := getAnnotation(ingress.Annotations, AnnotationOriginServerName); ok {
-			originServerName = ptr.To(name)
+	var proxySSLVerifyEnabled *bool
+
+	if proxySSLVerify, ok := getAnnotation(ingress.Annotations, AnnotationProxySSLVerify); ok {
+		switch proxySSLVerify {
+		case AnnotationProxySSLVerifyOn:
+			proxySSLVerifyEnabled = ptr.To(true)
+		case AnnotationProxySSLVerifyOff:
+			proxySSLVerifyEnabled = ptr.To(false)
+		default:
+			return nil, errors.Errorf(
+				"invalid value for annotation %s, available values: \"%s\" or \"%s\"",
+				AnnotationProxySSLVerify,
+				AnnotationProxySSLVerifyOn,
+				AnnotationProxySSLVerifyOff,
+			)
 		}
+	}
 
-		disableDNSManagement := false
-
-		if value, ok := getAnnotation(ingress.Annotations, AnnotationDisableDNSManagement); ok {
-			switch value {
-			case AnnotationDisableDNSManagementTrue:
-				disableDNSManagement = true
-			case AnnotationDisableDNSManagementFalse:
-				disableDNSManagement = false
-			default:
-				return nil, errors.Errorf(
-					"invalid value for annotation %s, available values: \"%s\" or \"%s\"",
-					AnnotationDisableDNSManagement,
-					AnnotationDisableDNSManagementTrue,
-					AnnotationDisableDNSManagementFalse,
-				)
-			}
+	// newExposure carries the settings of the annotations, they apply to
+	// every exposure of the ingress
+	newExposure := func(hostname string, serviceTarget string, path string) exposure.Exposure {
+		return exposure.Exposure{
+			Hostname:               hostname,
+			ServiceTarget:          serviceTarget,
+			PathPrefix:             path,
+			IsDeleted:              isDeleted,
+			ProxySSLVerifyEnabled:  proxySSLVerifyEnabled,
+			HTTPHostHeader:         httpHostHeader,
+			OriginServerName:       originServerName,
+			DisableDNSManagement:   disableDNSManagement,
+			AllowDNSTakeover:       allowDNSTakeover,
+			DNSOnly:                dnsOnly,
+			DNSTTL:                 dnsTTL,
+			ConnectTimeout:         originRequest.ConnectTimeout,
+			TLSTimeout:             originRequest.TLSTimeout,
+			TCPKeepAlive:           originRequest.TCPKeepAlive,
+			NoHappyEyeballs:        originRequest.NoHappyEyeballs,
+			KeepAliveConnections:   originRequest.KeepAliveConnections,
+			KeepAliveTimeout:       originRequest.KeepAliveTimeout,
+			NoTLSVerify:            originRequest.NoTLSVerify,
+			DisableChunkedEncoding: originRequest.DisableChunkedEncoding,
+			HTTP2Origin:            originRequest.HTTP2Origin,
 		}
+	}
 
-		originRequest, err := parseOriginRequestSettings(ingress.Annotations, scheme)
+	var defaultBackendTarget string
+	if ingress.Spec.DefaultBackend != nil {
+		defaultBackendTarget, err = serviceTargetOfBackend(ctx, kubeClient, ingress, *ingress.Spec.DefaultBackend, scheme, clusterDomain)
 		if err != nil {
-			return nil, err
+			return nil, errors.Wrap(err, "resolve default backend")
+		}
+		// without rules, the default backend serves every request reaching
+		// the tunnel that no other rule matches
+		if len(ingress.Spec.Rules) == 0 {
+			if !allowed.Allows("") {
+				return nil, errors.Errorf("default backend of ingress %s/%s would serve the catch-all of the tunnel, the hostname policies of namespace %s do not allow it", ingress.GetNamespace(), ingress.GetName(), ingress.GetNamespace())
+			}
+			return []exposure.Exposure{newExposure("", defaultBackendTarget, "")}, nil
 		}
+	}
 
-		var proxySSLVerifyEnabled *bool
+	var result []exposure.Exposure
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host == "" {
+			return nil, errors.Errorf("host in ingress %s/%s is empty", ingress.GetNamespace(), ingress.GetName())
+		}
+		if !allowed.Allows(rule.Host) {
+			return nil, errors.Errorf("host %s in ingress %s/%s is not allowed by the hostname policies of namespace %s", rule.Host, ingress.GetNamespace(), ingress.GetName(), ingress.GetNamespace())
+		}
 
-		if proxySSLVerify, ok := getAnnotation(ingress.Annotations, AnnotationProxySSLVerify); ok {
-			switch proxySSLVerify {
-			case AnnotationProxySSLVerifyOn:
-				proxySSLVerifyEnabled = ptr.To(true)
-			case AnnotationProxySSLVerifyOff:
-				proxySSLVerifyEnabled = ptr.To(false)
-			default:
-				return nil, errors.Errorf(
-					"invalid value for annotation %s, available values: \"%s\" or \"%s\"",
-					AnnotationProxySSLVerify,
-					AnnotationProxySSLVerifyOn,
-					AnnotationProxySSLVerifyOff,
-				)
-			}
+		// rule.HTTP is optional in the Ingress API, a rule may carry only a
+		// host, it is served by the default backend when there is one
+		if rule.HTTP == nil && defaultBackendTarget == "" {
+			logger.Info("ingress rule has no http section, skipped",
+				"ingress", fmt.Sprintf("%s/%s", ingress.GetNamespace(), ingress.GetName()),
+				"host", rule.Host,
+			)
+			recorder.Eventf(&ingress, v1.EventTypeWarning, EventReasonRuleSkipped, "rule for host %s has no http section, skipped", rule.Host)
+			continue
 		}
 
-		for _, path := range rule.HTTP.Paths {
-			namespacedName := types.NamespacedName{
-				Namespace: ingress.GetNamespace(),
-				Name:      path.Backend.Service.Name,
-			}
-			service := v1.Service{}
-			err := kubeClient.Get(ctx, namespacedName, &service)
-			if err != nil {
-				return nil, errors.Wrapf(err, "fetch service %s", namespacedName)
-			}
+		hostname := rule.Host
 
-			host, err := getHostFromService(&service, clusterDomain)
+		var paths []networkingv1.HTTPIngressPath
+		if rule.HTTP != nil {
+			paths = rule.HTTP.Paths
+		}
+		for _, path := range paths {
+			serviceTarget, err := serviceTargetOfBackend(ctx, kubeClient, ingress, path.Backend, scheme, clusterDomain)
 			if err != nil {
 				return nil, err
 			}
 
-			var port int32
-			if path.Backend.Service.Port.Name != "" {
-				ok, extractedPort := getPortWithName(service.Spec.Ports, path.Backend.Service.Port.Name)
-				if !ok {
-					return nil, errors.Errorf("service %s has no port named %s", namespacedName, path.Backend.Service.Port.Name)
-				}
-				port = extractedPort
-			} else {
-				port = path.Backend.Service.Port.Number
-			}
-
-			var supportedPathTypes = map[networkingv1.PathType]struct{}{
-				networkingv1.PathTypePrefix:                 {},
-				networkingv1.PathTypeImplementationSpecific: {},
-			}
-
 			if path.PathType == nil {
 				return nil, errors.Errorf("path type in ingress %s/%s is nil", ingress.GetNamespace(), ingress.GetName())
 			}
 
-			if _, ok := supportedPathTypes[*path.PathType]; !ok {
+			var tunnelPath string
+			switch *path.PathType {
+			case networkingv1.PathTypeExact:
+				tunnelPath = cloudflarecontroller.ExactPathRegex(path.Path)
+			case networkingv1.PathTypePrefix:
+				tunnelPath = cloudflarecontroller.PrefixPathRegex(path.Path)
+			case networkingv1.PathTypeImplementationSpecific:
+				if !useRegex {
+					tunnelPath = cloudflarecontroller.PrefixPathRegex(path.Path)
+					break
+				}
+				if _, err := regexp.Compile(path.Path); err != nil {
+					return nil, errors.Errorf("path %s in ingress %s/%s is not a valid regular expression: %s", path.Path, ingress.GetNamespace(), ingress.GetName(), err)
+				}
+				tunnelPath = path.Path
+			default:
 				return nil, errors.Errorf("path type in ingress %s/%s is %s, which is not supported", ingress.GetNamespace(), ingress.GetName(), *path.PathType)
 			}
 
-			result = append(result, exposure.Exposure{
-				Hostname:               hostname,
-				ServiceTarget:          fmt.Sprintf("%s://%s:%d", scheme, host, port),
-				PathPrefix:             path.Path,
-				IsDeleted:              isDeleted,
-				ProxySSLVerifyEnabled:  proxySSLVerifyEnabled,
-				HTTPHostHeader:         httpHostHeader,
-				OriginServerName:       originServerName,
-				DisableDNSManagement:   disableDNSManagement,
-				ConnectTimeout:         originRequest.ConnectTimeout,
-				TLSTimeout:             originRequest.TLSTimeout,
-				TCPKeepAlive:           originRequest.TCPKeepAlive,
-				NoHappyEyeballs:        originRequest.NoHappyEyeballs,
-				KeepAliveConnections:   originRequest.KeepAliveConnections,
-				KeepAliveTimeout:       originRequest.KeepAliveTimeout,
-				NoTLSVerify:            originRequest.NoTLSVerify,
-				DisableChunkedEncoding: originRequest.DisableChunkedEncoding,
-				HTTP2Origin:            originRequest.HTTP2Origin,
-			})
+			result = append(result, newExposure(hostname, serviceTarget, tunnelPath))
+		}
+
+		// a rule without path matches the whole host, it is sorted after
+		// the paths of the host
+		if defaultBackendTarget != "" {
+			result = append(result, newExposure(hostname, defaultBackendTarget, ""))
 		}
 	}
 
 	return result, nil
 }
 
+// serviceTargetOfBackend returns the url of the Service of the backend.
+func serviceTargetOfBackend(ctx context.Context, kubeClient client.Client, ingress networkingv1.Ingress, backend networkingv1.IngressBackend, scheme string, clusterDomain string) (string, error) {
+	if backend.Service == nil {
+		return "", errors.Errorf("backend in ingress %s/%s is not a service, resource backends are not supported", ingress.GetNamespace(), ingress.GetName())
+	}
+
+	namespacedName := types.NamespacedName{
+		Namespace: ingress.GetNamespace(),
+		Name:      backend.Service.Name,
+	}
+	service := v1.Service{}
+	err := kubeClient.Get(ctx, namespacedName, &service)
+	if err != nil {
+		return "", errors.Wrapf(err, "fetch service %s", namespacedName)
+	}
+
+	host, err := getHostFromService(&service, clusterDomain)
+	if err != nil {
+		return "", err
+	}
+
+	var port int32
+	if backend.Service.Port.Name != "" {
+		ok, extractedPort := getPortWithName(service.Spec.Ports, backend.Service.Port.Name)
+		if !ok {
+			return "", errors.Errorf("service %s has no port named %s", namespacedName, backend.Service.Port.Name)
+		}
+		port = extractedPort
+	} else {
+		port = backend.Service.Port.Number
+	}
+
+	return fmt.Sprintf("%s://%s:%d", scheme, host, port), nil
+}
+
 func getHostFromService(service *v1.Service, clusterDomain string) (string, error) {
 	if service.Spec.ClusterIP == "None" {
 		return "", errors.Errorf("service %s has None for cluster ip, headless service is not supported", client.ObjectKeyFromObject(service))
@@ -273,6 +384,29 @@ func parseOriginRequestSettings(annotations map[string]string, scheme string) (o
 	return settings, nil
 }
 
+// parseDNSRecordSettings returns whether the CNAME records of the ingress are
+// DNS-only and their TTL, zero for automatic.
+func parseDNSRecordSettings(annotations map[string]string) (bool, int, error) {
+	proxied, err := parseBoolAnnotation(annotations, AnnotationDNSProxied)
+	if err != nil {
+		return false, 0, err
+	}
+	dnsOnly := proxied != nil && !*proxied
+
+	ttl, err := parseIntAnnotation(annotations, AnnotationDNSTTL)
+	if err != nil || ttl == nil || *ttl == 1 {
+		return dnsOnly, 0, err
+	}
+	if *ttl < 30 || *ttl > 86400 {
+		return false, 0, errors.Errorf("invalid value %q for annotation %s, expect 1 for automatic or from 30 to 86400 seconds", annotations[AnnotationDNSTTL], AnnotationDNSTTL)
+	}
+	// Cloudflare always serves proxied records with the automatic TTL
+	if !dnsOnly {
+		return false, 0, errors.Errorf("annotation %s requires %s: \"false\", proxied records always use the automatic TTL", AnnotationDNSTTL, AnnotationDNSProxied)
+	}
+	return dnsOnly, *ttl, nil
+}
+
 func parseBoolAnnotation(annotations map[string]string, key string) (*bool, error) {
 	value, ok := getAnnotation(annotations, key)
 	if !ok {
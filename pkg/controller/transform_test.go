@@ -158,7 +158,7 @@ func TestFromIngressToExposureNilHTTP(t *testing.T) {
 	}
 
 	recorder := record.NewFakeRecorder(8)
-	exposures, err := FromIngressToExposure(context.Background(), logr.Discard(), nil, recorder, ingress, "cluster.local")
+	exposures, err := FromIngressToExposure(context.Background(), logr.Discard(), nil, recorder, ingress, "cluster.local", nil)
 	if err != nil {
 		t.Fatalf("expected a rule with nil HTTP to be skipped, got error: %v", err)
 	}
@@ -227,7 +227,7 @@ func TestFromIngressToExposureNilHTTPKeepsOtherRules(t *testing.T) {
 	}
 	kubeClient := fake.NewClientBuilder().WithObjects(&service).Build()
 
-	exposures, err := FromIngressToExposure(context.Background(), logr.Discard(), kubeClient, record.NewFakeRecorder(8), ingress, "cluster.local")
+	exposures, err := FromIngressToExposure(context.Background(), logr.Discard(), kubeClient, record.NewFakeRecorder(8), ingress, "cluster.local", nil)
 	if err != nil {
 		t.Fatalf("expected the host-only rule to be skipped, got error: %v", err)
 	}
@@ -238,3 +238,231 @@ func TestFromIngressToExposureNilHTTPKeepsOtherRules(t *testing.T) {
 		t.Fatalf("expected exposure for app.example.com, got %s", exposures[0].Hostname)
 	}
 }
+
+func TestFromIngressToExposurePathTypes(t *testing.T) {
+	service := v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "my-app",
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP: "10.0.0.1",
+			Ports:     []v1.ServicePort{{Port: 80}},
+		},
+	}
+	kubeClient := fake.NewClientBuilder().WithObjects(&service).Build()
+	newIngress := func(pathType networkingv1.PathType, path string, annotations map[string]string) networkingv1.Ingress {
+		return networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Name:        "paths",
+				Annotations: annotations,
+			},
+			Spec: networkingv1.IngressSpec{
+				Rules: []networkingv1.IngressRule{
+					{
+						Host: "app.example.com",
+						IngressRuleValue: networkingv1.IngressRuleValue{
+							HTTP: &networkingv1.HTTPIngressRuleValue{
+								Paths: []networkingv1.HTTPIngressPath{
+									{
+										Path:     path,
+										PathType: &pathType,
+										Backend: networkingv1.IngressBackend{
+											Service: &networkingv1.IngressServiceBackend{
+												Name: "my-app",
+												Port: networkingv1.ServiceBackendPort{Number: 80},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	useRegex := map[string]string{AnnotationUseRegex: AnnotationUseRegexTrue}
+
+	for _, tc := range []struct {
+		name        string
+		pathType    networkingv1.PathType
+		path        string
+		annotations map[string]string
+		want        string
+		wantErr     bool
+	}{
+		{name: "exact", pathType: networkingv1.PathTypeExact, path: "/v1.0/api", want: `^/v1\.0/api$`},
+		{name: "prefix", pathType: networkingv1.PathTypePrefix, path: "/api/", want: "^/api(/|$)"},
+		{name: "root prefix", pathType: networkingv1.PathTypePrefix, path: "/", want: "/"},
+		{name: "implementation specific without use-regex", pathType: networkingv1.PathTypeImplementationSpecific, path: "/api", want: "^/api(/|$)"},
+		{name: "implementation specific with use-regex", pathType: networkingv1.PathTypeImplementationSpecific, path: "^/users/[0-9]+$", annotations: useRegex, want: "^/users/[0-9]+$"},
+		{name: "invalid regex", pathType: networkingv1.PathTypeImplementationSpecific, path: "/users/[0-9", annotations: useRegex, wantErr: true},
+		{name: "invalid use-regex", pathType: networkingv1.PathTypeImplementationSpecific, path: "/api", annotations: map[string]string{AnnotationUseRegex: "yes"}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			exposures, err := FromIngressToExposure(context.Background(), logr.Discard(), kubeClient, record.NewFakeRecorder(8), newIngress(tc.pathType, tc.path, tc.annotations), "cluster.local", nil)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got exposures %v", exposures)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(exposures) != 1 || exposures[0].PathPrefix != tc.want {
+				t.Fatalf("expected tunnel path %q, got %v", tc.want, exposures)
+			}
+		})
+	}
+}
+
+func TestFromIngressToExposureDefaultBackend(t *testing.T) {
+	services := []v1.Service{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "api"},
+			Spec:       v1.ServiceSpec{ClusterIP: "10.0.0.1", Ports: []v1.ServicePort{{Port: 80}}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "fallback"},
+			Spec:       v1.ServiceSpec{ClusterIP: "10.0.0.2", Ports: []v1.ServicePort{{Name: "http", Port: 8080}}},
+		},
+	}
+	kubeClient := fake.NewClientBuilder().WithObjects(&services[0], &services[1]).Build()
+	pathType := networkingv1.PathTypePrefix
+	defaultBackend := &networkingv1.IngressBackend{
+		Service: &networkingv1.IngressServiceBackend{
+			Name: "fallback",
+			Port: networkingv1.ServiceBackendPort{Name: "http"},
+		},
+	}
+
+	ingress := networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+		Spec: networkingv1.IngressSpec{
+			DefaultBackend: defaultBackend,
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "app.example.com",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     "/api",
+									PathType: &pathType,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "api",
+											Port: networkingv1.ServiceBackendPort{Number: 80},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				{Host: "docs.example.com"},
+			},
+		},
+	}
+	exposures, err := FromIngressToExposure(context.Background(), logr.Discard(), kubeClient, record.NewFakeRecorder(8), ingress, "cluster.local", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, item := range exposures {
+		got = append(got, item.Hostname+" "+item.PathPrefix+" "+item.ServiceTarget)
+	}
+	want := []string{
+		"app.example.com ^/api(/|$) http://api.default.svc.cluster.local:80",
+		"app.example.com  http://fallback.default.svc.cluster.local:8080",
+		"docs.example.com  http://fallback.default.svc.cluster.local:8080",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected exposures\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	// without rules, the default backend is the catch-all of the tunnel
+	catchAll := networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "catch-all"},
+		Spec:       networkingv1.IngressSpec{DefaultBackend: defaultBackend},
+	}
+	exposures, err = FromIngressToExposure(context.Background(), logr.Discard(), kubeClient, record.NewFakeRecorder(8), catchAll, "cluster.local", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(exposures) != 1 || exposures[0].Hostname != "" || exposures[0].ServiceTarget != "http://fallback.default.svc.cluster.local:8080" {
+		t.Fatalf("expected a catch-all exposure, got %v", exposures)
+	}
+
+	catchAll.Spec.DefaultBackend = &networkingv1.IngressBackend{Resource: &v1.TypedLocalObjectReference{Kind: "Bucket", Name: "static"}}
+	if _, err := FromIngressToExposure(context.Background(), logr.Discard(), kubeClient, record.NewFakeRecorder(8), catchAll, "cluster.local", nil); err == nil {
+		t.Fatalf("expected a resource backend to be rejected")
+	}
+}
+
+func TestFromIngressToExposureDNSTakeover(t *testing.T) {
+	kubeClient := fake.NewClientBuilder().WithObjects(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "fallback"},
+		Spec:       v1.ServiceSpec{ClusterIP: "10.0.0.2", Ports: []v1.ServicePort{{Port: 80}}},
+	}).Build()
+	ingress := networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+		Spec: networkingv1.IngressSpec{
+			DefaultBackend: &networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{Name: "fallback", Port: networkingv1.ServiceBackendPort{Number: 80}},
+			},
+			Rules: []networkingv1.IngressRule{{Host: "app.example.com"}},
+		},
+	}
+
+	for value, want := range map[string]bool{"": false, AnnotationDNSTakeoverTrue: true, AnnotationDNSTakeoverFalse: false} {
+		ingress.Annotations = nil
+		if value != "" {
+			ingress.Annotations = map[string]string{AnnotationDNSTakeover: value}
+		}
+		exposures, err := FromIngressToExposure(context.Background(), logr.Discard(), kubeClient, record.NewFakeRecorder(8), ingress, "cluster.local", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(exposures) != 1 || exposures[0].AllowDNSTakeover != want {
+			t.Fatalf("expected AllowDNSTakeover %v for annotation %q, got %v", want, value, exposures)
+		}
+	}
+
+	ingress.Annotations = map[string]string{AnnotationDNSTakeover: "yes"}
+	if _, err := FromIngressToExposure(context.Background(), logr.Discard(), kubeClient, record.NewFakeRecorder(8), ingress, "cluster.local", nil); err == nil {
+		t.Fatalf("expected an invalid dns-takeover value to be rejected")
+	}
+}
+
+func TestParseDNSRecordSettings(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantDNSOnly bool
+		wantTTL     int
+		wantErr     bool
+	}{
+		{name: "proxied by default"},
+		{name: "dns only", annotations: map[string]string{AnnotationDNSProxied: "false"}, wantDNSOnly: true},
+		{name: "dns only with ttl", annotations: map[string]string{AnnotationDNSProxied: "false", AnnotationDNSTTL: "300"}, wantDNSOnly: true, wantTTL: 300},
+		{name: "automatic ttl", annotations: map[string]string{AnnotationDNSTTL: "1"}},
+		{name: "ttl of a proxied record", annotations: map[string]string{AnnotationDNSTTL: "300"}, wantErr: true},
+		{name: "ttl too short", annotations: map[string]string{AnnotationDNSProxied: "false", AnnotationDNSTTL: "10"}, wantErr: true},
+		{name: "invalid proxied", annotations: map[string]string{AnnotationDNSProxied: "no"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dnsOnly, ttl, err := parseDNSRecordSettings(tt.annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDNSRecordSettings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if dnsOnly != tt.wantDNSOnly || ttl != tt.wantTTL {
+				t.Fatalf("parseDNSRecordSettings() = %v, %v, want %v, %v", dnsOnly, ttl, tt.wantDNSOnly, tt.wantTTL)
+			}
+		})
+	}
+}
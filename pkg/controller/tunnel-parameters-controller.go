@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/apis/v1alpha1"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ reconcile.Reconciler = &TunnelParametersController{}
+
+// tunnelParametersResyncPeriod repairs drift of the cloudflared connector,
+// like the ticker of the default connector does.
+const tunnelParametersResyncPeriod = 5 * time.Minute
+
+// TunnelParametersController bootstraps the tunnel of every TunnelParameters
+// object and deploys its cloudflared connector. The connector resources are
+// owned by the parameters, so garbage collection removes them when the
+// parameters are deleted. The parameters hold the finalizer until the ingress
+// controller removed the tunnel ingress rules and DNS records of the tunnel.
+type TunnelParametersController struct {
+	logger     logr.Logger
+	kubeClient client.Client
+	recorder   record.EventRecorder
+	// namespace is where the cloudflared connectors run
+	namespace         string
+	cloudflaredConfig CloudflaredConfig
+	tunnels           *TunnelRegistry
+}
+
+func NewTunnelParametersController(logger logr.Logger, kubeClient client.Client, recorder record.EventRecorder, namespace string, cloudflaredConfig CloudflaredConfig, tunnels *TunnelRegistry) *TunnelParametersController {
+	return &TunnelParametersController{logger: logger, kubeClient: kubeClient, recorder: recorder, namespace: namespace, cloudflaredConfig: cloudflaredConfig, tunnels: tunnels}
+}
+
+func (t *TunnelParametersController) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	params := v1alpha1.TunnelParameters{}
+	err := t.kubeClient.Get(ctx, request.NamespacedName, &params)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			t.tunnels.Forget(request.Name)
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, errors.Wrapf(err, "fetch tunnel parameters %s", request.Name)
+	}
+	if params.DeletionTimestamp != nil {
+		// the ingress controller forgets the tunnel once it is emptied
+		if !slices.Contains(params.Finalizers, IngressControllerFinalizer) {
+			t.tunnels.Forget(params.Name)
+		}
+		return reconcile.Result{}, nil
+	}
+	if !slices.Contains(params.Finalizers, IngressControllerFinalizer) {
+		params.Finalizers = append(params.Finalizers, IngressControllerFinalizer)
+		if err := t.kubeClient.Update(ctx, &params); err != nil {
+			return reconcile.Result{}, errors.Wrapf(err, "attach finalizer to tunnel parameters %s", params.Name)
+		}
+	}
+
+	status := params.Status.DeepCopy()
+	status.ObservedGeneration = params.Generation
+	setReady := func(conditionStatus metav1.ConditionStatus, reason string, message string) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               v1alpha1.TunnelParametersConditionReady,
+			Status:             conditionStatus,
+			Reason:             reason,
+			Message:            message,
+			ObservedGeneration: params.Generation,
+		})
+	}
+
+	credentials, fingerprint, err := t.tunnels.resolveCredentials(ctx, params)
+	if err != nil {
+		t.recorder.Event(&params, v1.EventTypeWarning, EventReasonSyncFailed, err.Error())
+		setReady(metav1.ConditionFalse, v1alpha1.TunnelParametersReasonInvalidCredentials, err.Error())
+		if statusErr := t.updateStatus(ctx, params, *status); statusErr != nil {
+			return reconcile.Result{}, statusErr
+		}
+		return reconcile.Result{}, errors.Wrapf(err, "resolve credentials of tunnel parameters %s", params.Name)
+	}
+
+	tunnelClient, err := t.tunnels.clientFor(ctx, params, credentials, fingerprint)
+	if err != nil {
+		t.recorder.Event(&params, v1.EventTypeWarning, EventReasonSyncFailed, err.Error())
+		setReady(metav1.ConditionFalse, v1alpha1.TunnelParametersReasonCloudflareError, err.Error())
+		if statusErr := t.updateStatus(ctx, params, *status); statusErr != nil {
+			return reconcile.Result{}, statusErr
+		}
+		return reconcile.Result{}, errors.Wrapf(err, "bootstrap tunnel of tunnel parameters %s", params.Name)
+	}
+	status.TunnelDomain = tunnelClient.TunnelDomain()
+
+	connectorConfig := t.cloudflaredConfig
+	connectorConfig.Name = tunnelParametersConnectorName(params.Name)
+	connectorConfig.Owner = &metav1.OwnerReference{
+		APIVersion: v1alpha1.GroupVersion.String(),
+		Kind:       v1alpha1.TunnelParametersKind,
+		Name:       params.Name,
+		UID:        params.UID,
+	}
+	err = CreateOrUpdateControlledCloudflared(ctx, t.kubeClient, tunnelClient, t.namespace, connectorConfig)
+	if err != nil {
+		t.recorder.Event(&params, v1.EventTypeWarning, EventReasonSyncFailed, err.Error())
+		setReady(metav1.ConditionFalse, v1alpha1.TunnelParametersReasonConnectorError, err.Error())
+		if statusErr := t.updateStatus(ctx, params, *status); statusErr != nil {
+			return reconcile.Result{}, statusErr
+		}
+		return reconcile.Result{}, errors.Wrapf(err, "create or update cloudflared connector of tunnel parameters %s", params.Name)
+	}
+
+	setReady(metav1.ConditionTrue, v1alpha1.TunnelParametersReasonReady, "")
+	if err := t.updateStatus(ctx, params, *status); err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{RequeueAfter: tunnelParametersResyncPeriod}, nil
+}
+
+func (t *TunnelParametersController) updateStatus(ctx context.Context, params v1alpha1.TunnelParameters, status v1alpha1.TunnelParametersStatus) error {
+	if equality.Semantic.DeepEqual(params.Status, status) {
+		return nil
+	}
+	params.Status = status
+	if err := t.kubeClient.Status().Update(ctx, &params); err != nil {
+		return errors.Wrapf(err, "update tunnel parameters %s status", params.Name)
+	}
+	return nil
+}
+
+// tunnelParametersConnectorName names the cloudflared connector of a
+// TunnelParameters object, it is shortened with a hash to stay a valid label
+// value.
+func tunnelParametersConnectorName(name string) string {
+	result := "cloudflared-" + name
+	if len(result) <= 63 {
+		return result
+	}
+	sum := sha256.Sum256([]byte(name))
+	return fmt.Sprintf("%s-%x", result[:54], sum[:4])
+}
+
+// ingressClassTunnelParameters returns the name of the TunnelParameters object
+// referenced by the IngressClass, empty when the class routes to the default
+// tunnel.
+func ingressClassTunnelParameters(ingressClass networkingv1.IngressClass) (string, error) {
+	parameters := ingressClass.Spec.Parameters
+	if parameters == nil || parameters.APIGroup == nil || *parameters.APIGroup != v1alpha1.GroupVersion.Group {
+		return "", nil
+	}
+	if parameters.Kind != v1alpha1.TunnelParametersKind {
+		return "", errors.Errorf("ingress class %s references unknown parameters kind %s", ingressClass.Name, parameters.Kind)
+	}
+	if parameters.Scope != nil && *parameters.Scope != networkingv1.IngressClassParametersReferenceScopeCluster {
+		return "", errors.Errorf("ingress class %s references %s with scope %s, the kind is cluster scoped", ingressClass.Name, parameters.Kind, *parameters.Scope)
+	}
+	return parameters.Name, nil
+}
@@ -0,0 +1,361 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/apis/v1alpha1"
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// fakeTunnelProvider hands out one fake tunnel client per bootstrap and
+// records the credentials it was called with.
+type fakeTunnelProvider struct {
+	bootstraps  int
+	credentials []cloudflarecontroller.TunnelCredentials
+	tunnels     map[string]*fakeGatewayTunnelClient
+}
+
+func (f *fakeTunnelProvider) provide(_ context.Context, credentials cloudflarecontroller.TunnelCredentials, tunnelName string) (cloudflarecontroller.TunnelClientInterface, error) {
+	f.bootstraps++
+	f.credentials = append(f.credentials, credentials)
+	tunnel := &fakeGatewayTunnelClient{name: tunnelName}
+	f.tunnels[tunnelName] = tunnel
+	return tunnel, nil
+}
+
+func newTunnelParametersTestClient(t *testing.T, objects ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objects...).
+		WithStatusSubresource(&v1alpha1.TunnelParameters{}, &networkingv1.Ingress{}).
+		WithIndex(&networkingv1.Ingress{}, ingressServiceIndex, ingressServiceIndexValues).
+		Build()
+}
+
+func newTestTunnelRegistry(kubeClient client.Client) (*TunnelRegistry, *fakeTunnelProvider) {
+	provider := &fakeTunnelProvider{tunnels: map[string]*fakeGatewayTunnelClient{}}
+	defaults := cloudflarecontroller.TunnelCredentials{AccountID: "default-account", Credentials: cloudflarecontroller.StaticCredentials{Mode: cloudflarecontroller.AuthModeAPIToken, APIToken: "default-token"}}
+	return NewTunnelRegistry(logr.Discard(), kubeClient, "cloudflare", defaults, provider.provide), provider
+}
+
+func testTunnelParameters(name string, tunnelName string) *v1alpha1.TunnelParameters {
+	return &v1alpha1.TunnelParameters{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Generation: 1, UID: types.UID("uid-" + name)},
+		Spec:       v1alpha1.TunnelParametersSpec{TunnelName: tunnelName},
+	}
+}
+
+func testTunnelIngressClass(name string, params string) *networkingv1.IngressClass {
+	ingressClass := &networkingv1.IngressClass{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       networkingv1.IngressClassSpec{Controller: testControllerClass},
+	}
+	if params != "" {
+		ingressClass.Spec.Parameters = &networkingv1.IngressClassParametersReference{
+			APIGroup: ptr.To(v1alpha1.GroupVersion.Group),
+			Kind:     v1alpha1.TunnelParametersKind,
+			Name:     params,
+		}
+	}
+	return ingressClass
+}
+
+func testTunnelIngress(name string, host string, className string) *networkingv1.Ingress {
+	ingress := testAccessIngress(name, host)
+	ingress.Spec.IngressClassName = ptr.To(className)
+	return ingress
+}
+
+func TestIngressClassTunnelParameters(t *testing.T) {
+	name, err := ingressClassTunnelParameters(*testTunnelIngressClass("default", ""))
+	require.NoError(t, err)
+	assert.Empty(t, name)
+
+	name, err = ingressClassTunnelParameters(*testTunnelIngressClass("staging", "staging"))
+	require.NoError(t, err)
+	assert.Equal(t, "staging", name)
+
+	foreign := testTunnelIngressClass("foreign", "staging")
+	foreign.Spec.Parameters.APIGroup = ptr.To("example.com")
+	name, err = ingressClassTunnelParameters(*foreign)
+	require.NoError(t, err)
+	assert.Empty(t, name, "parameters of other groups are not ours to interpret")
+
+	unknownKind := testTunnelIngressClass("unknown", "staging")
+	unknownKind.Spec.Parameters.Kind = "Unknown"
+	_, err = ingressClassTunnelParameters(*unknownKind)
+	assert.Error(t, err)
+
+	namespaced := testTunnelIngressClass("namespaced", "staging")
+	namespaced.Spec.Parameters.Scope = ptr.To(networkingv1.IngressClassParametersReferenceScopeNamespace)
+	_, err = ingressClassTunnelParameters(*namespaced)
+	assert.Error(t, err)
+}
+
+func TestTunnelParametersConnectorName(t *testing.T) {
+	assert.Equal(t, "cloudflared-staging", tunnelParametersConnectorName("staging"))
+
+	long := tunnelParametersConnectorName("a-very-long-tunnel-parameters-name-that-goes-on-and-on-forever")
+	assert.LessOrEqual(t, len(long), 63)
+	assert.NotEqual(t, long, tunnelParametersConnectorName("a-very-long-tunnel-parameters-name-that-goes-on-and-on-forever-2"))
+}
+
+func TestTunnelRegistryCredentials(t *testing.T) {
+	params := testTunnelParameters("staging", "staging-tunnel")
+	params.Spec.AccountID = "staging-account"
+	params.Spec.CredentialsSecretRef = &v1alpha1.CredentialsSecretRef{Name: "staging-credentials"}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cloudflare", Name: "staging-credentials"},
+		Data:       map[string][]byte{"api-token": []byte("staging-token")},
+	}
+	kubeClient := newTunnelParametersTestClient(t, secret)
+	registry, provider := newTestTunnelRegistry(kubeClient)
+
+	first, err := registry.ClientFor(context.Background(), *params)
+	require.NoError(t, err)
+	assert.Equal(t, []cloudflarecontroller.TunnelCredentials{{AccountID: "staging-account", Credentials: cloudflarecontroller.StaticCredentials{Mode: cloudflarecontroller.AuthModeAPIToken, APIToken: "staging-token"}}}, provider.credentials)
+
+	second, err := registry.ClientFor(context.Background(), *params)
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+	assert.Equal(t, 1, provider.bootstraps)
+
+	t.Run("rotated token bootstraps again", func(t *testing.T) {
+		secret.Data["api-token"] = []byte("rotated-token")
+		require.NoError(t, kubeClient.Update(context.Background(), secret))
+
+		_, err := registry.ClientFor(context.Background(), *params)
+		require.NoError(t, err)
+		assert.Equal(t, 2, provider.bootstraps)
+		credentials, err := provider.credentials[1].Credentials.Credentials()
+		require.NoError(t, err)
+		assert.Equal(t, "rotated-token", credentials.APIToken)
+	})
+
+	t.Run("missing key is an error", func(t *testing.T) {
+		broken := params.DeepCopy()
+		broken.Spec.CredentialsSecretRef.APITokenKey = "token"
+		_, err := registry.ClientFor(context.Background(), *broken)
+		assert.ErrorContains(t, err, "has no key token")
+	})
+
+	t.Run("api key mode reads the key and the email", func(t *testing.T) {
+		apiKey := params.DeepCopy()
+		apiKey.Spec.CredentialsSecretRef.AuthMode = string(cloudflarecontroller.AuthModeAPIKey)
+		_, err := registry.ClientFor(context.Background(), *apiKey)
+		assert.ErrorContains(t, err, "has no key api-key")
+
+		secret.Data["api-key"] = []byte("staging-key")
+		secret.Data["api-email"] = []byte("ops@example.com")
+		require.NoError(t, kubeClient.Update(context.Background(), secret))
+		_, err = registry.ClientFor(context.Background(), *apiKey)
+		require.NoError(t, err)
+		assert.Equal(t, cloudflarecontroller.StaticCredentials{Mode: cloudflarecontroller.AuthModeAPIKey, APIKey: "staging-key", APIEmail: "ops@example.com"}, provider.credentials[len(provider.credentials)-1].Credentials)
+	})
+
+	t.Run("changed auth mode bootstraps again", func(t *testing.T) {
+		_, err := registry.ClientFor(context.Background(), *params)
+		require.NoError(t, err)
+		bootstraps := provider.bootstraps
+
+		apiKey := params.DeepCopy()
+		apiKey.Generation++
+		apiKey.Spec.CredentialsSecretRef.AuthMode = string(cloudflarecontroller.AuthModeAPIKey)
+		_, err = registry.ClientFor(context.Background(), *apiKey)
+		require.NoError(t, err)
+		assert.Equal(t, bootstraps+1, provider.bootstraps, "the secret did not change")
+		assert.Equal(t, cloudflarecontroller.AuthModeAPIKey, provider.credentials[len(provider.credentials)-1].Credentials.(cloudflarecontroller.StaticCredentials).Mode)
+	})
+
+	t.Run("unknown auth mode is an error", func(t *testing.T) {
+		broken := params.DeepCopy()
+		broken.Spec.CredentialsSecretRef.AuthMode = "password"
+		_, err := registry.ClientFor(context.Background(), *broken)
+		assert.ErrorContains(t, err, `unknown cloudflare auth mode "password"`)
+	})
+
+	t.Run("defaults apply without secret", func(t *testing.T) {
+		_, err := registry.ClientFor(context.Background(), *testTunnelParameters("production", "production-tunnel"))
+		require.NoError(t, err)
+		assert.Equal(t, cloudflarecontroller.TunnelCredentials{AccountID: "default-account", Credentials: cloudflarecontroller.StaticCredentials{Mode: cloudflarecontroller.AuthModeAPIToken, APIToken: "default-token"}}, provider.credentials[len(provider.credentials)-1])
+		assert.Len(t, registry.Clients(), 2)
+	})
+}
+
+func TestTunnelParametersControllerReconcile(t *testing.T) {
+	kubeClient := newTunnelParametersTestClient(t, testTunnelParameters("staging", "staging-tunnel"))
+	registry, _ := newTestTunnelRegistry(kubeClient)
+	controller := NewTunnelParametersController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cloudflare", CloudflaredConfig{
+		Image:    "cloudflared:test",
+		Replicas: 1,
+		Protocol: "auto",
+	}, registry)
+
+	result, err := controller.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "staging"}})
+	require.NoError(t, err)
+	assert.Equal(t, tunnelParametersResyncPeriod, result.RequeueAfter)
+
+	params := v1alpha1.TunnelParameters{}
+	require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Name: "staging"}, &params))
+	assert.True(t, meta.IsStatusConditionTrue(params.Status.Conditions, v1alpha1.TunnelParametersConditionReady))
+	assert.Equal(t, "staging-tunnel.cfargotunnel.com", params.Status.TunnelDomain)
+
+	deployment := appsv1.Deployment{}
+	require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "cloudflare", Name: "cloudflared-staging"}, &deployment))
+	require.Len(t, deployment.OwnerReferences, 1)
+	assert.Equal(t, v1alpha1.TunnelParametersKind, deployment.OwnerReferences[0].Kind)
+	assert.Equal(t, params.UID, deployment.OwnerReferences[0].UID)
+
+	secret := v1.Secret{}
+	require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "cloudflare", Name: "cloudflared-staging-token"}, &secret))
+
+	assert.Contains(t, params.Finalizers, IngressControllerFinalizer)
+
+	t.Run("deleted parameters are forgotten", func(t *testing.T) {
+		require.NoError(t, kubeClient.Delete(context.Background(), &params))
+		_, err := controller.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "staging"}})
+		require.NoError(t, err)
+		assert.Len(t, registry.Clients(), 1, "the tunnel is kept until the ingress controller emptied it")
+
+		ingressController := NewIngressController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cloudflare-tunnel", testControllerClass, "cluster.local", "", HostnameConflictPolicyFirstOwner, &fakeGatewayTunnelClient{}, registry, false, false, DriftOptions{})
+		_, err = ingressController.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "staging"}})
+		require.NoError(t, err)
+		assert.Empty(t, registry.Clients())
+		err = kubeClient.Get(context.Background(), types.NamespacedName{Name: "staging"}, &params)
+		assert.True(t, client.IgnoreNotFound(err) == nil && err != nil, "expected the parameters to be gone, got %v", err)
+	})
+}
+
+func TestTunnelParametersControllerInvalidCredentials(t *testing.T) {
+	params := testTunnelParameters("staging", "staging-tunnel")
+	params.Spec.CredentialsSecretRef = &v1alpha1.CredentialsSecretRef{Name: "missing"}
+	kubeClient := newTunnelParametersTestClient(t, params)
+	registry, provider := newTestTunnelRegistry(kubeClient)
+	controller := NewTunnelParametersController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cloudflare", CloudflaredConfig{}, registry)
+
+	_, err := controller.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "staging"}})
+	require.Error(t, err)
+	assert.Zero(t, provider.bootstraps)
+
+	require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Name: "staging"}, params))
+	condition := meta.FindStatusCondition(params.Status.Conditions, v1alpha1.TunnelParametersConditionReady)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, v1alpha1.TunnelParametersReasonInvalidCredentials, condition.Reason)
+}
+
+func TestIngressControllerRoutesClassesToTheirTunnel(t *testing.T) {
+	kubeClient := newTunnelParametersTestClient(t,
+		testTunnelParameters("staging", "staging-tunnel"),
+		testTunnelIngressClass("cloudflare-tunnel", ""),
+		testTunnelIngressClass("cloudflare-tunnel-staging", "staging"),
+		testTunnelIngressClass("cloudflare-tunnel-broken", "missing"),
+		testAccessService(),
+		testTunnelIngress("web", "web.example.com", "cloudflare-tunnel"),
+		testTunnelIngress("preview", "preview.example.com", "cloudflare-tunnel-staging"),
+		testTunnelIngress("secret", "secret.example.com", "cloudflare-tunnel-broken"),
+	)
+	registry, provider := newTestTunnelRegistry(kubeClient)
+	defaultTunnel := &fakeGatewayTunnelClient{name: "default"}
+	controller := NewIngressController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cloudflare-tunnel", testControllerClass, "cluster.local", "", HostnameConflictPolicyFirstOwner, defaultTunnel, registry, false, false, DriftOptions{})
+
+	for _, key := range []string{defaultTunnelKey, "staging"} {
+		_, err := controller.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: key}})
+		require.NoError(t, err)
+	}
+	// the Ingresses of the broken class are left out of every tunnel
+	_, err := controller.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "missing"}})
+	require.Error(t, err)
+
+	require.Len(t, defaultTunnel.exposures, 1)
+	assert.Equal(t, "web.example.com", defaultTunnel.exposures[0].Hostname)
+	stagingTunnel := provider.tunnels["staging-tunnel"]
+	require.NotNil(t, stagingTunnel)
+	require.Len(t, stagingTunnel.exposures, 1)
+	assert.Equal(t, "preview.example.com", stagingTunnel.exposures[0].Hostname)
+
+	preview := networkingv1.Ingress{}
+	require.NoError(t, kubeClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "preview"}, &preview))
+	require.Len(t, preview.Status.LoadBalancer.Ingress, 1)
+	assert.Equal(t, "staging-tunnel.cfargotunnel.com", preview.Status.LoadBalancer.Ingress[0].Hostname)
+
+	t.Run("moved ingress leaves its previous tunnel", func(t *testing.T) {
+		preview.Spec.IngressClassName = ptr.To("cloudflare-tunnel")
+		require.NoError(t, kubeClient.Update(context.Background(), &preview))
+
+		for _, key := range []string{defaultTunnelKey, "staging"} {
+			_, err := controller.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Name: key}})
+			require.NoError(t, err)
+		}
+		assert.Len(t, defaultTunnel.exposures, 2)
+		assert.Empty(t, stagingTunnel.exposures)
+	})
+}
+
+func TestIngressControllerEmptiesTunnelOfDeletedParameters(t *testing.T) {
+	ctx := context.Background()
+	params := testTunnelParameters("staging", "staging-tunnel")
+	params.Finalizers = []string{IngressControllerFinalizer}
+	stale := testTunnelIngress("stale", "stale.example.com", "cloudflare-tunnel-broken")
+	stale.Finalizers = []string{IngressControllerFinalizer}
+	kubeClient := newTunnelParametersTestClient(t,
+		params,
+		testTunnelIngressClass("cloudflare-tunnel-staging", "staging"),
+		testTunnelIngressClass("cloudflare-tunnel-broken", "missing"),
+		testAccessService(),
+		testTunnelIngress("preview", "preview.example.com", "cloudflare-tunnel-staging"),
+		stale,
+	)
+	registry, provider := newTestTunnelRegistry(kubeClient)
+	controller := NewIngressController(logr.Discard(), kubeClient, record.NewFakeRecorder(32), "cloudflare-tunnel", testControllerClass, "cluster.local", "", HostnameConflictPolicyFirstOwner, &fakeGatewayTunnelClient{}, registry, false, false, DriftOptions{})
+	stagingKey := reconcile.Request{NamespacedName: types.NamespacedName{Name: "staging"}}
+
+	_, err := controller.Reconcile(ctx, stagingKey)
+	require.NoError(t, err)
+	stagingTunnel := provider.tunnels["staging-tunnel"]
+	require.NotNil(t, stagingTunnel)
+	require.Len(t, stagingTunnel.exposures, 1)
+
+	require.NoError(t, kubeClient.Delete(ctx, params))
+	_, err = controller.Reconcile(ctx, stagingKey)
+	require.NoError(t, err)
+
+	require.Len(t, stagingTunnel.exposures, 1)
+	assert.Equal(t, "preview.example.com", stagingTunnel.exposures[0].Hostname)
+	assert.True(t, stagingTunnel.exposures[0].IsDeleted)
+	assert.Empty(t, registry.Clients())
+	err = kubeClient.Get(ctx, types.NamespacedName{Name: "staging"}, params)
+	assert.True(t, client.IgnoreNotFound(err) == nil && err != nil, "expected the parameters to be gone, got %v", err)
+	preview := networkingv1.Ingress{}
+	require.NoError(t, kubeClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: "preview"}, &preview))
+	assert.NotContains(t, preview.Finalizers, IngressControllerFinalizer)
+
+	t.Run("deleted ingress of missing parameters is released", func(t *testing.T) {
+		require.NoError(t, kubeClient.Delete(ctx, stale))
+		_, err := controller.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: "missing"}})
+		require.Error(t, err, "the parameters are still missing")
+		err = kubeClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: "stale"}, stale)
+		assert.True(t, client.IgnoreNotFound(err) == nil && err != nil, "expected the ingress to be gone, got %v", err)
+	})
+}
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/apis/v1alpha1"
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const defaultAPITokenKey = "api-token"
+
+// TunnelRegistry keeps one tunnel client per TunnelParameters object, shared
+// by the ingress controller and the TunnelParameters controller. A client is
+// bootstrapped again when the parameters or the credentials change.
+type TunnelRegistry struct {
+	logger     logr.Logger
+	kubeClient client.Client
+	// namespace holds the credential Secrets referenced by the parameters
+	namespace string
+	// defaults apply to parameters without account or Secret
+	defaults cloudflarecontroller.TunnelCredentials
+	provider cloudflarecontroller.TunnelClientProvider
+
+	mu      sync.Mutex
+	tunnels map[string]registeredTunnel
+}
+
+type registeredTunnel struct {
+	// fingerprint identifies the parameters and credentials the client was
+	// bootstrapped with
+	fingerprint string
+	client      cloudflarecontroller.TunnelClientInterface
+}
+
+func NewTunnelRegistry(logger logr.Logger, kubeClient client.Client, namespace string, defaults cloudflarecontroller.TunnelCredentials, provider cloudflarecontroller.TunnelClientProvider) *TunnelRegistry {
+	return &TunnelRegistry{
+		logger:     logger,
+		kubeClient: kubeClient,
+		namespace:  namespace,
+		defaults:   defaults,
+		provider:   provider,
+		tunnels:    map[string]registeredTunnel{},
+	}
+}
+
+// ClientFor returns the client of the tunnel named by the parameters.
+func (r *TunnelRegistry) ClientFor(ctx context.Context, params v1alpha1.TunnelParameters) (cloudflarecontroller.TunnelClientInterface, error) {
+	credentials, fingerprint, err := r.resolveCredentials(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return r.clientFor(ctx, params, credentials, fingerprint)
+}
+
+// Clients returns the clients bootstrapped so far by parameters name.
+func (r *TunnelRegistry) Clients() map[string]cloudflarecontroller.TunnelClientInterface {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make(map[string]cloudflarecontroller.TunnelClientInterface, len(r.tunnels))
+	for name, tunnel := range r.tunnels {
+		result[name] = tunnel.client
+	}
+	return result
+}
+
+// Forget drops the client of deleted parameters.
+func (r *TunnelRegistry) Forget(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tunnels, name)
+}
+
+func (r *TunnelRegistry) resolveCredentials(ctx context.Context, params v1alpha1.TunnelParameters) (cloudflarecontroller.TunnelCredentials, string, error) {
+	credentials := r.defaults
+	if params.Spec.AccountID != "" {
+		credentials.AccountID = params.Spec.AccountID
+	}
+	// the generation changes with every change of the spec, including the
+	// auth mode and the keys of the Secret
+	fingerprint := fmt.Sprintf("%s/%s#%d", credentials.AccountID, params.Spec.TunnelName, params.Generation)
+
+	secretRef := params.Spec.CredentialsSecretRef
+	if secretRef == nil {
+		return credentials, fingerprint, nil
+	}
+	mode, err := cloudflarecontroller.ParseAuthMode(secretRef.AuthMode)
+	if err != nil {
+		return cloudflarecontroller.TunnelCredentials{}, "", err
+	}
+
+	secret := v1.Secret{}
+	err = r.kubeClient.Get(ctx, types.NamespacedName{Namespace: r.namespace, Name: secretRef.Name}, &secret)
+	if err != nil {
+		return cloudflarecontroller.TunnelCredentials{}, "", errors.Wrapf(err, "fetch credentials secret %s/%s", r.namespace, secretRef.Name)
+	}
+	// a Global API Key is read from the api-key and api-email keys
+	keys := []string{secretRef.APITokenKey}
+	if keys[0] == "" {
+		keys[0] = defaultAPITokenKey
+	}
+	if mode == cloudflarecontroller.AuthModeAPIKey {
+		keys = []string{cloudflarecontroller.APIKeyFile, cloudflarecontroller.APIEmailFile}
+	}
+	for _, key := range keys {
+		if len(secret.Data[key]) == 0 {
+			return cloudflarecontroller.TunnelCredentials{}, "", errors.Errorf("credentials secret %s/%s has no key %s", r.namespace, secretRef.Name, key)
+		}
+	}
+	static := cloudflarecontroller.StaticCredentials{Mode: mode}
+	if mode == cloudflarecontroller.AuthModeAPIKey {
+		static.APIKey = string(secret.Data[cloudflarecontroller.APIKeyFile])
+		static.APIEmail = string(secret.Data[cloudflarecontroller.APIEmailFile])
+	} else {
+		static.APIToken = string(secret.Data[keys[0]])
+	}
+	credentials.Credentials = static
+	// the resource version changes with every token rotation
+	fingerprint = fmt.Sprintf("%s/%s@%s/%s:%s", fingerprint, secretRef.Name, secret.ResourceVersion, mode, strings.Join(keys, ","))
+	return credentials, fingerprint, nil
+}
+
+func (r *TunnelRegistry) clientFor(ctx context.Context, params v1alpha1.TunnelParameters, credentials cloudflarecontroller.TunnelCredentials, fingerprint string) (cloudflarecontroller.TunnelClientInterface, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cached, ok := r.tunnels[params.Name]; ok && cached.fingerprint == fingerprint {
+		return cached.client, nil
+	}
+
+	r.logger.Info("bootstrap tunnel for tunnel parameters", "tunnel-parameters", params.Name, "account-id", credentials.AccountID, "tunnel-name", params.Spec.TunnelName)
+	tunnelClient, err := r.provider(ctx, credentials, params.Spec.TunnelName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "bootstrap tunnel %s", params.Spec.TunnelName)
+	}
+	r.tunnels[params.Name] = registeredTunnel{fingerprint: fingerprint, client: tunnelClient}
+	return tunnelClient, nil
+}
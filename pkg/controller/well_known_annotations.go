@@ -22,6 +22,29 @@ const AnnotationDisableDNSManagement = "cloudflare-tunnel-ingress-controller.str
 const AnnotationDisableDNSManagementTrue = "true"
 const AnnotationDisableDNSManagementFalse = "false"
 
+// AnnotationDNSTakeover lets the controller replace existing CNAME, A or AAAA records
+// of the ingress hosts it does not own, unless another tunnel or owner owns them. Without
+// it such hosts are reported as conflicts.
+// Available values: "true" or "false", default "false".
+const AnnotationDNSTakeover = "cloudflare-tunnel-ingress-controller.strrl.dev/dns-takeover"
+const AnnotationDNSTakeoverTrue = "true"
+const AnnotationDNSTakeoverFalse = "false"
+
+// AnnotationDNSProxied set to "false" creates the CNAME records of the ingress hosts
+// DNS-only, without the Cloudflare proxy. Available values: "true" or "false", default "true".
+const AnnotationDNSProxied = "cloudflare-tunnel-ingress-controller.strrl.dev/dns-proxied"
+
+// AnnotationDNSTTL is the TTL of the CNAME records of the ingress hosts in seconds, 1 for
+// automatic or from 30 to 86400. Only DNS-only records take a TTL other than automatic.
+const AnnotationDNSTTL = "cloudflare-tunnel-ingress-controller.strrl.dev/dns-ttl"
+
+// AnnotationUseRegex passes the paths of pathType ImplementationSpecific as raw regular
+// expressions to cloudflared, instead of matching them as prefixes. Available values:
+// "true" or "false", default "false".
+const AnnotationUseRegex = "cloudflare-tunnel-ingress-controller.strrl.dev/use-regex"
+const AnnotationUseRegexTrue = "true"
+const AnnotationUseRegexFalse = "false"
+
 // The annotations below map to cloudflared originRequest settings applied to
 // every rule generated from the ingress. See
 // https://developers.cloudflare.com/cloudflare-one/networks/connectors/cloudflare-tunnel/configure-tunnels/origin-parameters/
@@ -61,3 +84,34 @@ const AnnotationDisableChunkedEncoding = "cloudflare-tunnel-ingress-controller.s
 
 // AnnotationHTTP2Origin connects to the origin with HTTP/2, available values: "true" or "false".
 const AnnotationHTTP2Origin = "cloudflare-tunnel-ingress-controller.strrl.dev/http2-origin"
+
+// The annotations below expose a Service as a non HTTP origin, for clients
+// connecting with cloudflared access. See
+// https://developers.cloudflare.com/cloudflare-one/access-controls/applications/non-http/
+
+// AnnotationExposeHostname is the hostname a Service is exposed at, setting it exposes the
+// Service.
+const AnnotationExposeHostname = "cloudflare-tunnel-ingress-controller.strrl.dev/expose-hostname"
+
+// AnnotationExposeProtocol is the protocol of an exposed Service, available values: "tcp",
+// "ssh", "rdp", "smb", "unix" or "unix+tls", default "tcp".
+const AnnotationExposeProtocol = "cloudflare-tunnel-ingress-controller.strrl.dev/expose-protocol"
+
+// AnnotationExposePort is the name or the number of the exposed port of the Service,
+// optional when the Service has a single port.
+const AnnotationExposePort = "cloudflare-tunnel-ingress-controller.strrl.dev/expose-port"
+
+// AnnotationExposeUnixSocket is the absolute path of the UNIX socket exposed by the
+// protocols "unix" and "unix+tls", the socket must be mounted into the cloudflared pods.
+const AnnotationExposeUnixSocket = "cloudflare-tunnel-ingress-controller.strrl.dev/expose-unix-socket"
+
+// AnnotationExposeIngressClass is the IngressClass whose tunnel exposes the Service, default
+// the ingress class of the controller.
+const AnnotationExposeIngressClass = "cloudflare-tunnel-ingress-controller.strrl.dev/expose-ingress-class"
+
+// AnnotationAllowedHostnames restricts the hostnames the Ingresses, the Services and the
+// HTTPRoutes of a namespace may expose, set on the Namespace. It is a comma separated list of hostnames,
+// eg. "app.example.com", and domain suffixes, eg. "*.example.com" allowing every hostname
+// below example.com. A single "*" also allows the catch-all of the tunnel. Without the
+// annotation every hostname is allowed. It is enforced together with the HostnamePolicies.
+const AnnotationAllowedHostnames = "cloudflare-tunnel-ingress-controller.strrl.dev/allowed-hostnames"
@@ -4,11 +4,14 @@ import "time"
 
 // Exposure is the minimal information for exposing a service.
 type Exposure struct {
-	// Hostname is the domain name to expose the service, eg. hello.strrl.dev
+	// Hostname is the domain name to expose the service, eg. hello.strrl.dev.
+	// An exposure without hostname is the catch-all of the tunnel, it serves
+	// the requests no other exposure matches.
 	Hostname string
 	// ServiceTarget is the url of the service to expose, eg. http://my-service.default.svc.cluster.local:9117
 	ServiceTarget string
-	// PathPrefix is the path prefix to expose the service, eg. /hello
+	// PathPrefix is the path of the tunnel rule, cloudflared matches it as a
+	// regular expression against the request path, eg. ^/hello(/|$)
 	PathPrefix string
 	// IsDeleted is the flag to indicate if the exposure is deleted.
 	IsDeleted bool
@@ -23,6 +26,15 @@ type Exposure struct {
 	// tunnel ingress rule. DNS can then be delegated to an external system, e.g.
 	// external-dns or a Cloudflare Load Balancer targeting the tunnel directly.
 	DisableDNSManagement bool
+	// AllowDNSTakeover lets the controller replace existing DNS records of the
+	// hostname it does not own, instead of reporting a conflict.
+	AllowDNSTakeover bool
+	// DNSOnly creates the CNAME record of the hostname unproxied, for a CDN
+	// or a Cloudflare Load Balancer sitting in front of the tunnel.
+	DNSOnly bool
+	// DNSTTL is the TTL of the CNAME record in seconds, zero is automatic.
+	// Only DNS-only records have a TTL other than automatic.
+	DNSTTL int
 
 	// The fields below map to cloudflared originRequest settings, nil means
 	// the cloudflared default applies.
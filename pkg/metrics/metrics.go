@@ -37,12 +37,70 @@ var (
 		Help:      "Total number of failed Cloudflare API calls.",
 	}, []string{"operation"})
 
+	// CloudflareAPIRetries counts the retried Cloudflare API requests by
+	// the status code, or error, of the failed attempt.
+	CloudflareAPIRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cloudflare_api_retries_total",
+		Help:      "Total number of retried Cloudflare API requests.",
+	}, []string{"reason"})
+
+	// CloudflareAPIThrottleWait is the time the requests spent waiting for
+	// the client-side request budget.
+	CloudflareAPIThrottleWait = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cloudflare_api_throttle_wait_seconds_total",
+		Help:      "Total time Cloudflare API requests waited for the request budget.",
+	})
+
+	// CloudflareAPIBudgetRemaining is the number of requests the budget
+	// allows at once, a value staying near zero means the controller is
+	// throttled.
+	CloudflareAPIBudgetRemaining = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cloudflare_api_budget_remaining",
+		Help:      "Number of Cloudflare API requests the request budget allows at once.",
+	})
+
 	// DNSRecordOperations counts DNS record changes applied to Cloudflare.
 	DNSRecordOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: namespace,
 		Name:      "dns_record_operations_total",
 		Help:      "Total number of DNS record changes applied to Cloudflare.",
 	}, []string{"operation", "record_type"})
+
+	// Drift is the number of Cloudflare resources of a tunnel differing
+	// from the desired state, as of the last drift check. It drops back to
+	// zero once the drift is repaired, so it only stays up in report-only
+	// mode or while the repair fails.
+	Drift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "drift_resources",
+		Help:      "Number of Cloudflare resources differing from the desired state.",
+	}, []string{"tunnel_id", "kind"})
+
+	// DriftRepairs counts the drifted Cloudflare resources the periodic
+	// resync repaired.
+	DriftRepairs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "drift_repairs_total",
+		Help:      "Total number of drifted Cloudflare resources repaired.",
+	}, []string{"tunnel_id", "kind"})
+
+	// PreflightCheckPassed is 1 when the preflight check passed, 0 when it
+	// failed, as of the last preflight. zone is empty but for the DNS
+	// checks.
+	PreflightCheckPassed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "preflight_check_passed",
+		Help:      "Whether the Cloudflare preflight check passed (1) or failed (0).",
+	}, []string{"check", "zone"})
+)
+
+// Values of the kind label of the drift metrics.
+const (
+	DriftKindIngressRule = "tunnel_ingress_rule"
+	DriftKindDNSRecord   = "dns_record"
 )
 
 func init() {
@@ -50,6 +108,12 @@ func init() {
 		LastSuccessfulSyncTimestamp,
 		ManagedExposures,
 		CloudflareAPIErrors,
+		CloudflareAPIRetries,
+		CloudflareAPIThrottleWait,
+		CloudflareAPIBudgetRemaining,
 		DNSRecordOperations,
+		Drift,
+		DriftRepairs,
+		PreflightCheckPassed,
 	)
 }
@@ -26,6 +26,14 @@ func (m *MockTunnelClient) PutExposures(ctx context.Context, exposures []exposur
 	return nil
 }
 
+func (m *MockTunnelClient) DetectDrift(ctx context.Context, exposures []exposure.Exposure) (cloudflarecontroller.Drift, error) {
+	return cloudflarecontroller.Drift{}, nil
+}
+
+func (m *MockTunnelClient) Plan(ctx context.Context, exposures []exposure.Exposure) (cloudflarecontroller.Plan, error) {
+	return cloudflarecontroller.Plan{}, nil
+}
+
 func (m *MockTunnelClient) TunnelDomain() string {
 	return "mock.tunnel.com"
 }
@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"log"
+	"os"
+
+	cloudflarecontroller "github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/cloudflare-controller/fake"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/pkg/controller"
+	"github.com/STRRL/cloudflare-tunnel-ingress-controller/test/fixtures"
+	"github.com/go-logr/stdr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const testControllerClass = "strrl.dev/cloudflare-tunnel-ingress-controller"
+
+var _ = Describe("ingress controller with a fake cloudflare", func() {
+	logger := stdr.NewWithOptions(log.New(os.Stderr, "", log.LstdFlags), stdr.Options{LogCaller: stdr.All})
+
+	It("should sync the tunnel and the DNS records of an ingress", func() {
+		By("preparing namespace")
+		namespaceFixtures := fixtures.NewKubernetesNamespaceFixtures(IntegrationTestNamespace, kubeClient)
+		ns, err := namespaceFixtures.Start(ctx)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		defer func() {
+			By("cleaning up namespace")
+			err := namespaceFixtures.Stop(ctx)
+			Expect(err).ShouldNot(HaveOccurred())
+		}()
+
+		By("preparing the fake cloudflare account")
+		api := fake.NewCloudflare("account")
+		api.AddZone("example.com")
+		tunnelClient, err := cloudflarecontroller.BootstrapTunnelClientWithTunnelName(ctx, logger, api, "account", "integration", cloudflarecontroller.DNSOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(api.Tunnels()).Should(HaveLen(1))
+		tunnelID := api.Tunnels()[0].ID
+
+		By("preparing ingress class, service and ingress")
+		ingressClass := networkingv1.IngressClass{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "cloudflare-tunnel-"},
+			Spec:       networkingv1.IngressClassSpec{Controller: testControllerClass},
+		}
+		err = kubeClient.Create(ctx, &ingressClass)
+		Expect(err).ShouldNot(HaveOccurred())
+		defer func() {
+			err := kubeClient.Delete(ctx, &ingressClass)
+			Expect(client.IgnoreNotFound(err)).ShouldNot(HaveOccurred())
+		}()
+
+		service := v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "web"},
+			Spec: v1.ServiceSpec{
+				ClusterIP: "10.0.0.25",
+				Ports:     []v1.ServicePort{{Name: "http", Protocol: v1.ProtocolTCP, Port: 80}},
+			},
+		}
+		err = kubeClient.Create(ctx, &service)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ingress := networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "web"},
+			Spec: networkingv1.IngressSpec{
+				IngressClassName: ptr.To(ingressClass.Name),
+				Rules: []networkingv1.IngressRule{
+					{
+						Host: "web.example.com",
+						IngressRuleValue: networkingv1.IngressRuleValue{
+							HTTP: &networkingv1.HTTPIngressRuleValue{
+								Paths: []networkingv1.HTTPIngressPath{
+									{
+										Path:     "/",
+										PathType: &pathTypePrefix,
+										Backend: networkingv1.IngressBackend{
+											Service: &networkingv1.IngressServiceBackend{
+												Name: service.Name,
+												Port: networkingv1.ServiceBackendPort{Number: 80},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+		err = kubeClient.Create(ctx, &ingress)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		By("reconciling the tunnel")
+		ingressController := controller.NewIngressController(logger, kubeClient, record.NewFakeRecorder(32), ingressClass.Name, testControllerClass, testClusterDomain, "", controller.HostnameConflictPolicyFirstOwner, tunnelClient, nil, false, false, controller.DriftOptions{})
+		request := reconcile.Request{NamespacedName: types.NamespacedName{Name: "@default"}}
+		_, err = ingressController.Reconcile(ctx, request)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		rules := api.TunnelConfiguration(tunnelID).Ingress
+		Expect(rules).Should(HaveLen(2))
+		Expect(rules[0].Hostname).Should(Equal("web.example.com"))
+		Expect(rules[0].Service).Should(Equal("http://web." + ns + ".svc." + testClusterDomain + ":80"))
+		Expect(api.DNSRecords("example.com")).Should(ContainElement(HaveField("Content", tunnelClient.TunnelDomain())))
+
+		err = kubeClient.Get(ctx, types.NamespacedName{Namespace: ns, Name: ingress.Name}, &ingress)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(ingress.Status.LoadBalancer.Ingress).Should(HaveLen(1))
+		Expect(ingress.Status.LoadBalancer.Ingress[0].Hostname).Should(Equal(tunnelClient.TunnelDomain()))
+
+		By("deleting the ingress")
+		err = kubeClient.Delete(ctx, &ingress)
+		Expect(err).ShouldNot(HaveOccurred())
+		_, err = ingressController.Reconcile(ctx, request)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(api.DNSRecords("example.com")).Should(BeEmpty())
+		Expect(api.TunnelConfiguration(tunnelID).Ingress).Should(HaveLen(1))
+	})
+})
@@ -101,7 +101,7 @@ var _ = Describe("transform ingress to exposure", func() {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		By("transforming ingress to exposure")
-		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain)
+		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain, nil)
 		Expect(err).ShouldNot(HaveOccurred())
 		Expect(exposure).ShouldNot(BeNil())
 		Expect(exposure).Should(HaveLen(1))
@@ -112,7 +112,7 @@ var _ = Describe("transform ingress to exposure", func() {
 		Expect(exposure[0].IsDeleted).Should(BeFalse())
 	})
 
-	It("should fail fast with PathType Exact", func() {
+	It("should resolve ingress with PathType Exact", func() {
 		// prepare
 		By("preparing namespace")
 		namespaceFixtures := fixtures.NewKubernetesNamespaceFixtures(IntegrationTestNamespace, kubeClient)
@@ -185,9 +185,10 @@ var _ = Describe("transform ingress to exposure", func() {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		By("transforming ingress to exposure")
-		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain)
-		Expect(err).Should(HaveOccurred())
-		Expect(exposure).Should(BeNil())
+		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(exposure).Should(HaveLen(1))
+		Expect(exposure[0].PathPrefix).Should(Equal("^/$"))
 	})
 
 	It("should fail fast with PathType ImplementationSpecific", func() {
@@ -263,7 +264,7 @@ var _ = Describe("transform ingress to exposure", func() {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		By("transforming ingress to exposure")
-		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain)
+		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain, nil)
 		Expect(err).ShouldNot(HaveOccurred())
 		Expect(exposure).Should(HaveLen(1))
 	})
@@ -341,7 +342,7 @@ var _ = Describe("transform ingress to exposure", func() {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		By("transforming ingress to exposure")
-		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain)
+		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain, nil)
 		Expect(err).ShouldNot(HaveOccurred())
 		Expect(exposure).ShouldNot(BeNil())
 		Expect(exposure).Should(HaveLen(1))
@@ -425,7 +426,7 @@ var _ = Describe("transform ingress to exposure", func() {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		By("transforming ingress to exposure")
-		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain)
+		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain, nil)
 		Expect(err).Should(HaveOccurred())
 		Expect(exposure).Should(BeNil())
 	})
@@ -503,7 +504,7 @@ var _ = Describe("transform ingress to exposure", func() {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		By("transforming ingress to exposure")
-		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain)
+		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain, nil)
 		Expect(err).Should(HaveOccurred())
 		Expect(exposure).Should(BeNil())
 	})
@@ -584,7 +585,7 @@ var _ = Describe("transform ingress to exposure", func() {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		By("transforming ingress to exposure")
-		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain)
+		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain, nil)
 		Expect(err).ShouldNot(HaveOccurred())
 		Expect(exposure).ShouldNot(BeNil())
 		Expect(exposure).Should(HaveLen(1))
@@ -673,7 +674,7 @@ var _ = Describe("transform ingress to exposure", func() {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		By("transforming ingress to exposure")
-		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain)
+		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain, nil)
 		Expect(err).ShouldNot(HaveOccurred())
 		Expect(exposure).ShouldNot(BeNil())
 		Expect(exposure).Should(HaveLen(1))
@@ -758,7 +759,7 @@ var _ = Describe("transform ingress to exposure", func() {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		By("transforming ingress to exposure with custom cluster domain")
-		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain)
+		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain, nil)
 		Expect(err).ShouldNot(HaveOccurred())
 		Expect(exposure).ShouldNot(BeNil())
 		Expect(exposure).Should(HaveLen(1))
@@ -846,7 +847,7 @@ var _ = Describe("transform ingress to exposure", func() {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		By("transforming ingress to exposure")
-		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain)
+		exposure, err := controller.FromIngressToExposure(ctx, logger, kubeClient, recorder, ingress, testClusterDomain, nil)
 		Expect(err).ShouldNot(HaveOccurred())
 		Expect(exposure).ShouldNot(BeNil())
 		Expect(exposure).Should(HaveLen(1))